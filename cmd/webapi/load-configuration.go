@@ -25,7 +25,21 @@ type WebAPIConfiguration struct {
 	}
 	Debug bool
 	DB    struct {
-		Filename string `conf:"default:/tmp/decaf.db"`
+		Driver       string        `conf:"default:sqlite3"`
+		Filename     string        `conf:"default:/tmp/decaf.db"`
+		JournalMode  string        `conf:"default:WAL"`
+		Synchronous  string        `conf:"default:NORMAL"`
+		BusyTimeout  time.Duration `conf:"default:5s"`
+		MaxReaders   int           `conf:"default:4"`
+		QueryTimeout time.Duration `conf:"default:10s"`
+		DSN          string        `conf:"noprint"`
+		MaxConns     int           `conf:"default:10"`
+	}
+	Admin struct {
+		Token string `conf:"noprint"`
+	}
+	Media struct {
+		SigningKey string `conf:"noprint"`
 	}
 }
 
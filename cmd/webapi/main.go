@@ -25,10 +25,10 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -36,10 +36,8 @@ import (
 	"syscall"
 
 	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/api"
-	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
 	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/globaltime"
 	"github.com/ardanlabs/conf"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
 )
 
@@ -84,20 +82,15 @@ func run() error {
 
 	// Start Database
 	logger.Println("initializing database support")
-	dbconn, err := sql.Open("sqlite3", cfg.DB.Filename)
+	db, closeDB, err := openDatabase(cfg)
 	if err != nil {
-		logger.WithError(err).Error("error opening SQLite DB")
-		return fmt.Errorf("opening SQLite: %w", err)
+		logger.WithError(err).Error("error opening the database")
+		return err
 	}
 	defer func() {
 		logger.Debug("database stopping")
-		_ = dbconn.Close()
+		closeDB()
 	}()
-	db, err := database.New(dbconn)
-	if err != nil {
-		logger.WithError(err).Error("error creating AppDatabase")
-		return fmt.Errorf("creating AppDatabase: %w", err)
-	}
 
 	// After database initialization and before API server initialization
 	// Ensure uploads/images directory exists
@@ -121,8 +114,10 @@ func run() error {
 
 	// Create the API router
 	apirouter, err := api.New(api.Config{
-		Logger:   logger,
-		Database: db,
+		Logger:          logger,
+		Database:        db,
+		AdminToken:      cfg.Admin.Token,
+		MediaSigningKey: cfg.Media.SigningKey,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -139,6 +134,11 @@ func run() error {
 	// Apply CORS policy
 	router = applyCORSHandler(router)
 
+	// Requests get their context from requestsCtx, which is cancelled to abort the database calls of the requests
+	// still running when the shutdown deadline passes
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
 	// Create the API server
 	apiserver := http.Server{
 		Addr:              cfg.Web.APIHost,
@@ -146,6 +146,9 @@ func run() error {
 		ReadTimeout:       cfg.Web.ReadTimeout,
 		ReadHeaderTimeout: cfg.Web.ReadTimeout,
 		WriteTimeout:      cfg.Web.WriteTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return requestsCtx
+		},
 	}
 
 	// Start the service listening for requests in a separate goroutine
@@ -178,6 +181,7 @@ func run() error {
 		err = apiserver.Shutdown(ctx)
 		if err != nil {
 			logger.WithError(err).Warning("error during graceful shutdown of HTTP server")
+			cancelRequests()
 			err = apiserver.Close()
 		}
 
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+)
+
+// openDatabase connects to the database selected by cfg.DB.Driver: a SQLite file, or the PostgreSQL database at
+// cfg.DB.DSN. It returns the AppDatabase and the function closing the connections.
+func openDatabase(cfg WebAPIConfiguration) (database.AppDatabase, func(), error) {
+	switch cfg.DB.Driver {
+	case "sqlite3":
+		dbconn, dbreaders, err := database.OpenSQLite(cfg.DB.Filename, database.SQLiteOptions{
+			JournalMode: cfg.DB.JournalMode,
+			Synchronous: cfg.DB.Synchronous,
+			BusyTimeout: cfg.DB.BusyTimeout,
+			MaxReaders:  cfg.DB.MaxReaders,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening SQLite: %w", err)
+		}
+		closeDB := func() {
+			_ = dbreaders.Close()
+			_ = dbconn.Close()
+		}
+		db, err := database.NewWithReaders(dbconn, dbreaders, cfg.DB.QueryTimeout)
+		if err != nil {
+			closeDB()
+			return nil, nil, fmt.Errorf("creating AppDatabase: %w", err)
+		}
+		return db, closeDB, nil
+
+	case "postgres":
+		dbconn, err := database.OpenPostgres(cfg.DB.DSN, cfg.DB.MaxConns)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening PostgreSQL: %w", err)
+		}
+		closeDB := func() { _ = dbconn.Close() }
+		db, err := database.NewPostgres(dbconn, cfg.DB.QueryTimeout)
+		if err != nil {
+			closeDB()
+			return nil, nil, fmt.Errorf("creating AppDatabase: %w", err)
+		}
+		return db, closeDB, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unknown database driver %q, use sqlite3 or postgres", cfg.DB.Driver)
+	}
+}
@@ -15,7 +15,13 @@ func (rt *_router) Handler() http.Handler {
 	rt.router.POST("/users/:username/photo", rt.setMyPhoto)
 	rt.router.GET("/users/:username", rt.getUser)
 	rt.router.GET("/users/:username/exists", rt.checkUserExists)
-	rt.router.GET("/allusers", rt.getAllUsers)
+	rt.router.GET("/users", rt.searchUsers)
+	rt.router.GET("/users/:username/mentions", rt.getUnseenMentions)
+	rt.router.PUT("/users/:username/privacy", rt.setPrivacy)
+	rt.router.GET("/users/:username/profile", rt.getProfile)
+	rt.router.PUT("/users/:username/profile", rt.updateProfile)
+	rt.router.POST("/users/:username/block", rt.blockUser)
+	rt.router.DELETE("/users/:username/block", rt.unblockUser)
 
 	// Group routes
 	rt.router.POST("/groups", rt.createGroup)
@@ -24,25 +30,81 @@ func (rt *_router) Handler() http.Handler {
 	rt.router.POST("/groups/:group_id/leave", rt.leaveGroup)
 
 	// Conversation routes
-	rt.router.GET("/conversations/:conversationId/messages", rt.getConversationMessages)
-	rt.router.GET("/users/:username/conversations", rt.getUserConversations)
+	rt.router.GET("/conversations/:conversationId/messages", rt.botRoute(scopeMessagesRead, rt.getConversationMessages))
+	rt.router.GET("/users/:username/conversations", rt.botRoute(scopeConversationsRead, rt.getUserConversations))
 	rt.router.POST("/conversations", rt.createConversation)
-	rt.router.GET("/conversations/:conversationId", rt.getConversation)
-	rt.router.GET("/conversations/:conversationId/details", rt.getConversationDetails)
+	rt.router.GET("/conversations/:conversationId", rt.botRoute(scopeMessagesRead, rt.getConversation))
+	rt.router.GET("/conversations/:conversationId/details",
+		rt.botRoute(scopeConversationsRead, rt.getConversationDetails))
+	rt.router.PUT("/conversations/:conversationId/ttl", rt.setMessageTTL)
+	rt.router.POST("/conversations/:conversationId/typing", rt.sendTyping)
+	rt.router.POST("/conversations/:conversationId/mute", rt.muteConversation)
+	rt.router.DELETE("/conversations/:conversationId/mute", rt.unmuteConversation)
 
 	// Reaction routes
-	rt.router.POST("/conversations/:conversationId/messages/:messageId/reactions", rt.addReaction)
-	rt.router.DELETE("/conversations/:conversationId/messages/:messageId/reactions", rt.removeReaction)
+	rt.router.POST("/conversations/:conversationId/messages/:messageId/reactions",
+		rt.botRoute(scopeMessagesWrite, rt.addReaction))
+	rt.router.DELETE("/conversations/:conversationId/messages/:messageId/reactions",
+		rt.botRoute(scopeMessagesWrite, rt.removeReaction))
+
+	// Pin routes
+	rt.router.POST("/conversations/:conversationId/messages/:messageId/pin", rt.pinMessage)
+	rt.router.DELETE("/conversations/:conversationId/messages/:messageId/pin", rt.unpinMessage)
+	rt.router.GET("/conversations/:conversationId/pins", rt.botRoute(scopeMessagesRead, rt.getPinnedMessages))
 
 	// Message routes
-	rt.router.POST("/conversations/:conversationId/messages", rt.sendMessage)
-	rt.router.DELETE("/conversations/:conversationId/messages/:messageId", rt.deleteMessage)
-	rt.router.POST("/conversations/:conversationId/messages/:messageId/forward", rt.forwardMessage)
-	rt.router.POST("/conversations/:conversationId/messages/:messageId/reply", rt.replyToMessage)
-	rt.router.POST("/conversations/:conversationId/image-message", rt.sendImageMessage)
-
-	// Add static file server for uploads
-	rt.router.ServeFiles("/uploads/*filepath", http.Dir("uploads"))
+	rt.router.POST("/conversations/:conversationId/messages", rt.botRoute(scopeMessagesWrite, rt.sendMessage))
+	rt.router.DELETE("/conversations/:conversationId/messages/:messageId",
+		rt.botRoute(scopeMessagesWrite, rt.deleteMessage))
+	rt.router.POST("/messages/:messageId/forward", rt.forwardMessage)
+	rt.router.POST("/conversations/:conversationId/messages/:messageId/reply",
+		rt.botRoute(scopeMessagesWrite, rt.replyToMessage))
+	rt.router.POST("/conversations/:conversationId/image-message", rt.botRoute(scopeMessagesWrite, rt.sendImageMessage))
+
+	// Poll routes
+	rt.router.POST("/conversations/:conversationId/polls", rt.botRoute(scopeMessagesWrite, rt.createPoll))
+	rt.router.POST("/conversations/:conversationId/messages/:messageId/votes",
+		rt.botRoute(scopeMessagesWrite, rt.votePoll))
+	rt.router.POST("/conversations/:conversationId/messages/:messageId/close",
+		rt.botRoute(scopeMessagesWrite, rt.closePoll))
+
+	// Attachment routes
+	rt.router.POST("/conversations/:conversationId/attachments", rt.botRoute(scopeMessagesWrite, rt.sendAttachments))
+	rt.router.GET("/attachments/:attachmentId", rt.getAttachment)
+
+	// Resumable upload routes
+	rt.router.POST("/upload-sessions", rt.createUpload)
+	rt.router.HEAD("/upload-sessions/:uploadId", rt.getUploadOffset)
+	rt.router.GET("/upload-sessions/:uploadId", rt.getUploadOffset)
+	rt.router.PATCH("/upload-sessions/:uploadId", rt.patchUpload)
+	rt.router.POST("/upload-sessions/:uploadId/finalize", rt.finalizeUpload)
+	rt.router.DELETE("/upload-sessions/:uploadId", rt.deleteUpload)
+
+	// Slash command routes
+	rt.router.POST("/conversations/:conversationId/commands", rt.botRoute(scopeCommandsWrite, rt.registerCommand))
+	rt.router.GET("/conversations/:conversationId/commands", rt.botRoute(scopeConversationsRead, rt.getCommands))
+	rt.router.DELETE("/conversations/:conversationId/commands/:name",
+		rt.botRoute(scopeCommandsWrite, rt.deleteCommand))
+
+	// Bot routes, for their owners
+	rt.router.POST("/bots", rt.createBot)
+	rt.router.GET("/bots", rt.getBots)
+	rt.router.POST("/bots/:botId/tokens", rt.createBotToken)
+	rt.router.GET("/bots/:botId/tokens", rt.getBotTokens)
+	rt.router.DELETE("/bots/:botId/tokens/:tokenId", rt.revokeBotToken)
+
+	// Webhook routes
+	rt.router.POST("/conversations/:conversationId/webhooks", rt.createWebhook)
+	rt.router.GET("/conversations/:conversationId/webhooks", rt.getWebhooks)
+	rt.router.DELETE("/conversations/:conversationId/webhooks/:webhookId", rt.deleteWebhook)
+	rt.router.GET("/conversations/:conversationId/webhooks/:webhookId/deliveries", rt.getWebhookDeliveries)
+
+	// Maintenance routes
+	rt.router.GET("/admin/media/gc", rt.getMediaGC)
+	rt.router.POST("/admin/media/gc", rt.collectMedia)
+
+	// Stored images, served to who can see them
+	rt.router.GET("/uploads/images/:filename", rt.getImage)
 
 	// Register routes
 	// rt.router.GET("/", rt.getHelloWorld)
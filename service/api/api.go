@@ -37,10 +37,15 @@ See the `main.go` file inside the `cmd/webapi` for a full usage example.
 package api
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
+	"fmt"
 	"net/http"
 
 	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/linkpreview"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/presence"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 )
@@ -52,6 +57,13 @@ type Config struct {
 
 	// Database is the instance of database.AppDatabase where data are saved
 	Database database.AppDatabase
+
+	// AdminToken authorizes the maintenance endpoints under /admin. They are disabled when it is empty.
+	AdminToken string
+
+	// MediaSigningKey signs the URLs of images sent in conversations. When empty a random key is used, and the URLs
+	// handed out stop working when the server restarts.
+	MediaSigningKey string
 }
 
 // Router is the package API interface representing an API handler builder
@@ -75,15 +87,64 @@ func New(cfg Config) (Router, error) {
 
 	// Create a new router where we will register HTTP endpoints. The server will pass requests to this router to be
 	// handled.
+	signingKey := []byte(cfg.MediaSigningKey)
+	if len(signingKey) == 0 {
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			return nil, fmt.Errorf("generating media signing key: %w", err)
+		}
+	}
+
 	router := httprouter.New()
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
-	return &_router{
+	ctx, cancel := context.WithCancel(context.Background())
+	rt := &_router{
 		router:     router,
 		baseLogger: cfg.Logger,
 		db:         cfg.Database,
-	}, nil
+		presence:   presence.New(onlineTTL, typingTTL, lastSeenPersistPeriod),
+		stop:       make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+		adminToken: cfg.AdminToken,
+
+		mediaSigningKey: signingKey,
+
+		linkPreviews:  make(chan linkPreviewJob, linkPreviewQueueSize),
+		linkPreviewer: linkpreview.New(linkPreviewTimeout, linkPreviewMaxBytes),
+
+		webhookWake:   make(chan struct{}, 1),
+		webhookClient: newWebhookClient(allowPrivateReceivers),
+
+		uploadLocks: newUploadLocks(),
+	}
+
+	// Background job that removes messages after their conversation TTL
+	go rt.sweepExpiredMessages(messageSweepInterval)
+
+	// Background job that forgets users who went offline
+	go rt.prunePresence(presencePruneInterval)
+
+	// Background job that removes abandoned uploads
+	go rt.sweepExpiredUploads(uploadSweepInterval)
+
+	// Background job that removes the media files nothing references anymore
+	go rt.collectMediaGarbage(mediaGCInterval)
+
+	// Background workers that fetch the previews of links sent in messages
+	for i := 0; i < linkPreviewWorkers; i++ {
+		go rt.fetchLinkPreviews()
+	}
+
+	// Background job that posts the reminders set with /remind
+	go rt.sendReminders(reminderInterval)
+
+	// Background job that delivers the events of conversations to their webhooks
+	go rt.dispatchWebhooks(webhookDispatchInterval)
+
+	return rt, nil
 }
 
 type _router struct {
@@ -94,4 +155,31 @@ type _router struct {
 	baseLogger logrus.FieldLogger
 
 	db database.AppDatabase
+
+	// presence tracks online and typing users
+	presence *presence.Registry
+
+	// stop is closed by Close() to terminate background goroutines
+	stop chan struct{}
+
+	// ctx is the context of the work not started by a request, like background jobs; Close() cancels it
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// uploadLocks serializes the requests writing to the same resumable upload
+	uploadLocks *uploadLocks
+
+	// adminToken authorizes the /admin endpoints, which are disabled when it is empty
+	adminToken string
+
+	// mediaSigningKey is the HMAC key of signed image URLs
+	mediaSigningKey []byte
+
+	// linkPreviews queues the links whose preview the workers fetch
+	linkPreviews  chan linkPreviewJob
+	linkPreviewer *linkpreview.Fetcher
+
+	// webhookWake wakes up the webhook dispatcher when deliveries are queued
+	webhookWake   chan struct{}
+	webhookClient *http.Client
 }
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	// The webhook and command receivers of the tests listen on loopback
+	allowPrivateReceivers = true
+	os.Exit(m.Run())
+}
+
+// newTestRouter returns a router on a new SQLite database and its handler. The test runs in a temporary working
+// directory, where the router stores the media files.
+func newTestRouter(t *testing.T) (*_router, http.Handler) {
+	t.Helper()
+
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("error changing working directory: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	writer, readers, err := database.OpenSQLite(filepath.Join(dir, "test.db"), database.SQLiteOptions{
+		JournalMode: "WAL",
+		Synchronous: "NORMAL",
+		MaxReaders:  2,
+	})
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = readers.Close()
+		_ = writer.Close()
+	})
+	db, err := database.NewWithReaders(writer, readers, 0)
+	if err != nil {
+		t.Fatalf("error creating database: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	router, err := New(Config{Logger: logger, Database: db, MediaSigningKey: "test signing key"})
+	if err != nil {
+		t.Fatalf("error creating router: %v", err)
+	}
+	t.Cleanup(func() { _ = router.Close() })
+
+	rt := router.(*_router)
+	return rt, rt.Handler()
+}
+
+// serve sends a request to the handler with token as bearer token, when not empty
+func serve(h http.Handler, method string, path string, token string, body io.Reader,
+	headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, body)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// serveJSON sends a request with a JSON body to the handler
+func serveJSON(h http.Handler, method string, path string, token string, body string) *httptest.ResponseRecorder {
+	return serve(h, method, path, token, strings.NewReader(body), map[string]string{"Content-Type": "application/json"})
+}
+
+// login creates the user if needed and returns its token
+func login(t *testing.T, h http.Handler, name string) string {
+	t.Helper()
+
+	w := serveJSON(h, http.MethodPost, "/session", "", `{"name":"`+name+`"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("error logging in %s: %d %s", name, w.Code, w.Body.String())
+	}
+	var resp loginResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("error decoding login response: %v", err)
+	}
+	return resp.Identifier
+}
+
+// decode parses the JSON body of a response into v
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatalf("error decoding response %q: %v", w.Body.String(), err)
+	}
+}
@@ -0,0 +1,419 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"image"
+	_ "image/gif"  // Register decoders for the image dimensions
+	_ "image/jpeg" // of attachments
+	_ "image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// attachmentsDir holds the attachment files. Unlike uploads it is not served statically, downloads go through
+	// getAttachment, which checks the requester is in the conversation.
+	attachmentsDir = "attachments"
+
+	// maxAttachmentsUpload is the largest request accepted when sending attachments, all files together
+	maxAttachmentsUpload = int64(100 << 20)
+
+	// maxAttachmentsMemory is how much of the upload is kept in memory, the rest is buffered in temporary files
+	maxAttachmentsMemory = int64(32 << 20)
+)
+
+// attachmentMetadata is what the client tells about a file that the server cannot work out on its own
+type attachmentMetadata struct {
+	DurationMS *int64 `json:"duration_ms"`
+	Width      *int   `json:"width"`
+	Height     *int   `json:"height"`
+}
+
+// attachmentPath is the file an attachment with the given storage key is saved to
+func attachmentPath(storageKey string) string {
+	return filepath.Join(attachmentsDir, storageKey)
+}
+
+// validMetadata tells whether the metadata given by the client make sense
+func validMetadata(metadata []attachmentMetadata) bool {
+	for _, m := range metadata {
+		if (m.DurationMS != nil && *m.DurationMS < 0) || (m.Width != nil && *m.Width <= 0) ||
+			(m.Height != nil && *m.Height <= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// sendAttachments handles POST /conversations/:conversationId/attachments. The multipart form carries one or more
+// "files", an optional "caption" and an optional "metadata" JSON array with an entry per file. A JSON body sends
+// finalized resumable uploads instead, see sendUploadedAttachments.
+func (rt *_router) sendAttachments(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationID := ps.ByName("conversationId")
+
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Checked before the files are read, and again when the message is created
+	err = rt.db.CheckParticipant(r.Context(), conversationID, user.ID)
+	if errors.Is(err, database.ErrNotParticipant) {
+		http.Error(w, "Not a participant of the conversation", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrConversationNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		rt.sendUploadedAttachments(w, r, conversationID, user)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentsUpload)
+	if err := r.ParseMultipartForm(maxAttachmentsMemory); err != nil {
+		http.Error(w, "Failed to parse form. Make sure the files are under 100MB", http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		_ = r.MultipartForm.RemoveAll()
+	}()
+
+	files := r.MultipartForm.File["files"]
+	if len(files) == 0 || len(files) > database.MaxAttachmentsPerMessage {
+		http.Error(w, "Between 1 and 10 files are required", http.StatusBadRequest)
+		return
+	}
+
+	metadata := make([]attachmentMetadata, len(files))
+	if raw := r.FormValue("metadata"); raw != "" {
+		var given []attachmentMetadata
+		err := json.Unmarshal([]byte(raw), &given)
+		if err != nil || len(given) > len(files) || !validMetadata(given) {
+			http.Error(w, "Invalid metadata", http.StatusBadRequest)
+			return
+		}
+		copy(metadata, given)
+	}
+
+	if err := os.MkdirAll(attachmentsDir, 0755); err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Files stored for a message that is not created are left to the media garbage collection, since an identical
+	// file may already be shared with other messages
+	attachments := make([]database.Attachment, 0, len(files))
+	for i, header := range files {
+		attachment, err := storeAttachment(header, metadata[i])
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error storing attachment")
+			http.Error(w, "Failed to save attachment", http.StatusInternalServerError)
+			return
+		}
+		attachments = append(attachments, *attachment)
+	}
+
+	used, err := rt.db.GetStorageUsage(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	for _, a := range attachments {
+		used += a.Size
+	}
+	if used > userStorageQuota {
+		http.Error(w, database.ErrQuotaExceeded.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	caption := strings.TrimSpace(r.FormValue("caption"))
+	message, err := rt.db.CreateAttachmentMessage(r.Context(), conversationID, user.ID, caption, attachments)
+	if errors.Is(err, database.ErrBlocked) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrNotParticipant) {
+		http.Error(w, "Not a participant of the conversation", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrConversationNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create message", http.StatusInternalServerError)
+		return
+	}
+	rt.queueLinkPreview(message.ID, caption)
+	rt.emitMessageCreated(r.Context(), message)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(message)
+}
+
+// storeAttachment saves an uploaded file under its checksum, shared with any identical file, and describes it
+func storeAttachment(header *multipart.FileHeader, metadata attachmentMetadata) (*database.Attachment, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	a := database.Attachment{
+		ID:         uuid.New().String(),
+		Filename:   attachmentFilename(header.Filename),
+		MIMEType:   header.Header.Get("Content-Type"),
+		DurationMS: metadata.DurationMS,
+		Width:      metadata.Width,
+		Height:     metadata.Height,
+	}
+
+	a.MIMEType, err = detectMIMEType(file, a.MIMEType)
+	if err != nil {
+		return nil, err
+	}
+
+	a.StorageKey, a.Size, a.Checksum, err = storeMedia(file, attachmentsDir, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err == nil {
+		readImageSize(file, &a)
+	}
+	return &a, nil
+}
+
+// detectMIMEType returns the declared MIME type of a file, or the one sniffed from its content when the client did
+// not know it: browsers send application/octet-stream for types they do not recognize. The file is rewound.
+func detectMIMEType(file io.ReadSeeker, declared string) (string, error) {
+	mimeType := declared
+	if mediaType, _, err := mime.ParseMediaType(declared); err != nil || mediaType == "application/octet-stream" {
+		head := make([]byte, 512)
+		n, err := io.ReadFull(file, head)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		mimeType = http.DetectContentType(head[:n])
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return mimeType, nil
+}
+
+// readImageSize fills the dimensions of images in the common formats from their header instead of trusting the client
+func readImageSize(file io.Reader, a *database.Attachment) {
+	if !strings.HasPrefix(a.MIMEType, "image/") {
+		return
+	}
+	if config, _, err := image.DecodeConfig(file); err == nil {
+		a.Width, a.Height = &config.Width, &config.Height
+	}
+}
+
+// sendUploadedAttachments sends finalized resumable uploads of the user as attachments. The JSON body lists the
+// "upload_ids" in order, with an optional "caption" and "metadata" like the multipart form. The uploads are used up.
+func (rt *_router) sendUploadedAttachments(w http.ResponseWriter, r *http.Request, conversationID string,
+	user *database.User) {
+	var req struct {
+		UploadIDs []string             `json:"upload_ids"`
+		Caption   string               `json:"caption"`
+		Metadata  []attachmentMetadata `json:"metadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.UploadIDs) == 0 || len(req.UploadIDs) > database.MaxAttachmentsPerMessage {
+		http.Error(w, "Between 1 and 10 uploads are required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Metadata) > len(req.UploadIDs) || !validMetadata(req.Metadata) {
+		http.Error(w, "Invalid metadata", http.StatusBadRequest)
+		return
+	}
+	metadata := make([]attachmentMetadata, len(req.UploadIDs))
+	copy(metadata, req.Metadata)
+
+	// Holding the uploads keeps them from being deleted or sent twice meanwhile
+	for i, uploadID := range req.UploadIDs {
+		if !rt.uploadLocks.tryLock(uploadID) {
+			for _, locked := range req.UploadIDs[:i] {
+				rt.uploadLocks.unlock(locked)
+			}
+			http.Error(w, "Upload is busy", http.StatusLocked)
+			return
+		}
+	}
+	defer func() {
+		for _, uploadID := range req.UploadIDs {
+			rt.uploadLocks.unlock(uploadID)
+		}
+	}()
+
+	attachments := make([]database.Attachment, 0, len(req.UploadIDs))
+	for i, uploadID := range req.UploadIDs {
+		upload, err := rt.db.GetUpload(r.Context(), uploadID, user.ID)
+		if errors.Is(err, database.ErrUploadNotFound) {
+			http.Error(w, "Upload not found", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		if !upload.Completed {
+			http.Error(w, "Upload is not finalized", http.StatusConflict)
+			return
+		}
+
+		a := database.Attachment{
+			ID:         uuid.New().String(),
+			Filename:   upload.Filename,
+			MIMEType:   upload.MIMEType,
+			Size:       upload.Size,
+			Checksum:   upload.Checksum,
+			StorageKey: upload.StorageKey,
+			DurationMS: metadata[i].DurationMS,
+			Width:      metadata[i].Width,
+			Height:     metadata[i].Height,
+		}
+		if file, err := os.Open(attachmentPath(a.StorageKey)); err == nil {
+			readImageSize(file, &a)
+			_ = file.Close()
+		}
+		attachments = append(attachments, a)
+	}
+
+	message, err := rt.db.CreateAttachmentMessage(r.Context(), conversationID, user.ID, strings.TrimSpace(req.Caption),
+		attachments)
+	if errors.Is(err, database.ErrBlocked) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrNotParticipant) {
+		http.Error(w, "Not a participant of the conversation", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrConversationNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create message", http.StatusInternalServerError)
+		return
+	}
+	rt.queueLinkPreview(message.ID, message.ContentStr)
+	rt.emitMessageCreated(r.Context(), message)
+
+	// The attachments took the files over, so dropping the uploads leaves them in place
+	for _, uploadID := range req.UploadIDs {
+		if _, err := rt.db.DeleteUpload(r.Context(), uploadID); err != nil {
+			rt.baseLogger.WithError(err).Warnf("error deleting sent upload %s", uploadID)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(message)
+}
+
+// attachmentFilename keeps the base name of an uploaded file, without directories or control characters
+func attachmentFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+// getAttachment handles GET /attachments/:attachmentId, serving the file to the members of its conversation. Ranges
+// are supported so audio and video can be streamed and downloads resumed.
+func (rt *_router) getAttachment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	attachment, err := rt.db.GetAttachment(r.Context(), ps.ByName("attachmentId"))
+	if errors.Is(err, database.ErrAttachmentNotFound) {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Non-members get the same answer as for a missing attachment, so IDs cannot be probed
+	inConversation, err := rt.db.IsUserInConversation(r.Context(), attachment.ConversationID, user.ID)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if !inConversation {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
+	file, err := os.Open(attachmentPath(attachment.StorageKey))
+	if err != nil {
+		rt.baseLogger.WithError(err).Errorf("error opening attachment %s", attachment.ID)
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", attachment.MIMEType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", contentDisposition(attachment.MIMEType, attachment.Filename))
+	w.Header().Set("ETag", `"`+attachment.Checksum+`"`)
+	http.ServeContent(w, r, "", stat.ModTime(), file)
+}
+
+// contentDisposition shows media in the browser and downloads everything else, keeping the original filename
+func contentDisposition(mimeType string, filename string) string {
+	disposition := "attachment"
+	for _, prefix := range []string{"image/", "audio/", "video/"} {
+		if strings.HasPrefix(mimeType, prefix) && mimeType != "image/svg+xml" {
+			disposition = "inline"
+		}
+	}
+	if header := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); header != "" {
+		return header
+	}
+	return disposition
+}
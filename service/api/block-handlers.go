@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// blockUser handles POST /users/{username}/block, where username is the user to block
+func (rt *_router) blockUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rt.changeBlock(w, r, ps, true)
+}
+
+// unblockUser handles DELETE /users/{username}/block, where username is the user to unblock
+func (rt *_router) unblockUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rt.changeBlock(w, r, ps, false)
+}
+
+// changeBlock blocks or unblocks the user in the URL on behalf of the authenticated user
+func (rt *_router) changeBlock(w http.ResponseWriter, r *http.Request, ps httprouter.Params, block bool) {
+	username := ps.ByName("username")
+	if username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	// Verify authentication
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	targetID, err := rt.db.GetUserID(r.Context(), username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+
+	if block {
+		err = rt.db.BlockUser(r.Context(), user.ID, targetID)
+	} else {
+		err = rt.db.UnblockUser(r.Context(), user.ID, targetID)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// muteConversation handles POST /conversations/{conversationId}/mute
+func (rt *_router) muteConversation(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	if conversationId == "" {
+		http.Error(w, "Conversation ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Verify authentication
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// The body is optional: without "until" the conversation is muted until unmuted
+	var req struct {
+		Until *time.Time `json:"until"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Until != nil && !req.Until.After(time.Now()) {
+		http.Error(w, "Mute end must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	// Verify user is part of the conversation
+	isParticipant, err := rt.db.IsUserInConversation(r.Context(), conversationId, user.ID)
+	if err != nil {
+		http.Error(w, "Error checking conversation access", http.StatusInternalServerError)
+		return
+	}
+	if !isParticipant {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := rt.db.MuteConversation(r.Context(), conversationId, user.ID, req.Until); err != nil {
+		log.Printf("Error muting conversation: %v", err)
+		http.Error(w, "Failed to mute conversation", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unmuteConversation handles DELETE /conversations/{conversationId}/mute
+func (rt *_router) unmuteConversation(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	if conversationId == "" {
+		http.Error(w, "Conversation ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Verify authentication
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := rt.db.UnmuteConversation(r.Context(), conversationId, user.ID); err != nil {
+		log.Printf("Error unmuting conversation: %v", err)
+		http.Error(w, "Failed to unmute conversation", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
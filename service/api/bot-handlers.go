@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Scopes of bot tokens. A bot token only works on the routes registered with botRoute for one of its scopes; every
+// other route only accepts the session tokens of humans.
+const (
+	scopeConversationsRead = "conversations:read"
+	scopeMessagesRead      = "messages:read"
+	scopeMessagesWrite     = "messages:write"
+	scopeCommandsWrite     = "commands:write"
+)
+
+var botScopes = []string{scopeConversationsRead, scopeMessagesRead, scopeMessagesWrite, scopeCommandsWrite}
+
+// botUserKey is the request context key of the bot authenticated by botRoute
+type botUserKey struct{}
+
+// botRoute lets bots call a route with a token having scope. Bot tokens are checked here, so that a missing scope is
+// told apart from a bad token; getUserFromToken then returns the bot. Session tokens are left to the handler.
+func (rt *_router) botRoute(scope string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if strings.HasPrefix(token, database.BotTokenPrefix) {
+			bot, err := rt.db.GetUserByBotToken(r.Context(), token)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !hasScope(bot.Scopes, scope) {
+				http.Error(w, "Token is missing the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), botUserKey{}, bot))
+		}
+		handle(w, r, ps)
+	}
+}
+
+// hasScope tells whether scope is among scopes
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// createBot handles POST /bots, creating a bot owned by the user
+func (rt *_router) createBot(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	bot, err := rt.db.CreateBot(r.Context(), user.ID, req.Username, strings.TrimSpace(req.DisplayName))
+	if errors.Is(err, database.ErrUsernameTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(bot)
+}
+
+// getBots handles GET /bots, listing the bots of the user
+func (rt *_router) getBots(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	bots, err := rt.db.GetBots(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Error getting bots: %v", err)
+		http.Error(w, "Failed to get bots", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(bots)
+}
+
+// createBotToken handles POST /bots/:botId/tokens. The token is only shown in the response.
+func (rt *_router) createBotToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if !hasScope(botScopes, scope) {
+			http.Error(w, "Unknown scope "+scope, http.StatusBadRequest)
+			return
+		}
+		if !hasScope(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	token, err := rt.db.CreateBotToken(r.Context(), user.ID, ps.ByName("botId"), scopes)
+	if errors.Is(err, database.ErrBotNotFound) {
+		http.Error(w, "Bot not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating bot token: %v", err)
+		http.Error(w, "Failed to create bot token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(token)
+}
+
+// getBotTokens handles GET /bots/:botId/tokens
+func (rt *_router) getBotTokens(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := rt.db.GetBotTokens(r.Context(), user.ID, ps.ByName("botId"))
+	if errors.Is(err, database.ErrBotNotFound) {
+		http.Error(w, "Bot not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting bot tokens: %v", err)
+		http.Error(w, "Failed to get bot tokens", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(tokens)
+}
+
+// revokeBotToken handles DELETE /bots/:botId/tokens/:tokenId
+func (rt *_router) revokeBotToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err = rt.db.RevokeBotToken(r.Context(), user.ID, ps.ByName("botId"), ps.ByName("tokenId"))
+	if errors.Is(err, database.ErrBotNotFound) || errors.Is(err, database.ErrBotTokenNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking bot token: %v", err)
+		http.Error(w, "Failed to revoke bot token", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
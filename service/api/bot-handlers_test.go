@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+)
+
+// createTestBotToken creates a token of the bot with the given scopes
+func createTestBotToken(t *testing.T, h http.Handler, owner string, botID string, scopes string) database.BotToken {
+	t.Helper()
+
+	w := serveJSON(h, http.MethodPost, "/bots/"+botID+"/tokens", owner, `{"scopes":`+scopes+`}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("error creating bot token: %d %s", w.Code, w.Body.String())
+	}
+	var token database.BotToken
+	decode(t, w, &token)
+	return token
+}
+
+func TestBotRouteScopes(t *testing.T) {
+	rt, h := newTestRouter(t)
+	alice := login(t, h, "alice")
+
+	w := serveJSON(h, http.MethodPost, "/bots", alice, `{"username":"helperbot"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("error creating bot: %d %s", w.Code, w.Body.String())
+	}
+	var bot database.Bot
+	decode(t, w, &bot)
+	conversationID, err := rt.db.CreateConversation(context.Background(), []string{"alice", "helperbot"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	reader := createTestBotToken(t, h, alice, bot.ID, `["messages:read"]`)
+	writer := createTestBotToken(t, h, alice, bot.ID, `["messages:read","messages:write"]`)
+	revoked := createTestBotToken(t, h, alice, bot.ID, `["messages:write"]`)
+	if w := serve(h, http.MethodDelete, "/bots/"+bot.ID+"/tokens/"+revoked.ID, alice, nil, nil); w.Code !=
+		http.StatusNoContent {
+		t.Fatalf("error revoking bot token: %d %s", w.Code, w.Body.String())
+	}
+
+	messages := "/conversations/" + conversationID + "/messages"
+	for _, c := range []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"read with the read scope", http.MethodGet, messages, reader.Token, http.StatusOK},
+		{"write without the write scope", http.MethodPost, messages, reader.Token, http.StatusForbidden},
+		{"write with the write scope", http.MethodPost, messages, writer.Token, http.StatusOK},
+		{"revoked token", http.MethodPost, messages, revoked.Token, http.StatusUnauthorized},
+		{"unknown token", http.MethodGet, messages, database.BotTokenPrefix + "unknown", http.StatusUnauthorized},
+		{"route not open to bots", http.MethodGet, "/bots", writer.Token, http.StatusUnauthorized},
+		{"human on a bot route", http.MethodPost, messages, alice, http.StatusOK},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			w := serveJSON(h, c.method, c.path, c.token, `{"content":"beep"}`)
+			if w.Code != c.status {
+				t.Errorf("expected %d; got %d %s", c.status, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// The message written with the bot token is sent by the bot
+	var resp struct {
+		Messages []database.Message `json:"messages"`
+	}
+	decode(t, serve(h, http.MethodGet, messages, alice, nil, nil), &resp)
+	var fromBot int
+	for _, m := range resp.Messages {
+		if m.SenderID == bot.ID {
+			fromBot++
+		}
+	}
+	if fromBot != 1 {
+		t.Errorf("expected one message sent by the bot; got %d in %+v", fromBot, resp.Messages)
+	}
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// commandNamePattern is the format of the names of commands registered by bots
+var commandNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// maxCommandDescription is the length limit of the descriptions of commands
+const maxCommandDescription = 100
+
+// commandInfo describes a command available in a conversation
+type commandInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Usage       string `json:"usage,omitempty"`
+	Builtin     bool   `json:"builtin"`
+	Bot         string `json:"bot,omitempty"`
+}
+
+// registerCommand handles POST /conversations/:conversationId/commands, through which a bot in the conversation
+// registers a command answered by its URL. The secret signing the calls is only returned here.
+func (rt *_router) registerCommand(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !user.IsBot {
+		http.Error(w, "Only bots can register commands", http.StatusForbidden)
+		return
+	}
+	isParticipant, err := rt.db.IsUserInConversation(r.Context(), conversationId, user.ID)
+	if err != nil {
+		http.Error(w, "Error checking conversation access", http.StatusInternalServerError)
+		return
+	}
+	if !isParticipant {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		URL         string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.ToLower(strings.TrimPrefix(req.Name, "/"))
+	if !commandNamePattern.MatchString(req.Name) {
+		http.Error(w, "Name must be 1 to 32 lowercase letters, digits, - or _, starting with a letter",
+			http.StatusBadRequest)
+		return
+	}
+	if _, ok := builtinCommands[req.Name]; ok {
+		http.Error(w, "/"+req.Name+" is a built-in command", http.StatusConflict)
+		return
+	}
+	req.Description = strings.TrimSpace(req.Description)
+	if len(req.Description) > maxCommandDescription {
+		http.Error(w, "Description is too long", http.StatusBadRequest)
+		return
+	}
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		http.Error(w, "URL must be an absolute http or https URL", http.StatusBadRequest)
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	command := database.SlashCommand{
+		ConversationID: conversationId,
+		Name:           req.Name,
+		Description:    req.Description,
+		BotID:          user.ID,
+		URL:            target.String(),
+		Secret:         hex.EncodeToString(secret),
+	}
+	err = rt.db.RegisterCommand(r.Context(), &command)
+	if errors.Is(err, database.ErrCommandTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error registering command: %v", err)
+		http.Error(w, "Failed to register command", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(command)
+}
+
+// getCommands handles GET /conversations/:conversationId/commands, listing the built-in commands and those
+// registered by bots in the conversation
+func (rt *_router) getCommands(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	isParticipant, err := rt.db.IsUserInConversation(r.Context(), conversationId, user.ID)
+	if err != nil {
+		http.Error(w, "Error checking conversation access", http.StatusInternalServerError)
+		return
+	}
+	if !isParticipant {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	registered, err := rt.db.GetCommands(r.Context(), conversationId)
+	if err != nil {
+		log.Printf("Error getting commands: %v", err)
+		http.Error(w, "Failed to get commands", http.StatusInternalServerError)
+		return
+	}
+
+	commands := make([]commandInfo, 0, len(builtinCommands)+len(registered))
+	for name, builtin := range builtinCommands {
+		commands = append(commands, commandInfo{Name: name, Description: builtin.description, Usage: builtin.usage,
+			Builtin: true})
+	}
+	for _, c := range registered {
+		commands = append(commands, commandInfo{Name: c.Name, Description: c.Description, Bot: c.Bot})
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(commands)
+}
+
+// deleteCommand handles DELETE /conversations/:conversationId/commands/:name. The bot that registered the command
+// can remove it, and so can the participants of a direct conversation or the admins of a group.
+func (rt *_router) deleteCommand(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	name := ps.ByName("name")
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	command, err := rt.db.GetCommand(r.Context(), conversationId, name)
+	if errors.Is(err, database.ErrCommandNotFound) {
+		http.Error(w, "Command not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting command: %v", err)
+		http.Error(w, "Failed to get command", http.StatusInternalServerError)
+		return
+	}
+
+	if command.BotID != user.ID {
+		if user.IsBot {
+			http.Error(w, "Only the bot that registered the command can remove it", http.StatusForbidden)
+			return
+		}
+		if _, ok := rt.authorizeConversationAdmin(r.Context(), w, user, conversationId); !ok {
+			return
+		}
+	}
+
+	err = rt.db.DeleteCommand(r.Context(), conversationId, name)
+	if errors.Is(err, database.ErrCommandNotFound) {
+		http.Error(w, "Command not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting command: %v", err)
+		http.Error(w, "Failed to delete command", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+)
+
+// Messages starting with /name are commands. Built-in commands are Go functions available in every conversation;
+// bots register their own commands per conversation, which are POSTed to the bot like webhooks. The reply of the
+// command is posted as a message, and a notice is only returned to who typed the command.
+const (
+	// commandTimeout is how long a bot can take to answer a command
+	commandTimeout = 5 * time.Second
+
+	// commandMaxReplyBytes is how much of the answer of a bot is read
+	commandMaxReplyBytes = 64 << 10
+
+	// reminderInterval is how often due reminders are posted
+	reminderInterval = 30 * time.Second
+
+	// minReminderDelay and maxReminderDelay bound how far in the future /remind can go
+	minReminderDelay = time.Minute
+	maxReminderDelay = 365 * 24 * time.Hour
+)
+
+var (
+	// commandPattern matches a message invoking a command, capturing its name and arguments
+	commandPattern = regexp.MustCompile(`(?s)^/([A-Za-z][A-Za-z0-9_-]*)(?:\s+(.*))?$`)
+
+	// rollPattern matches the dice of /roll, like 2d6
+	rollPattern = regexp.MustCompile(`^(\d{1,2})d(\d{1,4})$`)
+)
+
+// commandInvocation is a command typed by a user in a conversation
+type commandInvocation struct {
+	user           *database.User
+	conversationID string
+	name           string
+	args           string
+}
+
+// commandReply is the outcome of a command. Text is posted in the conversation by Sender, or by who typed the command
+// when Sender is nil; Notice is only returned to who typed it.
+type commandReply struct {
+	Text   string
+	Sender *database.User
+	Notice string
+}
+
+// commandError is a mistake in the use of a command, reported to the user as is
+type commandError string
+
+func (e commandError) Error() string {
+	return string(e)
+}
+
+// builtinCommand is a command implemented by the server
+type builtinCommand struct {
+	description string
+	usage       string
+	run         func(ctx context.Context, rt *_router, inv commandInvocation) (*commandReply, error)
+}
+
+// builtinCommands are available in every conversation. help is added by init, since it lists them.
+var builtinCommands = map[string]builtinCommand{
+	"shrug": {
+		description: "Appends ¯\\_(ツ)_/¯ to your message",
+		usage:       "/shrug [message]",
+		run:         runShrug,
+	},
+	"roll": {
+		description: "Rolls dice, one six-sided die by default",
+		usage:       "/roll [NdM]",
+		run:         runRoll,
+	},
+	"remind": {
+		description: "Reminds you of something in this conversation later",
+		usage:       "/remind <10m|2h|3d> <message>",
+		run:         runRemind,
+	},
+}
+
+func init() {
+	builtinCommands["help"] = builtinCommand{
+		description: "Lists the commands available in this conversation",
+		usage:       "/help",
+		run:         runHelp,
+	}
+}
+
+// parseCommand tells whether content invokes a command, returning its lowercase name and its arguments
+func parseCommand(content string) (string, string, bool) {
+	match := commandPattern.FindStringSubmatch(strings.TrimSpace(content))
+	if match == nil {
+		return "", "", false
+	}
+	return strings.ToLower(match[1]), strings.TrimSpace(match[2]), true
+}
+
+// runCommand runs a command typed by user in a conversation and answers the request sending it
+func (rt *_router) runCommand(ctx context.Context, w http.ResponseWriter, user *database.User, conversationID string,
+	name string, args string) {
+	if !rt.authorizeParticipant(ctx, w, user, conversationID) {
+		return
+	}
+
+	inv := commandInvocation{user: user, conversationID: conversationID, name: name, args: args}
+	var reply *commandReply
+	var err error
+	if builtin, ok := builtinCommands[name]; ok {
+		reply, err = builtin.run(ctx, rt, inv)
+	} else {
+		reply, err = rt.runBotCommand(ctx, inv)
+	}
+	var usageErr commandError
+	if errors.As(err, &usageErr) || errors.Is(err, database.ErrCommandNotFound) {
+		if errors.Is(err, database.ErrCommandNotFound) {
+			err = fmt.Errorf("unknown command /%s, send //%s to post it as text", name, name)
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		rt.baseLogger.WithError(err).Warnf("error running command /%s", name)
+		http.Error(w, fmt.Sprintf("The /%s command failed", name), http.StatusBadGateway)
+		return
+	}
+
+	response := struct {
+		Command   string `json:"command"`
+		MessageID string `json:"message_id,omitempty"`
+		Notice    string `json:"notice,omitempty"`
+	}{Command: name, Notice: reply.Notice}
+	if reply.Text != "" {
+		sender := reply.Sender
+		if sender == nil {
+			sender = user
+		}
+		response.MessageID, err = rt.postTextMessage(ctx, conversationID, sender, reply.Text)
+		if errors.Is(err, database.ErrBlocked) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error posting command reply")
+			http.Error(w, "Failed to send message", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// runBotCommand POSTs a command to the bot that registered it in the conversation, signed like webhook deliveries,
+// and reads its reply. Commands of bots no longer in the conversation are unknown.
+func (rt *_router) runBotCommand(ctx context.Context, inv commandInvocation) (*commandReply, error) {
+	command, err := rt.db.GetCommand(ctx, inv.conversationID, inv.name)
+	if err != nil {
+		return nil, err
+	}
+	if present, err := rt.db.IsUserInConversation(ctx, inv.conversationID, command.BotID); err != nil {
+		return nil, err
+	} else if !present {
+		return nil, database.ErrCommandNotFound
+	}
+
+	payload, err := json.Marshal(map[string]string{
+		"command":         command.Name,
+		"args":            inv.args,
+		"conversation_id": inv.conversationID,
+		"user_id":         inv.user.ID,
+		"username":        inv.user.Username,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The bot is no longer waited for once who typed the command is gone
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, command.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "WASAText-Commands/1.0")
+	req.Header.Set("X-Command-Timestamp", timestamp)
+	req.Header.Set("X-Command-Signature", "sha256="+webhookSignature(command.Secret, timestamp, payload))
+
+	resp, err := rt.webhookClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var answer struct {
+		Text   string `json:"text"`
+		Notice string `json:"notice"`
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(io.LimitReader(resp.Body, commandMaxReplyBytes)).Decode(&answer); err != nil {
+			return nil, fmt.Errorf("invalid answer: %w", err)
+		}
+	}
+	return &commandReply{
+		Text:   strings.TrimSpace(answer.Text),
+		Sender: &database.User{ID: command.BotID, Username: command.Bot},
+		Notice: answer.Notice,
+	}, nil
+}
+
+// runHelp lists the built-in commands and those registered in the conversation
+func runHelp(ctx context.Context, rt *_router, inv commandInvocation) (*commandReply, error) {
+	lines := make([]string, 0, len(builtinCommands))
+	for _, builtin := range builtinCommands {
+		lines = append(lines, fmt.Sprintf("%s: %s", builtin.usage, builtin.description))
+	}
+	sort.Strings(lines)
+
+	commands, err := rt.db.GetCommands(ctx, inv.conversationID)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range commands {
+		lines = append(lines, fmt.Sprintf("/%s: %s (by %s)", c.Name, c.Description, c.Bot))
+	}
+	return &commandReply{Notice: strings.Join(lines, "\n")}, nil
+}
+
+// runShrug posts the arguments followed by a shrug
+func runShrug(_ context.Context, _ *_router, inv commandInvocation) (*commandReply, error) {
+	return &commandReply{Text: strings.TrimSpace(inv.args + ` ¯\_(ツ)_/¯`)}, nil
+}
+
+// runRoll rolls N dice of M faces and posts the result
+func runRoll(_ context.Context, _ *_router, inv commandInvocation) (*commandReply, error) {
+	dice := inv.args
+	if dice == "" {
+		dice = "1d6"
+	}
+	match := rollPattern.FindStringSubmatch(strings.ToLower(dice))
+	if match == nil {
+		return nil, commandError("usage: /roll [NdM], like /roll 2d6")
+	}
+	count, _ := strconv.Atoi(match[1])
+	faces, _ := strconv.Atoi(match[2])
+	if count < 1 || count > 20 || faces < 2 {
+		return nil, commandError("roll between 1 and 20 dice with at least 2 faces")
+	}
+
+	rolls := make([]string, count)
+	total := 0
+	for i := range rolls {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(faces)))
+		if err != nil {
+			return nil, err
+		}
+		roll := int(n.Int64()) + 1
+		total += roll
+		rolls[i] = strconv.Itoa(roll)
+	}
+	text := fmt.Sprintf("%s rolled %dd%d: %d", inv.user.Username, count, faces, total)
+	if count > 1 {
+		text = fmt.Sprintf("%s rolled %dd%d: %s = %d", inv.user.Username, count, faces, strings.Join(rolls, " + "),
+			total)
+	}
+	return &commandReply{Text: text}, nil
+}
+
+// runRemind schedules a reminder, posted as a system message when due
+func runRemind(ctx context.Context, rt *_router, inv commandInvocation) (*commandReply, error) {
+	fields := strings.Fields(inv.args)
+	if len(fields) < 2 {
+		return nil, commandError("usage: /remind <10m|2h|3d> <message>")
+	}
+	delay, err := parseReminderDelay(fields[0])
+	if err != nil || delay < minReminderDelay || delay > maxReminderDelay {
+		return nil, commandError("the delay must be between 1m and 365d, like 10m, 2h or 3d")
+	}
+	text := strings.TrimSpace(strings.TrimPrefix(inv.args, fields[0]))
+
+	dueAt := time.Now().Add(delay)
+	if err := rt.db.CreateReminder(ctx, inv.conversationID, inv.user.ID, text, dueAt); err != nil {
+		return nil, err
+	}
+	return &commandReply{Notice: "I will remind you on " + dueAt.UTC().Format(time.RFC1123)}, nil
+}
+
+// parseReminderDelay parses a Go duration, also accepting days like 3d
+func parseReminderDelay(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// sendReminders posts the due reminders every interval until the router is closed
+func (rt *_router) sendReminders(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rt.stop:
+			return
+		case <-ticker.C:
+			if _, err := rt.db.SendDueReminders(rt.ctx, time.Now()); err != nil {
+				rt.baseLogger.WithError(err).Error("error sending reminders")
+			}
+		}
+	}
+}
@@ -1,12 +1,17 @@
 package api
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
-	//"sapienzaProject/service/database"
-
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -27,7 +32,7 @@ func (rt *_router) getConversationMessages(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Verify user is part of the conversation
-	isParticipant, err := rt.db.IsUserInConversation(user.Username, conversationId)
+	isParticipant, err := rt.db.IsUserInConversation(r.Context(), conversationId, user.ID)
 	if err != nil {
 		http.Error(w, "Error checking conversation access", http.StatusInternalServerError)
 		return
@@ -38,13 +43,21 @@ func (rt *_router) getConversationMessages(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Get messages
-	messages, err := rt.db.GetConversationMessages(conversationId)
+	messages, err := rt.db.GetConversationMessages(r.Context(), conversationId, user.ID)
 	if err != nil {
 		log.Printf("Error getting messages: %v", err)
 		http.Error(w, "Failed to get messages", http.StatusInternalServerError)
 		return
 	}
 
+	rt.signMessageImages(messages)
+	rt.hideForwardOrigins(r.Context(), user, messages)
+
+	// Opening the conversation counts as seeing its mentions
+	if err := rt.db.MarkMentionsSeen(r.Context(), conversationId, user.ID); err != nil {
+		log.Printf("Error marking mentions as seen: %v", err)
+	}
+
 	// Return messages
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{
@@ -80,8 +93,29 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 		return
 	}
 
+	// A leading /command runs the command instead of being sent, a leading // sends the text with one slash
+	if name, args, ok := parseCommand(req.Content); ok {
+		rt.runCommand(r.Context(), w, user, conversationId, name, args)
+		return
+	}
+	if strings.HasPrefix(req.Content, "//") {
+		req.Content = req.Content[1:]
+	}
+
 	// Create message
-	messageId, err := rt.db.CreateMessage(conversationId, user.Username, req.Content)
+	messageId, err := rt.postTextMessage(r.Context(), conversationId, user, req.Content)
+	if errors.Is(err, database.ErrBlocked) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrNotParticipant) {
+		http.Error(w, "Not a participant of the conversation", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrConversationNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("Error creating message: %v", err)
 		http.Error(w, "Failed to send message", http.StatusInternalServerError)
@@ -98,6 +132,26 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 	}
 }
 
+// postTextMessage sends a text message, fetching its link preview and telling the webhooks of the conversation
+func (rt *_router) postTextMessage(ctx context.Context, conversationID string, sender *database.User,
+	content string) (string, error) {
+	messageID, err := rt.db.CreateMessage(ctx, conversationID, sender.ID, content)
+	if err != nil {
+		return "", err
+	}
+	rt.queueLinkPreview(messageID, content)
+	rt.emitMessageCreated(ctx, &database.Message{
+		ID:             messageID,
+		ConversationID: conversationID,
+		SenderID:       sender.ID,
+		Sender:         sender.Username,
+		Content:        sql.NullString{String: content, Valid: true},
+		Time:           time.Now(),
+		Kind:           database.MessageKindText,
+	})
+	return messageID, nil
+}
+
 // createConversation handles POST /conversations
 func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	// Get authenticated user
@@ -132,7 +186,11 @@ func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, _
 	log.Printf("Final participants list: %v", participants)
 
 	// Create conversation
-	conversationID, err := rt.db.CreateConversation(participants)
+	conversationID, err := rt.db.CreateConversation(r.Context(), participants)
+	if errors.Is(err, database.ErrBlocked) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		log.Printf("Create conversation error: %v", err)
 		http.Error(w, "Failed to create conversation", http.StatusInternalServerError)
@@ -151,7 +209,15 @@ func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, _
 	}
 }
 
-// getUserConversations maneja GET /users/{username}/conversations
+const (
+	// defaultInboxLimit is the number of conversations in a page of the inbox when the client does not choose one
+	defaultInboxLimit = 50
+
+	// maxInboxLimit caps the number of conversations in a page of the inbox
+	maxInboxLimit = 100
+)
+
+// getUserConversations maneja GET /users/{username}/conversations?limit=&cursor=
 func (rt *_router) getUserConversations(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	username := ps.ByName("username")
 	if username == "" {
@@ -172,17 +238,48 @@ func (rt *_router) getUserConversations(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	// Get conversations using username
-	conversations, err := rt.db.GetUserConversations(user.Username)
+	query := r.URL.Query()
+	limit := defaultInboxLimit
+	if l := query.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxInboxLimit {
+			http.Error(w, "Limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	conversations, nextCursor, err := rt.db.GetUserConversations(r.Context(), user.ID, limit, query.Get("cursor"))
+	if errors.Is(err, database.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Printf("Error getting conversations: %v", err)
 		http.Error(w, "Failed to get conversations", http.StatusInternalServerError)
 		return
 	}
 
+	// The other participant of a direct conversation is a contact, so only "nobody" hides the photo
+	for i := range conversations {
+		c := &conversations[i]
+		if c.IsGroup {
+			continue
+		}
+		if c.PhotoVisibility == database.VisibleToNobody {
+			c.PhotoURL = ""
+		}
+		c.PhotoURL = rt.signPhotoURL(c.PhotoURL, c.PhotoVisibility)
+	}
+
 	// Return conversations
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(conversations); err != nil {
+	if err := json.NewEncoder(w).Encode(struct {
+		Conversations []database.Conversation `json:"conversations"`
+		NextCursor    string                  `json:"next_cursor,omitempty"`
+	}{
+		Conversations: conversations,
+		NextCursor:    nextCursor,
+	}); err != nil {
 		http.Error(w, "Error encoding response", http.StatusInternalServerError)
 		return
 	}
@@ -200,7 +297,7 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 	}
 
 	// Check if user is in conversation
-	isParticipant, err := rt.db.IsUserInConversation(user.Username, conversationId)
+	isParticipant, err := rt.db.IsUserInConversation(r.Context(), conversationId, user.ID)
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -211,12 +308,20 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 	}
 
 	// Get messages
-	messages, err := rt.db.GetConversationMessages(conversationId)
+	messages, err := rt.db.GetConversationMessages(r.Context(), conversationId, user.ID)
 	if err != nil {
 		http.Error(w, "Failed to get messages", http.StatusInternalServerError)
 		return
 	}
 
+	rt.signMessageImages(messages)
+	rt.hideForwardOrigins(r.Context(), user, messages)
+
+	// Opening the conversation counts as seeing its mentions
+	if err := rt.db.MarkMentionsSeen(r.Context(), conversationId, user.ID); err != nil {
+		log.Printf("Error marking mentions as seen: %v", err)
+	}
+
 	// Send response
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{
@@ -241,7 +346,7 @@ func (rt *_router) getConversationDetails(w http.ResponseWriter, r *http.Request
 	log.Printf("Authenticated user: %s", user.Username)
 
 	// Verify user is part of the conversation
-	isParticipant, err := rt.db.IsUserInConversation(user.Username, conversationId)
+	isParticipant, err := rt.db.IsUserInConversation(r.Context(), conversationId, user.ID)
 	if err != nil {
 		log.Printf("Error checking participation: %v", err)
 		http.Error(w, "Error checking conversation access", http.StatusInternalServerError)
@@ -254,12 +359,13 @@ func (rt *_router) getConversationDetails(w http.ResponseWriter, r *http.Request
 	}
 
 	// Get conversation details
-	details, err := rt.db.GetConversationDetails(conversationId)
+	details, err := rt.db.GetConversationDetails(r.Context(), conversationId)
 	if err != nil {
 		log.Printf("Error getting conversation details: %v", err)
 		http.Error(w, "Failed to get conversation details", http.StatusInternalServerError)
 		return
 	}
+	rt.applyParticipantPrivacy(details, user)
 	log.Printf("Retrieved conversation details: %+v", details)
 
 	// Return conversation details
@@ -271,27 +377,94 @@ func (rt *_router) getConversationDetails(w http.ResponseWriter, r *http.Request
 	}
 }
 
-// Add this new handler function
-func (rt *_router) getAllUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+// messageTTLs are the disappearing messages settings clients can choose, in seconds
+var messageTTLs = map[string]int64{
+	"off": 0,
+	"1h":  60 * 60,
+	"24h": 24 * 60 * 60,
+	"7d":  7 * 24 * 60 * 60,
+	"90d": 90 * 24 * 60 * 60,
+}
+
+// setMessageTTL handles PUT /conversations/{conversationId}/ttl
+func (rt *_router) setMessageTTL(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	if conversationId == "" {
+		http.Error(w, "Conversation ID is required", http.StatusBadRequest)
+		return
+	}
+
 	// Get authenticated user
-	_, err := rt.getUserFromToken(r)
+	user, err := rt.getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	// Get all users
-	users, err := rt.db.GetAllUsers()
-	if err != nil {
-		log.Printf("Error getting users: %v", err)
-		http.Error(w, "Failed to get users", http.StatusInternalServerError)
+	// Parse request body
+	var req struct {
+		TTL string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	ttl, ok := messageTTLs[req.TTL]
+	if !ok {
+		http.Error(w, "TTL must be one of off, 1h, 24h, 7d, 90d", http.StatusBadRequest)
+		return
+	}
+
+	// In groups only admins can change the setting
+	if _, ok := rt.authorizeConversationAdmin(r.Context(), w, user, conversationId); !ok {
+		return
+	}
+
+	if err := rt.db.SetMessageTTL(r.Context(), conversationId, user.ID, ttl); err != nil {
+		log.Printf("Error setting message TTL: %v", err)
+		http.Error(w, "Failed to update disappearing messages", http.StatusInternalServerError)
 		return
 	}
 
-	// Return users
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(users); err != nil {
+	if err := json.NewEncoder(w).Encode(map[string]int64{
+		"message_ttl": ttl,
+	}); err != nil {
 		http.Error(w, "Error encoding response", http.StatusInternalServerError)
 		return
 	}
 }
+
+// authorizeConversationAdmin checks that user can manage the settings of a conversation: any participant of a direct
+// conversation, only admins in groups. It writes the error response and returns false otherwise.
+func (rt *_router) authorizeConversationAdmin(ctx context.Context, w http.ResponseWriter, user *database.User,
+	conversationId string) (*database.User, bool) {
+	isParticipant, err := rt.db.IsUserInConversation(ctx, conversationId, user.ID)
+	if err != nil {
+		http.Error(w, "Error checking conversation access", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !isParticipant {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	details, err := rt.db.GetConversationDetails(ctx, conversationId)
+	if err != nil {
+		log.Printf("Error getting conversation details: %v", err)
+		http.Error(w, "Failed to get conversation details", http.StatusInternalServerError)
+		return nil, false
+	}
+	if details.IsGroup {
+		isAdmin, err := rt.db.IsGroupAdmin(ctx, conversationId, user.ID)
+		if err != nil {
+			http.Error(w, "Error checking group admin", http.StatusInternalServerError)
+			return nil, false
+		}
+		if !isAdmin {
+			http.Error(w, "Only group admins can do this", http.StatusForbidden)
+			return nil, false
+		}
+	}
+	return user, true
+}
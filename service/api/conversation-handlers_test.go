@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+)
+
+func TestForwardOriginHidden(t *testing.T) {
+	rt, h := newTestRouter(t)
+	ctx := context.Background()
+	alice, bob, carol := login(t, h, "alice"), login(t, h, "bob"), login(t, h, "carol")
+
+	origin, err := rt.db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	target, err := rt.db.CreateConversation(ctx, []string{"alice", "carol"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	err = rt.db.SetPrivacy(ctx, userID(t, rt, bob), database.VisibleToEveryone, database.VisibleToEveryone,
+		database.VisibleToNobody)
+	if err != nil {
+		t.Fatalf("error updating privacy: %v", err)
+	}
+	w := serveJSON(h, http.MethodPost, "/conversations/"+origin+"/messages", bob, `{"content":"between us"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("error sending message: %d %s", w.Code, w.Body.String())
+	}
+	var sent struct {
+		MessageID string `json:"message_id"`
+	}
+	decode(t, w, &sent)
+	if w := serveJSON(h, http.MethodPost, "/messages/"+sent.MessageID+"/forward", alice,
+		`{"targets":["`+target+`"]}`); w.Code != http.StatusCreated && w.Code != http.StatusOK {
+		t.Fatalf("error forwarding message: %d %s", w.Code, w.Body.String())
+	}
+
+	// Both routes reading the messages of a conversation hide the origin alike
+	for _, path := range []string{"/conversations/" + target, "/conversations/" + target + "/messages"} {
+		for _, c := range []struct {
+			name   string
+			token  string
+			origin database.ForwardedFrom
+		}{
+			{"carol", carol, database.ForwardedFrom{}},
+			{"alice", alice, database.ForwardedFrom{SenderID: userID(t, rt, bob), Sender: "bob",
+				ConversationID: origin, MessageID: sent.MessageID}},
+		} {
+			var resp struct {
+				Messages []database.Message `json:"messages"`
+			}
+			decode(t, serve(h, http.MethodGet, path, c.token, nil, nil), &resp)
+			if len(resp.Messages) != 1 || resp.Messages[0].ForwardedFrom == nil {
+				t.Fatalf("expected the forwarded message on %s; got %+v", path, resp.Messages)
+			}
+			if got := *resp.Messages[0].ForwardedFrom; got != c.origin {
+				t.Errorf("expected %s to see the origin %+v on %s; got %+v", c.name, c.origin, path, got)
+			}
+		}
+	}
+}
@@ -2,14 +2,12 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
-	"time"
 
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -47,7 +45,7 @@ func (rt *_router) createGroup(w http.ResponseWriter, r *http.Request, _ httprou
 	}
 
 	// Create group with members
-	group, err := rt.db.CreateGroup(requestBody.Name, user.ID, requestBody.Members)
+	group, err := rt.db.CreateGroup(r.Context(), requestBody.Name, user.ID, requestBody.Members)
 	if err != nil {
 		http.Error(w, "Failed to create group: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -76,7 +74,7 @@ func (rt *_router) updateGroupName(w http.ResponseWriter, r *http.Request, ps ht
 	}
 
 	// Verify authentication
-	_, err := rt.getUserFromToken(r)
+	user, err := rt.getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -92,7 +90,11 @@ func (rt *_router) updateGroupName(w http.ResponseWriter, r *http.Request, ps ht
 	}
 
 	// Update name
-	err = rt.db.UpdateGroupName(groupID, requestBody.NewName)
+	err = rt.db.UpdateGroupName(r.Context(), groupID, user.ID, requestBody.NewName)
+	if errors.Is(err, database.ErrNotParticipant) {
+		http.Error(w, "Only members can rename the group", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to update group name: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -110,7 +112,7 @@ func (rt *_router) updateGroupPhoto(w http.ResponseWriter, r *http.Request, ps h
 	}
 
 	// Verify authentication
-	_, err := rt.getUserFromToken(r)
+	user, err := rt.getUserFromToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -136,22 +138,7 @@ func (rt *_router) updateGroupPhoto(w http.ResponseWriter, r *http.Request, ps h
 		return
 	}
 
-	// Generate unique filename
-	filename := fmt.Sprintf("group_%s_%d%s",
-		groupID,
-		time.Now().UnixNano(),
-		filepath.Ext(header.Filename))
-
-	// Save file to uploads/images directory
-	filepath := filepath.Join("uploads", "images", filename)
-	dst, err := os.Create(filepath)
-	if err != nil {
-		http.Error(w, "Failed to create file", http.StatusInternalServerError)
-		return
-	}
-	defer dst.Close()
-
-	_, err = io.Copy(dst, file)
+	filename, err := storeImage(file, header.Filename)
 	if err != nil {
 		http.Error(w, "Failed to save file", http.StatusInternalServerError)
 		return
@@ -159,7 +146,12 @@ func (rt *_router) updateGroupPhoto(w http.ResponseWriter, r *http.Request, ps h
 
 	// Create the URL that points to your backend server
 	photoURL := fmt.Sprintf("http://localhost:3000/uploads/images/%s", filename)
-	if err := rt.db.UpdateGroupPhoto(groupID, photoURL); err != nil {
+	err = rt.db.UpdateGroupPhoto(r.Context(), groupID, user.ID, photoURL)
+	if errors.Is(err, database.ErrNotParticipant) {
+		http.Error(w, "Only members can change the group photo", http.StatusForbidden)
+		return
+	}
+	if err != nil {
 		http.Error(w, "Failed to update photo in database", http.StatusInternalServerError)
 		return
 	}
@@ -192,7 +184,7 @@ func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprou
 	}
 
 	// Abandonar grupo
-	err = rt.db.LeaveGroup(groupID, user.ID)
+	err = rt.db.LeaveGroup(r.Context(), groupID, user.ID)
 	if err != nil {
 		http.Error(w, "Failed to leave group", http.StatusInternalServerError)
 		return
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Images sent in conversations are only served to the members of those conversations, either authenticated with
+// their bearer token or through a signed URL: <img> tags cannot send headers, so the API hands out image URLs
+// carrying an expiry and an HMAC of the path. Group photos, and profile photos their owners show to everyone, stay
+// public; the other profile photos are handed out with signed URLs to the viewers allowed to see them.
+const (
+	// signedURLLifetime is how long a signed image URL works at least. URLs are signed for fixed windows, so that the
+	// same image keeps the same URL, and the browser cache, for a while.
+	signedURLLifetime = time.Hour
+
+	// publicImageMaxAge is how long browsers can cache a public photo before revalidating its ETag
+	publicImageMaxAge = 24 * time.Hour
+
+	// publicBaseURL is the address images are linked from in API responses
+	publicBaseURL = "http://localhost:3000"
+)
+
+// signImageURL turns the path of an image sent in a conversation, like "/uploads/images/<name>", into a signed URL
+func (rt *_router) signImageURL(imagePath string) string {
+	window := int64(signedURLLifetime / time.Second)
+	expires := (time.Now().Unix()/window + 2) * window
+	return publicBaseURL + imagePath + "?expires=" + strconv.FormatInt(expires, 10) +
+		"&sig=" + rt.imageSignature(imagePath, expires)
+}
+
+// imageSignature is the HMAC of an image path and the expiry of its URL
+func (rt *_router) imageSignature(imagePath string, expires int64) string {
+	mac := hmac.New(sha256.New, rt.mediaSigningKey)
+	mac.Write([]byte(imagePath + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validImageSignature checks the expiry and signature carried by the query of an image URL
+func (rt *_router) validImageSignature(imagePath string, r *http.Request) bool {
+	query := r.URL.Query()
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return false
+	}
+	expected := rt.imageSignature(imagePath, expires)
+	return hmac.Equal([]byte(query.Get("sig")), []byte(expected))
+}
+
+// signPhotoURL returns the URL a viewer allowed to see a profile photo loads it from. Photos shown to everyone are
+// public; the others are signed, as they are only served to who can see them and <img> tags send no bearer token.
+func (rt *_router) signPhotoURL(photoURL string, visibility string) string {
+	i := strings.Index(photoURL, "/uploads/images/")
+	if visibility == database.VisibleToEveryone || i < 0 {
+		return photoURL
+	}
+	return rt.signImageURL(photoURL[i:])
+}
+
+// signMessageImages replaces the image URLs of messages with signed URLs
+func (rt *_router) signMessageImages(messages []database.Message) {
+	for i := range messages {
+		if messages[i].ImageURL.Valid {
+			messages[i].ImageURLStr = rt.signImageURL(messages[i].ImageURL.String)
+		}
+	}
+}
+
+// getImage handles GET /uploads/images/:filename. Public photos are cacheable; other images need a signed URL or the
+// bearer token of a user who can see them: a member of a conversation they were sent to, or a viewer the privacy
+// settings of a profile photo allow.
+func (rt *_router) getImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	filename := ps.ByName("filename")
+	if filename == "" || strings.HasPrefix(filename, ".") {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	imagePath := "/uploads/images/" + filename
+
+	public, err := rt.db.IsPublicImage(r.Context(), imagePath)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case public:
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(publicImageMaxAge/time.Second)))
+	case rt.validImageSignature(imagePath, r):
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(signedURLLifetime/time.Second)))
+	default:
+		user, err := rt.getUserFromToken(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		allowed, err := rt.db.CanAccessImage(r.Context(), user.ID, imagePath)
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			// Not telling apart images the user cannot see from missing ones
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+
+	file, err := os.Open(filepath.Join(imagesDir, filename))
+	if os.IsNotExist(err) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Files are named after the hash of their content, which never changes
+	w.Header().Set("ETag", `"`+strings.TrimSuffix(filename, filepath.Ext(filename))+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	http.ServeContent(w, r, filename, info.ModTime(), file)
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+)
+
+// storeTestImage writes an image file and returns its path as saved in the database
+func storeTestImage(t *testing.T, name string) string {
+	t.Helper()
+
+	if err := os.MkdirAll(imagesDir, 0755); err != nil {
+		t.Fatalf("error creating images directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(imagesDir, name), []byte("\x89PNG\r\n\x1a\n"), 0644); err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	return "/uploads/images/" + name
+}
+
+// postImageMessage sends a PNG image to a conversation through the image message form
+func postImageMessage(t *testing.T, h http.Handler, token string, conversationID string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="image"; filename="photo.png"`)
+	header.Set("Content-Type", "image/png")
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatalf("error creating form: %v", err)
+	}
+	_, _ = part.Write([]byte("\x89PNG\r\n\x1a\n"))
+	if err := form.Close(); err != nil {
+		t.Fatalf("error creating form: %v", err)
+	}
+	return serve(h, http.MethodPost, "/conversations/"+conversationID+"/image-message", token, &body,
+		map[string]string{"Content-Type": form.FormDataContentType()})
+}
+
+// userID returns the ID of the user with the given token
+func userID(t *testing.T, rt *_router, token string) string {
+	t.Helper()
+
+	user, err := rt.db.GetUserByToken(context.Background(), token)
+	if err != nil {
+		t.Fatalf("error getting user: %v", err)
+	}
+	return user.ID
+}
+
+// relativeURL strips the scheme and host of an URL returned by the API
+func relativeURL(t *testing.T, rawURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("error parsing URL %q: %v", rawURL, err)
+	}
+	return u.RequestURI()
+}
+
+func TestSignedImageURL(t *testing.T) {
+	rt, h := newTestRouter(t)
+	ctx := context.Background()
+	alice, bob, carol := login(t, h, "alice"), login(t, h, "bob"), login(t, h, "carol")
+
+	conversationID, err := rt.db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	imagePath := storeTestImage(t, "chat.png")
+	otherPath := storeTestImage(t, "other.png")
+	if _, err := rt.db.CreateImageMessage(ctx, conversationID, userID(t, rt, alice), imagePath); err != nil {
+		t.Fatalf("error sending image: %v", err)
+	}
+
+	signed := relativeURL(t, rt.signImageURL(imagePath))
+	query, _ := url.ParseQuery(strings.SplitN(signed, "?", 2)[1])
+	expires, _ := strconv.ParseInt(query.Get("expires"), 10, 64)
+	expired := time.Now().Unix() - 1
+
+	for _, c := range []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{"signed URL", signed, "", http.StatusOK},
+		{"no signature", imagePath, "", http.StatusUnauthorized},
+		{"tampered signature", imagePath + "?expires=" + query.Get("expires") + "&sig=" + rt.imageSignature(imagePath,
+			expires+1), "", http.StatusUnauthorized},
+		{"later expiry", imagePath + "?expires=" + strconv.FormatInt(expires+3600, 10) + "&sig=" + query.Get("sig"), "",
+			http.StatusUnauthorized},
+		{"expired", imagePath + "?expires=" + strconv.FormatInt(expired, 10) + "&sig=" +
+			rt.imageSignature(imagePath, expired), "", http.StatusUnauthorized},
+		{"signature of another image", otherPath + "?" + strings.SplitN(signed, "?", 2)[1], "", http.StatusUnauthorized},
+		{"member", imagePath, bob, http.StatusOK},
+		{"not a member", imagePath, carol, http.StatusNotFound},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			w := serve(h, http.MethodGet, c.path, c.token, nil, nil)
+			if w.Code != c.status {
+				t.Errorf("expected %d; got %d %s", c.status, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestProfilePhotoVisibility(t *testing.T) {
+	rt, h := newTestRouter(t)
+	ctx := context.Background()
+	alice, bob, carol := login(t, h, "alice"), login(t, h, "bob"), login(t, h, "carol")
+	aliceID := userID(t, rt, alice)
+
+	if _, err := rt.db.CreateConversation(ctx, []string{"alice", "bob"}); err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	photoPath := storeTestImage(t, "avatar.png")
+	if err := rt.db.UpdateUserPhoto(ctx, aliceID, publicBaseURL+photoPath); err != nil {
+		t.Fatalf("error updating photo: %v", err)
+	}
+
+	w := serve(h, http.MethodGet, photoPath, "", nil, nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Cache-Control"), "public") {
+		t.Errorf("expected a photo shown to everyone to be public; got %d with %v", w.Code, w.Header())
+	}
+
+	err := rt.db.SetPrivacy(ctx, aliceID, database.VisibleToContacts, database.VisibleToEveryone,
+		database.VisibleToEveryone)
+	if err != nil {
+		t.Fatalf("error updating privacy: %v", err)
+	}
+	for _, c := range []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"anonymous", "", http.StatusUnauthorized},
+		{"contact", bob, http.StatusOK},
+		{"owner", alice, http.StatusOK},
+		{"not a contact", carol, http.StatusNotFound},
+	} {
+		w := serve(h, http.MethodGet, photoPath, c.token, nil, nil)
+		if w.Code != c.status {
+			t.Errorf("expected %s to get %d; got %d", c.name, c.status, w.Code)
+		}
+	}
+
+	// The contact gets a signed URL for the <img> tag, the others no photo at all
+	var profile database.Profile
+	decode(t, serve(h, http.MethodGet, "/users/alice/profile", bob, nil, nil), &profile)
+	if !strings.Contains(profile.PhotoURL, "sig=") {
+		t.Fatalf("expected a signed photo URL for the contact; got %q", profile.PhotoURL)
+	}
+	if w := serve(h, http.MethodGet, relativeURL(t, profile.PhotoURL), "", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("expected the signed photo URL to work; got %d", w.Code)
+	}
+	profile = database.Profile{}
+	decode(t, serve(h, http.MethodGet, "/users/alice/profile", carol, nil, nil), &profile)
+	if profile.PhotoURL != "" || len(profile.AvatarHistory) != 0 {
+		t.Errorf("expected no photo for who is not a contact; got %+v", profile)
+	}
+}
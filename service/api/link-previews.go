@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/linkpreview"
+)
+
+const (
+	// linkPreviewWorkers is how many link previews are fetched at the same time
+	linkPreviewWorkers = 4
+
+	// linkPreviewQueueSize is how many messages can wait for their preview. Messages sent while the queue is full get
+	// no preview.
+	linkPreviewQueueSize = 100
+
+	// linkPreviewTimeout is how long fetching a page can take
+	linkPreviewTimeout = 5 * time.Second
+
+	// linkPreviewMaxBytes is how much of a page is read looking for its preview
+	linkPreviewMaxBytes = 512 << 10
+)
+
+// linkPreviewJob asks to fetch the preview of a link sent in a message
+type linkPreviewJob struct {
+	messageID string
+	link      string
+}
+
+// queueLinkPreview schedules fetching the preview of the first link in the content of a message, if any. It never
+// blocks the request sending the message.
+func (rt *_router) queueLinkPreview(messageID string, content string) {
+	link := linkpreview.FindURL(content)
+	if link == "" {
+		return
+	}
+	select {
+	case rt.linkPreviews <- linkPreviewJob{messageID: messageID, link: link}:
+	default:
+		rt.baseLogger.Warnf("link preview queue full, skipping message %s", messageID)
+	}
+}
+
+// fetchLinkPreviews fetches the queued link previews until the router is closed
+func (rt *_router) fetchLinkPreviews() {
+	for {
+		select {
+		case <-rt.stop:
+			return
+		case job := <-rt.linkPreviews:
+			preview, err := rt.linkPreviewer.Fetch(rt.ctx, job.link)
+			if errors.Is(err, linkpreview.ErrNoPreview) || errors.Is(err, linkpreview.ErrForbiddenAddress) {
+				continue
+			}
+			if err != nil {
+				rt.baseLogger.WithError(err).Debugf("error fetching link preview of %s", job.link)
+				continue
+			}
+
+			err = rt.db.SetLinkPreview(rt.ctx, job.messageID, database.LinkPreview{
+				URL:         preview.URL,
+				Title:       preview.Title,
+				Description: preview.Description,
+				ImageURL:    preview.ImageURL,
+			})
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("error saving link preview")
+			}
+		}
+	}
+}
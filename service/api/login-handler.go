@@ -28,7 +28,7 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, _ httprouter.
 	}
 
 	// Crear sesión
-	session, err := rt.db.CreateSession(req.Name)
+	session, err := rt.db.CreateSession(r.Context(), req.Name)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
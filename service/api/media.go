@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Media files are content addressed: they are named after the SHA-256 of their content, so that storing the same
+// photo or attachment twice keeps a single file. Since a file can be shared by users, groups and messages, deleting
+// any of them only drops a reference; the garbage collection removes the files nothing references anymore.
+const (
+	// imagesDir is where profile photos, group photos and image messages are stored, served under /uploads/images
+	imagesDir = "uploads/images"
+
+	// mediaGCInterval is how often unreferenced media files are removed
+	mediaGCInterval = time.Hour
+
+	// mediaGCGracePeriod protects files stored recently, whose reference may not be saved yet
+	mediaGCGracePeriod = time.Hour
+)
+
+// mediaDirs are the directories the garbage collection looks into. Paths of files are relative to the working
+// directory, as in database.GetMediaReferences.
+var mediaDirs = []string{"uploads", attachmentsDir}
+
+// imageExtensions maps the sniffed type of an image to the extension of its file, used to serve the right type
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// mediaGCReport describes the files a garbage collection removed or, in a dry run, would remove
+type mediaGCReport struct {
+	DryRun           bool     `json:"dry_run"`
+	Files            int      `json:"files"`
+	ReclaimableBytes int64    `json:"reclaimable_bytes"`
+	Paths            []string `json:"paths"`
+}
+
+// storeMedia saves the content of src into dir, named after its SHA-256 followed by ext. When the same content is
+// already stored the existing file is kept, and touched so that the garbage collection does not remove it before the
+// new reference is saved. It returns the name of the file, the size and the checksum of the content.
+func storeMedia(src io.Reader, dir string, ext string) (string, int64, string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, "", err
+	}
+
+	tmp := filepath.Join(dir, ".tmp-"+uuid.New().String())
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return "", 0, "", err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(dst, hash), src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", 0, "", err
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	name := checksum + ext
+	if err := moveIntoStore(tmp, filepath.Join(dir, name)); err != nil {
+		return "", 0, "", err
+	}
+	return name, size, checksum, nil
+}
+
+// moveIntoStore moves a file to its content addressed path, dropping it if the same content is already there
+func moveIntoStore(src string, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		now := time.Now()
+		if err := os.Chtimes(dst, now, now); err != nil {
+			return err
+		}
+		return os.Remove(src)
+	}
+	if err := os.Rename(src, dst); err != nil {
+		_ = os.Remove(src)
+		return err
+	}
+	return nil
+}
+
+// linkIntoStore makes a file also available at its content addressed path, leaving the source in place. The stored
+// file is touched either way, so that the garbage collection does not remove it before its reference is saved.
+func linkIntoStore(src string, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		if err := os.Link(src, dst); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	now := time.Now()
+	return os.Chtimes(dst, now, now)
+}
+
+// storeImage saves an uploaded image under imagesDir, returning its file name. The extension comes from the content,
+// and from the name of the file only for types the server does not sniff.
+func storeImage(file io.ReadSeeker, filename string) (string, error) {
+	mimeType, err := detectMIMEType(file, "")
+	if err != nil {
+		return "", err
+	}
+	ext, ok := imageExtensions[mimeType]
+	if !ok {
+		ext = strings.ToLower(filepath.Ext(filepath.Base(filename)))
+	}
+	name, _, _, err := storeMedia(file, imagesDir, ext)
+	return name, err
+}
+
+// collectMediaGarbage removes the unreferenced media files every interval until the router is closed
+func (rt *_router) collectMediaGarbage(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rt.stop:
+			return
+		case <-ticker.C:
+			report, err := rt.runMediaGC(rt.ctx, false)
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("error collecting media garbage")
+				continue
+			}
+			if report.Files > 0 {
+				rt.baseLogger.Infof("removed %d unreferenced media files, %d bytes", report.Files,
+					report.ReclaimableBytes)
+			}
+		}
+	}
+}
+
+// runMediaGC finds the media files nothing references that were not touched for the grace period, and removes them
+// unless dryRun is set. Partial uploads and temporary files are left alone, they belong to uploads in progress.
+func (rt *_router) runMediaGC(ctx context.Context, dryRun bool) (*mediaGCReport, error) {
+	refs, err := rt.db.GetMediaReferences(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := mediaGCReport{DryRun: dryRun, Paths: make([]string, 0)}
+	cutoff := time.Now().Add(-mediaGCGracePeriod)
+	for _, dir := range mediaDirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			name := info.Name()
+			if info.IsDir() || strings.HasSuffix(name, ".part") || strings.HasPrefix(name, ".tmp-") {
+				return nil
+			}
+			rel := filepath.ToSlash(path)
+			if refs[rel] > 0 || info.ModTime().After(cutoff) {
+				return nil
+			}
+
+			if !dryRun {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					rt.baseLogger.WithError(err).Warnf("error removing media file %s", path)
+					return nil
+				}
+			}
+			report.Files++
+			report.ReclaimableBytes += info.Size()
+			report.Paths = append(report.Paths, rel)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &report, nil
+}
+
+// removePartialUploads removes from disk the partial files of dropped uploads
+func (rt *_router) removePartialUploads(keys []string) {
+	for _, key := range keys {
+		path := partialUploadPath(key)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			rt.baseLogger.WithError(err).Warnf("error removing partial upload %s", path)
+		}
+	}
+}
+
+// isAdmin checks the request carries the admin token as bearer token. With no token configured nobody is admin.
+func (rt *_router) isAdmin(r *http.Request) bool {
+	authHeader := r.Header.Get("Authorization")
+	if rt.adminToken == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(authHeader[7:]), []byte(rt.adminToken)) == 1
+}
+
+// getMediaGC handles GET /admin/media/gc, reporting what a garbage collection would remove without removing it
+func (rt *_router) getMediaGC(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rt.serveMediaGC(w, r, true)
+}
+
+// collectMedia handles POST /admin/media/gc, removing the unreferenced media files now
+func (rt *_router) collectMedia(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rt.serveMediaGC(w, r, false)
+}
+
+func (rt *_router) serveMediaGC(w http.ResponseWriter, r *http.Request, dryRun bool) {
+	if !rt.isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	report, err := rt.runMediaGC(r.Context(), dryRun)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error collecting media garbage")
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
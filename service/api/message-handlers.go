@@ -1,15 +1,17 @@
 package api
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
-	"os"
-	"path"
 	"strings"
+	"time"
 
-	"github.com/google/uuid"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -30,25 +32,43 @@ func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps http
 	}
 
 	// Verificar que el usuario es el remitente del mensaje
-	message, err := rt.db.GetMessageByID(messageID)
-	if err != nil || message.Sender != user.Username {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	message, ok := rt.authorizeMessage(r.Context(), w, user, conversationID, messageID)
+	if !ok {
+		return
+	}
+	if message.SenderID != user.ID {
+		http.Error(w, "Only the sender can delete the message", http.StatusForbidden)
 		return
 	}
 
 	// Eliminar mensaje
-	err = rt.db.DeleteMessage(messageID)
-	if err != nil {
+	if err := rt.db.DeleteMessage(r.Context(), messageID); err != nil {
 		http.Error(w, "Failed to delete message", http.StatusInternalServerError)
 		return
 	}
+	rt.emitWebhookEvent(r.Context(), message.ConversationID, webhookMessageDeleted, map[string]string{
+		"message_id": messageID,
+		"deleted_by": user.ID,
+	})
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// forwardMessage maneja POST conversations/{conversationId}/messages/{messageId}
+// maxForwardTargets is how many conversations and groups a message can be forwarded to at once
+const maxForwardTargets = 20
+
+// forwardResult is the outcome of a forward for one target. When a target does not accept the message no target gets
+// it: the others are reported with status 424 (Failed Dependency).
+type forwardResult struct {
+	ConversationID string            `json:"conversation_id"`
+	Status         int               `json:"status"`
+	Error          string            `json:"error,omitempty"`
+	Message        *database.Message `json:"message,omitempty"`
+}
+
+// forwardMessage maneja POST /messages/{messageId}/forward, forwarding a message the user can read to the
+// conversations and groups they take part in
 func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	targetConversationID := ps.ByName("conversationId")
 	messageID := ps.ByName("messageId")
 
 	// Get authenticated user
@@ -58,22 +78,99 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 		return
 	}
 
-	// Forward the message using the updated database method
-	newMessage, err := rt.db.ForwardMessage(messageID, targetConversationID, user.Username)
-	if err != nil {
+	var req struct {
+		Targets []string `json:"targets"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Targets) == 0 || len(req.Targets) > maxForwardTargets {
+		http.Error(w, fmt.Sprintf("Between 1 and %d targets are required", maxForwardTargets), http.StatusBadRequest)
+		return
+	}
+
+	results, err := rt.db.ForwardMessage(r.Context(), messageID, user.ID, req.Targets)
+	switch {
+	case errors.Is(err, database.ErrMessageNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, database.ErrNotForwardable):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil && !errors.Is(err, database.ErrForwardRejected):
+		log.Printf("Error forwarding message: %v", err)
 		http.Error(w, "Failed to forward message", http.StatusInternalServerError)
 		return
 	}
 
-	// Return the new message
+	status := http.StatusCreated
+	response := make([]forwardResult, len(results))
+	for i, result := range results {
+		response[i] = forwardResult{ConversationID: result.ConversationID, Status: http.StatusCreated}
+		switch {
+		case result.Err != nil:
+			response[i].Status = http.StatusForbidden
+			response[i].Error = result.Err.Error()
+			status = http.StatusForbidden
+		case result.Message == nil:
+			response[i].Status = http.StatusFailedDependency
+		default:
+			rt.emitMessageCreated(r.Context(), result.Message)
+			if result.Message.ImageURL.Valid {
+				result.Message.ImageURLStr = rt.signImageURL(result.Message.ImageURL.String)
+			}
+			rt.hideForwardOrigins(r.Context(), user, []database.Message{*result.Message})
+			response[i].Message = result.Message
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(newMessage); err != nil {
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": response,
+	}); err != nil {
 		http.Error(w, "Error encoding response", http.StatusInternalServerError)
 		return
 	}
 }
 
+// hideForwardOrigins removes from forwarded messages what viewer may not know of their origin: the conversation and
+// message are only shown to who takes part in that conversation, and the original sender only as far as their
+// forward visibility allows
+func (rt *_router) hideForwardOrigins(ctx context.Context, viewer *database.User, messages []database.Message) {
+	participant := make(map[string]bool)
+	for i := range messages {
+		origin := messages[i].ForwardedFrom
+		if origin == nil {
+			continue
+		}
+
+		inOrigin, checked := participant[origin.ConversationID]
+		if !checked {
+			var err error
+			if inOrigin, err = rt.db.IsUserInConversation(ctx, origin.ConversationID, viewer.ID); err != nil {
+				log.Printf("Error checking forward origin: %v", err)
+			}
+			participant[origin.ConversationID] = inOrigin
+		}
+		if inOrigin {
+			continue
+		}
+		origin.ConversationID = ""
+		origin.MessageID = ""
+
+		visible, err := rt.canSee(ctx, viewer, origin.SenderID, origin.Visibility)
+		if err != nil {
+			log.Printf("Error checking forward visibility: %v", err)
+		}
+		if !visible {
+			origin.SenderID = ""
+			origin.Sender = ""
+		}
+	}
+}
+
 func (rt *_router) replyToMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	conversationID := ps.ByName("conversationId")
 	messageID := ps.ByName("messageId")
@@ -95,11 +192,38 @@ func (rt *_router) replyToMessage(w http.ResponseWriter, r *http.Request, ps htt
 	}
 
 	// Create reply message
-	newMessageID, err := rt.db.CreateReplyMessage(conversationID, user.Username, req.Content, messageID)
+	newMessageID, err := rt.db.CreateReplyMessage(r.Context(), conversationID, user.ID, req.Content, messageID)
+	if errors.Is(err, database.ErrMessageNotInConversation) {
+		http.Error(w, "Message not found in the conversation", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrNotParticipant) {
+		http.Error(w, "Not a participant of the conversation", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrConversationNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to create reply", http.StatusInternalServerError)
 		return
 	}
+	rt.queueLinkPreview(newMessageID, req.Content)
+	rt.emitMessageCreated(r.Context(), &database.Message{
+		ID:             newMessageID,
+		ConversationID: conversationID,
+		SenderID:       user.ID,
+		Sender:         user.Username,
+		Content:        sql.NullString{String: req.Content, Valid: true},
+		ReplyToID:      sql.NullString{String: messageID, Valid: true},
+		Time:           time.Now(),
+		Kind:           database.MessageKindText,
+	})
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -141,42 +265,45 @@ func (rt *_router) sendImageMessage(w http.ResponseWriter, r *http.Request, ps h
 		return
 	}
 
-	// Create uploads directory if it doesn't exist
-	if err := os.MkdirAll("uploads/images", 0755); err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
-		return
-	}
-
-	// Generate unique filename
-	filename := fmt.Sprintf("%s-%s", uuid.New().String(), header.Filename)
-	filepath := path.Join("uploads/images", filename)
-
-	// Save file
-	dst, err := os.Create(filepath)
+	filename, err := storeImage(file, header.Filename)
 	if err != nil {
 		http.Error(w, "Failed to save image", http.StatusInternalServerError)
 		return
 	}
-	defer dst.Close()
-
-	if _, err := io.Copy(dst, file); err != nil {
-		http.Error(w, "Failed to save image", http.StatusInternalServerError)
-		return
-	}
 
 	// Create message with image URL
 	imageURL := fmt.Sprintf("/uploads/images/%s", filename)
-	newMessageID, err := rt.db.CreateImageMessage(conversationID, user.Username, imageURL)
+	newMessageID, err := rt.db.CreateImageMessage(r.Context(), conversationID, user.ID, imageURL)
+	if errors.Is(err, database.ErrBlocked) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrNotParticipant) {
+		http.Error(w, "Not a participant of the conversation", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrConversationNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to create message", http.StatusInternalServerError)
 		return
 	}
+	rt.emitMessageCreated(r.Context(), &database.Message{
+		ID:             newMessageID,
+		ConversationID: conversationID,
+		SenderID:       user.ID,
+		Sender:         user.Username,
+		ImageURL:       sql.NullString{String: imageURL, Valid: true},
+		Time:           time.Now(),
+		Kind:           database.MessageKindImage,
+	})
 
-	// Return the full URL in the response
-	fullImageURL := fmt.Sprintf("http://localhost:3000%s", imageURL)
+	// Return the signed URL in the response, the image is not public
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{
 		"message_id": newMessageID,
-		"image_url":  fullImageURL,
+		"image_url":  rt.signImageURL(imageURL),
 	})
 }
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendMessageOutsideConversation(t *testing.T) {
+	rt, h := newTestRouter(t)
+	alice, carol := login(t, h, "alice"), login(t, h, "carol")
+	login(t, h, "bob")
+
+	conversationID, err := rt.db.CreateConversation(context.Background(), []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	w := serveJSON(h, http.MethodPost, "/conversations/"+conversationID+"/messages", alice, `{"content":"hello"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("error sending message: %d %s", w.Code, w.Body.String())
+	}
+	var sent struct {
+		MessageID string `json:"message_id"`
+	}
+	decode(t, w, &sent)
+
+	// Every route creating a message answers alike to who is not a member and for a conversation that does not exist
+	for _, route := range []struct {
+		name string
+		send func(conversationID string) *httptest.ResponseRecorder
+	}{
+		{"message", func(conversationID string) *httptest.ResponseRecorder {
+			return serveJSON(h, http.MethodPost, "/conversations/"+conversationID+"/messages", carol,
+				`{"content":"hi"}`)
+		}},
+		{"command", func(conversationID string) *httptest.ResponseRecorder {
+			return serveJSON(h, http.MethodPost, "/conversations/"+conversationID+"/messages", carol,
+				`{"content":"/help"}`)
+		}},
+		{"reply", func(conversationID string) *httptest.ResponseRecorder {
+			return serveJSON(h, http.MethodPost, "/conversations/"+conversationID+"/messages/"+sent.MessageID+
+				"/reply", carol, `{"content":"hi"}`)
+		}},
+		{"image", func(conversationID string) *httptest.ResponseRecorder {
+			return postImageMessage(t, h, carol, conversationID)
+		}},
+		{"attachments", func(conversationID string) *httptest.ResponseRecorder {
+			return serveJSON(h, http.MethodPost, "/conversations/"+conversationID+"/attachments", carol,
+				`{"upload_ids":["`+sent.MessageID+`"]}`)
+		}},
+	} {
+		t.Run(route.name, func(t *testing.T) {
+			if w := route.send(conversationID); w.Code != http.StatusForbidden {
+				t.Errorf("expected who is not a member refused; got %d %s", w.Code, w.Body.String())
+			}
+			if w := route.send("00000000-0000-0000-0000-000000000000"); w.Code != http.StatusNotFound {
+				t.Errorf("expected an unknown conversation not found; got %d %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMessageOfOtherConversation(t *testing.T) {
+	rt, h := newTestRouter(t)
+	ctx := context.Background()
+	alice, bob, carol := login(t, h, "alice"), login(t, h, "bob"), login(t, h, "carol")
+
+	conversationID, err := rt.db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	otherID, err := rt.db.CreateConversation(ctx, []string{"alice", "carol"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	messageID, err := rt.db.CreateMessage(ctx, conversationID, userID(t, rt, alice), "hello")
+	if err != nil {
+		t.Fatalf("error creating message: %v", err)
+	}
+	path := "/conversations/" + conversationID + "/messages/" + messageID
+
+	// A message is only reachable through its own conversation
+	if w := serveJSON(h, http.MethodPost, "/conversations/"+otherID+"/messages/"+messageID+"/reply", alice,
+		`{"content":"hi"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected replying from another conversation not found; got %d %s", w.Code, w.Body.String())
+	}
+	for _, c := range []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{"another conversation", "/conversations/" + otherID + "/messages/" + messageID, alice, http.StatusNotFound},
+		{"unknown message", "/conversations/" + conversationID + "/messages/missing", alice, http.StatusNotFound},
+		{"not a member", path, carol, http.StatusForbidden},
+		{"not the sender", path, bob, http.StatusForbidden},
+		{"the sender", path, alice, http.StatusNoContent},
+	} {
+		if w := serve(h, http.MethodDelete, c.path, c.token, nil, nil); w.Code != c.status {
+			t.Errorf("%s: expected deleting to answer %d; got %d %s", c.name, c.status, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/globaltime"
+)
+
+// messageSweepInterval is how often expired messages of conversations with disappearing messages are removed
+const messageSweepInterval = time.Minute
+
+// sweepExpiredMessages deletes expired messages every interval until the router is closed
+func (rt *_router) sweepExpiredMessages(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rt.stop:
+			return
+		case <-ticker.C:
+			rt.deleteExpiredMessages(rt.ctx)
+		}
+	}
+}
+
+// deleteExpiredMessages runs a single sweep. The files of the deleted messages are left to the media garbage
+// collection.
+func (rt *_router) deleteExpiredMessages(ctx context.Context) {
+	if err := rt.db.DeleteExpiredMessages(ctx, globaltime.Now()); err != nil {
+		rt.baseLogger.WithError(err).Error("error deleting expired messages")
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// pinMessage handles POST /conversations/{conversationId}/messages/{messageId}/pin
+func (rt *_router) pinMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	messageId := ps.ByName("messageId")
+	if conversationId == "" || messageId == "" {
+		http.Error(w, "Message ID and Conversation ID are required", http.StatusBadRequest)
+		return
+	}
+
+	// Verify authentication
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Verify user is part of the conversation
+	isParticipant, err := rt.db.IsUserInConversation(r.Context(), conversationId, user.ID)
+	if err != nil {
+		http.Error(w, "Error checking conversation access", http.StatusInternalServerError)
+		return
+	}
+	if !isParticipant {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err = rt.db.PinMessage(r.Context(), conversationId, messageId, user.ID)
+	switch {
+	case errors.Is(err, database.ErrMessageNotInConversation):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, database.ErrPinLimitReached):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Error pinning message: %v", err)
+		http.Error(w, "Failed to pin message", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// unpinMessage handles DELETE /conversations/{conversationId}/messages/{messageId}/pin
+func (rt *_router) unpinMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	messageId := ps.ByName("messageId")
+	if conversationId == "" || messageId == "" {
+		http.Error(w, "Message ID and Conversation ID are required", http.StatusBadRequest)
+		return
+	}
+
+	// Verify authentication
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Verify user is part of the conversation
+	isParticipant, err := rt.db.IsUserInConversation(r.Context(), conversationId, user.ID)
+	if err != nil {
+		http.Error(w, "Error checking conversation access", http.StatusInternalServerError)
+		return
+	}
+	if !isParticipant {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := rt.db.UnpinMessage(r.Context(), conversationId, messageId); err != nil {
+		http.Error(w, "Failed to unpin message: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getPinnedMessages handles GET /conversations/{conversationId}/pins
+func (rt *_router) getPinnedMessages(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	if conversationId == "" {
+		http.Error(w, "Conversation ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Verify authentication
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Verify user is part of the conversation
+	isParticipant, err := rt.db.IsUserInConversation(r.Context(), conversationId, user.ID)
+	if err != nil {
+		http.Error(w, "Error checking conversation access", http.StatusInternalServerError)
+		return
+	}
+	if !isParticipant {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	pins, err := rt.db.GetPinnedMessages(r.Context(), conversationId)
+	if err != nil {
+		log.Printf("Error getting pinned messages: %v", err)
+		http.Error(w, "Failed to get pinned messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"pins": pins,
+	}); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
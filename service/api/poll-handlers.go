@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Length limits of the texts of polls
+const (
+	maxPollQuestion = 300
+	maxPollOption   = 100
+)
+
+// createPoll handles POST /conversations/:conversationId/polls, sending a message of kind poll
+func (rt *_router) createPoll(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !rt.authorizeParticipant(r.Context(), w, user, conversationId) {
+		return
+	}
+
+	var req struct {
+		Question       string     `json:"question"`
+		Options        []string   `json:"options"`
+		MultipleChoice bool       `json:"multiple_choice"`
+		Anonymous      bool       `json:"anonymous"`
+		ClosesAt       *time.Time `json:"closes_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	poll := database.Poll{
+		Question:       strings.TrimSpace(req.Question),
+		MultipleChoice: req.MultipleChoice,
+		Anonymous:      req.Anonymous,
+		ClosesAt:       req.ClosesAt,
+	}
+	if poll.Question == "" || utf8.RuneCountInString(poll.Question) > maxPollQuestion {
+		http.Error(w, fmt.Sprintf("The question must have 1 to %d characters", maxPollQuestion), http.StatusBadRequest)
+		return
+	}
+	if len(req.Options) < database.MinPollOptions || len(req.Options) > database.MaxPollOptions {
+		http.Error(w, fmt.Sprintf("A poll must have between %d and %d options", database.MinPollOptions,
+			database.MaxPollOptions), http.StatusBadRequest)
+		return
+	}
+	seen := make(map[string]bool, len(req.Options))
+	for _, text := range req.Options {
+		text = strings.TrimSpace(text)
+		if text == "" || utf8.RuneCountInString(text) > maxPollOption || seen[strings.ToLower(text)] {
+			http.Error(w, fmt.Sprintf("Options must be distinct and have 1 to %d characters", maxPollOption),
+				http.StatusBadRequest)
+			return
+		}
+		seen[strings.ToLower(text)] = true
+		poll.Options = append(poll.Options, database.PollOption{Text: text})
+	}
+	if poll.ClosesAt != nil && !poll.ClosesAt.After(time.Now()) {
+		http.Error(w, "The closing time must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	message, err := rt.db.CreatePoll(r.Context(), conversationId, user.ID, poll)
+	if errors.Is(err, database.ErrBlocked) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrNotParticipant) {
+		http.Error(w, "Not a participant of the conversation", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrConversationNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating poll: %v", err)
+		http.Error(w, "Failed to create poll", http.StatusInternalServerError)
+		return
+	}
+	rt.emitMessageCreated(r.Context(), message)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(message)
+}
+
+// votePoll handles POST /conversations/:conversationId/messages/:messageId/votes. The options replace the previous
+// vote of the user; no options withdraws it.
+func (rt *_router) votePoll(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	messageId := ps.ByName("messageId")
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !rt.authorizeParticipant(r.Context(), w, user, conversationId) {
+		return
+	}
+
+	var req struct {
+		Options []int `json:"options"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err = rt.db.VotePoll(r.Context(), conversationId, messageId, user.ID, req.Options)
+	switch {
+	case errors.Is(err, database.ErrPollNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, database.ErrPollClosed):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, database.ErrInvalidVote):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Error voting in poll: %v", err)
+		http.Error(w, "Failed to vote", http.StatusInternalServerError)
+		return
+	}
+
+	rt.writePoll(r.Context(), w, messageId, user.ID)
+}
+
+// closePoll handles POST /conversations/:conversationId/messages/:messageId/close. Who created the poll can close it,
+// and so can the admins of a group.
+func (rt *_router) closePoll(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	messageId := ps.ByName("messageId")
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !rt.authorizeParticipant(r.Context(), w, user, conversationId) {
+		return
+	}
+
+	message, err := rt.db.GetMessageByID(r.Context(), messageId)
+	if errors.Is(err, database.ErrMessageNotFound) || (err == nil && message.ConversationID != conversationId) {
+		http.Error(w, database.ErrPollNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting message: %v", err)
+		http.Error(w, "Failed to get message", http.StatusInternalServerError)
+		return
+	}
+	if message.SenderID != user.ID {
+		isAdmin, err := rt.db.IsGroupAdmin(r.Context(), conversationId, user.ID)
+		if err != nil {
+			http.Error(w, "Error checking group admin", http.StatusInternalServerError)
+			return
+		}
+		if !isAdmin {
+			http.Error(w, "Only who created the poll can close it", http.StatusForbidden)
+			return
+		}
+	}
+
+	err = rt.db.ClosePoll(r.Context(), conversationId, messageId)
+	switch {
+	case errors.Is(err, database.ErrPollNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, database.ErrPollClosed):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Error closing poll: %v", err)
+		http.Error(w, "Failed to close poll", http.StatusInternalServerError)
+		return
+	}
+
+	rt.writePoll(r.Context(), w, messageId, user.ID)
+}
+
+// writePoll answers with the poll of a message as seen by viewerID
+func (rt *_router) writePoll(ctx context.Context, w http.ResponseWriter, messageId string, viewerID string) {
+	poll, err := rt.db.GetPoll(ctx, messageId, viewerID)
+	if err != nil {
+		log.Printf("Error getting poll: %v", err)
+		http.Error(w, "Failed to get poll", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(poll)
+}
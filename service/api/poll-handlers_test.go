@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+)
+
+func TestPollAccess(t *testing.T) {
+	rt, h := newTestRouter(t)
+	alice, carol := login(t, h, "alice"), login(t, h, "carol")
+	login(t, h, "bob")
+
+	conversationID, err := rt.db.CreateConversation(context.Background(), []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	const body = `{"question":"Lunch?","options":["Pizza","Sushi"]}`
+	w := serveJSON(h, http.MethodPost, "/conversations/"+conversationID+"/polls", alice, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("error creating poll: %d %s", w.Code, w.Body.String())
+	}
+	var poll database.Message
+	decode(t, w, &poll)
+
+	// Who is not a member is refused, and an unknown conversation is not found, on every route
+	for _, c := range []struct {
+		name string
+		path string
+		body string
+	}{
+		{"create", "/polls", body},
+		{"vote", "/messages/" + poll.ID + "/votes", `{"options":[0]}`},
+		{"close", "/messages/" + poll.ID + "/close", ``},
+	} {
+		if w := serveJSON(h, http.MethodPost, "/conversations/"+conversationID+c.path, carol, c.body); w.Code !=
+			http.StatusForbidden {
+			t.Errorf("%s: expected who is not a member refused; got %d %s", c.name, w.Code, w.Body.String())
+		}
+		if w := serveJSON(h, http.MethodPost, "/conversations/00000000-0000-0000-0000-000000000000"+c.path, alice,
+			c.body); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected an unknown conversation not found; got %d %s", c.name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPollTextLimits(t *testing.T) {
+	rt, h := newTestRouter(t)
+	alice := login(t, h, "alice")
+	login(t, h, "bob")
+
+	conversationID, err := rt.db.CreateConversation(context.Background(), []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	// The limits count characters, not the bytes of their encoding
+	for _, c := range []struct {
+		question string
+		option   string
+		status   int
+	}{
+		{strings.Repeat("è", maxPollQuestion), strings.Repeat("ü", maxPollOption), http.StatusCreated},
+		{strings.Repeat("è", maxPollQuestion+1), "Pizza", http.StatusBadRequest},
+		{"Lunch?", strings.Repeat("ü", maxPollOption+1), http.StatusBadRequest},
+	} {
+		w := serveJSON(h, http.MethodPost, "/conversations/"+conversationID+"/polls", alice,
+			`{"question":"`+c.question+`","options":["`+c.option+`","Sushi"]}`)
+		if w.Code != c.status {
+			t.Errorf("expected %d for a question of %d and an option of %d characters; got %d %s", c.status,
+				len([]rune(c.question)), len([]rune(c.option)), w.Code, w.Body.String())
+		}
+	}
+}
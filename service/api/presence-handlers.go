@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/globaltime"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// onlineTTL is how long a user is shown online after their last request
+	onlineTTL = 30 * time.Second
+
+	// typingTTL is how long a typing heartbeat lasts; clients should send one every few seconds while typing
+	typingTTL = 5 * time.Second
+
+	// lastSeenPersistPeriod limits how often users.last_seen is written for an active user
+	lastSeenPersistPeriod = time.Minute
+
+	// presencePruneInterval is how often expired presence entries are dropped
+	presencePruneInterval = time.Minute
+)
+
+// prunePresence drops expired presence entries every interval until the router is closed
+func (rt *_router) prunePresence(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rt.stop:
+			return
+		case <-ticker.C:
+			rt.presence.Prune()
+		}
+	}
+}
+
+// touchPresence marks the user online, saving the last seen time when the registry asks for it
+func (rt *_router) touchPresence(ctx context.Context, user *database.User) {
+	if !rt.presence.Touch(user.ID) {
+		return
+	}
+	if err := rt.db.UpdateLastSeen(ctx, user.ID, globaltime.Now()); err != nil {
+		log.Printf("Error updating last seen: %v", err)
+	}
+}
+
+// applyParticipantPrivacy adds online and typing flags to the participants and hides what their privacy settings do
+// not allow the viewer to see. Members of the same conversation are contacts, so only "nobody" hides anything here.
+func (rt *_router) applyParticipantPrivacy(details *database.ConversationDetails, viewer *database.User) {
+	for i := range details.Participants {
+		p := &details.Participants[i]
+		p.Typing = rt.presence.IsTyping(p.UserID, details.ID)
+		if p.UserID != viewer.ID && p.PhotoVisibility == database.VisibleToNobody {
+			p.PhotoURL = ""
+		}
+		p.PhotoURL = rt.signPhotoURL(p.PhotoURL, p.PhotoVisibility)
+		if p.UserID == viewer.ID {
+			p.Online = rt.presence.IsOnline(p.UserID)
+			continue
+		}
+		if p.LastSeenVisibility == database.VisibleToNobody {
+			p.LastSeen = nil
+			continue
+		}
+		p.Online = rt.presence.IsOnline(p.UserID)
+	}
+}
+
+// sendTyping handles POST /conversations/{conversationId}/typing
+func (rt *_router) sendTyping(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	if conversationId == "" {
+		http.Error(w, "Conversation ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Get authenticated user
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Verify user is part of the conversation
+	isParticipant, err := rt.db.IsUserInConversation(r.Context(), conversationId, user.ID)
+	if err != nil {
+		http.Error(w, "Error checking conversation access", http.StatusInternalServerError)
+		return
+	}
+	if !isParticipant {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rt.presence.Typing(user.ID, conversationId)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setPrivacy handles PUT /users/{username}/privacy
+func (rt *_router) setPrivacy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	username := ps.ByName("username")
+	if username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	// Get user from token
+	user, err := rt.getUserFromToken(r)
+	if err != nil || user.Username != username {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		PhotoVisibility    string `json:"photo_visibility"`
+		LastSeenVisibility string `json:"last_seen_visibility"`
+		ForwardVisibility  string `json:"forward_visibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	// Clients predating the forward visibility leave it unchanged
+	if req.ForwardVisibility == "" {
+		req.ForwardVisibility = user.ForwardVisibility
+	}
+
+	err = rt.db.SetPrivacy(r.Context(), user.ID, req.PhotoVisibility, req.LastSeenVisibility, req.ForwardVisibility)
+	if errors.Is(err, database.ErrInvalidProfile) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating privacy settings: %v", err)
+		http.Error(w, "Failed to update privacy settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"photo_visibility":     req.PhotoVisibility,
+		"last_seen_visibility": req.LastSeenVisibility,
+		"forward_visibility":   req.ForwardVisibility,
+	}); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
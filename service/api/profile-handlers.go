@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// getProfile handles GET /users/{username}/profile. Any authenticated user can read a profile, but the photo and the
+// last seen time are only included when the privacy settings of the owner allow it.
+func (rt *_router) getProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	username := ps.ByName("username")
+	if username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	// Verify authentication
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	profile, err := rt.db.GetProfile(r.Context(), username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting profile: %v", err)
+		http.Error(w, "Failed to get profile", http.StatusInternalServerError)
+		return
+	}
+
+	showPhoto, err := rt.canSee(r.Context(), user, profile.UserID, profile.PhotoVisibility)
+	if err != nil {
+		log.Printf("Error checking contacts: %v", err)
+		http.Error(w, "Failed to get profile", http.StatusInternalServerError)
+		return
+	}
+	showLastSeen, err := rt.canSee(r.Context(), user, profile.UserID, profile.LastSeenVisibility)
+	if err != nil {
+		log.Printf("Error checking contacts: %v", err)
+		http.Error(w, "Failed to get profile", http.StatusInternalServerError)
+		return
+	}
+
+	if !showPhoto {
+		profile.PhotoURL = ""
+		profile.AvatarHistory = nil
+	}
+	profile.PhotoURL = rt.signPhotoURL(profile.PhotoURL, profile.PhotoVisibility)
+	for i := range profile.AvatarHistory {
+		profile.AvatarHistory[i].PhotoURL = rt.signPhotoURL(profile.AvatarHistory[i].PhotoURL, profile.PhotoVisibility)
+	}
+	if showLastSeen {
+		profile.Online = rt.presence.IsOnline(profile.UserID)
+	} else {
+		profile.LastSeen = nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(profile); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// canSee checks if the viewer is allowed to see something the owner shares with the given visibility
+func (rt *_router) canSee(ctx context.Context, viewer *database.User, ownerID string, visibility string) (bool, error) {
+	if viewer.ID == ownerID {
+		return true, nil
+	}
+	switch visibility {
+	case database.VisibleToEveryone:
+		return true, nil
+	case database.VisibleToContacts:
+		return rt.db.AreContacts(ctx, viewer.ID, ownerID)
+	default:
+		return false, nil
+	}
+}
+
+// updateProfile handles PUT /users/{username}/profile
+func (rt *_router) updateProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	username := ps.ByName("username")
+	if username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	// Get user from token
+	user, err := rt.getUserFromToken(r)
+	if err != nil || user.Username != username {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		DisplayName string `json:"display_name"`
+		Bio         string `json:"bio"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err = rt.db.UpdateProfile(r.Context(), user.ID, req.DisplayName, req.Bio)
+	if errors.Is(err, database.ErrInvalidProfile) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating profile: %v", err)
+		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+		return
+	}
+
+	profile, err := rt.db.GetProfile(r.Context(), user.Username)
+	if err != nil {
+		log.Printf("Error getting profile: %v", err)
+		http.Error(w, "Failed to get profile", http.StatusInternalServerError)
+		return
+	}
+	profile.Online = rt.presence.IsOnline(profile.UserID)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(profile); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
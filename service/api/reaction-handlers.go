@@ -1,17 +1,61 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
 	"github.com/julienschmidt/httprouter"
 )
 
+// authorizeParticipant checks that user takes part in conversationID. It writes the error response and returns false
+// otherwise.
+func (rt *_router) authorizeParticipant(ctx context.Context, w http.ResponseWriter, user *database.User,
+	conversationID string) bool {
+	err := rt.db.CheckParticipant(ctx, conversationID, user.ID)
+	if errors.Is(err, database.ErrNotParticipant) {
+		http.Error(w, "Not a participant of the conversation", http.StatusForbidden)
+		return false
+	}
+	if errors.Is(err, database.ErrConversationNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Error checking conversation access", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// authorizeMessage checks that user takes part in conversationID and that messageID is one of its messages. It writes
+// the error response and returns false otherwise.
+func (rt *_router) authorizeMessage(ctx context.Context, w http.ResponseWriter, user *database.User,
+	conversationID string, messageID string) (*database.Message, bool) {
+	if !rt.authorizeParticipant(ctx, w, user, conversationID) {
+		return nil, false
+	}
+
+	message, err := rt.db.GetMessageByID(ctx, messageID)
+	if errors.Is(err, database.ErrMessageNotFound) || (err == nil && message.ConversationID != conversationID) {
+		http.Error(w, "Message not found in the conversation", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error getting message: %v", err)
+		http.Error(w, "Failed to get message", http.StatusInternalServerError)
+		return nil, false
+	}
+	return message, true
+}
+
 // addReaction maneja POST /conversations/{conversationId}/messages/{messageId}/reactions
 func (rt *_router) addReaction(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	messageId := ps.ByName("messageId")
-	//conversationId := ps.ByName("conversationId")
+	conversationId := ps.ByName("conversationId")
 
 	if messageId == "" {
 		http.Error(w, "Message ID is required", http.StatusBadRequest)
@@ -24,6 +68,9 @@ func (rt *_router) addReaction(w http.ResponseWriter, r *http.Request, ps httpro
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	if _, ok := rt.authorizeMessage(r.Context(), w, user, conversationId, messageId); !ok {
+		return
+	}
 
 	// Parsear body
 	var requestBody struct {
@@ -37,16 +84,30 @@ func (rt *_router) addReaction(w http.ResponseWriter, r *http.Request, ps httpro
 	log.Printf("Adding reaction - MessageID: %s, UserID: %s, Reaction: %s", messageId, user.ID, requestBody.Reaction)
 
 	// Añadir reacción
-	err = rt.db.AddReaction(messageId, user.ID, requestBody.Reaction)
+	err = rt.db.AddReaction(r.Context(), messageId, user.ID, requestBody.Reaction)
+	if errors.Is(err, database.ErrInvalidReaction) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrMessageNotFound) {
+		http.Error(w, "Message not found in the conversation", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to add reaction", http.StatusInternalServerError)
 		return
 	}
+	rt.emitWebhookEvent(r.Context(), conversationId, webhookMessageReacted, map[string]string{
+		"message_id": messageId,
+		"user_id":    user.ID,
+		"username":   user.Username,
+		"reaction":   requestBody.Reaction,
+	})
 
 	w.WriteHeader(http.StatusCreated)
 }
 
-// removeReaction maneja DELETE /conversations/{conversationId}/messages/{messageId}/reactions
+// removeReaction maneja DELETE /conversations/{conversationId}/messages/{messageId}/reactions?reaction={emoji}
 func (rt *_router) removeReaction(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	messageId := ps.ByName("messageId")
 	conversationId := ps.ByName("conversationId")
@@ -63,10 +124,23 @@ func (rt *_router) removeReaction(w http.ResponseWriter, r *http.Request, ps htt
 		return
 	}
 
+	reaction := r.URL.Query().Get("reaction")
+	if reaction == "" {
+		http.Error(w, "Reaction is required", http.StatusBadRequest)
+		return
+	}
+	if _, ok := rt.authorizeMessage(r.Context(), w, user, conversationId, messageId); !ok {
+		return
+	}
+
 	log.Printf("Removing reaction - MessageID: %s, UserID: %s, ConversationID: %s", messageId, user.ID, conversationId)
 
 	// Eliminar reacción
-	err = rt.db.RemoveReaction(messageId, user.ID)
+	err = rt.db.RemoveReaction(r.Context(), messageId, user.ID, reaction)
+	if errors.Is(err, database.ErrReactionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("Error removing reaction: %v", err)
 		http.Error(w, "Failed to remove reaction", http.StatusInternalServerError)
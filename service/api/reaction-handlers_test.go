@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestReactionAccess(t *testing.T) {
+	rt, h := newTestRouter(t)
+	ctx := context.Background()
+	alice, bob, carol := login(t, h, "alice"), login(t, h, "bob"), login(t, h, "carol")
+
+	private, err := rt.db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	other, err := rt.db.CreateConversation(ctx, []string{"alice", "carol"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	messageID, err := rt.db.CreateMessage(ctx, private, userID(t, rt, bob), "hello")
+	if err != nil {
+		t.Fatalf("error creating message: %v", err)
+	}
+	reactions := func(conversationID string, messageID string) string {
+		return "/conversations/" + conversationID + "/messages/" + messageID + "/reactions"
+	}
+	thumbsUp := "?reaction=" + url.QueryEscape("👍")
+
+	for _, c := range []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"not a member", http.MethodPost, reactions(private, messageID), carol, http.StatusForbidden},
+		{"message of another conversation", http.MethodPost, reactions(other, messageID), carol, http.StatusNotFound},
+		{"unknown conversation", http.MethodPost, reactions("missing", messageID), alice, http.StatusNotFound},
+		{"unknown message", http.MethodPost, reactions(private, "missing"), alice, http.StatusNotFound},
+		{"member", http.MethodPost, reactions(private, messageID), alice, http.StatusCreated},
+		{"remove as not a member", http.MethodDelete, reactions(private, messageID) + thumbsUp, carol,
+			http.StatusForbidden},
+		{"remove from another conversation", http.MethodDelete, reactions(other, messageID) + thumbsUp, alice,
+			http.StatusNotFound},
+		{"remove a reaction of another user", http.MethodDelete, reactions(private, messageID) + thumbsUp, bob,
+			http.StatusNotFound},
+		{"remove", http.MethodDelete, reactions(private, messageID) + thumbsUp, alice, http.StatusNoContent},
+		{"remove again", http.MethodDelete, reactions(private, messageID) + thumbsUp, alice, http.StatusNotFound},
+	} {
+		w := serveJSON(h, c.method, c.path, c.token, `{"reaction":"👍"}`)
+		if w.Code != c.status {
+			t.Errorf("%s: expected %d; got %d %s", c.name, c.status, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// defaultUserSearchLimit is the page size when the client does not choose one
+	defaultUserSearchLimit = 20
+
+	// maxUserSearchLimit caps the page size, so that the directory cannot be dumped in one request
+	maxUserSearchLimit = 50
+)
+
+// searchUsers handles GET /users?q=&limit=&cursor=
+func (rt *_router) searchUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	// Get authenticated user
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultUserSearchLimit
+	if l := query.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxUserSearchLimit {
+			http.Error(w, "Limit must be between 1 and 50", http.StatusBadRequest)
+			return
+		}
+	}
+
+	users, nextCursor, err := rt.db.SearchUsers(r.Context(), user.ID, query.Get("q"), limit, query.Get("cursor"))
+	if errors.Is(err, database.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrSearchQueryTooShort) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error searching users: %v", err)
+		http.Error(w, "Failed to search users", http.StatusInternalServerError)
+		return
+	}
+
+	// Contacts are known from the search itself, so the photo privacy needs no extra queries
+	for i := range users {
+		u := &users[i]
+		if u.PhotoVisibility == database.VisibleToNobody ||
+			(u.PhotoVisibility == database.VisibleToContacts && !u.IsContact) {
+			u.PhotoURL = ""
+		}
+		u.PhotoURL = rt.signPhotoURL(u.PhotoURL, u.PhotoVisibility)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Users      []database.UserSummary `json:"users"`
+		NextCursor string                 `json:"next_cursor,omitempty"`
+	}{
+		Users:      users,
+		NextCursor: nextCursor,
+	}); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSearchUsersQuery(t *testing.T) {
+	_, h := newTestRouter(t)
+	alice := login(t, h, "alice")
+	login(t, h, "bob")
+	login(t, h, "bobby")
+
+	for _, c := range []struct {
+		path   string
+		status int
+	}{
+		{"/users", http.StatusBadRequest},
+		{"/users?q=", http.StatusBadRequest},
+		{"/users?q=%20%20%20", http.StatusBadRequest},
+		{"/users?q=b&limit=1", http.StatusBadRequest},
+		{"/users?q=bo", http.StatusOK},
+	} {
+		if w := serve(h, http.MethodGet, c.path, alice, nil, nil); w.Code != c.status {
+			t.Errorf("%s: expected %d; got %d %s", c.path, c.status, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/globaltime"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Resumable uploads follow the core tus protocol (https://tus.io/protocols/resumable-upload): the client creates an
+// upload with its size, PATCHes chunks at the offset the server reports, and can ask for the offset again after a
+// failure to resume from there. Finalized uploads are sent as attachments by their ID.
+const (
+	// tusVersion is the tus protocol version the upload endpoints speak
+	tusVersion = "1.0.0"
+
+	// maxUploadSize is the largest file that can be uploaded
+	maxUploadSize = int64(1 << 30)
+
+	// userStorageQuota is how many bytes of attachments and pending uploads each user can store
+	userStorageQuota = int64(2 << 30)
+
+	// uploadSweepInterval is how often abandoned uploads are removed
+	uploadSweepInterval = 10 * time.Minute
+)
+
+// uploadLocks makes sure a single request at a time writes to an upload
+type uploadLocks struct {
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+func newUploadLocks() *uploadLocks {
+	return &uploadLocks{held: make(map[string]bool)}
+}
+
+// tryLock takes the lock of an upload, returning false if another request holds it
+func (l *uploadLocks) tryLock(uploadID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[uploadID] {
+		return false
+	}
+	l.held[uploadID] = true
+	return true
+}
+
+func (l *uploadLocks) unlock(uploadID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.held, uploadID)
+}
+
+// partialUploadPath is the file the received chunks of an upload are appended to until it is finalized
+func partialUploadPath(storageKey string) string {
+	return attachmentPath(storageKey) + ".part"
+}
+
+// sweepExpiredUploads deletes abandoned uploads every interval until the router is closed
+func (rt *_router) sweepExpiredUploads(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rt.stop:
+			return
+		case <-ticker.C:
+			partialKeys, err := rt.db.DeleteExpiredUploads(rt.ctx, globaltime.Now())
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("error deleting expired uploads")
+				continue
+			}
+			rt.removePartialUploads(partialKeys)
+		}
+	}
+}
+
+// createUpload handles POST /upload-sessions, starting an upload of a file of the given size
+func (rt *_router) createUpload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Filename string `json:"filename"`
+		MIMEType string `json:"mime_type"`
+		Size     int64  `json:"size"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Size <= 0 {
+		http.Error(w, "Size must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.Size > maxUploadSize {
+		http.Error(w, "File is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := os.MkdirAll(attachmentsDir, 0755); err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	upload := database.Upload{
+		ID:         uuid.New().String(),
+		UserID:     user.ID,
+		Filename:   attachmentFilename(req.Filename),
+		MIMEType:   req.MIMEType,
+		Size:       req.Size,
+		StorageKey: uuid.New().String(),
+	}
+	if upload.MIMEType == "" {
+		upload.MIMEType = "application/octet-stream"
+	}
+	err = rt.db.CreateUpload(r.Context(), &upload, userStorageQuota)
+	if errors.Is(err, database.ErrQuotaExceeded) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	// The partial file exists from the start, so that an empty chunk can be resumed like any other
+	file, err := os.OpenFile(partialUploadPath(upload.StorageKey), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	_ = file.Close()
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Location", "/upload-sessions/"+upload.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(upload)
+}
+
+// getUploadOffset handles HEAD and GET /upload-sessions/:uploadId, telling where to resume an upload from
+func (rt *_router) getUploadOffset(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	upload, err := rt.db.GetUpload(r.Context(), ps.ByName("uploadId"), user.ID)
+	if errors.Is(err, database.ErrUploadNotFound) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(upload)
+}
+
+// patchUpload handles PATCH /upload-sessions/:uploadId, appending the body at the Upload-Offset the client last got.
+// What arrives is kept even if the connection drops, so the client can resume from the offset it gets back.
+func (rt *_router) patchUpload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	uploadID := ps.ByName("uploadId")
+	if !rt.uploadLocks.tryLock(uploadID) {
+		http.Error(w, "Upload is busy", http.StatusLocked)
+		return
+	}
+	defer rt.uploadLocks.unlock(uploadID)
+
+	upload, err := rt.db.GetUpload(r.Context(), uploadID, user.ID)
+	if errors.Is(err, database.ErrUploadNotFound) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if upload.Completed {
+		http.Error(w, "Upload already finalized", http.StatusConflict)
+		return
+	}
+	if offset != upload.Offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		http.Error(w, "Upload-Offset does not match the received bytes", http.StatusConflict)
+		return
+	}
+
+	file, err := os.OpenFile(partialUploadPath(upload.StorageKey), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	// Bytes past the recorded offset come from a chunk that failed before it was recorded
+	if err := file.Truncate(upload.Offset); err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := file.Seek(upload.Offset, io.SeekStart); err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	remaining := upload.Size - upload.Offset
+	written, copyErr := io.Copy(file, io.LimitReader(r.Body, remaining))
+	if err := file.Sync(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	tooLarge := false
+	if copyErr == nil && written == remaining {
+		n, _ := r.Body.Read(make([]byte, 1))
+		tooLarge = n > 0
+	}
+
+	// The request context is canceled when the client drops mid-chunk, yet the bytes written must be recorded, or the
+	// next chunk would truncate them
+	newOffset := upload.Offset + written
+	if err := rt.db.SetUploadOffset(rt.ctx, upload.ID, newOffset); err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+
+	if tooLarge {
+		http.Error(w, "Chunk goes past the upload size", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if copyErr != nil {
+		rt.baseLogger.WithError(copyErr).Warnf("upload %s interrupted at %d bytes", upload.ID, newOffset)
+		http.Error(w, "Upload interrupted", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finalizeUpload handles POST /upload-sessions/:uploadId/finalize. Once every byte is received the file gets its
+// checksum and, when the client did not know it, its MIME type, and can be sent as an attachment.
+func (rt *_router) finalizeUpload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	uploadID := ps.ByName("uploadId")
+	if !rt.uploadLocks.tryLock(uploadID) {
+		http.Error(w, "Upload is busy", http.StatusLocked)
+		return
+	}
+	defer rt.uploadLocks.unlock(uploadID)
+
+	upload, err := rt.db.GetUpload(r.Context(), uploadID, user.ID)
+	if errors.Is(err, database.ErrUploadNotFound) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if upload.Completed {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(upload)
+		return
+	}
+	if upload.Offset != upload.Size {
+		http.Error(w, "Upload is not complete", http.StatusConflict)
+		return
+	}
+
+	partial := partialUploadPath(upload.StorageKey)
+	file, err := os.Open(partial)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	mimeType, err := detectMIMEType(file, upload.MIMEType)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	_ = file.Close()
+
+	// From now on the file is stored under its checksum, shared with any identical attachment. The partial file is
+	// removed only once the upload is completed, so that a failed finalize can be retried; a stored copy nothing
+	// references is left to the media garbage collection.
+	if err := linkIntoStore(partial, attachmentPath(checksum)); err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if err := rt.db.CompleteUpload(r.Context(), upload.ID, mimeType, checksum); err != nil {
+		http.Error(w, "Failed to finalize upload", http.StatusInternalServerError)
+		return
+	}
+	if err := os.Remove(partial); err != nil {
+		rt.baseLogger.WithError(err).Warnf("error removing partial upload %s", partial)
+	}
+
+	upload.Completed = true
+	upload.MIMEType = mimeType
+	upload.Checksum = checksum
+	upload.StorageKey = checksum
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(upload)
+}
+
+// deleteUpload handles DELETE /upload-sessions/:uploadId, aborting an upload or dropping a finalized one not sent yet
+func (rt *_router) deleteUpload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	uploadID := ps.ByName("uploadId")
+	if !rt.uploadLocks.tryLock(uploadID) {
+		http.Error(w, "Upload is busy", http.StatusLocked)
+		return
+	}
+	defer rt.uploadLocks.unlock(uploadID)
+
+	if _, err := rt.db.GetUpload(r.Context(), uploadID, user.ID); errors.Is(err, database.ErrUploadNotFound) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	partialKey, err := rt.db.DeleteUpload(r.Context(), uploadID)
+	if err != nil {
+		http.Error(w, "Failed to delete upload", http.StatusInternalServerError)
+		return
+	}
+	rt.removePartialUploads([]string{partialKey})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+)
+
+// createTestUpload starts an upload of size bytes and returns its ID
+func createTestUpload(t *testing.T, h http.Handler, token string, size int) string {
+	t.Helper()
+
+	w := serveJSON(h, http.MethodPost, "/upload-sessions", token,
+		`{"filename":"notes.txt","mime_type":"text/plain","size":`+strconv.Itoa(size)+`}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("error creating upload: %d %s", w.Code, w.Body.String())
+	}
+	var upload database.Upload
+	decode(t, w, &upload)
+	return upload.ID
+}
+
+// patchChunk sends a chunk of an upload at offset
+func patchChunk(h http.Handler, token string, uploadID string, offset int64, chunk []byte) *http.Response {
+	w := serve(h, http.MethodPatch, "/upload-sessions/"+uploadID, token, bytes.NewReader(chunk), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.FormatInt(offset, 10),
+	})
+	return w.Result()
+}
+
+func TestResumableUpload(t *testing.T) {
+	_, h := newTestRouter(t)
+	token := login(t, h, "alice")
+	content := []byte("hello, resumable world")
+	uploadID := createTestUpload(t, h, token, len(content))
+
+	resp := patchChunk(h, token, uploadID, 0, content[:5])
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != "5" {
+		t.Fatalf("expected the first chunk accepted up to 5; got %d at %s", resp.StatusCode,
+			resp.Header.Get("Upload-Offset"))
+	}
+
+	w := serve(h, http.MethodHead, "/upload-sessions/"+uploadID, token, nil, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "5" ||
+		w.Header().Get("Upload-Length") != strconv.Itoa(len(content)) {
+		t.Errorf("expected to resume from 5 of %d; got %d with %v", len(content), w.Code, w.Header())
+	}
+
+	// A chunk sent at another offset is refused, telling the client where to resume from
+	resp = patchChunk(h, token, uploadID, 2, content[2:])
+	if resp.StatusCode != http.StatusConflict || resp.Header.Get("Upload-Offset") != "5" {
+		t.Errorf("expected an offset mismatch at 5; got %d at %s", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	w = serve(h, http.MethodPatch, "/upload-sessions/"+uploadID, token, bytes.NewReader(content[5:]),
+		map[string]string{"Content-Type": "application/octet-stream", "Upload-Offset": "5"})
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected a chunk without the tus content type refused; got %d", w.Code)
+	}
+	resp = patchChunk(h, token, uploadID, 5, append(content[5:len(content):len(content)], "too much"...))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a chunk past the size refused; got %d", resp.StatusCode)
+	}
+
+	// The bytes in the size were kept, so the upload is complete
+	w = serve(h, http.MethodPost, "/upload-sessions/"+uploadID+"/finalize", token, nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("error finalizing upload: %d %s", w.Code, w.Body.String())
+	}
+	var upload database.Upload
+	decode(t, w, &upload)
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	if !upload.Completed || upload.Checksum != checksum {
+		t.Errorf("expected the upload completed with checksum %s; got %+v", checksum, upload)
+	}
+
+	stored, err := os.ReadFile(attachmentPath(checksum))
+	if err != nil || !bytes.Equal(stored, content) {
+		t.Errorf("expected the content stored under its checksum; got %q, %v", stored, err)
+	}
+	if partials, _ := filepath.Glob(filepath.Join(attachmentsDir, "*.part")); len(partials) != 0 {
+		t.Errorf("expected the partial file removed; got %v", partials)
+	}
+
+	resp = patchChunk(h, token, uploadID, int64(len(content)), nil)
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected a finalized upload to refuse chunks; got %d", resp.StatusCode)
+	}
+}
+
+// droppedChunk is the body of a chunk whose client goes away after sending part of it: once the part is read, the
+// request is canceled and reading fails
+type droppedChunk struct {
+	part   io.Reader
+	cancel context.CancelFunc
+}
+
+func (c *droppedChunk) Read(p []byte) (int, error) {
+	n, err := c.part.Read(p)
+	if err == io.EOF {
+		c.cancel()
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestResumeDroppedChunk(t *testing.T) {
+	_, h := newTestRouter(t)
+	token := login(t, h, "alice")
+	content := []byte("sent over a flaky connection")
+	uploadID := createTestUpload(t, h, token, len(content))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest(http.MethodPatch, "/upload-sessions/"+uploadID,
+		&droppedChunk{part: bytes.NewReader(content[:10]), cancel: cancel}).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	// The bytes received before the drop are kept, so the client resumes after them
+	w := serve(h, http.MethodHead, "/upload-sessions/"+uploadID, token, nil, nil)
+	if w.Header().Get("Upload-Offset") != "10" {
+		t.Fatalf("expected to resume from 10; got %d with %v", w.Code, w.Header())
+	}
+	if resp := patchChunk(h, token, uploadID, 10, content[10:]); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("error sending the rest: %d", resp.StatusCode)
+	}
+	w = serve(h, http.MethodPost, "/upload-sessions/"+uploadID+"/finalize", token, nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("error finalizing upload: %d %s", w.Code, w.Body.String())
+	}
+	var upload database.Upload
+	decode(t, w, &upload)
+	stored, err := os.ReadFile(attachmentPath(upload.Checksum))
+	if err != nil || !bytes.Equal(stored, content) {
+		t.Errorf("expected the whole content stored; got %q, %v", stored, err)
+	}
+}
+
+// failingCompletion fails the first completion of an upload
+type failingCompletion struct {
+	database.AppDatabase
+	failed bool
+}
+
+func (db *failingCompletion) CompleteUpload(ctx context.Context, uploadID string, mimeType string,
+	checksum string) error {
+	if !db.failed {
+		db.failed = true
+		return errors.New("database is locked")
+	}
+	return db.AppDatabase.CompleteUpload(ctx, uploadID, mimeType, checksum)
+}
+
+func TestFinalizeUploadRetry(t *testing.T) {
+	rt, h := newTestRouter(t)
+	rt.db = &failingCompletion{AppDatabase: rt.db}
+	token := login(t, h, "alice")
+	content := []byte("finalized on the second try")
+	uploadID := createTestUpload(t, h, token, len(content))
+
+	if resp := patchChunk(h, token, uploadID, 0, content); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("error sending chunk: %d", resp.StatusCode)
+	}
+	w := serve(h, http.MethodPost, "/upload-sessions/"+uploadID+"/finalize", token, nil, nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the first finalize to fail; got %d", w.Code)
+	}
+
+	// Nothing was lost, so the client can finalize again
+	w = serve(h, http.MethodPost, "/upload-sessions/"+uploadID+"/finalize", token, nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("error finalizing upload again: %d %s", w.Code, w.Body.String())
+	}
+	var upload database.Upload
+	decode(t, w, &upload)
+	stored, err := os.ReadFile(attachmentPath(upload.Checksum))
+	if !upload.Completed || err != nil || !bytes.Equal(stored, content) {
+		t.Errorf("expected the upload completed and stored; got %+v, %q, %v", upload, stored, err)
+	}
+}
@@ -4,14 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
-	"time"
 
 	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
 	"github.com/julienschmidt/httprouter"
@@ -69,14 +65,14 @@ func (rt *_router) setMyUserName(w http.ResponseWriter, r *http.Request, ps http
 	token := authHeader[7:]
 
 	// Verify user is authorized to change this username
-	user, err := rt.db.GetUserByToken(token)
+	user, err := rt.db.GetUserByToken(r.Context(), token)
 	if err != nil || user.Username != username {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	// Update username in database
-	if err := rt.db.UpdateUsername(user.ID, requestBody.NewName); err != nil {
+	if err := rt.db.UpdateUsername(r.Context(), user.ID, requestBody.NewName); err != nil {
 		// Check for specific error messages
 		switch {
 		case err.Error() == "new username is the same as current username":
@@ -142,29 +138,7 @@ func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
-	// Generate unique filename
-	filename := fmt.Sprintf("%s_%d%s",
-		user.ID,
-		time.Now().UnixNano(),
-		filepath.Ext(header.Filename))
-
-	// Ensure the uploads/images directory exists
-	uploadDir := filepath.Join("uploads", "images")
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		http.Error(w, "Failed to ensure upload directory exists", http.StatusInternalServerError)
-		return
-	}
-
-	// Save file to uploads/images directory
-	filePath := filepath.Join(uploadDir, filename)
-	dst, err := os.Create(filePath)
-	if err != nil {
-		http.Error(w, "Failed to create file", http.StatusInternalServerError)
-		return
-	}
-	defer dst.Close()
-
-	_, err = io.Copy(dst, file)
+	filename, err := storeImage(file, header.Filename)
 	if err != nil {
 		http.Error(w, "Failed to save file", http.StatusInternalServerError)
 		return
@@ -172,7 +146,7 @@ func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, ps httprou
 
 	// Create the URL that points to your backend server
 	photoURL := fmt.Sprintf("http://localhost:3000/uploads/images/%s", filename)
-	if err := rt.db.UpdateUserPhoto(user.ID, photoURL); err != nil {
+	if err := rt.db.UpdateUserPhoto(r.Context(), user.ID, photoURL); err != nil {
 		http.Error(w, "Failed to update photo in database", http.StatusInternalServerError)
 		return
 	}
@@ -181,7 +155,7 @@ func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, ps httprou
 	response := struct {
 		PhotoURL string `json:"photo_url"`
 	}{
-		PhotoURL: photoURL,
+		PhotoURL: rt.signPhotoURL(photoURL, user.PhotoVisibility),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -206,7 +180,7 @@ func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, ps httprou
 // 	}
 
 // 	// Get conversations
-// 	conversations, err := rt.db.GetUserConversations(user.ID)
+// 	conversations, err := rt.db.GetUserConversations(ctx, user.ID)
 // 	if err != nil {
 // 		http.Error(w, "Failed to get conversations", http.StatusInternalServerError)
 // 		return
@@ -230,14 +204,25 @@ func (rt *_router) getUserFromToken(r *http.Request) (*database.User, error) {
 		return nil, errors.New("invalid authorization header")
 	}
 
+	// Bot tokens only work on the routes registered for them, which authenticate the bot beforehand
+	if bot, ok := r.Context().Value(botUserKey{}).(*database.User); ok {
+		rt.touchPresence(r.Context(), bot)
+		return bot, nil
+	}
 	token := authHeader[7:]
+	if strings.HasPrefix(token, database.BotTokenPrefix) {
+		return nil, errors.New("bot tokens are not accepted on this route")
+	}
 	log.Printf("Looking for token: %s", token)
 
-	user, err := rt.db.GetUserByToken(token)
+	user, err := rt.db.GetUserByToken(r.Context(), token)
 	if err != nil {
 		log.Printf("Error getting user by token: %v", err)
+		return nil, err
 	}
-	return user, err
+
+	rt.touchPresence(r.Context(), user)
+	return user, nil
 }
 
 func (rt *_router) getUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -262,11 +247,19 @@ func (rt *_router) getUser(w http.ResponseWriter, r *http.Request, ps httprouter
 
 	// Return user data
 	response := struct {
-		Username string `json:"username"`
-		PhotoURL string `json:"photo_url"`
+		Username           string `json:"username"`
+		PhotoURL           string `json:"photo_url"`
+		DisplayName        string `json:"display_name"`
+		Bio                string `json:"bio"`
+		PhotoVisibility    string `json:"photo_visibility"`
+		LastSeenVisibility string `json:"last_seen_visibility"`
 	}{
-		Username: user.Username,
-		PhotoURL: user.PhotoURL,
+		Username:           user.Username,
+		PhotoURL:           rt.signPhotoURL(user.PhotoURL, user.PhotoVisibility),
+		DisplayName:        user.DisplayName,
+		Bio:                user.Bio,
+		PhotoVisibility:    user.PhotoVisibility,
+		LastSeenVisibility: user.LastSeenVisibility,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -280,7 +273,7 @@ func (rt *_router) checkUserExists(w http.ResponseWriter, r *http.Request, ps ht
 	username := ps.ByName("username")
 
 	// Check if user exists in the database
-	exists := rt.db.HasUser(username)
+	exists := rt.db.HasUser(r.Context(), username)
 
 	// Set response headers
 	w.Header().Set("Content-Type", "application/json")
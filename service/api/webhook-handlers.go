@@ -0,0 +1,194 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// defaultDeliveriesLimit and maxDeliveriesLimit bound the delivery history returned at once
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 200
+)
+
+// authorizeWebhookAdmin checks that the user of the request can manage the webhooks of a conversation. It writes the
+// error response and returns false otherwise.
+func (rt *_router) authorizeWebhookAdmin(w http.ResponseWriter, r *http.Request,
+	conversationId string) (*database.User, bool) {
+	user, err := rt.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	return rt.authorizeConversationAdmin(r.Context(), w, user, conversationId)
+}
+
+// createWebhook subscribes a URL to the events of a conversation. The secret signing the deliveries is generated
+// when not given, and is only returned here.
+func (rt *_router) createWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	user, ok := rt.authorizeWebhookAdmin(w, r, conversationId)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		http.Error(w, "URL must be an absolute http or https URL", http.StatusBadRequest)
+		return
+	}
+
+	// No events means all of them
+	events := req.Events
+	if len(events) == 0 {
+		events = webhookEvents
+	}
+	seen := make(map[string]bool)
+	var filtered []string
+	for _, event := range events {
+		if !isWebhookEvent(event) {
+			http.Error(w, "Unknown event "+strconv.Quote(event), http.StatusBadRequest)
+			return
+		}
+		if !seen[event] {
+			seen[event] = true
+			filtered = append(filtered, event)
+		}
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+			return
+		}
+		secret = hex.EncodeToString(buf)
+	}
+
+	webhook := database.Webhook{
+		ConversationID: conversationId,
+		URL:            target.String(),
+		Secret:         secret,
+		Events:         filtered,
+		CreatedBy:      user.ID,
+	}
+	if err := rt.db.CreateWebhook(r.Context(), &webhook); err != nil {
+		log.Printf("Error creating webhook: %v", err)
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(webhook)
+}
+
+// getWebhooks lists the webhooks of a conversation
+func (rt *_router) getWebhooks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	if _, ok := rt.authorizeWebhookAdmin(w, r, conversationId); !ok {
+		return
+	}
+
+	webhooks, err := rt.db.GetWebhooks(r.Context(), conversationId)
+	if err != nil {
+		log.Printf("Error getting webhooks: %v", err)
+		http.Error(w, "Failed to get webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(webhooks)
+}
+
+// deleteWebhook removes a webhook of a conversation and its pending deliveries
+func (rt *_router) deleteWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	if _, ok := rt.authorizeWebhookAdmin(w, r, conversationId); !ok {
+		return
+	}
+
+	err := rt.db.DeleteWebhook(r.Context(), conversationId, ps.ByName("webhookId"))
+	if errors.Is(err, database.ErrWebhookNotFound) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting webhook: %v", err)
+		http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getWebhookDeliveries returns the latest deliveries of a webhook, optionally filtered by status. Dead deliveries
+// are the ones that failed every attempt.
+func (rt *_router) getWebhookDeliveries(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conversationId := ps.ByName("conversationId")
+	if _, ok := rt.authorizeWebhookAdmin(w, r, conversationId); !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", database.WebhookDeliveryPending, database.WebhookDeliveryDelivered, database.WebhookDeliveryDead:
+	default:
+		http.Error(w, "Status must be one of pending, delivered, dead", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultDeliveriesLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxDeliveriesLimit {
+			http.Error(w, "Limit must be between 1 and "+strconv.Itoa(maxDeliveriesLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := rt.db.GetWebhookDeliveries(r.Context(), conversationId, ps.ByName("webhookId"), status, limit)
+	if errors.Is(err, database.ErrWebhookNotFound) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting webhook deliveries: %v", err)
+		http.Error(w, "Failed to get webhook deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(deliveries)
+}
+
+// isWebhookEvent tells whether webhooks can subscribe to event
+func isWebhookEvent(event string) bool {
+	for _, e := range webhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/linkpreview"
+	"github.com/google/uuid"
+)
+
+// Webhooks receive the events of a conversation as JSON POSTs signed with their secret. Events are queued in the
+// database and delivered by a background dispatcher, which retries failed deliveries with exponential backoff until
+// they succeed or, after webhookMaxAttempts, are dead: dead deliveries stay in the history as a dead-letter log.
+const (
+	// webhookDispatchInterval is how often the dispatcher looks for due deliveries when not woken up by an event
+	webhookDispatchInterval = 2 * time.Second
+
+	// webhookTimeout is how long a receiver can take to answer
+	webhookTimeout = 10 * time.Second
+
+	// webhookMaxAttempts is how many times a delivery is tried before it is dead
+	webhookMaxAttempts = 6
+
+	// webhookBaseBackoff is the wait before the first retry, doubled after every failed attempt
+	webhookBaseBackoff = 10 * time.Second
+
+	// webhookBatchSize is how many deliveries are sent at the same time
+	webhookBatchSize = 20
+
+	// webhookHistoryRetention is how long delivered and dead deliveries are kept in the history
+	webhookHistoryRetention = 30 * 24 * time.Hour
+)
+
+// allowPrivateReceivers lets webhooks and bot commands call loopback and private addresses. Only the tests set it, so
+// that they can use local receivers.
+var allowPrivateReceivers = false
+
+// newWebhookClient returns the client of webhooks and bot commands. Their URLs are given by users and called from the
+// server, so unless allowPrivate is set it refuses to connect to addresses that are not public, like the link preview
+// fetcher. Redirects are not followed.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowPrivate {
+		dialer.Control = linkpreview.ControlPublic
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   webhookTimeout,
+			ResponseHeaderTimeout: webhookTimeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       time.Minute,
+		},
+		Timeout: webhookTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Events webhooks can subscribe to
+const (
+	webhookMessageCreated = "message.created"
+	webhookMessageDeleted = "message.deleted"
+	webhookMessageReacted = "message.reacted"
+)
+
+var webhookEvents = []string{webhookMessageCreated, webhookMessageDeleted, webhookMessageReacted}
+
+// webhookEvent is the body of a delivery
+type webhookEvent struct {
+	ID             string      `json:"event_id"`
+	Event          string      `json:"event"`
+	ConversationID string      `json:"conversation_id"`
+	CreatedAt      time.Time   `json:"created_at"`
+	Data           interface{} `json:"data"`
+}
+
+// webhookMessage describes a message in message.created events
+type webhookMessage struct {
+	MessageID   string                `json:"message_id"`
+	SenderID    string                `json:"sender_id"`
+	Sender      string                `json:"sender"`
+	Content     string                `json:"content"`
+	Kind        string                `json:"kind"`
+	Forwarded   bool                  `json:"forwarded,omitempty"`
+	ReplyToID   string                `json:"reply_to_id,omitempty"`
+	ImageURL    string                `json:"image_url,omitempty"`
+	Attachments []database.Attachment `json:"attachments,omitempty"`
+	Poll        *database.Poll        `json:"poll,omitempty"`
+	Timestamp   time.Time             `json:"timestamp"`
+}
+
+// webhookMessageFrom describes a message for webhooks. Images get a signed URL.
+func (rt *_router) webhookMessageFrom(m *database.Message) webhookMessage {
+	wm := webhookMessage{
+		MessageID:   m.ID,
+		SenderID:    m.SenderID,
+		Sender:      m.Sender,
+		Content:     m.ContentStr,
+		Kind:        m.Kind,
+		Forwarded:   m.ForwardedFrom != nil,
+		ReplyToID:   m.ReplyToIDStr,
+		Attachments: m.Attachments,
+		Poll:        m.Poll,
+		Timestamp:   m.Time.UTC(),
+	}
+	if m.Content.Valid {
+		wm.Content = m.Content.String
+	}
+	if m.ReplyToID.Valid {
+		wm.ReplyToID = m.ReplyToID.String
+	}
+	if m.ImageURL.Valid {
+		wm.ImageURL = rt.signImageURL(m.ImageURL.String)
+	}
+	return wm
+}
+
+// emitMessageCreated sends a new message of a conversation to its webhooks
+func (rt *_router) emitMessageCreated(ctx context.Context, m *database.Message) {
+	rt.emitWebhookEvent(ctx, m.ConversationID, webhookMessageCreated, rt.webhookMessageFrom(m))
+}
+
+// emitWebhookEvent queues an event of a conversation for the webhooks subscribed to it. Failures are logged: the
+// action that caused the event already happened.
+func (rt *_router) emitWebhookEvent(ctx context.Context, conversationID string, event string, data interface{}) {
+	payload, err := json.Marshal(webhookEvent{
+		ID:             uuid.New().String(),
+		Event:          event,
+		ConversationID: conversationID,
+		CreatedAt:      time.Now().UTC(),
+		Data:           data,
+	})
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding webhook event")
+		return
+	}
+
+	queued, err := rt.db.QueueWebhookDeliveries(ctx, conversationID, event, payload)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error queueing webhook deliveries")
+		return
+	}
+	if queued > 0 {
+		select {
+		case rt.webhookWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// dispatchWebhooks delivers the due webhook deliveries every interval, or as soon as an event is queued, until the
+// router is closed
+func (rt *_router) dispatchWebhooks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-rt.stop:
+			return
+		case <-ticker.C:
+			rt.deliverDueWebhooks(rt.ctx)
+		case <-rt.webhookWake:
+			rt.deliverDueWebhooks(rt.ctx)
+		case <-prune.C:
+			if err := rt.db.DeleteOldWebhookDeliveries(rt.ctx, time.Now().Add(-webhookHistoryRetention)); err != nil {
+				rt.baseLogger.WithError(err).Error("error pruning webhook deliveries")
+			}
+		}
+	}
+}
+
+// deliverDueWebhooks sends a batch of due deliveries in parallel and records their outcome
+func (rt *_router) deliverDueWebhooks(ctx context.Context) {
+	deliveries, err := rt.db.GetDueWebhookDeliveries(ctx, time.Now(), webhookBatchSize)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting due webhook deliveries")
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range deliveries {
+		wg.Add(1)
+		go func(d database.WebhookDelivery) {
+			defer wg.Done()
+			rt.deliverWebhook(ctx, d)
+		}(d)
+	}
+	wg.Wait()
+}
+
+// deliverWebhook makes an attempt of a delivery, scheduling a retry or declaring it dead on failure
+func (rt *_router) deliverWebhook(ctx context.Context, d database.WebhookDelivery) {
+	statusCode, err := rt.postWebhook(d)
+	if err == nil {
+		if err := rt.db.MarkWebhookDelivered(ctx, d.ID, statusCode); err != nil {
+			rt.baseLogger.WithError(err).Error("error recording webhook delivery")
+		}
+		return
+	}
+
+	attempts := d.Attempts + 1
+	var retryAt *time.Time
+	if attempts < webhookMaxAttempts {
+		next := time.Now().Add(webhookBaseBackoff << (attempts - 1))
+		retryAt = &next
+	} else {
+		rt.baseLogger.WithError(err).Warnf("webhook delivery %s to %s is dead after %d attempts", d.ID, d.URL,
+			attempts)
+	}
+	if err := rt.db.MarkWebhookFailed(ctx, d.ID, statusCode, err.Error(), retryAt); err != nil {
+		rt.baseLogger.WithError(err).Error("error recording webhook delivery")
+	}
+}
+
+// postWebhook sends a delivery, returning the status code of the response if any. Only 2xx responses count as
+// delivered; redirects are not followed.
+func (rt *_router) postWebhook(d database.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "WASAText-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Delivery", d.ID)
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+webhookSignature(d.Secret, timestamp, d.Payload))
+
+	resp, err := rt.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookSignature is the hex HMAC-SHA256, keyed with the webhook secret, of the timestamp, a dot and the body.
+// Signing the timestamp lets receivers reject replayed deliveries.
+func webhookSignature(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
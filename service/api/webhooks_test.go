@@ -0,0 +1,266 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+)
+
+// webhookReceiver is a local server recording the deliveries it gets and answering them with status
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t *testing.T, status int) *webhookReceiver {
+	t.Helper()
+
+	rec := &webhookReceiver{status: status}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.requests = append(rec.requests, receivedWebhook{header: r.Header.Clone(), body: body})
+		w.WriteHeader(rec.status)
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+func (rec *webhookReceiver) received() []receivedWebhook {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]receivedWebhook(nil), rec.requests...)
+}
+
+// waitFor polls condition until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// subscribeWebhook creates a conversation between alice and bob with a webhook posting to url, returning the
+// conversation and the webhook IDs
+func subscribeWebhook(t *testing.T, rt *_router, h http.Handler, token string, url string) (string, string) {
+	t.Helper()
+
+	conversationID, err := rt.db.CreateConversation(context.Background(), []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	w := serveJSON(h, http.MethodPost, "/conversations/"+conversationID+"/webhooks", token,
+		`{"url":"`+url+`","secret":"s3cret","events":["message.created"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("error creating webhook: %d %s", w.Code, w.Body.String())
+	}
+	var webhook database.Webhook
+	decode(t, w, &webhook)
+	return conversationID, webhook.ID
+}
+
+// webhookDeliveries returns the delivery history of a webhook
+func webhookDeliveries(t *testing.T, h http.Handler, token string, conversationID string, webhookID string,
+	status string) []database.WebhookDelivery {
+	t.Helper()
+
+	w := serve(h, http.MethodGet, "/conversations/"+conversationID+"/webhooks/"+webhookID+"/deliveries?status="+
+		status, token, nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("error getting deliveries: %d %s", w.Code, w.Body.String())
+	}
+	var deliveries []database.WebhookDelivery
+	decode(t, w, &deliveries)
+	return deliveries
+}
+
+func TestWebhookSignature(t *testing.T) {
+	rt, h := newTestRouter(t)
+	alice := login(t, h, "alice")
+	login(t, h, "bob")
+	receiver := newWebhookReceiver(t, http.StatusOK)
+	conversationID, webhookID := subscribeWebhook(t, rt, h, alice, receiver.URL)
+
+	if w := serveJSON(h, http.MethodPost, "/conversations/"+conversationID+"/messages", alice,
+		`{"content":"hello"}`); w.Code != http.StatusOK {
+		t.Fatalf("error sending message: %d %s", w.Code, w.Body.String())
+	}
+	waitFor(t, "the delivery", func() bool { return len(receiver.received()) == 1 })
+
+	// The receiver checks the signature with the secret alone
+	got := receiver.received()[0]
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(got.header.Get("X-Webhook-Timestamp") + "."))
+	mac.Write(got.body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if signature := got.header.Get("X-Webhook-Signature"); !hmac.Equal([]byte(signature), []byte(expected)) {
+		t.Errorf("expected signature %s; got %s", expected, signature)
+	}
+	if got.header.Get("X-Webhook-Event") != webhookMessageCreated || got.header.Get("X-Webhook-Delivery") == "" {
+		t.Errorf("expected the event and delivery headers; got %v", got.header)
+	}
+
+	var event struct {
+		webhookEvent
+		Data webhookMessage `json:"data"`
+	}
+	if err := json.Unmarshal(got.body, &event); err != nil {
+		t.Fatalf("error decoding event: %v", err)
+	}
+	if event.Event != webhookMessageCreated || event.ConversationID != conversationID ||
+		event.Data.Content != "hello" || event.Data.Sender != "alice" {
+		t.Errorf("expected the message.created event of hello; got %+v", event)
+	}
+
+	waitFor(t, "the delivery to be recorded", func() bool {
+		return len(webhookDeliveries(t, h, alice, conversationID, webhookID, database.WebhookDeliveryDelivered)) == 1
+	})
+	d := webhookDeliveries(t, h, alice, conversationID, webhookID, database.WebhookDeliveryDelivered)[0]
+	if d.Attempts != 1 || d.LastStatusCode != http.StatusOK || d.DeliveredAt == nil {
+		t.Errorf("expected a delivery on the first attempt; got %+v", d)
+	}
+}
+
+func TestWebhookPrivateAddress(t *testing.T) {
+	allowPrivateReceivers = false
+	t.Cleanup(func() { allowPrivateReceivers = true })
+	rt, h := newTestRouter(t)
+	alice := login(t, h, "alice")
+	login(t, h, "bob")
+	receiver := newWebhookReceiver(t, http.StatusOK)
+	conversationID, webhookID := subscribeWebhook(t, rt, h, alice, receiver.URL)
+
+	if w := serveJSON(h, http.MethodPost, "/conversations/"+conversationID+"/messages", alice,
+		`{"content":"hello"}`); w.Code != http.StatusOK {
+		t.Fatalf("error sending message: %d %s", w.Code, w.Body.String())
+	}
+
+	// The server does not connect to its own network, so the receiver on loopback never gets the event
+	var pending []database.WebhookDelivery
+	waitFor(t, "the first attempt", func() bool {
+		pending = webhookDeliveries(t, h, alice, conversationID, webhookID, database.WebhookDeliveryPending)
+		return len(pending) == 1 && pending[0].Attempts == 1
+	})
+	if !strings.Contains(pending[0].LastError, "address not allowed") || pending[0].LastStatusCode != 0 {
+		t.Errorf("expected the address refused; got %+v", pending[0])
+	}
+	if n := len(receiver.received()); n != 0 {
+		t.Errorf("expected no requests to the receiver; got %d", n)
+	}
+}
+
+func TestWebhookMessageKind(t *testing.T) {
+	rt, h := newTestRouter(t)
+	alice := login(t, h, "alice")
+	login(t, h, "bob")
+	receiver := newWebhookReceiver(t, http.StatusOK)
+	conversationID, _ := subscribeWebhook(t, rt, h, alice, receiver.URL)
+
+	if w := serveJSON(h, http.MethodPost, "/conversations/"+conversationID+"/messages", alice,
+		`{"content":"hello"}`); w.Code != http.StatusOK {
+		t.Fatalf("error sending message: %d %s", w.Code, w.Body.String())
+	}
+	waitFor(t, "the text message", func() bool { return len(receiver.received()) == 1 })
+	if w := postImageMessage(t, h, alice, conversationID); w.Code != http.StatusCreated {
+		t.Fatalf("error sending image: %d %s", w.Code, w.Body.String())
+	}
+	waitFor(t, "the image message", func() bool { return len(receiver.received()) == 2 })
+
+	for i, kind := range []string{database.MessageKindText, database.MessageKindImage} {
+		var event struct {
+			Data webhookMessage `json:"data"`
+		}
+		if err := json.Unmarshal(receiver.received()[i].body, &event); err != nil {
+			t.Fatalf("error decoding event: %v", err)
+		}
+		if event.Data.Kind != kind || (kind == database.MessageKindImage) != (event.Data.ImageURL != "") {
+			t.Errorf("expected a %s message; got %+v", kind, event.Data)
+		}
+	}
+}
+
+func TestWebhookRetryAndDeadLetter(t *testing.T) {
+	rt, h := newTestRouter(t)
+	ctx := context.Background()
+	alice := login(t, h, "alice")
+	login(t, h, "bob")
+	receiver := newWebhookReceiver(t, http.StatusServiceUnavailable)
+	conversationID, webhookID := subscribeWebhook(t, rt, h, alice, receiver.URL)
+
+	sent := time.Now()
+	if w := serveJSON(h, http.MethodPost, "/conversations/"+conversationID+"/messages", alice,
+		`{"content":"hello"}`); w.Code != http.StatusOK {
+		t.Fatalf("error sending message: %d %s", w.Code, w.Body.String())
+	}
+
+	// The dispatcher makes the first attempt right away; the retries are made here, as they are not due for a while
+	var pending []database.WebhookDelivery
+	waitFor(t, "the first attempt", func() bool {
+		pending = webhookDeliveries(t, h, alice, conversationID, webhookID, database.WebhookDeliveryPending)
+		return len(pending) == 1 && pending[0].Attempts == 1
+	})
+	attempted := time.Now()
+
+	for attempt := 1; attempt < webhookMaxAttempts; attempt++ {
+		d := pending[0]
+		backoff := webhookBaseBackoff << (attempt - 1)
+		earliest := sent.Add(backoff).Truncate(time.Second)
+		if d.Attempts != attempt || d.LastStatusCode != http.StatusServiceUnavailable || d.NextAttemptAt == nil ||
+			d.NextAttemptAt.Before(earliest) || d.NextAttemptAt.After(attempted.Add(backoff)) {
+			t.Fatalf("expected attempt %d to fail with a retry in %s; got %+v", attempt, backoff, d)
+		}
+
+		due, err := rt.db.GetDueWebhookDeliveries(ctx, d.NextAttemptAt.Add(time.Second), webhookBatchSize)
+		if err != nil || len(due) != 1 {
+			t.Fatalf("expected the delivery due at its retry time; got %+v, %v", due, err)
+		}
+		sent = time.Now()
+		rt.deliverWebhook(ctx, due[0])
+		attempted = time.Now()
+		pending = webhookDeliveries(t, h, alice, conversationID, webhookID, database.WebhookDeliveryPending)
+		if attempt+1 < webhookMaxAttempts && len(pending) != 1 {
+			t.Fatalf("expected the delivery still pending after %d attempts; got %+v", attempt+1, pending)
+		}
+	}
+
+	// After the last attempt the delivery is dead, kept in the history and never retried
+	dead := webhookDeliveries(t, h, alice, conversationID, webhookID, database.WebhookDeliveryDead)
+	if len(pending) != 0 || len(dead) != 1 {
+		t.Fatalf("expected a dead delivery; got pending %+v and dead %+v", pending, dead)
+	}
+	if dead[0].Attempts != webhookMaxAttempts || dead[0].NextAttemptAt != nil || dead[0].LastError == "" {
+		t.Errorf("expected %d attempts and no retry; got %+v", webhookMaxAttempts, dead[0])
+	}
+	if n := len(receiver.received()); n != webhookMaxAttempts {
+		t.Errorf("expected %d requests; got %d", webhookMaxAttempts, n)
+	}
+	due, err := rt.db.GetDueWebhookDeliveries(ctx, time.Now().Add(24*time.Hour), webhookBatchSize)
+	if err != nil || len(due) != 0 {
+		t.Errorf("expected nothing left to deliver; got %+v, %v", due, err)
+	}
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrAttachmentNotFound is returned when an attachment does not exist
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// MaxAttachmentsPerMessage limits how many files a single message can carry
+const MaxAttachmentsPerMessage = 10
+
+// CreateAttachmentMessage creates a message carrying the given attachments, with an optional caption. The files must
+// already be stored under the attachments' StorageKey.
+func (db *appdbimpl) CreateAttachmentMessage(ctx context.Context, conversationID string, senderID string,
+	caption string, attachments []Attachment) (*Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if len(attachments) == 0 || len(attachments) > MaxAttachmentsPerMessage {
+		return nil, fmt.Errorf("a message must have between 1 and %d attachments", MaxAttachmentsPerMessage)
+	}
+
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
+
+	msg := Message{
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Content:        sql.NullString{String: caption, Valid: caption != ""},
+		Kind:           MessageKindAttachment,
+	}
+	if err := appendMessage(ctx, tx, &msg); err != nil {
+		return nil, err
+	}
+
+	for i := range attachments {
+		a := &attachments[i]
+		a.MessageID = msg.ID
+		a.ConversationID = conversationID
+		if a.UploaderID == "" {
+			a.UploaderID = senderID
+		}
+		if err := insertAttachment(ctx, tx, a, i); err != nil {
+			return nil, err
+		}
+	}
+	msg.Attachments = attachments
+
+	msg.Mentions, err = insertMentions(ctx, tx, conversationID, msg.ID, senderID, caption)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	msg.Reactions = make([]Reaction, 0)
+	return &msg, nil
+}
+
+// insertAttachment stores the metadata of an attachment at the given position of its message
+func insertAttachment(ctx context.Context, ex execer, a *Attachment, position int) error {
+	a.URL = attachmentURL(a.ID)
+	_, err := ex.ExecContext(ctx, `
+        INSERT INTO attachments (id, message_id, position, filename, mime_type, size, checksum, storage_key,
+                                 duration_ms, width, height, uploaded_by)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''))
+    `, a.ID, a.MessageID, position, a.Filename, a.MIMEType, a.Size, a.Checksum, a.StorageKey,
+		a.DurationMS, a.Width, a.Height, a.UploaderID)
+	if err != nil {
+		return fmt.Errorf("error inserting attachment: %w", err)
+	}
+	return nil
+}
+
+// copyAttachments gives the message toMessageID a copy of every attachment of fromMessageID, sharing the stored files
+func copyAttachments(ctx context.Context, ex execer, fromMessageID string, toMessageID string) error {
+	attachments, err := getMessageAttachments(ctx, ex, fromMessageID)
+	if err != nil {
+		return err
+	}
+	for i := range attachments {
+		a := &attachments[i]
+		a.ID = generateUUID()
+		a.MessageID = toMessageID
+		if err := insertAttachment(ctx, ex, a, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAttachment returns an attachment with the conversation it was sent to
+func (db *appdbimpl) GetAttachment(ctx context.Context, attachmentID string) (*Attachment, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var a Attachment
+	err := db.r.QueryRowContext(ctx, `
+        SELECT a.id, a.message_id, m.conversation_id, a.filename, a.mime_type, a.size, a.checksum, a.storage_key,
+               a.duration_ms, a.width, a.height, COALESCE(a.uploaded_by, '')
+        FROM attachments a
+        JOIN messages m ON m.id = a.message_id
+        WHERE a.id = ?
+    `, attachmentID).Scan(&a.ID, &a.MessageID, &a.ConversationID, &a.Filename, &a.MIMEType, &a.Size, &a.Checksum,
+		&a.StorageKey, &a.DurationMS, &a.Width, &a.Height, &a.UploaderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting attachment: %w", err)
+	}
+	a.URL = attachmentURL(a.ID)
+	return &a, nil
+}
+
+// getMessageAttachments returns the attachments of a message in the order they were sent
+func getMessageAttachments(ctx context.Context, ex execer, messageID string) ([]Attachment, error) {
+	rows, err := ex.QueryContext(ctx, `
+        SELECT a.id, a.message_id, m.conversation_id, a.filename, a.mime_type, a.size, a.checksum, a.storage_key,
+               a.duration_ms, a.width, a.height, COALESCE(a.uploaded_by, '')
+        FROM attachments a
+        JOIN messages m ON m.id = a.message_id
+        WHERE a.message_id = ?
+        ORDER BY a.position
+    `, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting attachments: %w", err)
+	}
+	defer rows.Close()
+
+	return scanAttachments(rows)
+}
+
+// getConversationAttachments returns the attachments of every message of a conversation, by message ID
+func (db *appdbimpl) getConversationAttachments(ctx context.Context, conversationID string) (map[string][]Attachment,
+	error) {
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT a.id, a.message_id, m.conversation_id, a.filename, a.mime_type, a.size, a.checksum, a.storage_key,
+               a.duration_ms, a.width, a.height, COALESCE(a.uploaded_by, '')
+        FROM attachments a
+        JOIN messages m ON m.id = a.message_id
+        WHERE m.conversation_id = ?
+        ORDER BY a.message_id, a.position
+    `, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting attachments: %w", err)
+	}
+	defer rows.Close()
+
+	attachments, err := scanAttachments(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	byMessage := make(map[string][]Attachment)
+	for _, a := range attachments {
+		byMessage[a.MessageID] = append(byMessage[a.MessageID], a)
+	}
+	return byMessage, nil
+}
+
+// scanAttachments reads attachment rows selected with the column order of getMessageAttachments
+func scanAttachments(rows *sql.Rows) ([]Attachment, error) {
+	attachments := make([]Attachment, 0)
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.ID, &a.MessageID, &a.ConversationID, &a.Filename, &a.MIMEType, &a.Size, &a.Checksum,
+			&a.StorageKey, &a.DurationMS, &a.Width, &a.Height, &a.UploaderID); err != nil {
+			return nil, fmt.Errorf("error scanning attachment: %w", err)
+		}
+		a.URL = attachmentURL(a.ID)
+		attachments = append(attachments, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating attachments: %w", err)
+	}
+	return attachments, nil
+}
+
+// attachmentURL is the API path an attachment is downloaded from
+func attachmentURL(attachmentID string) string {
+	return "/attachments/" + attachmentID
+}
+
+// deleteMessages removes messages and refreshes the previews of their conversations. Their reactions, pins, mentions,
+// attachments, link previews and polls are deleted by the foreign key cascades, which also detach the replies to them.
+func (db *appdbimpl) deleteMessages(ctx context.Context, tx *sql.Tx, messageIDs []string) error {
+	conversations := make(map[string]bool)
+	for _, id := range messageIDs {
+		var conversationID string
+		err := tx.QueryRowContext(ctx, `SELECT conversation_id FROM messages WHERE id = ?`, id).Scan(&conversationID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error getting message conversation: %w", err)
+		}
+		conversations[conversationID] = true
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("error deleting message: %w", err)
+		}
+	}
+
+	for conversationID := range conversations {
+		if err := db.refreshPreview(ctx, tx, conversationID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAttachmentMessages(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	otherID, err := db.CreateConversation(ctx, []string{"alice"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	duration := int64(12500)
+	msg, err := db.CreateAttachmentMessage(ctx, conversationID, "user1", "hey @bob", []Attachment{
+		{ID: "att1", Filename: "notes.pdf", MIMEType: "application/pdf", Size: 1024, Checksum: "aa", StorageKey: "key1"},
+		{ID: "att2", Filename: "voice.ogg", MIMEType: "audio/ogg", Size: 2048, Checksum: "bb", StorageKey: "key2",
+			DurationMS: &duration},
+	})
+	if err != nil {
+		t.Fatalf("error creating attachment message: %v", err)
+	}
+	if msg.Kind != "attachment" || msg.ContentStr != "hey @bob" || len(msg.Mentions) != 1 {
+		t.Errorf("expected an attachment message mentioning bob; got %+v", msg)
+	}
+
+	if _, err := db.CreateAttachmentMessage(ctx, conversationID, "user1", "", nil); err == nil {
+		t.Errorf("expected an error for a message without attachments")
+	}
+
+	messages, err := db.GetConversationMessages(ctx, conversationID, "user2")
+	if err != nil {
+		t.Fatalf("error getting messages: %v", err)
+	}
+	if len(messages) != 1 || len(messages[0].Attachments) != 2 {
+		t.Fatalf("expected one message with two attachments; got %+v", messages)
+	}
+	voice := messages[0].Attachments[1]
+	if voice.Filename != "voice.ogg" || voice.DurationMS == nil || *voice.DurationMS != duration ||
+		voice.URL != "/attachments/att2" {
+		t.Errorf("expected voice.ogg lasting %dms; got %+v", duration, voice)
+	}
+
+	attachment, err := db.GetAttachment(ctx, "att1")
+	if err != nil {
+		t.Fatalf("error getting attachment: %v", err)
+	}
+	if attachment.ConversationID != conversationID || attachment.StorageKey != "key1" {
+		t.Errorf("expected att1 in %s stored as key1; got %+v", conversationID, attachment)
+	}
+	if _, err := db.GetAttachment(ctx, "missing"); !errors.Is(err, ErrAttachmentNotFound) {
+		t.Errorf("expected ErrAttachmentNotFound; got %v", err)
+	}
+
+	// A forwarded copy shares the stored files, which outlive the original until the copy is gone too
+	forwarded, err := forwardTo(db, msg.ID, "user1", otherID)
+	if err != nil {
+		t.Fatalf("error forwarding message: %v", err)
+	}
+	if len(forwarded.Attachments) != 2 || forwarded.Attachments[0].ID == "att1" ||
+		forwarded.Attachments[0].StorageKey != "key1" {
+		t.Errorf("expected copies of the attachments sharing key1; got %+v", forwarded.Attachments)
+	}
+
+	if err := db.DeleteMessage(ctx, msg.ID); err != nil {
+		t.Fatalf("error deleting message: %v", err)
+	}
+	if refs, err := db.GetMediaReferences(ctx); err != nil || refs["attachments/key1"] != 1 {
+		t.Errorf("expected the forwarded copy to keep key1; got %v, %v", refs, err)
+	}
+	if _, err := db.GetAttachment(ctx, "att1"); !errors.Is(err, ErrAttachmentNotFound) {
+		t.Errorf("expected att1 to be deleted with its message; got %v", err)
+	}
+
+	if err := db.DeleteMessage(ctx, forwarded.ID); err != nil {
+		t.Fatalf("error deleting message: %v", err)
+	}
+	if refs, err := db.GetMediaReferences(ctx); err != nil || len(refs) != 0 {
+		t.Errorf("expected both files to be unreferenced; got %v, %v", refs, err)
+	}
+}
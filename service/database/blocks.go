@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBlocked is returned when a message or conversation involves two users and one of them blocked the other
+var ErrBlocked = errors.New("one of the users has blocked the other")
+
+// BlockUser prevents blockedID from starting conversations or sending direct messages to blockerID
+func (db *appdbimpl) BlockUser(ctx context.Context, blockerID string, blockedID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if blockerID == blockedID {
+		return errors.New("users cannot block themselves")
+	}
+
+	_, err := db.c.ExecContext(ctx, `
+        INSERT INTO blocks (blocker_id, blocked_id, created_at)
+        VALUES (?, ?, ?)
+        ON CONFLICT DO NOTHING
+    `, blockerID, blockedID, time.Now())
+	if err != nil {
+		return fmt.Errorf("error blocking user: %w", err)
+	}
+	return nil
+}
+
+// UnblockUser removes a block
+func (db *appdbimpl) UnblockUser(ctx context.Context, blockerID string, blockedID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.c.ExecContext(ctx, `
+        DELETE FROM blocks
+        WHERE blocker_id = ? AND blocked_id = ?
+    `, blockerID, blockedID)
+	if err != nil {
+		return fmt.Errorf("error unblocking user: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if rows == 0 {
+		return errors.New("user is not blocked")
+	}
+	return nil
+}
+
+// checkUsersNotBlocked returns ErrBlocked if any two of the given user IDs have a block between them, in either
+// direction
+func checkUsersNotBlocked(ctx context.Context, ex execer, userIDs []string) error {
+	for i, a := range userIDs {
+		for _, b := range userIDs[i+1:] {
+			var blocked bool
+			err := ex.QueryRowContext(ctx, `
+                SELECT EXISTS(
+                    SELECT 1 FROM blocks
+                    WHERE (blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)
+                )
+            `, a, b, b, a).Scan(&blocked)
+			if err != nil {
+				return fmt.Errorf("error checking blocks: %w", err)
+			}
+			if blocked {
+				return ErrBlocked
+			}
+		}
+	}
+	return nil
+}
+
+// checkSenderNotBlocked returns ErrBlocked if the conversation is a direct conversation and the sender and the other
+// participant have a block between them. Groups are not affected by blocks.
+func checkSenderNotBlocked(ctx context.Context, ex execer, conversationID string, senderID string) error {
+	var blocked bool
+	err := ex.QueryRowContext(ctx, `
+        SELECT EXISTS(
+            SELECT 1
+            FROM conversation_participants me
+            JOIN conversation_participants other
+              ON other.conversation_id = me.conversation_id AND other.user_id != me.user_id
+            JOIN blocks b
+              ON (b.blocker_id = other.user_id AND b.blocked_id = me.user_id)
+              OR (b.blocker_id = me.user_id AND b.blocked_id = other.user_id)
+            WHERE me.conversation_id = ? AND me.user_id = ?
+        )
+    `, conversationID, senderID).Scan(&blocked)
+	if err != nil {
+		return fmt.Errorf("error checking blocks: %w", err)
+	}
+	if blocked {
+		return ErrBlocked
+	}
+	return nil
+}
+
+// MuteConversation silences a conversation for a member until the given time, or forever when until is nil
+func (db *appdbimpl) MuteConversation(ctx context.Context, conversationID string, userID string,
+	until *time.Time) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var mutedUntil sql.NullTime
+	if until != nil {
+		mutedUntil = sql.NullTime{Time: *until, Valid: true}
+	}
+
+	_, err := db.c.ExecContext(ctx, `
+        INSERT INTO conversation_mutes (conversation_id, user_id, muted_until)
+        VALUES (?, ?, ?)
+        ON CONFLICT (conversation_id, user_id) DO UPDATE SET muted_until = excluded.muted_until
+    `, conversationID, userID, mutedUntil)
+	if err != nil {
+		return fmt.Errorf("error muting conversation: %w", err)
+	}
+	return nil
+}
+
+// UnmuteConversation removes the mute of a member
+func (db *appdbimpl) UnmuteConversation(ctx context.Context, conversationID string, userID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.c.ExecContext(ctx, `
+        DELETE FROM conversation_mutes
+        WHERE conversation_id = ? AND user_id = ?
+    `, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("error unmuting conversation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBlockUser(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2'),
+		('user3', 'carol', 'token3')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	// A message bob can forward
+	otherID, err := db.CreateConversation(ctx, []string{"bob", "carol"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	forwardable, err := db.CreateMessage(ctx, otherID, "user3", "hi")
+	if err != nil {
+		t.Fatalf("error creating message: %v", err)
+	}
+
+	// A message bob can reply to
+	repliable, err := db.CreateMessage(ctx, conversationID, "user1", "hello")
+	if err != nil {
+		t.Fatalf("error creating message: %v", err)
+	}
+
+	if err := db.BlockUser(ctx, "user1", "user1"); err == nil {
+		t.Error("expected error blocking yourself but got none")
+	}
+	if err := db.BlockUser(ctx, "user1", "user2"); err != nil {
+		t.Fatalf("unexpected error blocking: %v", err)
+	}
+
+	// Every send path enforces the block, in both directions
+	if _, err := db.CreateMessage(ctx, conversationID, "user2", "hi"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("CreateMessage: expected ErrBlocked; got %v", err)
+	}
+	if _, err := db.SendMessage(ctx, conversationID, "user1", "hi"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("SendMessage: expected ErrBlocked; got %v", err)
+	}
+	if _, err := db.CreateReplyMessage(ctx, conversationID, "user2", "hi", repliable); !errors.Is(err, ErrBlocked) {
+		t.Errorf("CreateReplyMessage: expected ErrBlocked; got %v", err)
+	}
+	_, err = db.CreateImageMessage(ctx, conversationID, "user2", "/uploads/images/x.png")
+	if !errors.Is(err, ErrBlocked) {
+		t.Errorf("CreateImageMessage: expected ErrBlocked; got %v", err)
+	}
+	if _, err := forwardTo(db, forwardable, "user2", conversationID); !errors.Is(err, ErrBlocked) {
+		t.Errorf("ForwardMessage: expected ErrBlocked; got %v", err)
+	}
+	if _, err := db.CreateConversation(ctx, []string{"bob", "alice"}); !errors.Is(err, ErrBlocked) {
+		t.Errorf("CreateConversation: expected ErrBlocked; got %v", err)
+	}
+
+	for query, expected := range map[string]int{"bob": 0, "carol": 1} {
+		users, _, err := db.SearchUsers(ctx, "user1", query, 10, "")
+		if err != nil {
+			t.Fatalf("error searching users: %v", err)
+		}
+		if len(users) != expected {
+			t.Errorf("expected bob to be hidden; got %+v searching %s", users, query)
+		}
+	}
+
+	if err := db.UnblockUser(ctx, "user1", "user2"); err != nil {
+		t.Fatalf("unexpected error unblocking: %v", err)
+	}
+	if err := db.UnblockUser(ctx, "user1", "user2"); err == nil {
+		t.Error("expected error unblocking twice but got none")
+	}
+	if _, err := db.CreateMessage(ctx, conversationID, "user2", "hi again"); err != nil {
+		t.Errorf("unexpected error after unblock: %v", err)
+	}
+}
+
+func TestMuteConversation(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('alice', 'alice', 'token1'),
+		('bob', 'bob', 'token2')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	tests := []struct {
+		name        string
+		until       *time.Time
+		expectMuted bool
+	}{
+		{name: "muted forever", until: nil, expectMuted: true},
+		{name: "muted until a future time", until: &until, expectMuted: true},
+		{name: "mute expired", until: &time.Time{}, expectMuted: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := db.MuteConversation(ctx, conversationID, "alice", tt.until); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			conversations, _, err := db.GetUserConversations(ctx, "alice", 50, "")
+			if err != nil {
+				t.Fatalf("error getting conversations: %v", err)
+			}
+			if len(conversations) != 1 {
+				t.Fatalf("expected 1 conversation; got %d", len(conversations))
+			}
+			conv := conversations[0]
+			if conv.Muted != tt.expectMuted {
+				t.Errorf("expected muted=%v; got %v", tt.expectMuted, conv.Muted)
+			}
+			if tt.expectMuted && tt.until != nil && (conv.MutedUntil == nil || !conv.MutedUntil.Equal(*tt.until)) {
+				t.Errorf("expected muted until %v; got %v", *tt.until, conv.MutedUntil)
+			}
+		})
+	}
+
+	if err := db.UnmuteConversation(ctx, conversationID, "alice"); err != nil {
+		t.Fatalf("unexpected error unmuting: %v", err)
+	}
+	conversations, _, err := db.GetUserConversations(ctx, "bob", 50, "")
+	if err != nil {
+		t.Fatalf("error getting conversations: %v", err)
+	}
+	if len(conversations) != 1 || conversations[0].Muted {
+		t.Errorf("expected the conversation not to be muted for bob; got %+v", conversations)
+	}
+}
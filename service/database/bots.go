@@ -0,0 +1,262 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BotTokenPrefix starts every bot token, telling them apart from the session tokens of humans
+const BotTokenPrefix = "bot_"
+
+var (
+	// ErrBotNotFound is returned when a bot does not exist or belongs to another user
+	ErrBotNotFound = errors.New("bot not found")
+
+	// ErrBotTokenNotFound is returned when a token does not exist, belongs to another bot or is revoked
+	ErrBotTokenNotFound = errors.New("bot token not found")
+
+	// ErrUsernameTaken is returned when creating a bot with the username of an existing user
+	ErrUsernameTaken = errors.New("username is already taken")
+
+	// ErrBotLogin is returned when trying to start a session as a bot
+	ErrBotLogin = errors.New("bots cannot log in, use a bot token")
+)
+
+// CreateBot creates a bot account owned by ownerID. Bots can be added to conversations like any user but do not
+// show up in searches.
+func (db *appdbimpl) CreateBot(ctx context.Context, ownerID string, username string, displayName string) (*Bot, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+	if displayName == "" {
+		displayName = username
+	}
+
+	var exists bool
+	if err := db.c.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)`,
+		username).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("error checking username: %w", err)
+	}
+	if exists {
+		return nil, ErrUsernameTaken
+	}
+
+	// Bots never use the session token, which is only there because every user has one
+	bot := Bot{
+		ID:          generateUUID(),
+		Username:    username,
+		DisplayName: displayName,
+		OwnerID:     ownerID,
+	}
+	_, err := db.c.ExecContext(ctx, `
+        INSERT INTO users (id, username, token, display_name, is_bot, owner_id, last_seen_visibility)
+        VALUES (?, ?, ?, ?, 1, ?, ?)
+    `, bot.ID, bot.Username, generateUUID(), bot.DisplayName, bot.OwnerID, VisibleToNobody)
+	if err != nil {
+		return nil, fmt.Errorf("error creating bot: %w", err)
+	}
+	return &bot, nil
+}
+
+// GetBots returns the bots owned by a user
+func (db *appdbimpl) GetBots(ctx context.Context, ownerID string) ([]Bot, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT id, username, COALESCE(display_name, username), owner_id
+        FROM users
+        WHERE is_bot = 1 AND owner_id = ?
+        ORDER BY username
+    `, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting bots: %w", err)
+	}
+	defer rows.Close()
+
+	bots := make([]Bot, 0)
+	for rows.Next() {
+		var b Bot
+		if err := rows.Scan(&b.ID, &b.Username, &b.DisplayName, &b.OwnerID); err != nil {
+			return nil, fmt.Errorf("error scanning bot: %w", err)
+		}
+		bots = append(bots, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating bots: %w", err)
+	}
+	return bots, nil
+}
+
+// CreateBotToken issues a new token for a bot owned by ownerID, limited to scopes. The token is only returned here.
+func (db *appdbimpl) CreateBotToken(ctx context.Context, ownerID string, botID string, scopes []string) (*BotToken,
+	error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := checkBotOwner(ctx, db.c, ownerID, botID); err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("error generating bot token: %w", err)
+	}
+	token := BotToken{
+		ID:        generateUUID(),
+		BotID:     botID,
+		Token:     BotTokenPrefix + hex.EncodeToString(secret),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	_, err := db.c.ExecContext(ctx, `
+        INSERT INTO bot_tokens (id, bot_id, token_hash, scopes, created_at)
+        VALUES (?, ?, ?, ?, ?)
+    `, token.ID, token.BotID, hashBotToken(token.Token), strings.Join(scopes, ","), token.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error creating bot token: %w", err)
+	}
+	return &token, nil
+}
+
+// GetBotTokens returns the tokens of a bot owned by ownerID, revoked ones included, without the tokens themselves
+func (db *appdbimpl) GetBotTokens(ctx context.Context, ownerID string, botID string) ([]BotToken, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := checkBotOwner(ctx, db.r, ownerID, botID); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT id, bot_id, scopes, `+db.dialect.formatTime("created_at")+`,
+               `+db.dialect.formatTime("last_used_at")+`, `+db.dialect.formatTime("revoked_at")+`
+        FROM bot_tokens
+        WHERE bot_id = ?
+        ORDER BY created_at, rowid
+    `, botID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting bot tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]BotToken, 0)
+	for rows.Next() {
+		var t BotToken
+		var scopes, createdAt string
+		var lastUsedAt, revokedAt sql.NullString
+		if err := rows.Scan(&t.ID, &t.BotID, &scopes, &createdAt, &lastUsedAt, &revokedAt); err != nil {
+			return nil, fmt.Errorf("error scanning bot token: %w", err)
+		}
+		t.Scopes = splitScopes(scopes)
+		if t.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAt); err != nil {
+			return nil, fmt.Errorf("error parsing timestamp: %w", err)
+		}
+		if t.LastUsedAt, err = parseNullTimestamp(lastUsedAt); err != nil {
+			return nil, err
+		}
+		if t.RevokedAt, err = parseNullTimestamp(revokedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating bot tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// RevokeBotToken stops a token of a bot owned by ownerID from working. Revoked tokens stay listed.
+func (db *appdbimpl) RevokeBotToken(ctx context.Context, ownerID string, botID string, tokenID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := checkBotOwner(ctx, db.c, ownerID, botID); err != nil {
+		return err
+	}
+
+	res, err := db.c.ExecContext(ctx, `
+        UPDATE bot_tokens SET revoked_at = ?
+        WHERE id = ? AND bot_id = ? AND revoked_at IS NULL
+    `, time.Now().UTC(), tokenID, botID)
+	if err != nil {
+		return fmt.Errorf("error revoking bot token: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrBotTokenNotFound
+	}
+	return nil
+}
+
+// GetUserByBotToken returns the bot a token that is not revoked belongs to, with the scopes of the token
+func (db *appdbimpl) GetUserByBotToken(ctx context.Context, token string) (*User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var user User
+	var tokenID, scopes string
+	var photoURL, displayName, bio sql.NullString
+	err := db.c.QueryRowContext(ctx, `
+        SELECT t.id, t.scopes, u.id, u.username, u.photo_url, u.display_name, u.bio, u.photo_visibility,
+               u.last_seen_visibility, u.forward_visibility
+        FROM bot_tokens t
+        JOIN users u ON u.id = t.bot_id
+        WHERE t.token_hash = ? AND t.revoked_at IS NULL AND u.is_bot = 1
+    `, hashBotToken(token)).Scan(&tokenID, &scopes, &user.ID, &user.Username, &photoURL, &displayName, &bio,
+		&user.PhotoVisibility, &user.LastSeenVisibility, &user.ForwardVisibility)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrBotTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting bot token: %w", err)
+	}
+	user.PhotoURL = photoURL.String
+	user.DisplayName = displayName.String
+	user.Bio = bio.String
+	user.IsBot = true
+	user.Scopes = splitScopes(scopes)
+
+	if _, err := db.c.ExecContext(ctx, `UPDATE bot_tokens SET last_used_at = ? WHERE id = ?`, time.Now().UTC(),
+		tokenID); err != nil {
+		return nil, fmt.Errorf("error updating bot token: %w", err)
+	}
+	return &user, nil
+}
+
+// checkBotOwner returns ErrBotNotFound unless botID is a bot owned by ownerID
+func checkBotOwner(ctx context.Context, e execer, ownerID string, botID string) error {
+	var owned bool
+	err := e.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND is_bot = 1 AND owner_id = ?)`,
+		botID, ownerID).Scan(&owned)
+	if err != nil {
+		return fmt.Errorf("error checking bot: %w", err)
+	}
+	if !owned {
+		return ErrBotNotFound
+	}
+	return nil
+}
+
+// hashBotToken is what is stored of a bot token: unlike session tokens they live long, so they are not kept in clear
+func hashBotToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitScopes reads the scopes of a token, stored comma separated
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return []string{}
+	}
+	return strings.Split(scopes, ",")
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBots(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+
+	bot, err := db.CreateBot(ctx, "user1", "standup", "Standup reminder")
+	if err != nil {
+		t.Fatalf("error creating bot: %v", err)
+	}
+	if _, err := db.CreateBot(ctx, "user1", "bob", ""); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("expected ErrUsernameTaken; got %v", err)
+	}
+	if _, err := db.CreateSession(ctx, "standup"); !errors.Is(err, ErrBotLogin) {
+		t.Errorf("expected bots not to log in; got %v", err)
+	}
+	if bots, err := db.GetBots(ctx, "user1"); err != nil || len(bots) != 1 || bots[0].ID != bot.ID {
+		t.Errorf("expected the bot of alice; got %+v, %v", bots, err)
+	}
+	if bots, err := db.GetBots(ctx, "user2"); err != nil || len(bots) != 0 {
+		t.Errorf("expected bob to have no bots; got %+v, %v", bots, err)
+	}
+
+	// Only the owner manages the tokens
+	if _, err := db.CreateBotToken(ctx, "user2", bot.ID, []string{"messages:write"}); !errors.Is(err, ErrBotNotFound) {
+		t.Errorf("expected ErrBotNotFound for another user; got %v", err)
+	}
+	token, err := db.CreateBotToken(ctx, "user1", bot.ID, []string{"messages:write", "conversations:read"})
+	if err != nil {
+		t.Fatalf("error creating bot token: %v", err)
+	}
+	if !strings.HasPrefix(token.Token, BotTokenPrefix) {
+		t.Errorf("expected the token to start with %q; got %q", BotTokenPrefix, token.Token)
+	}
+
+	user, err := db.GetUserByBotToken(ctx, token.Token)
+	if err != nil || user.ID != bot.ID || !user.IsBot || len(user.Scopes) != 2 || user.Scopes[1] != "conversations:read" {
+		t.Fatalf("expected the bot with the token scopes; got %+v, %v", user, err)
+	}
+	tokens, err := db.GetBotTokens(ctx, "user1", bot.ID)
+	if err != nil || len(tokens) != 1 || tokens[0].Token != "" || tokens[0].LastUsedAt == nil {
+		t.Errorf("expected one used token without its value; got %+v, %v", tokens, err)
+	}
+
+	if err := db.RevokeBotToken(ctx, "user1", bot.ID, token.ID); err != nil {
+		t.Fatalf("error revoking bot token: %v", err)
+	}
+	if err := db.RevokeBotToken(ctx, "user1", bot.ID, token.ID); !errors.Is(err, ErrBotTokenNotFound) {
+		t.Errorf("expected ErrBotTokenNotFound revoking twice; got %v", err)
+	}
+	if _, err := db.GetUserByBotToken(ctx, token.Token); !errors.Is(err, ErrBotTokenNotFound) {
+		t.Errorf("expected a revoked token to fail; got %v", err)
+	}
+
+	// Bots are told apart in conversations and left out of searches
+	if _, err := db.CreateGroup(ctx, "team", "user1", []string{"bob", "standup"}); err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+	conversations, _, err := db.GetUserConversations(ctx, "user1", 50, "")
+	if err != nil || len(conversations) != 1 || len(conversations[0].Participants) != 3 ||
+		len(conversations[0].Bots) != 1 || conversations[0].Bots[0] != "standup" {
+		t.Errorf("expected the bot among the participants; got %+v, %v", conversations, err)
+	}
+	details, err := db.GetConversationDetails(ctx, conversations[0].ID)
+	if err != nil {
+		t.Fatalf("error getting conversation details: %v", err)
+	}
+	for _, p := range details.Participants {
+		if p.IsBot != (p.Username == "standup") {
+			t.Errorf("expected only standup to be a bot; got %+v", p)
+		}
+	}
+	users, _, err := db.SearchUsers(ctx, "user2", "standup", 10, "")
+	if err != nil || len(users) != 0 {
+		t.Errorf("expected the search to leave the bot out; got %+v, %v", users, err)
+	}
+}
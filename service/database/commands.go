@@ -0,0 +1,234 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	// ErrCommandNotFound is returned when no bot registered a command in the conversation
+	ErrCommandNotFound = errors.New("command not found")
+
+	// ErrCommandTaken is returned when registering a command another bot registered in the conversation
+	ErrCommandTaken = errors.New("command registered by another bot")
+)
+
+// RegisterCommand registers command.Name in command.ConversationID for command.BotID, replacing the previous
+// registration of the same bot
+func (db *appdbimpl) RegisterCommand(ctx context.Context, command *SlashCommand) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
+
+	var owner string
+	err = tx.QueryRowContext(ctx, `SELECT bot_id FROM slash_commands WHERE conversation_id = ? AND name = ?`,
+		command.ConversationID, command.Name).Scan(&owner)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("error checking command: %w", err)
+	}
+	if err == nil && owner != command.BotID {
+		return ErrCommandTaken
+	}
+
+	if err := tx.QueryRowContext(ctx, "SELECT username FROM users WHERE id = ?",
+		command.BotID).Scan(&command.Bot); err != nil {
+		return fmt.Errorf("error getting bot username: %w", err)
+	}
+	command.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO slash_commands (conversation_id, name, bot_id, url, secret, description, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (conversation_id, name) DO UPDATE
+        SET bot_id = excluded.bot_id, url = excluded.url, secret = excluded.secret,
+            description = excluded.description, created_at = excluded.created_at
+    `, command.ConversationID, command.Name, command.BotID, command.URL, command.Secret, command.Description,
+		command.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error registering command: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// GetCommands returns the commands registered by bots in a conversation, without their URLs and secrets
+func (db *appdbimpl) GetCommands(ctx context.Context, conversationID string) ([]SlashCommand, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT c.conversation_id, c.name, c.description, c.bot_id, COALESCE(u.username, c.bot_id),
+               `+db.dialect.formatTime("c.created_at")+`
+        FROM slash_commands c
+        LEFT JOIN users u ON u.id = c.bot_id
+        WHERE c.conversation_id = ?
+        ORDER BY c.name
+    `, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting commands: %w", err)
+	}
+	defer rows.Close()
+
+	commands := make([]SlashCommand, 0)
+	for rows.Next() {
+		var c SlashCommand
+		var createdAt string
+		if err := rows.Scan(&c.ConversationID, &c.Name, &c.Description, &c.BotID, &c.Bot, &createdAt); err != nil {
+			return nil, fmt.Errorf("error scanning command: %w", err)
+		}
+		if c.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAt); err != nil {
+			return nil, fmt.Errorf("error parsing timestamp: %w", err)
+		}
+		commands = append(commands, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating commands: %w", err)
+	}
+	return commands, nil
+}
+
+// GetCommand returns a command registered in a conversation with its URL and secret
+func (db *appdbimpl) GetCommand(ctx context.Context, conversationID string, name string) (*SlashCommand, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var c SlashCommand
+	var createdAt string
+	err := db.r.QueryRowContext(ctx, `
+        SELECT c.conversation_id, c.name, c.description, c.bot_id, COALESCE(u.username, c.bot_id), c.url, c.secret,
+               `+db.dialect.formatTime("c.created_at")+`
+        FROM slash_commands c
+        LEFT JOIN users u ON u.id = c.bot_id
+        WHERE c.conversation_id = ? AND c.name = ?
+    `, conversationID, name).Scan(&c.ConversationID, &c.Name, &c.Description, &c.BotID, &c.Bot, &c.URL, &c.Secret,
+		&createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCommandNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting command: %w", err)
+	}
+	if c.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAt); err != nil {
+		return nil, fmt.Errorf("error parsing timestamp: %w", err)
+	}
+	return &c, nil
+}
+
+// DeleteCommand removes a command registered in a conversation
+func (db *appdbimpl) DeleteCommand(ctx context.Context, conversationID string, name string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	res, err := db.c.ExecContext(ctx, `DELETE FROM slash_commands WHERE conversation_id = ? AND name = ?`, conversationID,
+		name)
+	if err != nil {
+		return fmt.Errorf("error deleting command: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrCommandNotFound
+	}
+	return nil
+}
+
+// CreateReminder schedules a reminder of text for a user in a conversation
+func (db *appdbimpl) CreateReminder(ctx context.Context, conversationID string, userID string, text string,
+	dueAt time.Time) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.c.ExecContext(ctx, `
+        INSERT INTO reminders (id, conversation_id, user_id, text, due_at, created_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, generateUUID(), conversationID, userID, text, dueAt.UTC(), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("error creating reminder: %w", err)
+	}
+	return nil
+}
+
+// SendDueReminders posts the reminders due at now as system messages in their conversations, returning how many were
+// sent. Reminders of users who left the conversation are dropped.
+func (db *appdbimpl) SendDueReminders(ctx context.Context, now time.Time) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT r.id, r.conversation_id, r.user_id, u.username, r.text,
+               r.user_id IN (
+                   SELECT user_id FROM conversation_participants WHERE conversation_id = r.conversation_id
+                   UNION
+                   SELECT user_id FROM group_members WHERE group_id = r.conversation_id
+               )
+        FROM reminders r
+        JOIN users u ON u.id = r.user_id
+        WHERE `+db.dialect.timeValue("r.due_at")+` <= `+db.dialect.timeValue("?")+`
+        ORDER BY r.due_at
+    `, now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("error getting due reminders: %w", err)
+	}
+	type reminder struct {
+		id, conversationID, userID, username, text string
+		member                                     bool
+	}
+	var due []reminder
+	for rows.Next() {
+		var r reminder
+		if err := rows.Scan(&r.id, &r.conversationID, &r.userID, &r.username, &r.text, &r.member); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning reminder: %w", err)
+		}
+		due = append(due, r)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, fmt.Errorf("error iterating reminders: %w", err)
+	}
+	rows.Close()
+
+	sent := 0
+	for _, r := range due {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM reminders WHERE id = ?`, r.id); err != nil {
+			return 0, fmt.Errorf("error deleting reminder: %w", err)
+		}
+		if !r.member {
+			continue
+		}
+
+		content := fmt.Sprintf("Reminder for %s: %s", r.username, r.text)
+		if err := insertSystemMessage(ctx, tx, r.conversationID, r.userID, SystemEventReminder, content, now); err != nil {
+			return 0, err
+		}
+		sent++
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return sent, nil
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCommands(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES ('user1', 'alice', 'token1');
+		INSERT INTO users (id, username, token, is_bot, owner_id) VALUES
+		('bot1', 'cibot', 'token2', 1, 'user1'),
+		('bot2', 'otherbot', 'token3', 1, 'user1')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "cibot"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	command := SlashCommand{ConversationID: conversationID, Name: "deploy", Description: "Deploy a branch",
+		BotID: "bot1", URL: "http://ci.internal/deploy", Secret: "s1"}
+	if err := db.RegisterCommand(ctx, &command); err != nil {
+		t.Fatalf("error registering command: %v", err)
+	}
+	if command.Bot != "cibot" {
+		t.Errorf("expected the bot username to be filled; got %q", command.Bot)
+	}
+	taken := SlashCommand{ConversationID: conversationID, Name: "deploy", BotID: "bot2", URL: "http://x", Secret: "s"}
+	if err := db.RegisterCommand(ctx, &taken); !errors.Is(err, ErrCommandTaken) {
+		t.Errorf("expected ErrCommandTaken; got %v", err)
+	}
+
+	// The same bot can update its command
+	command.URL = "http://ci.internal/v2/deploy"
+	if err := db.RegisterCommand(ctx, &command); err != nil {
+		t.Fatalf("error updating command: %v", err)
+	}
+	got, err := db.GetCommand(ctx, conversationID, "deploy")
+	if err != nil || got.URL != "http://ci.internal/v2/deploy" || got.Secret != "s1" || got.Bot != "cibot" {
+		t.Errorf("expected the updated command; got %+v, %v", got, err)
+	}
+	commands, err := db.GetCommands(ctx, conversationID)
+	if err != nil || len(commands) != 1 || commands[0].URL != "" || commands[0].Secret != "" {
+		t.Errorf("expected one command without URL and secret; got %+v, %v", commands, err)
+	}
+
+	if err := db.DeleteCommand(ctx, conversationID, "deploy"); err != nil {
+		t.Fatalf("error deleting command: %v", err)
+	}
+	if _, err := db.GetCommand(ctx, conversationID, "deploy"); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("expected ErrCommandNotFound after deleting; got %v", err)
+	}
+	if err := db.DeleteCommand(ctx, conversationID, "deploy"); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("expected ErrCommandNotFound deleting twice; got %v", err)
+	}
+}
+
+func TestReminders(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	now := time.Now()
+	if err := db.CreateReminder(ctx, conversationID, "user1", "standup", now.Add(time.Minute)); err != nil {
+		t.Fatalf("error creating reminder: %v", err)
+	}
+	if err := db.CreateReminder(ctx, conversationID, "user2", "later", now.Add(time.Hour)); err != nil {
+		t.Fatalf("error creating reminder: %v", err)
+	}
+
+	if n, err := db.SendDueReminders(ctx, now); err != nil || n != 0 {
+		t.Fatalf("expected no reminder to be due yet; got %d, %v", n, err)
+	}
+	if n, err := db.SendDueReminders(ctx, now.Add(2*time.Minute)); err != nil || n != 1 {
+		t.Fatalf("expected one reminder to be sent; got %d, %v", n, err)
+	}
+	if n, err := db.SendDueReminders(ctx, now.Add(2*time.Minute)); err != nil || n != 0 {
+		t.Fatalf("expected the reminder to be sent once; got %d, %v", n, err)
+	}
+
+	messages, err := db.GetConversationMessages(ctx, conversationID, "user1")
+	if err != nil {
+		t.Fatalf("error getting messages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Kind != "system" || messages[0].ContentStr != "Reminder for alice: standup" {
+		t.Errorf("expected the reminder as a system message; got %+v", messages)
+	}
+}
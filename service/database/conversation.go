@@ -1,11 +1,13 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
-	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,56 +17,53 @@ func generateUUID() string {
 	return uuid.New().String()
 }
 
-// Función auxiliar para validar que una conversación existe
-func (db *appdbimpl) conversationExists(conversationID string) (bool, error) {
-	var exists bool
-	err := db.c.QueryRow(`
-        SELECT EXISTS(
-            SELECT 1 FROM conversations 
-            WHERE id = ?
-        )
-    `, conversationID).Scan(&exists)
-
-	if err != nil {
-		return false, fmt.Errorf("error checking conversation existence: %w", err)
-	}
-	return exists, nil
-}
+// GetConversationMessages obtiene los mensajes de una conversación. Reactions are marked as reacted_by_me for viewerID.
+func (db *appdbimpl) GetConversationMessages(ctx context.Context, conversationID string, viewerID string) ([]Message,
+	error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-// GetConversationMessages obtiene los mensajes de una conversación
-func (db *appdbimpl) GetConversationMessages(conversationID string) ([]Message, error) {
-	rows, err := db.c.Query(`
-        SELECT m.id, m.conversation_id, m.sender, 
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT m.id, m.conversation_id, m.sender, COALESCE(s.username, m.sender),
                m.content, m.image_url, m.reply_to_id, 
-               strftime('%Y-%m-%d %H:%M:%S', m.timestamp) as formatted_timestamp,
-               r.user_id, r.reaction
+               `+db.dialect.formatTime("m.timestamp")+` as formatted_timestamp,
+               m.kind, COALESCE(m.system_event, ''), m.forwarded_from_message, m.forwarded_from_sender,
+               m.forwarded_from_conversation, COALESCE(f.username, ''), COALESCE(f.forward_visibility, '')
         FROM messages m
-        LEFT JOIN reactions r ON m.id = r.message_id
+        LEFT JOIN users s ON s.id = m.sender
+        LEFT JOIN users f ON f.id = m.forwarded_from_sender
         WHERE m.conversation_id = ?
-        ORDER BY m.timestamp ASC
+        ORDER BY m.timestamp ASC, m.rowid ASC
     `, conversationID)
 	if err != nil {
 		return nil, fmt.Errorf("error getting messages: %w", err)
 	}
 	defer rows.Close()
 
-	messageMap := make(map[string]*Message)
+	messages := make([]Message, 0)
 
 	for rows.Next() {
 		var msg Message
-		var userID, reaction sql.NullString
 		var timestampStr string
+		var forwardedMessage, forwardedSender, forwardedConversation sql.NullString
+		var origin ForwardedFrom
 
 		err := rows.Scan(
 			&msg.ID,
 			&msg.ConversationID,
+			&msg.SenderID,
 			&msg.Sender,
 			&msg.Content,
 			&msg.ImageURL,
 			&msg.ReplyToID,
 			&timestampStr,
-			&userID,
-			&reaction,
+			&msg.Kind,
+			&msg.SystemEvent,
+			&forwardedMessage,
+			&forwardedSender,
+			&forwardedConversation,
+			&origin.Sender,
+			&origin.Visibility,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning message: %w", err)
@@ -87,66 +86,69 @@ func (db *appdbimpl) GetConversationMessages(conversationID string) ([]Message,
 		if msg.ReplyToID.Valid {
 			msg.ReplyToIDStr = msg.ReplyToID.String
 		}
-
-		// Get or create message in map
-		existingMsg, exists := messageMap[msg.ID]
-		if !exists {
-			msg.Reactions = make([]Reaction, 0)
-			messageMap[msg.ID] = &msg
-			existingMsg = &msg
+		if forwardedMessage.Valid {
+			origin.MessageID = forwardedMessage.String
+			origin.SenderID = forwardedSender.String
+			origin.ConversationID = forwardedConversation.String
+			msg.ForwardedFrom = &origin
 		}
 
-		// Add reaction if present
-		if userID.Valid && reaction.Valid {
-			existingMsg.Reactions = append(existingMsg.Reactions, Reaction{
-				UserID:   userID.String,
-				Reaction: reaction.String,
-			})
-		}
+		messages = append(messages, msg)
 	}
-
-	// Convert map to slice
-	messages := make([]Message, 0, len(messageMap))
-	for _, msg := range messageMap {
-		messages = append(messages, *msg)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating messages: %w", err)
 	}
 
-	// Sort messages by timestamp
-	sort.Slice(messages, func(i, j int) bool {
-		return messages[i].Time.Before(messages[j].Time)
-	})
-
-	return messages, nil
-}
-
-// SendMessage añade un nuevo mensaje a una conversación
-func (db *appdbimpl) SendMessage(conversationID string, senderID string, content string) (*Message, error) {
-	// Validar que la conversación existe
-	conversationExists, err := db.conversationExists(conversationID)
+	// Attach reactions, mentions, attachments, link previews and polls
+	reactions, err := db.getConversationReactions(ctx, conversationID, viewerID)
 	if err != nil {
 		return nil, err
 	}
-	if !conversationExists {
-		return nil, errors.New("conversation not found")
+	mentions, err := db.getConversationMentions(ctx, conversationID)
+	if err != nil {
+		return nil, err
 	}
-
-	// Verificar que el sender es parte de la conversación
-	var isParticipant bool
-	err = db.c.QueryRow(`
-        SELECT EXISTS(
-            SELECT 1 FROM conversation_participants 
-            WHERE conversation_id = ? AND user_id = ?
-        )
-    `, conversationID, senderID).Scan(&isParticipant)
-
+	attachments, err := db.getConversationAttachments(ctx, conversationID)
 	if err != nil {
-		return nil, fmt.Errorf("error checking participant: %w", err)
+		return nil, err
+	}
+	linkPreviews, err := db.getConversationLinkPreviews(ctx, conversationID)
+	if err != nil {
+		return nil, err
 	}
-	if !isParticipant {
-		return nil, errors.New("sender is not part of the conversation")
+	polls, err := db.getConversationPolls(ctx, conversationID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range messages {
+		msg := &messages[i]
+		msg.Reactions = reactions[msg.ID]
+		if msg.Reactions == nil {
+			msg.Reactions = make([]Reaction, 0)
+		}
+		msg.Mentions = mentions[msg.ID]
+		if msg.Mentions == nil {
+			msg.Mentions = make([]Mention, 0)
+		}
+		msg.Attachments = attachments[msg.ID]
+		if msg.Attachments == nil {
+			msg.Attachments = make([]Attachment, 0)
+		}
+		msg.LinkPreview = linkPreviews[msg.ID]
+		msg.Poll = polls[msg.ID]
 	}
 
-	tx, err := db.c.Begin()
+	return messages, nil
+}
+
+// SendMessage añade un nuevo mensaje a una conversación
+func (db *appdbimpl) SendMessage(ctx context.Context, conversationID string, senderID string,
+	content string) (*Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.c.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error starting transaction: %w", err)
 	}
@@ -158,30 +160,18 @@ func (db *appdbimpl) SendMessage(conversationID string, senderID string, content
 
 	// Crear mensaje
 	msg := Message{
-		ID:         generateUUID(),
-		Sender:     senderID,
-		Content:    sql.NullString{String: content, Valid: true},
-		ContentStr: content,
-		Time:       time.Now(),
-	}
-
-	// Insertar mensaje
-	_, err = tx.Exec(`
-        INSERT INTO messages (id, conversation_id, sender, content, timestamp)
-        VALUES (?, ?, ?, ?, ?)
-    `, msg.ID, conversationID, msg.Sender, msg.Content, msg.Time)
-	if err != nil {
-		return nil, fmt.Errorf("error inserting message: %w", err)
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Content:        sql.NullString{String: content, Valid: true},
+		Kind:           MessageKindText,
+	}
+	if err := appendMessage(ctx, tx, &msg); err != nil {
+		return nil, err
 	}
 
-	// Actualizar último mensaje de la conversación
-	_, err = tx.Exec(`
-        UPDATE conversations 
-        SET last_message = ?, timestamp = ?
-        WHERE id = ?
-    `, content, msg.Time, conversationID)
+	msg.Mentions, err = insertMentions(ctx, tx, conversationID, msg.ID, senderID, content)
 	if err != nil {
-		return nil, fmt.Errorf("error updating conversation: %w", err)
+		return nil, err
 	}
 
 	// Commit transacción
@@ -192,24 +182,41 @@ func (db *appdbimpl) SendMessage(conversationID string, senderID string, content
 	return &msg, nil
 }
 
+// CheckParticipant returns ErrConversationNotFound when there is no such conversation or group and ErrNotParticipant
+// when the user is not part of it, like sending a message there would
+func (db *appdbimpl) CheckParticipant(ctx context.Context, conversationID string, userID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, isMember, err := membership(ctx, db.r, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return ErrNotParticipant
+	}
+	return nil
+}
+
 // IsUserInConversation checks if a user is part of a conversation
-func (db *appdbimpl) IsUserInConversation(username string, conversationId string) (bool, error) {
+func (db *appdbimpl) IsUserInConversation(ctx context.Context, conversationID string, userID string) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var count int
-	err := db.c.QueryRow(`
+	err := db.r.QueryRowContext(ctx, `
         SELECT COUNT(*) FROM (
             -- Check regular conversations
-            SELECT cp.conversation_id
-            FROM conversation_participants cp
-            JOIN users u ON cp.user_id = u.id
-            WHERE cp.conversation_id = ? AND u.username = ?
+            SELECT conversation_id
+            FROM conversation_participants
+            WHERE conversation_id = ? AND user_id = ?
             UNION ALL
             -- Check group conversations
-            SELECT gm.group_id
-            FROM group_members gm
-            JOIN users u ON gm.user_id = u.id
-            WHERE gm.group_id = ? AND u.username = ?
-        )`,
-		conversationId, username, conversationId, username).Scan(&count)
+            SELECT group_id
+            FROM group_members
+            WHERE group_id = ? AND user_id = ?
+        ) AS memberships`,
+		conversationID, userID, conversationID, userID).Scan(&count)
 
 	if err != nil {
 		return false, fmt.Errorf("error checking conversation participant: %w", err)
@@ -219,10 +226,13 @@ func (db *appdbimpl) IsUserInConversation(username string, conversationId string
 }
 
 // CreateConversation creates a new conversation between users
-func (db *appdbimpl) CreateConversation(participants []string) (string, error) {
+func (db *appdbimpl) CreateConversation(ctx context.Context, participants []string) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	log.Printf("Creating conversation with participants: %v", participants)
 
-	tx, err := db.c.Begin()
+	tx, err := db.c.BeginTx(ctx, nil)
 	if err != nil {
 		return "", fmt.Errorf("error starting transaction: %w", err)
 	}
@@ -237,7 +247,7 @@ func (db *appdbimpl) CreateConversation(participants []string) (string, error) {
 	log.Printf("Generated conversation ID: %s", conversationID)
 
 	// Create conversation with current timestamp
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
         INSERT INTO conversations (id, timestamp, last_message)
         VALUES (?, ?, ?)
     `, conversationID, time.Now(), "")
@@ -246,10 +256,11 @@ func (db *appdbimpl) CreateConversation(participants []string) (string, error) {
 	}
 
 	// Add participants using their user IDs
+	userIDs := make([]string, 0, len(participants))
 	for _, username := range participants {
 		// Get user ID for the username
 		var userID string
-		err := tx.QueryRow(`
+		err := tx.QueryRowContext(ctx, `
             SELECT id FROM users WHERE username = ?
         `, username).Scan(&userID)
 		if err != nil {
@@ -257,13 +268,18 @@ func (db *appdbimpl) CreateConversation(participants []string) (string, error) {
 		}
 
 		log.Printf("Adding participant: %s (ID: %s)", username, userID)
-		_, err = tx.Exec(`
+		_, err = tx.ExecContext(ctx, `
             INSERT INTO conversation_participants (conversation_id, user_id)
             VALUES (?, ?)
         `, conversationID, userID)
 		if err != nil {
 			return "", fmt.Errorf("error adding participant: %w", err)
 		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if err := checkUsersNotBlocked(ctx, tx, userIDs); err != nil {
+		return "", err
 	}
 
 	if err = tx.Commit(); err != nil {
@@ -274,58 +290,76 @@ func (db *appdbimpl) CreateConversation(participants []string) (string, error) {
 	return conversationID, nil
 }
 
-// GetUserConversations obtiene todas las conversaciones de un usuario
-func (db *appdbimpl) GetUserConversations(username string) ([]Conversation, error) {
-	log.Printf("Getting conversations for user: %s", username)
+// GetUserConversations returns a page of the conversations and groups of a user, most recently active first. It
+// returns at most limit conversations and the cursor of the next page, empty on the last page. The members of the
+// whole page are loaded with one query per kind of conversation.
+func (db *appdbimpl) GetUserConversations(ctx context.Context, userID string, limit int,
+	cursor string) ([]Conversation, string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	query := `
-        WITH LastMessages AS (
-            SELECT 
-                conversation_id,
-                content,
-                strftime('%Y-%m-%d %H:%M:%S', timestamp) as msg_timestamp,
-                reply_to_id,
-                ROW_NUMBER() OVER (PARTITION BY conversation_id ORDER BY timestamp DESC) as rn
-            FROM messages
-        )
-        SELECT DISTINCT 
-            c.id, 
-            COALESCE(lm.content, '') as last_message,
-            strftime('%Y-%m-%d %H:%M:%S', COALESCE(lm.msg_timestamp, c.timestamp)) as conv_timestamp,
-            FALSE as is_group,
-            '' as group_name,
-            COALESCE(u2.photo_url, '') as photo_url,
-            CASE WHEN lm.reply_to_id IS NOT NULL THEN 1 ELSE 0 END as is_reply
-        FROM conversations c
-        JOIN conversation_participants cp ON c.id = cp.conversation_id
-        JOIN users u ON cp.user_id = u.id
-        JOIN conversation_participants cp2 ON c.id = cp2.conversation_id
-        JOIN users u2 ON cp2.user_id = u2.id
-        LEFT JOIN LastMessages lm ON lm.conversation_id = c.id AND lm.rn = 1
-        WHERE u.username = ? AND u2.username != ?
-        UNION ALL
-        SELECT 
-            g.id,
-            COALESCE(lm.content, '') as last_message,
-            strftime('%Y-%m-%d %H:%M:%S', COALESCE(lm.msg_timestamp, g.timestamp)) as conv_timestamp,
-            TRUE as is_group,
-            g.name as group_name,
-            COALESCE(g.photo_url, '') as photo_url,
-            CASE WHEN lm.reply_to_id IS NOT NULL THEN 1 ELSE 0 END as is_reply
-        FROM groups g
-        JOIN group_members gm ON g.id = gm.group_id
-        JOIN users u ON gm.user_id = u.id
-        LEFT JOIN LastMessages lm ON lm.conversation_id = g.id AND lm.rn = 1
-        WHERE u.username = ?
-        ORDER BY conv_timestamp DESC`
+	if limit <= 0 {
+		return nil, "", errors.New("limit must be positive")
+	}
+
+	// The first page starts after every timestamp, as formatted timestamps never sort above "9"
+	afterTimestamp, afterID := "9", ""
+	if cursor != "" {
+		var err error
+		afterTimestamp, afterID, err = decodeInboxCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
 
-	rows, err := db.c.Query(query, username, username, username)
+	query := `
+        SELECT * FROM (
+            SELECT DISTINCT
+                c.id,
+                COALESCE(c.last_message, '') as last_message,
+                ` + db.dialect.formatTime("c.timestamp") + ` as conv_timestamp,
+                FALSE as is_group,
+                '' as group_name,
+                COALESCE(u2.photo_url, '') as photo_url,
+                COALESCE(u2.photo_visibility, '') as photo_visibility,
+                c.last_message_is_reply as is_reply,
+                cm.conversation_id IS NOT NULL as is_muted,
+                COALESCE(` + db.dialect.formatTime("cm.muted_until") + `, '') as muted_until
+            FROM conversations c
+            JOIN conversation_participants cp ON c.id = cp.conversation_id
+            LEFT JOIN conversation_participants cp2 ON c.id = cp2.conversation_id AND cp2.user_id != cp.user_id
+            LEFT JOIN users u2 ON cp2.user_id = u2.id
+            LEFT JOIN conversation_mutes cm ON cm.conversation_id = c.id AND cm.user_id = cp.user_id
+            WHERE cp.user_id = ?
+            UNION ALL
+            SELECT
+                g.id,
+                COALESCE(g.last_message, '') as last_message,
+                ` + db.dialect.formatTime("g.timestamp") + ` as conv_timestamp,
+                TRUE as is_group,
+                g.name as group_name,
+                COALESCE(g.photo_url, '') as photo_url,
+                '' as photo_visibility,
+                g.last_message_is_reply as is_reply,
+                cm.conversation_id IS NOT NULL as is_muted,
+                COALESCE(` + db.dialect.formatTime("cm.muted_until") + `, '') as muted_until
+            FROM groups g
+            JOIN group_members gm ON g.id = gm.group_id
+            LEFT JOIN conversation_mutes cm ON cm.conversation_id = g.id AND cm.user_id = gm.user_id
+            WHERE gm.user_id = ?
+        ) AS inbox
+        WHERE conv_timestamp < ? OR (conv_timestamp = ? AND id < ?)
+        ORDER BY conv_timestamp DESC, id DESC
+        LIMIT ?`
+
+	rows, err := db.r.QueryContext(ctx, query, userID, userID, afterTimestamp, afterTimestamp, afterID, limit+1)
 	if err != nil {
-		return nil, fmt.Errorf("error getting conversations: %w", err)
+		return nil, "", fmt.Errorf("error getting conversations: %w", err)
 	}
 	defer rows.Close()
 
-	var conversations []Conversation
+	conversations := make([]Conversation, 0)
+	var timestamps []string
 	for rows.Next() {
 		var conv Conversation
 		var isGroup bool
@@ -333,6 +367,8 @@ func (db *appdbimpl) GetUserConversations(username string) ([]Conversation, erro
 		var photoURL string
 		var isReply int
 		var timestampStr string // Changed to string to handle timestamp
+		var isMuted bool
+		var mutedUntilStr string
 
 		err := rows.Scan(
 			&conv.ID,
@@ -341,125 +377,258 @@ func (db *appdbimpl) GetUserConversations(username string) ([]Conversation, erro
 			&isGroup,
 			&groupName,
 			&photoURL,
+			&conv.PhotoVisibility,
 			&isReply,
+			&isMuted,
+			&mutedUntilStr,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("error scanning conversation: %w", err)
+			return nil, "", fmt.Errorf("error scanning conversation: %w", err)
 		}
 
 		// Parse the timestamp string into time.Time
 		timestamp, err := time.Parse("2006-01-02 15:04:05", timestampStr)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing timestamp: %w", err)
+			return nil, "", fmt.Errorf("error parsing timestamp: %w", err)
 		}
 		conv.Timestamp = timestamp
 
 		conv.IsGroup = isGroup
 		conv.PhotoURL = photoURL
 		conv.LastMessageIsReply = isReply == 1
-		if isGroup {
-			conv.Name = groupName
-			members, err := db.getGroupMembers(conv.ID)
+
+		// A mute with an end time in the past no longer applies
+		if isMuted && mutedUntilStr != "" {
+			mutedUntil, err := time.Parse("2006-01-02 15:04:05", mutedUntilStr)
 			if err != nil {
-				return nil, fmt.Errorf("error getting group members: %w", err)
+				return nil, "", fmt.Errorf("error parsing mute end: %w", err)
 			}
-			conv.Participants = members
-		} else {
-			participants, err := db.GetConversationParticipants(conv.ID)
-			if err != nil {
-				return nil, fmt.Errorf("error getting participants: %w", err)
+			isMuted = mutedUntil.After(time.Now())
+			if isMuted {
+				conv.MutedUntil = &mutedUntil
 			}
-			conv.Participants = participants
+		}
+		conv.Muted = isMuted
+		if isGroup {
+			conv.Name = groupName
 		}
 
 		conversations = append(conversations, conv)
+		timestamps = append(timestamps, timestampStr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("error iterating conversations: %w", err)
+	}
+	_ = rows.Close()
+
+	// The extra row only tells whether there is another page
+	var nextCursor string
+	if len(conversations) > limit {
+		conversations = conversations[:limit]
+		nextCursor = encodeInboxCursor(timestamps[limit-1], conversations[limit-1].ID)
 	}
 
-	return conversations, nil
+	if err := db.loadConversationMembers(ctx, conversations); err != nil {
+		return nil, "", err
+	}
+	return conversations, nextCursor, nil
 }
 
-// Helper function to get group members
-func (db *appdbimpl) getGroupMembers(groupID string) ([]string, error) {
-	rows, err := db.c.Query(`
-        SELECT u.username
+// loadConversationMembers fills the participants and the bots of the conversations, with one query for the direct
+// conversations and one for the groups
+func (db *appdbimpl) loadConversationMembers(ctx context.Context, conversations []Conversation) error {
+	var directIDs, groupIDs []interface{}
+	for _, conv := range conversations {
+		if conv.IsGroup {
+			groupIDs = append(groupIDs, conv.ID)
+		} else {
+			directIDs = append(directIDs, conv.ID)
+		}
+	}
+
+	participants, err := queryMemberNames(ctx, db.r, `
+        SELECT cp.conversation_id, u.username, u.is_bot
+        FROM conversation_participants cp
+        JOIN users u ON cp.user_id = u.id
+        WHERE cp.conversation_id IN (%s)`, directIDs)
+	if err != nil {
+		return fmt.Errorf("error getting participants: %w", err)
+	}
+	members, err := queryMemberNames(ctx, db.r, `
+        SELECT gm.group_id, u.username, u.is_bot
         FROM group_members gm
         JOIN users u ON gm.user_id = u.id
-        WHERE gm.group_id = ?`, groupID)
+        WHERE gm.group_id IN (%s)`, groupIDs)
+	if err != nil {
+		return fmt.Errorf("error getting group members: %w", err)
+	}
+
+	for i := range conversations {
+		conv := &conversations[i]
+		names := participants[conv.ID]
+		if conv.IsGroup {
+			names = members[conv.ID]
+		}
+		conv.Participants = names.usernames
+		conv.Bots = names.bots
+	}
+	return nil
+}
+
+// memberNames are the usernames of the members of a conversation, and those of the bots among them
+type memberNames struct {
+	usernames []string
+	bots      []string
+}
+
+// queryMemberNames runs query, whose %s is replaced by a placeholder for each of ids, and groups the rows of
+// conversation ID, username and bot flag by conversation
+func queryMemberNames(ctx context.Context, ex execer, query string, ids []interface{}) (map[string]memberNames, error) {
+	names := make(map[string]memberNames)
+	if len(ids) == 0 {
+		return names, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	rows, err := ex.QueryContext(ctx, fmt.Sprintf(query, placeholders), ids...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var members []string
 	for rows.Next() {
-		var username string
-		if err := rows.Scan(&username); err != nil {
+		var conversationID, username string
+		var isBot bool
+		if err := rows.Scan(&conversationID, &username, &isBot); err != nil {
 			return nil, err
 		}
-		members = append(members, username)
+		n := names[conversationID]
+		n.usernames = append(n.usernames, username)
+		if isBot {
+			n.bots = append(n.bots, username)
+		}
+		names[conversationID] = n
 	}
-	return members, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// encodeInboxCursor packs the sort key of the last conversation of a page
+func encodeInboxCursor(timestamp string, conversationID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(timestamp + "|" + conversationID))
 }
 
-func (db *appdbimpl) CreateMessage(conversationId string, sender string, content string) (string, error) {
-	messageId := generateUUID()
+// decodeInboxCursor unpacks a cursor made by encodeInboxCursor
+func decodeInboxCursor(cursor string) (string, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return "", "", ErrInvalidCursor
+	}
+	if _, err := time.Parse("2006-01-02 15:04:05", parts[0]); err != nil {
+		return "", "", ErrInvalidCursor
+	}
+	return parts[0], parts[1], nil
+}
 
-	_, err := db.c.Exec(`
-        INSERT INTO messages (id, conversation_id, sender, content, timestamp)
-        VALUES (?, ?, ?, ?, ?)`,
-		messageId, conversationId, sender, content, time.Now())
+func (db *appdbimpl) CreateMessage(ctx context.Context, conversationId string, senderID string,
+	content string) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
+	tx, err := db.c.BeginTx(ctx, nil)
 	if err != nil {
+		return "", fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
+
+	msg := Message{
+		ConversationID: conversationId,
+		SenderID:       senderID,
+		Content:        sql.NullString{String: content, Valid: true},
+		Kind:           MessageKindText,
+	}
+	if err := appendMessage(ctx, tx, &msg); err != nil {
 		return "", fmt.Errorf("error creating message: %w", err)
 	}
 
-	// Update last_message in conversation
-	_, err = db.c.Exec(`
-        UPDATE conversations 
-        SET last_message = ?
-        WHERE id = ?`,
-		content, conversationId)
+	if _, err := insertMentions(ctx, tx, conversationId, msg.ID, senderID, content); err != nil {
+		return "", err
+	}
 
-	if err != nil {
-		return "", fmt.Errorf("error updating conversation: %w", err)
+	if err = tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing transaction: %w", err)
 	}
+	return msg.ID, nil
+}
+
+func (db *appdbimpl) GetConversationParticipants(ctx context.Context, conversationId string) ([]string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	return messageId, nil
+	participants, _, err := db.getConversationParticipants(ctx, conversationId)
+	return participants, err
 }
 
-func (db *appdbimpl) GetConversationParticipants(conversationId string) ([]string, error) {
-	rows, err := db.c.Query(`
-        SELECT u.username
+// getConversationParticipants returns the usernames of the participants of a direct conversation, and which of them
+// are bots
+func (db *appdbimpl) getConversationParticipants(ctx context.Context, conversationId string) ([]string, []string,
+	error) {
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT u.username, u.is_bot
         FROM conversation_participants cp
         JOIN users u ON cp.user_id = u.id
         WHERE cp.conversation_id = ?
     `, conversationId)
 	if err != nil {
-		return nil, fmt.Errorf("error getting participants: %w", err)
+		return nil, nil, fmt.Errorf("error getting participants: %w", err)
 	}
 	defer rows.Close()
 
-	var participants []string
+	participants, bots, err := scanMemberNames(rows)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error scanning participants: %w", err)
+	}
+	return participants, bots, nil
+}
+
+// scanMemberNames reads rows of usernames and bot flags, returning all the usernames and those of the bots
+func scanMemberNames(rows *sql.Rows) ([]string, []string, error) {
+	var names, bots []string
 	for rows.Next() {
 		var username string
-		if err := rows.Scan(&username); err != nil {
-			return nil, fmt.Errorf("error scanning participant: %w", err)
+		var isBot bool
+		if err := rows.Scan(&username, &isBot); err != nil {
+			return nil, nil, err
+		}
+		names = append(names, username)
+		if isBot {
+			bots = append(bots, username)
 		}
-		participants = append(participants, username)
 	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating participants: %w", err)
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
 	}
-
-	return participants, nil
+	return names, bots, nil
 }
 
-func (db *appdbimpl) GetConversationDetails(conversationID string) (*ConversationDetails, error) {
+func (db *appdbimpl) GetConversationDetails(ctx context.Context, conversationID string) (*ConversationDetails, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	// First check if this is a group
 	var isGroup bool
 	//var groupName string
-	err := db.c.QueryRow(`
+	err := db.r.QueryRowContext(ctx, `
         SELECT EXISTS(
             SELECT 1 FROM groups WHERE id = ?
         )`, conversationID).Scan(&isGroup)
@@ -474,55 +643,28 @@ func (db *appdbimpl) GetConversationDetails(conversationID string) (*Conversatio
 
 	if isGroup {
 		// Get group details
-		err = db.c.QueryRow(`
+		err = db.r.QueryRowContext(ctx, `
             SELECT name, COALESCE(photo_url, '') 
             FROM groups 
             WHERE id = ?`, conversationID).Scan(&details.Name, &details.PhotoURL)
 		if err != nil {
 			return nil, fmt.Errorf("error getting group details: %w", err)
 		}
+	}
 
-		// Get group members
-		rows, err := db.c.Query(`
-            SELECT u.username 
-            FROM group_members gm
-            JOIN users u ON gm.user_id = u.id
-            WHERE gm.group_id = ?`, conversationID)
-		if err != nil {
-			return nil, fmt.Errorf("error getting group members: %w", err)
-		}
-		defer rows.Close()
+	details.Participants, err = db.getParticipants(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
 
-		var members []string
-		for rows.Next() {
-			var username string
-			if err := rows.Scan(&username); err != nil {
-				return nil, fmt.Errorf("error scanning member: %w", err)
-			}
-			members = append(members, username)
-		}
-		details.Participants = members
-	} else {
-		// Get regular conversation participants
-		rows, err := db.c.Query(`
-            SELECT u.username 
-            FROM conversation_participants cp
-            JOIN users u ON cp.user_id = u.id
-            WHERE cp.conversation_id = ?`, conversationID)
-		if err != nil {
-			return nil, fmt.Errorf("error getting participants: %w", err)
-		}
-		defer rows.Close()
+	details.MessageTTL, err = db.getMessageTTL(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
 
-		var participants []string
-		for rows.Next() {
-			var username string
-			if err := rows.Scan(&username); err != nil {
-				return nil, fmt.Errorf("error scanning participant: %w", err)
-			}
-			participants = append(participants, username)
-		}
-		details.Participants = participants
+	details.PinnedMessages, err = db.GetPinnedMessages(ctx, conversationID)
+	if err != nil {
+		return nil, err
 	}
 
 	return details, nil
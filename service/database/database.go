@@ -16,110 +16,257 @@ This is an example on how to migrate the DB and connect to it:
 
 	// Start Database
 	logger.Println("initializing database support")
-	db, err := sql.Open("sqlite3", "./foo.db")
+	db, readers, err := database.OpenSQLite("./foo.db", database.SQLiteOptions{
+		JournalMode: "WAL",
+		Synchronous: "NORMAL",
+		BusyTimeout: 5 * time.Second,
+		MaxReaders:  4,
+	})
 	if err != nil {
 		logger.WithError(err).Error("error opening SQLite DB")
 		return fmt.Errorf("opening SQLite: %w", err)
 	}
 	defer func() {
 		logger.Debug("database stopping")
+		_ = readers.Close()
 		_ = db.Close()
 	}()
 
-Then you can initialize the AppDatabase and pass it to the api package.
+Then you can initialize the AppDatabase with NewWithReaders and pass it to the api package.
+
+With PostgreSQL, open the connections with OpenPostgres and initialize the AppDatabase with NewPostgres instead.
 */
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 )
 
 // AppDatabase es la interfaz de alto nivel para la BD
 type AppDatabase interface {
-	Ping() error
+	Ping(ctx context.Context) error
 
 	// User operations
-	GetUserByToken(token string) (*User, error)
-	UpdateUsername(userID string, newUsername string) error
-	UpdateUserPhoto(userID string, photoURL string) error
-	GetUserConversations(userID string) ([]Conversation, error)
+	GetUserByToken(ctx context.Context, token string) (*User, error)
+	UpdateUsername(ctx context.Context, userID string, newUsername string) error
+	UpdateUserPhoto(ctx context.Context, userID string, photoURL string) error
+	GetUserConversations(ctx context.Context, userID string, limit int, cursor string) ([]Conversation, string, error)
 
 	// Conversation operations
-	GetConversationMessages(conversationID string) ([]Message, error)
-	SendMessage(conversationID string, senderID string, content string) (*Message, error)
-	IsUserInConversation(conversationID string, userID string) (bool, error)
+	GetConversationMessages(ctx context.Context, conversationID string, viewerID string) ([]Message, error)
+	SendMessage(ctx context.Context, conversationID string, senderID string, content string) (*Message, error)
+	IsUserInConversation(ctx context.Context, conversationID string, userID string) (bool, error)
+	CheckParticipant(ctx context.Context, conversationID string, userID string) error
 
 	// Message operations
-	GetMessageByID(messageID string) (*Message, error)
-	DeleteMessage(messageID string) error
-	ForwardMessage(messageID, newConversationID, senderID string) (*Message, error)
+	GetMessageByID(ctx context.Context, messageID string) (*Message, error)
+	DeleteMessage(ctx context.Context, messageID string) error
+	ForwardMessage(ctx context.Context, messageID string, senderID string, targetIDs []string) ([]ForwardResult, error)
 
 	// Reaction operations
-	AddReaction(messageID string, userID string, reaction string) error
-	RemoveReaction(messageID string, userID string) error
+	AddReaction(ctx context.Context, messageID string, userID string, reaction string) error
+	RemoveReaction(ctx context.Context, messageID string, userID string, reaction string) error
 
 	// Group operations
-	CreateGroup(name string, creatorID string, members []string) (*Group, error)
-	UpdateGroupName(groupID string, newName string) error
-	UpdateGroupPhoto(groupID string, photoURL string) error
-	LeaveGroup(groupID string, userID string) error
-
-	CreateSession(name string) (*Session, error)
-
-	CreateConversation(participants []string) (string, error)
-
-	CreateMessage(conversationId string, sender string, content string) (string, error)
-
-	GetConversationParticipants(conversationId string) ([]string, error)
-
-	GetConversationDetails(conversationID string) (*ConversationDetails, error)
-
-	CreateImageMessage(conversationID, sender, imageURL string) (string, error)
-
-	CreateReplyMessage(conversationID, sender, content, replyToID string) (string, error)
-
-	HasUser(username string) bool
-
-	GetAllUsers() ([]string, error)
+	CreateGroup(ctx context.Context, name string, creatorID string, members []string) (*Group, error)
+	UpdateGroupName(ctx context.Context, groupID string, actorID string, newName string) error
+	UpdateGroupPhoto(ctx context.Context, groupID string, actorID string, photoURL string) error
+	LeaveGroup(ctx context.Context, groupID string, userID string) error
+
+	CreateSession(ctx context.Context, name string) (*Session, error)
+
+	CreateConversation(ctx context.Context, participants []string) (string, error)
+
+	CreateMessage(ctx context.Context, conversationId string, senderID string, content string) (string, error)
+
+	GetConversationParticipants(ctx context.Context, conversationId string) ([]string, error)
+
+	GetConversationDetails(ctx context.Context, conversationID string) (*ConversationDetails, error)
+
+	CreateImageMessage(ctx context.Context, conversationID, senderID, imageURL string) (string, error)
+
+	CreateReplyMessage(ctx context.Context, conversationID, senderID, content, replyToID string) (string, error)
+
+	// Attachments
+	CreateAttachmentMessage(ctx context.Context, conversationID, senderID, caption string,
+		attachments []Attachment) (*Message, error)
+	GetAttachment(ctx context.Context, attachmentID string) (*Attachment, error)
+
+	// Resumable uploads
+	CreateUpload(ctx context.Context, upload *Upload, quota int64) error
+	GetUpload(ctx context.Context, uploadID string, userID string) (*Upload, error)
+	SetUploadOffset(ctx context.Context, uploadID string, offset int64) error
+	CompleteUpload(ctx context.Context, uploadID string, mimeType string, checksum string) error
+	DeleteUpload(ctx context.Context, uploadID string) (string, error)
+	DeleteExpiredUploads(ctx context.Context, now time.Time) ([]string, error)
+	GetStorageUsage(ctx context.Context, userID string) (int64, error)
+
+	// Link previews
+	SetLinkPreview(ctx context.Context, messageID string, preview LinkPreview) error
+
+	// Webhooks
+	CreateWebhook(ctx context.Context, webhook *Webhook) error
+	GetWebhooks(ctx context.Context, conversationID string) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, conversationID string, webhookID string) error
+	QueueWebhookDeliveries(ctx context.Context, conversationID string, event string, payload []byte) (int, error)
+	GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error)
+	MarkWebhookDelivered(ctx context.Context, deliveryID string, statusCode int) error
+	MarkWebhookFailed(ctx context.Context, deliveryID string, statusCode int, reason string, retryAt *time.Time) error
+	GetWebhookDeliveries(ctx context.Context, conversationID string, webhookID string, status string,
+		limit int) ([]WebhookDelivery, error)
+	DeleteOldWebhookDeliveries(ctx context.Context, before time.Time) error
+
+	// Bot accounts
+	CreateBot(ctx context.Context, ownerID string, username string, displayName string) (*Bot, error)
+	GetBots(ctx context.Context, ownerID string) ([]Bot, error)
+	CreateBotToken(ctx context.Context, ownerID string, botID string, scopes []string) (*BotToken, error)
+	GetBotTokens(ctx context.Context, ownerID string, botID string) ([]BotToken, error)
+	RevokeBotToken(ctx context.Context, ownerID string, botID string, tokenID string) error
+	GetUserByBotToken(ctx context.Context, token string) (*User, error)
+
+	// Slash commands
+	RegisterCommand(ctx context.Context, command *SlashCommand) error
+	GetCommands(ctx context.Context, conversationID string) ([]SlashCommand, error)
+	GetCommand(ctx context.Context, conversationID string, name string) (*SlashCommand, error)
+	DeleteCommand(ctx context.Context, conversationID string, name string) error
+	CreateReminder(ctx context.Context, conversationID string, userID string, text string, dueAt time.Time) error
+	SendDueReminders(ctx context.Context, now time.Time) (int, error)
+
+	// Polls
+	CreatePoll(ctx context.Context, conversationID string, senderID string, poll Poll) (*Message, error)
+	GetPoll(ctx context.Context, messageID string, viewerID string) (*Poll, error)
+	VotePoll(ctx context.Context, conversationID string, messageID string, userID string, options []int) error
+	ClosePoll(ctx context.Context, conversationID string, messageID string) error
+
+	// Media garbage collection and access
+	GetMediaReferences(ctx context.Context) (map[string]int, error)
+	IsPublicImage(ctx context.Context, imagePath string) (bool, error)
+	CanAccessImage(ctx context.Context, userID string, imagePath string) (bool, error)
+
+	HasUser(ctx context.Context, username string) bool
+
+	SearchUsers(ctx context.Context, userID string, query string, limit int, cursor string) ([]UserSummary, string, error)
+
+	// Disappearing messages
+	SetMessageTTL(ctx context.Context, conversationID string, actorID string, ttl int64) error
+	DeleteExpiredMessages(ctx context.Context, now time.Time) error
+	IsGroupAdmin(ctx context.Context, groupID string, userID string) (bool, error)
+
+	// Pinned messages
+	PinMessage(ctx context.Context, conversationID string, messageID string, userID string) error
+	UnpinMessage(ctx context.Context, conversationID string, messageID string) error
+	GetPinnedMessages(ctx context.Context, conversationID string) ([]PinnedMessage, error)
+
+	// Mentions
+	GetUnseenMentions(ctx context.Context, userID string) ([]MentionNotification, error)
+	MarkMentionsSeen(ctx context.Context, conversationID string, userID string) error
+
+	// Presence and profiles
+	UpdateLastSeen(ctx context.Context, userID string, lastSeen time.Time) error
+	SetPrivacy(ctx context.Context, userID string, photoVisibility string, lastSeenVisibility string,
+		forwardVisibility string) error
+	UpdateProfile(ctx context.Context, userID string, displayName string, bio string) error
+	GetProfile(ctx context.Context, username string) (*Profile, error)
+	AreContacts(ctx context.Context, userID string, otherID string) (bool, error)
+
+	// Blocks and mutes
+	GetUserID(ctx context.Context, username string) (string, error)
+	BlockUser(ctx context.Context, blockerID string, blockedID string) error
+	UnblockUser(ctx context.Context, blockerID string, blockedID string) error
+	MuteConversation(ctx context.Context, conversationID string, userID string, until *time.Time) error
+	UnmuteConversation(ctx context.Context, conversationID string, userID string) error
 }
 
 type appdbimpl struct {
+	// c runs the writes and r the read-only queries; they are the same pool unless built by NewWithReaders
 	c *sql.DB
+	r *sql.DB
+
+	// timeout bounds every call to the database, zero means no bound other than the caller context
+	timeout time.Duration
+
+	// dialect writes the SQL of the database engine, SQLite or PostgreSQL
+	dialect dialect
 }
 
 // New retorna una nueva instancia de AppDatabase
 func New(db *sql.DB) (AppDatabase, error) {
-	if db == nil {
+	return NewWithReaders(db, db, 0)
+}
+
+// NewWithReaders returns an AppDatabase writing to db and running the read-only queries on readers, like the pools
+// opened by OpenSQLite. Each call is cancelled after queryTimeout, if positive.
+func NewWithReaders(db *sql.DB, readers *sql.DB, queryTimeout time.Duration) (AppDatabase, error) {
+	if db == nil || readers == nil {
 		return nil, errors.New("database is required when building a AppDatabase")
 	}
+	if queryTimeout < 0 {
+		return nil, fmt.Errorf("invalid query timeout %s", queryTimeout)
+	}
 
-	// Crear tablas si no existen
-	sqlStmt := `
+	if err := withoutForeignKeys(context.Background(), db, setupSchema); err != nil {
+		return nil, err
+	}
+	return &appdbimpl{
+		c:       db,
+		r:       readers,
+		timeout: queryTimeout,
+		dialect: sqliteDialect{},
+	}, nil
+}
+
+// withTimeout derives the context of a call from the one of the caller, bounding it to the query timeout
+func (db *appdbimpl) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.timeout)
+}
+
+// tablesSchema creates the tables of the current version that do not exist yet, for SQLite. PostgreSQL gets it through
+// postgresTables.
+const tablesSchema = `
 	CREATE TABLE IF NOT EXISTS users (
 		id TEXT PRIMARY KEY,
 		username TEXT UNIQUE NOT NULL,
 		token TEXT UNIQUE NOT NULL,
-		photo_url TEXT
+		photo_url TEXT,
+		last_seen DATETIME,
+		display_name TEXT,
+		bio TEXT,
+		photo_visibility TEXT NOT NULL DEFAULT 'everyone',
+		last_seen_visibility TEXT NOT NULL DEFAULT 'everyone',
+		forward_visibility TEXT NOT NULL DEFAULT 'everyone',
+		is_bot INTEGER NOT NULL DEFAULT 0,
+		owner_id TEXT REFERENCES users(id)
 	);
 
 	CREATE TABLE IF NOT EXISTS conversations (
 		id TEXT PRIMARY KEY,
 		last_message TEXT,
-		timestamp DATETIME
+		timestamp DATETIME,
+		last_message_is_reply INTEGER NOT NULL DEFAULT 0
 	);
 
 	CREATE TABLE IF NOT EXISTS messages (
 		id TEXT PRIMARY KEY,
 		conversation_id TEXT,
-		sender TEXT,
+		sender TEXT REFERENCES users(id),
 		content TEXT,
 		timestamp DATETIME,
-		reply_to_id TEXT REFERENCES messages(id),
+		reply_to_id TEXT REFERENCES messages(id) ON DELETE SET NULL,
 		image_url TEXT,
-		FOREIGN KEY (conversation_id) REFERENCES conversations(id)
+		kind TEXT NOT NULL DEFAULT 'text',
+		system_event TEXT,
+		forwarded_from_message TEXT,
+		forwarded_from_sender TEXT REFERENCES users(id),
+		forwarded_from_conversation TEXT
 	);
+	CREATE INDEX IF NOT EXISTS messages_conversation ON messages (conversation_id, timestamp);
 
 	CREATE TABLE IF NOT EXISTS conversation_participants (
 		conversation_id TEXT,
@@ -128,15 +275,15 @@ func New(db *sql.DB) (AppDatabase, error) {
 		FOREIGN KEY (conversation_id) REFERENCES conversations(id),
 		FOREIGN KEY (user_id) REFERENCES users(id)
 	);
+	CREATE INDEX IF NOT EXISTS conversation_participants_user ON conversation_participants (user_id);
 
 	CREATE TABLE IF NOT EXISTS reactions (
 		message_id TEXT,
 		user_id TEXT,
-		reaction TEXT,
-		PRIMARY KEY (message_id, user_id),
+		reaction TEXT NOT NULL,
+		PRIMARY KEY (message_id, user_id, reaction),
 		FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
-		FOREIGN KEY (user_id) REFERENCES users(id),
-		CHECK (length(reaction) >= 1 AND length(reaction) <= 5)
+		FOREIGN KEY (user_id) REFERENCES users(id)
 	);
 
 	CREATE TABLE IF NOT EXISTS sessions (
@@ -149,19 +296,275 @@ func New(db *sql.DB) (AppDatabase, error) {
 		id TEXT PRIMARY KEY,
 		name TEXT NOT NULL,
 		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		photo_url TEXT
+		photo_url TEXT,
+		last_message TEXT,
+		last_message_is_reply INTEGER NOT NULL DEFAULT 0
 	);
 
 	CREATE TABLE IF NOT EXISTS group_members (
 		group_id TEXT,
 		user_id TEXT,
+		is_admin INTEGER NOT NULL DEFAULT 0,
 		FOREIGN KEY (group_id) REFERENCES groups(id),
 		FOREIGN KEY (user_id) REFERENCES users(id),
 		PRIMARY KEY (group_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS group_members_user ON group_members (user_id);
+
+	CREATE TABLE IF NOT EXISTS conversation_settings (
+		conversation_id TEXT PRIMARY KEY,
+		message_ttl BIGINT NOT NULL DEFAULT 0,
+		updated_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS pinned_messages (
+		conversation_id TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		pinned_by TEXT NOT NULL,
+		pinned_at DATETIME NOT NULL,
+		PRIMARY KEY (conversation_id, message_id),
+		FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+		FOREIGN KEY (pinned_by) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS message_mentions (
+		message_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		seen INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (message_id, user_id),
+		FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS blocks (
+		blocker_id TEXT NOT NULL,
+		blocked_id TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (blocker_id, blocked_id),
+		FOREIGN KEY (blocker_id) REFERENCES users(id),
+		FOREIGN KEY (blocked_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS avatar_history (
+		user_id TEXT NOT NULL,
+		photo_url TEXT NOT NULL,
+		set_at DATETIME NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS conversation_mutes (
+		conversation_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		muted_until DATETIME,
+		PRIMARY KEY (conversation_id, user_id),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS attachments (
+		id TEXT PRIMARY KEY,
+		message_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		filename TEXT NOT NULL,
+		mime_type TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		checksum TEXT NOT NULL,
+		storage_key TEXT NOT NULL,
+		duration_ms BIGINT,
+		width INTEGER,
+		height INTEGER,
+		uploaded_by TEXT,
+		FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+		FOREIGN KEY (uploaded_by) REFERENCES users(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS attachments_message ON attachments (message_id, position);
+	CREATE INDEX IF NOT EXISTS attachments_storage_key ON attachments (storage_key);
+
+	CREATE TABLE IF NOT EXISTS uploads (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		filename TEXT NOT NULL,
+		mime_type TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		upload_offset BIGINT NOT NULL DEFAULT 0,
+		checksum TEXT,
+		storage_key TEXT NOT NULL,
+		completed INTEGER NOT NULL DEFAULT 0,
+		expires_at DATETIME NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS link_previews (
+		message_id TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		title TEXT NOT NULL,
+		description TEXT NOT NULL,
+		image_url TEXT NOT NULL,
+		FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		conversation_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		created_by TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (created_by) REFERENCES users(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS webhooks_conversation ON webhooks (conversation_id);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id TEXT PRIMARY KEY,
+		webhook_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_status_code INTEGER,
+		last_error TEXT,
+		next_attempt_at DATETIME,
+		created_at DATETIME NOT NULL,
+		delivered_at DATETIME,
+		FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook ON webhook_deliveries (webhook_id, created_at);
+
+	CREATE TABLE IF NOT EXISTS bot_tokens (
+		id TEXT PRIMARY KEY,
+		bot_id TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		last_used_at DATETIME,
+		revoked_at DATETIME,
+		FOREIGN KEY (bot_id) REFERENCES users(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS bot_tokens_bot ON bot_tokens (bot_id);
+
+	CREATE TABLE IF NOT EXISTS slash_commands (
+		conversation_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		bot_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (conversation_id, name),
+		FOREIGN KEY (bot_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS reminders (
+		id TEXT PRIMARY KEY,
+		conversation_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		text TEXT NOT NULL,
+		due_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS reminders_due ON reminders (due_at);
+
+	CREATE TABLE IF NOT EXISTS polls (
+		message_id TEXT PRIMARY KEY,
+		multiple_choice INTEGER NOT NULL DEFAULT 0,
+		anonymous INTEGER NOT NULL DEFAULT 0,
+		closes_at DATETIME,
+		closed_at DATETIME,
+		FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS poll_options (
+		message_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		text TEXT NOT NULL,
+		PRIMARY KEY (message_id, position),
+		FOREIGN KEY (message_id) REFERENCES polls(message_id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS poll_votes (
+		message_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		user_id TEXT NOT NULL,
+		voted_at DATETIME NOT NULL,
+		PRIMARY KEY (message_id, position, user_id),
+		FOREIGN KEY (message_id, position) REFERENCES poll_options(message_id, position) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id)
 	);`
 
-	if _, err := db.Exec(sqlStmt); err != nil {
-		return nil, fmt.Errorf("error creating database schema: %w", err)
+// setupSchema creates the tables that do not exist yet and migrates the existing ones
+func setupSchema(ctx context.Context, db migrator) error {
+	// Crear tablas si no existen
+	if _, err := db.ExecContext(ctx, tablesSchema); err != nil {
+		return fmt.Errorf("error creating database schema: %w", err)
+	}
+
+	// Columns added after the first release; CREATE TABLE IF NOT EXISTS does not touch existing tables
+	// Previews of conversations and groups are stored since conversations have last_message_is_reply; older databases
+	// get them computed once
+	previewsStored, err := hasColumn(ctx, db, "conversations", "last_message_is_reply")
+	if err != nil {
+		return err
+	}
+
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"messages", "kind", "TEXT NOT NULL DEFAULT 'text'"},
+		{"group_members", "is_admin", "INTEGER NOT NULL DEFAULT 0"},
+		{"users", "last_seen", "DATETIME"},
+		{"users", "display_name", "TEXT"},
+		{"users", "bio", "TEXT"},
+		{"users", "photo_visibility", "TEXT NOT NULL DEFAULT 'everyone'"},
+		{"users", "last_seen_visibility", "TEXT NOT NULL DEFAULT 'everyone'"},
+		{"attachments", "uploaded_by", "TEXT REFERENCES users(id)"},
+		{"users", "is_bot", "INTEGER NOT NULL DEFAULT 0"},
+		{"users", "owner_id", "TEXT REFERENCES users(id)"},
+		{"users", "forward_visibility", "TEXT NOT NULL DEFAULT 'everyone'"},
+		{"messages", "system_event", "TEXT"},
+		{"messages", "forwarded_from_message", "TEXT"},
+		{"messages", "forwarded_from_sender", "TEXT REFERENCES users(id)"},
+		{"messages", "forwarded_from_conversation", "TEXT"},
+		{"conversations", "last_message_is_reply", "INTEGER NOT NULL DEFAULT 0"},
+		{"groups", "last_message", "TEXT"},
+		{"groups", "last_message_is_reply", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(ctx, db, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	if err := migrateHideLastSeen(ctx, db); err != nil {
+		return err
+	}
+	if err := migrateUserIDs(ctx, db); err != nil {
+		return err
+	}
+	if err := migrateReactionsKey(ctx, db); err != nil {
+		return err
+	}
+	if err := migrateMessageKinds(ctx, db); err != nil {
+		return err
+	}
+	if err := promoteGroupAdmins(ctx, db, sqliteDialect{}, ""); err != nil {
+		return err
+	}
+	if !previewsStored {
+		if err := migratePreviews(ctx, db); err != nil {
+			return err
+		}
+	}
+	if err := migrateWebhooksTable(ctx, db); err != nil {
+		return err
 	}
 
 	// // After creating tables, insert test users
@@ -171,38 +574,56 @@ func New(db *sql.DB) (AppDatabase, error) {
 	// 	('user1', 'manuel1', 'token1'),
 	// 	('user2', 'manueltest', 'token2');
 	// `
-	// if _, err := db.Exec(sqlStmt); err != nil {
-	// 	return nil, fmt.Errorf("error inserting test users: %w", err)
+	// if _, err := db.ExecContext(ctx, sqlStmt); err != nil {
+	// 	return fmt.Errorf("error inserting test users: %w", err)
 	// }
 
-	return &appdbimpl{
-		c: db,
-	}, nil
+	return nil
 }
 
-func (db *appdbimpl) Ping() error {
-	return db.c.Ping()
+// addColumnIfMissing adds a column to an existing table if the table was created before the column existed
+func addColumnIfMissing(ctx context.Context, db execer, table string, column string, definition string) error {
+	found, err := hasColumn(ctx, db, table, column)
+	if err != nil || found {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column,
+		definition)); err != nil {
+		return fmt.Errorf("error adding column %s.%s: %w", table, column, err)
+	}
+	return nil
 }
 
-func (db *appdbimpl) GetAllUsers() ([]string, error) {
-	rows, err := db.c.Query(`
-		SELECT username 
-		FROM users 
-		ORDER BY username
-	`)
+// hasColumn tells whether a table has a column
+func hasColumn(ctx context.Context, db execer, table string, column string) (bool, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
 	if err != nil {
-		return nil, fmt.Errorf("error getting users: %w", err)
+		return false, fmt.Errorf("error reading columns of %s: %w", table, err)
 	}
 	defer rows.Close()
 
-	var users []string
+	found := false
 	for rows.Next() {
-		var username string
-		if err := rows.Scan(&username); err != nil {
-			return nil, fmt.Errorf("error scanning username: %w", err)
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, fmt.Errorf("error scanning columns of %s: %w", table, err)
+		}
+		if name == column {
+			found = true
 		}
-		users = append(users, username)
 	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("error iterating columns of %s: %w", table, err)
+	}
+	return found, nil
+}
+
+func (db *appdbimpl) Ping(ctx context.Context) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	return users, nil
+	return db.c.PingContext(ctx)
 }
@@ -1,18 +1,39 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// setupTestDB crea una base de datos en memoria para testing
-func setupTestDB(t *testing.T) AppDatabase {
-	db, err := sql.Open("sqlite3", ":memory:")
+// testPostgresVariable names the environment variable with the DSN of a PostgreSQL database to run the tests on
+// instead of SQLite, like "postgres://postgres@localhost/decaf_test?sslmode=disable"
+const testPostgresVariable = "DECAF_TEST_POSTGRES_DSN"
+
+// setupTestDB crea una base de datos en memoria para testing. Each connection to a plain ":memory:" DSN gets its own
+// empty database, so a named shared-cache database is used to let all pool connections see the same tables. Like the
+// writer opened by OpenSQLite, it enforces foreign keys and has a single connection, so that a query nested in another
+// one deadlocks the tests rather than the server.
+func setupTestDB(t testing.TB) AppDatabase {
+	if dsn := os.Getenv(testPostgresVariable); dsn != "" {
+		return setupPostgresTestDB(t, dsn)
+	}
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared&_foreign_keys=1", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		t.Fatalf("error opening database: %v", err)
 	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
 
 	appDB, err := New(db)
 	if err != nil {
@@ -22,10 +43,182 @@ func setupTestDB(t *testing.T) AppDatabase {
 	return appDB
 }
 
+// setupPostgresTestDB gives the test an empty schema of its own in the PostgreSQL database at dsn, dropped when the
+// test ends. The pool has a single connection, as the one of setupTestDB, and runs in UTC so that the timestamps
+// written as text by the tests mean the same as in SQLite.
+func setupPostgresTestDB(t testing.TB, dsn string) AppDatabase {
+	admin, err := OpenPostgres(dsn, 1)
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() { _ = admin.Close() })
+
+	schema := "test_" + strings.ReplaceAll(generateUUID(), "-", "")
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("error creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("error dropping schema: %v", err)
+		}
+	})
+
+	params := url.Values{"search_path": {schema}, "timezone": {"UTC"}}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatalf("error parsing %s: %v", testPostgresVariable, err)
+		}
+		query := u.Query()
+		for k, v := range params {
+			query[k] = v
+		}
+		u.RawQuery = query.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + schema + " timezone=UTC"
+	}
+
+	db, err := OpenPostgres(dsn, 1)
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	// Registered after the schema cleanup, so it runs before it
+	t.Cleanup(func() { _ = db.Close() })
+
+	appDB, err := NewPostgres(db, 0)
+	if err != nil {
+		t.Fatalf("error creating app database: %v", err)
+	}
+	return appDB
+}
+
+// skipOnPostgres skips the tests of what only SQLite has when they run on PostgreSQL
+func skipOnPostgres(t testing.TB) {
+	if os.Getenv(testPostgresVariable) != "" {
+		t.Skip("SQLite only")
+	}
+}
+
 // TestPing verifica la conexión a la base de datos
 func TestPing(t *testing.T) {
 	db := setupTestDB(t)
-	if err := db.Ping(); err != nil {
+	ctx := context.Background()
+	if err := db.Ping(ctx); err != nil {
 		t.Errorf("ping failed: %v", err)
 	}
 }
+
+// TestContextCancellation checks that calls stop when the context of the caller is done or the query timeout passes
+func TestContextCancellation(t *testing.T) {
+	db := setupTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := db.GetUserConversations(ctx, "user1", 50, ""); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled; got %v", err)
+	}
+	if _, err := db.CreateConversation(ctx, []string{"user1", "user2"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled creating a conversation; got %v", err)
+	}
+
+	impl := db.(*appdbimpl)
+	bounded := *impl
+	bounded.timeout = time.Nanosecond
+	_, _, err := bounded.GetUserConversations(context.Background(), "user1", 50, "")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded; got %v", err)
+	}
+	if _, err := NewWithReaders(impl.c, impl.r, -time.Second); err == nil {
+		t.Error("expected a negative query timeout to be rejected")
+	}
+}
+
+// TestForeignKeyCascades checks that deleting a message or a webhook deletes what belongs to it through the foreign
+// key cascades
+func TestForeignKeyCascades(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	c := db.(*appdbimpl).c
+
+	_, err := c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2'),
+		('user3', 'carol', 'token3')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	msgID, err := db.CreateMessage(ctx, conversationID, "user1", "hi @bob, see https://example.com")
+	if err != nil {
+		t.Fatalf("error creating message: %v", err)
+	}
+	if err := db.AddReaction(ctx, msgID, "user2", "👍"); err != nil {
+		t.Fatalf("error adding reaction: %v", err)
+	}
+	if err := db.PinMessage(ctx, conversationID, msgID, "user2"); err != nil {
+		t.Fatalf("error pinning message: %v", err)
+	}
+	if err := db.SetLinkPreview(ctx, msgID, LinkPreview{URL: "https://example.com", Title: "Example"}); err != nil {
+		t.Fatalf("error setting link preview: %v", err)
+	}
+	_, err = c.Exec(`
+		INSERT INTO attachments (id, message_id, position, filename, mime_type, size, checksum, storage_key)
+		VALUES ('att1', ?, 0, 'notes.txt', 'text/plain', 5, 'sum', 'key1')
+	`, msgID)
+	if err != nil {
+		t.Fatalf("error inserting attachment: %v", err)
+	}
+	replyID, err := db.CreateReplyMessage(ctx, conversationID, "user2", "hello", msgID)
+	if err != nil {
+		t.Fatalf("error creating reply: %v", err)
+	}
+
+	if err := db.DeleteMessage(ctx, msgID); err != nil {
+		t.Fatalf("error deleting message: %v", err)
+	}
+	var left int
+	err = c.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM reactions) + (SELECT COUNT(*) FROM pinned_messages) +
+		       (SELECT COUNT(*) FROM message_mentions) + (SELECT COUNT(*) FROM link_previews) +
+		       (SELECT COUNT(*) FROM attachments)
+	`).Scan(&left)
+	if err != nil || left != 0 {
+		t.Errorf("expected everything of the message to be deleted with it; %d rows left, %v", left, err)
+	}
+	var replyTo sql.NullString
+	if err := c.QueryRow(`SELECT reply_to_id FROM messages WHERE id = ?`, replyID).Scan(&replyTo); err != nil {
+		t.Fatalf("error reading reply: %v", err)
+	}
+	if replyTo.Valid {
+		t.Errorf("expected the reply to be detached; got reply_to_id %q", replyTo.String)
+	}
+
+	// Groups have no conversations row, yet have webhooks and messages
+	group, err := db.CreateGroup(ctx, "Office", "user1", []string{"bob", "carol"})
+	if err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+	webhook := Webhook{ConversationID: group.ID, URL: "https://example.com/hook", Secret: "s", Events: []string{"message"},
+		CreatedBy: "user1"}
+	if err := db.CreateWebhook(ctx, &webhook); err != nil {
+		t.Fatalf("error creating group webhook: %v", err)
+	}
+	if _, err := db.CreateMessage(ctx, group.ID, "user2", "hello"); err != nil {
+		t.Fatalf("error creating group message: %v", err)
+	}
+	if n, err := db.QueueWebhookDeliveries(ctx, group.ID, "message", []byte("{}")); err != nil || n != 1 {
+		t.Fatalf("expected one delivery queued; got %d, %v", n, err)
+	}
+	if err := db.DeleteWebhook(ctx, group.ID, webhook.ID); err != nil {
+		t.Fatalf("error deleting webhook: %v", err)
+	}
+	if err := c.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries`).Scan(&left); err != nil || left != 0 {
+		t.Errorf("expected the deliveries to be deleted with the webhook; %d left, %v", left, err)
+	}
+}
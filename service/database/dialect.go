@@ -0,0 +1,67 @@
+package database
+
+// dialect writes the few expressions that SQLite and PostgreSQL spell differently. Queries use the SQL both accept
+// and ask the dialect of their appdbimpl for the rest; the `?` placeholders are the same on both, see OpenPostgres.
+type dialect interface {
+	// formatTime selects a timestamp as text like "2006-01-02 15:04:05", in UTC
+	formatTime(expr string) string
+
+	// timeValue makes a timestamp comparable with other timestamps, whatever the time zone they were stored in
+	timeValue(expr string) string
+
+	// addSeconds is the timeValue of a timestamp moved forward by a number of seconds
+	addSeconds(expr string, seconds string) string
+
+	// position is the 1-based index of substr in str, 0 when str does not contain it
+	position(str string, substr string) string
+
+	// likeNoCase is the LIKE operator ignoring case
+	likeNoCase() string
+}
+
+// sqliteDialect stores timestamps as text, compared and shifted through julianday
+type sqliteDialect struct{}
+
+func (sqliteDialect) formatTime(expr string) string {
+	return "strftime('%Y-%m-%d %H:%M:%S', " + expr + ")"
+}
+
+func (sqliteDialect) timeValue(expr string) string {
+	return "julianday(" + expr + ")"
+}
+
+func (sqliteDialect) addSeconds(expr string, seconds string) string {
+	return "julianday(" + expr + ") + " + seconds + " / 86400.0"
+}
+
+func (sqliteDialect) position(str string, substr string) string {
+	return "instr(" + str + ", " + substr + ")"
+}
+
+// likeNoCase is LIKE, which ignores the case of ASCII letters in SQLite
+func (sqliteDialect) likeNoCase() string {
+	return "LIKE"
+}
+
+// postgresDialect stores timestamps as TIMESTAMPTZ, which compare as they are
+type postgresDialect struct{}
+
+func (postgresDialect) formatTime(expr string) string {
+	return "to_char(" + expr + " AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')"
+}
+
+func (postgresDialect) timeValue(expr string) string {
+	return expr
+}
+
+func (postgresDialect) addSeconds(expr string, seconds string) string {
+	return expr + " + " + seconds + " * INTERVAL '1 second'"
+}
+
+func (postgresDialect) position(str string, substr string) string {
+	return "strpos(" + str + ", " + substr + ")"
+}
+
+func (postgresDialect) likeNoCase() string {
+	return "ILIKE"
+}
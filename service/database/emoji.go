@@ -0,0 +1,102 @@
+package database
+
+// legacyReactions are the text emoticons offered by the first clients, still accepted next to emoji
+var legacyReactions = map[string]bool{
+	":)": true,
+	":(": true,
+	":D": true,
+	":P": true,
+	"<3": true,
+}
+
+// emojiRanges is the allow-list of code points that can start an emoji. It follows the Extended_Pictographic
+// property of Unicode closely enough for reactions, without pulling in the full Unicode tables.
+var emojiRanges = []struct{ lo, hi rune }{
+	{0x00A9, 0x00A9}, {0x00AE, 0x00AE}, {0x203C, 0x203C}, {0x2049, 0x2049},
+	{0x2122, 0x2122}, {0x2139, 0x2139}, {0x2194, 0x21AA}, {0x231A, 0x23FF},
+	{0x24C2, 0x24C2}, {0x25AA, 0x27BF}, {0x2934, 0x2935}, {0x2B05, 0x2B55},
+	{0x3030, 0x3030}, {0x303D, 0x303D}, {0x3297, 0x3299}, {0x1F000, 0x1FAFF},
+}
+
+const (
+	zeroWidthJoiner   = 0x200D
+	keycapCombiner    = 0x20E3
+	variationText     = 0xFE0E
+	variationEmoji    = 0xFE0F
+	regionalIndicator = 0x1F1E6 // Regional indicators go from A (0x1F1E6) to Z (0x1F1FF)
+
+	// maxEmojiParts is the longest ZWJ sequence in Unicode, e.g. a kiss between two people
+	maxEmojiParts = 4
+)
+
+// isValidReaction checks that a reaction is one of the legacy emoticons or exactly one emoji grapheme cluster:
+// a flag, a keycap, or up to maxEmojiParts emoji joined by ZWJ, each with optional variation selectors, skin tones
+// and tag sequences. Counting bytes or code points does not work, since a single emoji can take more than ten code
+// points.
+func isValidReaction(reaction string) bool {
+	if legacyReactions[reaction] {
+		return true
+	}
+
+	runes := []rune(reaction)
+	if len(runes) == 0 {
+		return false
+	}
+
+	// Flags are two regional indicators and cannot be joined
+	if isRegionalIndicator(runes[0]) {
+		return len(runes) == 2 && isRegionalIndicator(runes[1])
+	}
+
+	// Keycaps are a digit, # or *, an optional emoji variation selector and the combining keycap
+	if isKeycapBase(runes[0]) {
+		rest := runes[1:]
+		if len(rest) > 0 && rest[0] == variationEmoji {
+			rest = rest[1:]
+		}
+		return len(rest) == 1 && rest[0] == keycapCombiner
+	}
+
+	i := 0
+	for parts := 1; ; parts++ {
+		if parts > maxEmojiParts || i >= len(runes) || !isEmoji(runes[i]) {
+			return false
+		}
+		i++
+		for i < len(runes) && isEmojiModifier(runes[i]) {
+			i++
+		}
+		if i == len(runes) {
+			return true
+		}
+		if runes[i] != zeroWidthJoiner {
+			return false
+		}
+		i++
+	}
+}
+
+// isEmoji checks if r is in the emoji allow-list
+func isEmoji(r rune) bool {
+	for _, e := range emojiRanges {
+		if r >= e.lo && r <= e.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmojiModifier checks if r changes the emoji before it: variation selectors, skin tones and tag characters
+func isEmojiModifier(r rune) bool {
+	return r == variationText || r == variationEmoji ||
+		(r >= 0x1F3FB && r <= 0x1F3FF) ||
+		(r >= 0xE0020 && r <= 0xE007F)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= regionalIndicator && r <= regionalIndicator+25
+}
+
+func isKeycapBase(r rune) bool {
+	return (r >= '0' && r <= '9') || r == '#' || r == '*'
+}
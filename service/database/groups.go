@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -9,13 +10,16 @@ import (
 )
 
 // CreateGroup creates a new group with multiple members
-func (db *appdbimpl) CreateGroup(name string, creatorID string, members []string) (*Group, error) {
+func (db *appdbimpl) CreateGroup(ctx context.Context, name string, creatorID string, members []string) (*Group, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	if name == "" {
 		return nil, errors.New("group name is required")
 	}
 
 	// Start transaction
-	tx, err := db.c.Begin()
+	tx, err := db.c.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error starting transaction: %w", err)
 	}
@@ -27,7 +31,7 @@ func (db *appdbimpl) CreateGroup(name string, creatorID string, members []string
 
 	// Create group
 	groupID := generateUUID()
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
         INSERT INTO groups (id, name, timestamp)
         VALUES (?, ?, CURRENT_TIMESTAMP)
     `, groupID, name)
@@ -35,20 +39,31 @@ func (db *appdbimpl) CreateGroup(name string, creatorID string, members []string
 		return nil, fmt.Errorf("error creating group: %w", err)
 	}
 
-	// Add creator as member
-	_, err = tx.Exec(`
-        INSERT INTO group_members (group_id, user_id)
-        VALUES (?, ?)
+	// Add creator as member and admin
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO group_members (group_id, user_id, is_admin)
+        VALUES (?, ?, 1)
     `, groupID, creatorID)
 	if err != nil {
 		return nil, fmt.Errorf("error adding group creator: %w", err)
 	}
 
+	var creator string
+	if err := tx.QueryRowContext(ctx, "SELECT username FROM users WHERE id = ?", creatorID).Scan(&creator); err != nil {
+		return nil, fmt.Errorf("error getting creator username: %w", err)
+	}
+	now := time.Now()
+	err = insertSystemMessage(ctx, tx, groupID, creatorID, SystemEventGroupCreated,
+		fmt.Sprintf("%s created the group %q", creator, name), now)
+	if err != nil {
+		return nil, err
+	}
+
 	// Add other members
 	for _, memberUsername := range members {
 		// Get user ID from username
 		var userID string
-		err := tx.QueryRow(`
+		err := tx.QueryRowContext(ctx, `
             SELECT id FROM users WHERE username = ?
         `, memberUsername).Scan(&userID)
 		if err != nil {
@@ -56,13 +71,18 @@ func (db *appdbimpl) CreateGroup(name string, creatorID string, members []string
 		}
 
 		// Add member to group
-		_, err = tx.Exec(`
+		_, err = tx.ExecContext(ctx, `
             INSERT INTO group_members (group_id, user_id)
             VALUES (?, ?)
         `, groupID, userID)
 		if err != nil {
 			return nil, fmt.Errorf("error adding member %s: %w", memberUsername, err)
 		}
+		err = insertSystemMessage(ctx, tx, groupID, creatorID, SystemEventMemberJoined,
+			fmt.Sprintf("%s added %s", creator, memberUsername), now)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if err = tx.Commit(); err != nil {
@@ -72,49 +92,53 @@ func (db *appdbimpl) CreateGroup(name string, creatorID string, members []string
 	return &Group{
 		ID:        groupID,
 		Name:      name,
-		CreatedAt: time.Now(),
+		CreatedAt: now,
 	}, nil
 }
 
-// UpdateGroupName updates the group name
-func (db *appdbimpl) UpdateGroupName(groupID string, newName string) error {
+// UpdateGroupName updates the group name on behalf of actorID, telling the members with a system message
+func (db *appdbimpl) UpdateGroupName(ctx context.Context, groupID string, actorID string, newName string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	if newName == "" {
 		return errors.New("group name is required")
 	}
 
-	result, err := db.c.Exec(`
-        UPDATE groups 
-        SET name = ?
-        WHERE id = ?
-    `, newName, groupID)
-	if err != nil {
-		return fmt.Errorf("error updating group name: %w", err)
-	}
+	return db.updateGroup(ctx, groupID, actorID, "name", newName, SystemEventGroupRenamed,
+		func(actor string) string { return fmt.Sprintf("%s renamed the group to %q", actor, newName) })
+}
 
-	rows, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("error checking affected rows: %w", err)
-	}
-	if rows == 0 {
-		return errors.New("group not found")
+// UpdateGroupPhoto updates the group photo on behalf of actorID, telling the members with a system message
+func (db *appdbimpl) UpdateGroupPhoto(ctx context.Context, groupID string, actorID string, photoURL string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if photoURL == "" {
+		return errors.New("photo URL is required")
 	}
 
-	return nil
+	return db.updateGroup(ctx, groupID, actorID, "photo_url", photoURL, SystemEventGroupPhotoChanged,
+		func(actor string) string { return fmt.Sprintf("%s changed the group photo", actor) })
 }
 
-// UpdateGroupPhoto updates the group photo
-func (db *appdbimpl) UpdateGroupPhoto(groupID string, photoURL string) error {
-	if photoURL == "" {
-		return errors.New("photo URL is required")
+// updateGroup sets a column of a group and posts the system message event, described by describe from the username
+// of actorID
+func (db *appdbimpl) updateGroup(ctx context.Context, groupID string, actorID string, column string, value string,
+	event string, describe func(actor string) string) error {
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
 	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
 
-	result, err := db.c.Exec(`
-        UPDATE groups 
-        SET photo_url = ?
-        WHERE id = ?
-    `, photoURL, groupID)
+	result, err := tx.ExecContext(ctx, `UPDATE groups SET `+column+` = ? WHERE id = ?`, value, groupID)
 	if err != nil {
-		return fmt.Errorf("error updating group photo: %w", err)
+		return fmt.Errorf("error updating group %s: %w", column, err)
 	}
 
 	rows, err := result.RowsAffected()
@@ -125,26 +149,109 @@ func (db *appdbimpl) UpdateGroupPhoto(groupID string, photoURL string) error {
 		return errors.New("group not found")
 	}
 
+	var actor string
+	if err := tx.QueryRowContext(ctx, "SELECT username FROM users WHERE id = ?", actorID).Scan(&actor); err != nil {
+		return fmt.Errorf("error getting actor username: %w", err)
+	}
+	if err := insertSystemMessage(ctx, tx, groupID, actorID, event, describe(actor), time.Now()); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
 	return nil
 }
 
-// LeaveGroup allows a user to leave a group
-func (db *appdbimpl) LeaveGroup(groupID string, userID string) error {
-	result, err := db.c.Exec(`
+// LeaveGroup allows a user to leave a group, telling the remaining members with a system message
+func (db *appdbimpl) LeaveGroup(ctx context.Context, groupID string, userID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
+
+	// The members are told while the user still belongs to the group, as only members post to it
+	var username string
+	if err := tx.QueryRowContext(ctx, "SELECT username FROM users WHERE id = ?", userID).Scan(&username); err != nil {
+		return fmt.Errorf("error getting username: %w", err)
+	}
+	err = insertSystemMessage(ctx, tx, groupID, userID, SystemEventMemberLeft, username+" left the group", time.Now())
+	if errors.Is(err, ErrNotParticipant) || errors.Is(err, ErrConversationNotFound) {
+		return errors.New("user is not a member of this group")
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
         DELETE FROM group_members
         WHERE group_id = ? AND user_id = ?
     `, groupID, userID)
 	if err != nil {
 		return fmt.Errorf("error leaving group: %w", err)
 	}
+	if err := promoteGroupAdmins(ctx, tx, db.dialect, groupID); err != nil {
+		return err
+	}
 
-	rows, err := result.RowsAffected()
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// IsGroupAdmin checks if a user is an admin of the group, who can manage its settings
+func (db *appdbimpl) IsGroupAdmin(ctx context.Context, groupID string, userID string) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var isAdmin bool
+	err := db.r.QueryRowContext(ctx, `
+        SELECT EXISTS(SELECT 1 FROM group_members WHERE group_id = ? AND user_id = ? AND is_admin = 1)
+    `, groupID, userID).Scan(&isAdmin)
 	if err != nil {
-		return fmt.Errorf("error checking affected rows: %w", err)
+		return false, fmt.Errorf("error checking group admin: %w", err)
 	}
-	if rows == 0 {
-		return errors.New("user is not a member of this group")
+	return isAdmin, nil
+}
+
+// promoteGroupAdmins makes an admin in the groups left without one, so that someone can still manage them: the member
+// who posted first, or the first member by ID when none posted. The creator is the admin of a new group; this covers
+// the last admin leaving and the groups created before admins existed. An empty groupID checks every group.
+func promoteGroupAdmins(ctx context.Context, db execer, d dialect, groupID string) error {
+	firstPost := "MIN(" + d.timeValue("m.timestamp") + ")"
+	query := `
+        UPDATE group_members SET is_admin = 1
+        WHERE NOT EXISTS(SELECT 1 FROM group_members a WHERE a.group_id = group_members.group_id AND a.is_admin = 1)
+          AND user_id = (
+            SELECT gm.user_id
+            FROM group_members gm
+            LEFT JOIN messages m ON m.conversation_id = gm.group_id AND m.sender = gm.user_id
+            WHERE gm.group_id = group_members.group_id
+            GROUP BY gm.user_id
+            ORDER BY ` + firstPost + ` IS NULL, ` + firstPost + `, gm.user_id
+            LIMIT 1
+          )`
+	var args []interface{}
+	if groupID != "" {
+		query += " AND group_id = ?"
+		args = append(args, groupID)
 	}
 
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("error promoting group admins: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 && groupID == "" {
+		log.Printf("Promoted %d members to admins of groups without one", n)
+	}
 	return nil
 }
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGroupAdmins(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2'),
+		('user3', 'carol', 'token3')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	group, err := db.CreateGroup(ctx, "Office", "user1", []string{"bob", "carol"})
+	if err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+
+	for user, expected := range map[string]bool{"user1": true, "user2": false, "user3": false} {
+		isAdmin, err := db.IsGroupAdmin(ctx, group.ID, user)
+		if err != nil {
+			t.Fatalf("error checking admin: %v", err)
+		}
+		if isAdmin != expected {
+			t.Errorf("expected %s admin %v; got %v", user, expected, isAdmin)
+		}
+	}
+
+	// carol posted before bob, so she takes over when the only admin leaves
+	if _, err := db.SendMessage(ctx, group.ID, "user3", "hi"); err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, err := db.SendMessage(ctx, group.ID, "user2", "hello"); err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	if err := db.LeaveGroup(ctx, group.ID, "user1"); err != nil {
+		t.Fatalf("error leaving group: %v", err)
+	}
+	if isAdmin, err := db.IsGroupAdmin(ctx, group.ID, "user3"); err != nil || !isAdmin {
+		t.Errorf("expected carol promoted to admin; got %v, %v", isAdmin, err)
+	}
+	if isAdmin, err := db.IsGroupAdmin(ctx, group.ID, "user2"); err != nil || isAdmin {
+		t.Errorf("expected bob still a member; got %v, %v", isAdmin, err)
+	}
+}
+
+func TestMigrateGroupAdmins(t *testing.T) {
+	skipOnPostgres(t)
+	db := setupTestDB(t)
+	ctx := context.Background()
+	impl := db.(*appdbimpl)
+
+	// IDs longer than usernames, which the user ID migration would replace
+	alice, bob := "00000000-0000-0000-0000-000000000001", "00000000-0000-0000-0000-000000000002"
+	_, err := impl.c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('00000000-0000-0000-0000-000000000001', 'alice', 'token1'),
+		('00000000-0000-0000-0000-000000000002', 'bob', 'token2');
+		INSERT INTO groups (id, name) VALUES ('posted', 'Posted'), ('silent', 'Silent');
+		INSERT INTO group_members (group_id, user_id) VALUES
+		('posted', '00000000-0000-0000-0000-000000000001'), ('posted', '00000000-0000-0000-0000-000000000002'),
+		('silent', '00000000-0000-0000-0000-000000000001'), ('silent', '00000000-0000-0000-0000-000000000002');
+		INSERT INTO messages (id, conversation_id, sender, content, timestamp) VALUES
+		('msg1', 'posted', '00000000-0000-0000-0000-000000000002', 'first', '2024-01-01 10:00:00'),
+		('msg2', 'posted', '00000000-0000-0000-0000-000000000001', 'second', '2024-01-01 10:01:00');
+	`)
+	if err != nil {
+		t.Fatalf("error inserting groups without admins: %v", err)
+	}
+	if err := setupSchema(ctx, impl.c); err != nil {
+		t.Fatalf("error migrating database: %v", err)
+	}
+
+	// The first poster manages a group, the first member by ID one where nobody posted
+	want := []struct {
+		group, user string
+		admin       bool
+	}{
+		{"posted", alice, false},
+		{"posted", bob, true},
+		{"silent", alice, true},
+		{"silent", bob, false},
+	}
+	for _, w := range want {
+		isAdmin, err := db.IsGroupAdmin(ctx, w.group, w.user)
+		if err != nil {
+			t.Fatalf("error checking admin: %v", err)
+		}
+		if isAdmin != w.admin {
+			t.Errorf("expected %s admin of %s %v; got %v", w.user, w.group, w.admin, isAdmin)
+		}
+	}
+}
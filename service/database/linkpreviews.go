@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// SetLinkPreview saves the preview of the link in a message. Nothing is saved if the message was deleted meanwhile.
+func (db *appdbimpl) SetLinkPreview(ctx context.Context, messageID string, preview LinkPreview) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.c.ExecContext(ctx, `
+        INSERT INTO link_previews (message_id, url, title, description, image_url)
+        SELECT id, ?, ?, ?, ? FROM messages WHERE id = ?
+        ON CONFLICT (message_id) DO UPDATE
+        SET url = excluded.url, title = excluded.title, description = excluded.description,
+            image_url = excluded.image_url
+    `, preview.URL, preview.Title, preview.Description, preview.ImageURL, messageID)
+	if err != nil {
+		return fmt.Errorf("error saving link preview: %w", err)
+	}
+	return nil
+}
+
+// copyLinkPreview gives the message toMessageID the link preview of fromMessageID, if it has one
+func copyLinkPreview(ctx context.Context, ex execer, fromMessageID string, toMessageID string) error {
+	_, err := ex.ExecContext(ctx, `
+        INSERT INTO link_previews (message_id, url, title, description, image_url)
+        SELECT ?, url, title, description, image_url FROM link_previews WHERE message_id = ?
+    `, toMessageID, fromMessageID)
+	if err != nil {
+		return fmt.Errorf("error copying link preview: %w", err)
+	}
+	return nil
+}
+
+// getConversationLinkPreviews returns the link previews of the messages of a conversation, by message ID
+func (db *appdbimpl) getConversationLinkPreviews(ctx context.Context, conversationID string) (map[string]*LinkPreview,
+	error) {
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT l.message_id, l.url, l.title, l.description, l.image_url
+        FROM link_previews l
+        JOIN messages m ON m.id = l.message_id
+        WHERE m.conversation_id = ?
+    `, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting link previews: %w", err)
+	}
+	defer rows.Close()
+
+	previews := make(map[string]*LinkPreview)
+	for rows.Next() {
+		var messageID string
+		var p LinkPreview
+		if err := rows.Scan(&messageID, &p.URL, &p.Title, &p.Description, &p.ImageURL); err != nil {
+			return nil, fmt.Errorf("error scanning link preview: %w", err)
+		}
+		previews[messageID] = &p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating link previews: %w", err)
+	}
+	return previews, nil
+}
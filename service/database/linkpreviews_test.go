@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLinkPreviews(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	c := db.(*appdbimpl).c
+
+	_, err := c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2'),
+		('user3', 'carol', 'token3')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	otherID, err := db.CreateConversation(ctx, []string{"alice", "carol"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	messageID, err := db.CreateMessage(ctx, conversationID, "user1", "look https://example.com")
+	if err != nil {
+		t.Fatalf("error creating message: %v", err)
+	}
+	preview := LinkPreview{URL: "https://example.com", Title: "Example", ImageURL: "https://example.com/a.png"}
+	if err := db.SetLinkPreview(ctx, messageID, preview); err != nil {
+		t.Fatalf("error setting link preview: %v", err)
+	}
+
+	messages, err := db.GetConversationMessages(ctx, conversationID, "user2")
+	if err != nil {
+		t.Fatalf("error getting messages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].LinkPreview == nil || *messages[0].LinkPreview != preview {
+		t.Fatalf("expected the message with its preview; got %+v", messages)
+	}
+
+	// A forwarded copy keeps the preview, which goes away with each message
+	forwarded, err := forwardTo(db, messageID, "user1", otherID)
+	if err != nil {
+		t.Fatalf("error forwarding message: %v", err)
+	}
+	if err := db.DeleteMessage(ctx, messageID); err != nil {
+		t.Fatalf("error deleting message: %v", err)
+	}
+	messages, err = db.GetConversationMessages(ctx, otherID, "user3")
+	if err != nil {
+		t.Fatalf("error getting messages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].ID != forwarded.ID || messages[0].LinkPreview == nil {
+		t.Errorf("expected the forwarded copy to keep the preview; got %+v", messages)
+	}
+
+	// A preview fetched after its message was deleted is dropped
+	if err := db.SetLinkPreview(ctx, messageID, preview); err != nil {
+		t.Fatalf("error setting link preview: %v", err)
+	}
+	var count int
+	if err := c.QueryRow(`SELECT COUNT(*) FROM link_previews`).Scan(&count); err != nil || count != 1 {
+		t.Errorf("expected only the preview of the copy; got %d, %v", count, err)
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"regexp"
@@ -10,39 +11,41 @@ import (
 	"github.com/google/uuid"
 )
 
-func (db *appdbimpl) CreateSession(name string) (*Session, error) {
+func (db *appdbimpl) CreateSession(ctx context.Context, name string) (*Session, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	log.Printf("Creating session for: %s", name)
 
 	// Validate name format
-	if len(name) < 3 || len(name) > 16 {
-		return nil, errors.New("name must be between 3 and 16 characters")
-	}
-
-	namePattern := regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
-	if !namePattern.MatchString(name) {
-		return nil, errors.New("invalid name format")
+	if err := validateUsername(name); err != nil {
+		return nil, err
 	}
 
 	// Generate new token
 	newToken := uuid.New().String()
 
-	// Check if user exists
-	var exists bool
-	err := db.c.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", name).Scan(&exists)
+	// Check if user exists. Bots only authenticate with their API tokens.
+	var exists, isBot bool
+	err := db.c.QueryRowContext(ctx, "SELECT COUNT(*) > 0, COALESCE(MAX(is_bot), 0) FROM users WHERE username = ?", name).
+		Scan(&exists, &isBot)
 	if err != nil {
 		return nil, fmt.Errorf("error checking user existence: %w", err)
 	}
+	if isBot {
+		return nil, ErrBotLogin
+	}
 
 	if exists {
 		// Update existing user's token
-		_, err = db.c.Exec("UPDATE users SET token = ? WHERE username = ?", newToken, name)
+		_, err = db.c.ExecContext(ctx, "UPDATE users SET token = ? WHERE username = ?", newToken, name)
 		if err != nil {
 			return nil, fmt.Errorf("error updating user token: %w", err)
 		}
 	} else {
-		// Create new user with username as ID
-		_, err = db.c.Exec("INSERT INTO users (id, username, token) VALUES (?, ?, ?)",
-			name, name, newToken) // Using name as ID
+		// Create new user with an opaque ID, so that the username can change later
+		_, err = db.c.ExecContext(ctx, "INSERT INTO users (id, username, token) VALUES (?, ?, ?)",
+			uuid.New().String(), name, newToken)
 		if err != nil {
 			return nil, fmt.Errorf("error creating user: %w", err)
 		}
@@ -53,3 +56,17 @@ func (db *appdbimpl) CreateSession(name string) (*Session, error) {
 		Identifier: newToken,
 	}, nil
 }
+
+// usernamePattern is the format of usernames, which must also be 3 to 16 characters long
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// validateUsername checks the format of a username of a new user or bot
+func validateUsername(name string) error {
+	if len(name) < 3 || len(name) > 16 {
+		return errors.New("name must be between 3 and 16 characters")
+	}
+	if !usernamePattern.MatchString(name) {
+		return errors.New("invalid name format")
+	}
+	return nil
+}
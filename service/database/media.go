@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// GetMediaReferences counts, for every stored file, how many rows refer to it. Files are identified by their path
+// relative to the working directory, like "uploads/images/<sha256>.png" or "attachments/<sha256>": photos and
+// images are referenced by URLs containing "/uploads/", attachments and finalized uploads by their storage key.
+// Partial uploads are not counted, they are removed together with their upload.
+func (db *appdbimpl) GetMediaReferences(ctx context.Context) (map[string]int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT path, COUNT(*) FROM (
+            SELECT 'uploads/' || substr(image_url, `+db.uploadsIndex("image_url")+` + 9) AS path
+            FROM messages WHERE `+db.uploadsIndex("image_url")+` > 0
+            UNION ALL
+            SELECT 'uploads/' || substr(photo_url, `+db.uploadsIndex("photo_url")+` + 9)
+            FROM users WHERE `+db.uploadsIndex("photo_url")+` > 0
+            UNION ALL
+            SELECT 'uploads/' || substr(photo_url, `+db.uploadsIndex("photo_url")+` + 9)
+            FROM groups WHERE `+db.uploadsIndex("photo_url")+` > 0
+            UNION ALL
+            SELECT 'uploads/' || substr(photo_url, `+db.uploadsIndex("photo_url")+` + 9)
+            FROM avatar_history WHERE `+db.uploadsIndex("photo_url")+` > 0
+            UNION ALL
+            SELECT 'attachments/' || storage_key FROM attachments
+            UNION ALL
+            SELECT 'attachments/' || storage_key FROM uploads WHERE completed = 1
+        ) AS refs
+        GROUP BY path
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("error getting media references: %w", err)
+	}
+	defer rows.Close()
+
+	refs := make(map[string]int)
+	for rows.Next() {
+		var path string
+		var count int
+		if err := rows.Scan(&path, &count); err != nil {
+			return nil, fmt.Errorf("error scanning media reference: %w", err)
+		}
+		refs[path] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating media references: %w", err)
+	}
+	return refs, nil
+}
+
+// IsPublicImage tells whether an image, given by its path like "/uploads/images/<name>", is shown to anyone: the
+// photo of a group, or the current or a previous photo of a user who shows their photo to everyone. Other avatars and
+// the images sent in conversations are only served to who can see them, see CanAccessImage.
+func (db *appdbimpl) IsPublicImage(ctx context.Context, imagePath string) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var public bool
+	err := db.r.QueryRowContext(ctx, `
+        SELECT EXISTS(
+            SELECT 1 FROM users WHERE photo_visibility = ?2 AND `+db.uploadsIndex("photo_url")+` > 0
+                AND substr(photo_url, `+db.uploadsIndex("photo_url")+`) = ?1
+            UNION ALL
+            SELECT 1 FROM groups WHERE `+db.uploadsIndex("photo_url")+` > 0
+                AND substr(photo_url, `+db.uploadsIndex("photo_url")+`) = ?1
+            UNION ALL
+            SELECT 1 FROM avatar_history h
+            JOIN users u ON u.id = h.user_id
+            WHERE u.photo_visibility = ?2 AND `+db.uploadsIndex("h.photo_url")+` > 0
+                AND substr(h.photo_url, `+db.uploadsIndex("h.photo_url")+`) = ?1
+        )
+    `, imagePath, VisibleToEveryone).Scan(&public)
+	if err != nil {
+		return false, fmt.Errorf("error checking image: %w", err)
+	}
+	return public, nil
+}
+
+// CanAccessImage tells whether a user can see an image, given by its path like "/uploads/images/<name>": it was sent
+// to a conversation or group the user belongs to, or it is a current or previous photo of a user that the privacy
+// settings of its owner let them see
+func (db *appdbimpl) CanAccessImage(ctx context.Context, userID string, imagePath string) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	// The owner of the photo u sees it, and so do the contacts when it is visible to them
+	photoVisible := `(u.id = ?1 OR (u.photo_visibility = ?3 AND (
+                EXISTS(SELECT 1 FROM conversation_participants a
+                       JOIN conversation_participants b ON a.conversation_id = b.conversation_id
+                       WHERE a.user_id = ?1 AND b.user_id = u.id)
+                OR EXISTS(SELECT 1 FROM group_members a
+                          JOIN group_members b ON a.group_id = b.group_id
+                          WHERE a.user_id = ?1 AND b.user_id = u.id))))`
+
+	var allowed bool
+	err := db.r.QueryRowContext(ctx, `
+        SELECT EXISTS(
+            SELECT 1
+            FROM messages m
+            WHERE m.image_url = ?2
+              AND (EXISTS(SELECT 1 FROM conversation_participants p
+                          WHERE p.conversation_id = m.conversation_id AND p.user_id = ?1)
+                   OR EXISTS(SELECT 1 FROM group_members g WHERE g.group_id = m.conversation_id AND g.user_id = ?1))
+            UNION ALL
+            SELECT 1 FROM users u WHERE `+db.uploadsIndex("u.photo_url")+` > 0
+                AND substr(u.photo_url, `+db.uploadsIndex("u.photo_url")+`) = ?2 AND `+photoVisible+`
+            UNION ALL
+            SELECT 1 FROM avatar_history h
+            JOIN users u ON u.id = h.user_id
+            WHERE `+db.uploadsIndex("h.photo_url")+` > 0
+                AND substr(h.photo_url, `+db.uploadsIndex("h.photo_url")+`) = ?2 AND `+photoVisible+`
+        )
+    `, userID, imagePath, VisibleToContacts).Scan(&allowed)
+	if err != nil {
+		return false, fmt.Errorf("error checking image access: %w", err)
+	}
+	return allowed, nil
+}
+
+// uploadsIndex is the position of "/uploads/" in a URL column, 0 when the URL is not of an uploaded image
+func (db *appdbimpl) uploadsIndex(column string) string {
+	return db.dialect.position(column, "'/uploads/'")
+}
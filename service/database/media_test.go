@@ -0,0 +1,195 @@
+package database
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMediaReferences(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	c := db.(*appdbimpl).c
+
+	_, err := c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+
+	// Photos are saved as absolute URLs, image messages as paths: both point to the same file
+	if err := db.UpdateUserPhoto(ctx, "user1", "http://localhost:3000/uploads/images/photo.png"); err != nil {
+		t.Fatalf("error updating photo: %v", err)
+	}
+	group, err := db.CreateGroup(ctx, "friends", "user1", []string{"bob"})
+	if err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+	if err := db.UpdateGroupPhoto(ctx, group.ID, "user1", "http://localhost:3000/uploads/images/photo.png"); err != nil {
+		t.Fatalf("error updating group photo: %v", err)
+	}
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	imageMessageID, err := db.CreateImageMessage(ctx, conversationID, "user2", "/uploads/images/photo.png")
+	if err != nil {
+		t.Fatalf("error sending image: %v", err)
+	}
+
+	_, err = db.CreateAttachmentMessage(ctx, conversationID, "user1", "", []Attachment{
+		{ID: "att1", Filename: "a.txt", MIMEType: "text/plain", Size: 1, Checksum: "sha1", StorageKey: "sha1"},
+	})
+	if err != nil {
+		t.Fatalf("error sending attachment: %v", err)
+	}
+	upload := Upload{ID: "up1", UserID: "user2", Filename: "b.txt", MIMEType: "text/plain", Size: 1,
+		StorageKey: "partial"}
+	if err := db.CreateUpload(ctx, &upload, 100); err != nil {
+		t.Fatalf("error creating upload: %v", err)
+	}
+
+	refs, err := db.GetMediaReferences(ctx)
+	if err != nil {
+		t.Fatalf("error getting media references: %v", err)
+	}
+	// The user photo is referenced by the user and by their avatar history
+	if refs["uploads/images/photo.png"] != 4 || refs["attachments/sha1"] != 1 || len(refs) != 2 {
+		t.Errorf("expected photo.png referenced 4 times and sha1 once; got %v", refs)
+	}
+
+	// A finalized upload keeps its file until it is sent or expires
+	if err := db.SetUploadOffset(ctx, "up1", 1); err != nil {
+		t.Fatalf("error setting offset: %v", err)
+	}
+	if err := db.CompleteUpload(ctx, "up1", "text/plain", "sha2"); err != nil {
+		t.Fatalf("error completing upload: %v", err)
+	}
+	if err := db.DeleteMessage(ctx, imageMessageID); err != nil {
+		t.Fatalf("error deleting message: %v", err)
+	}
+	refs, err = db.GetMediaReferences(ctx)
+	if err != nil {
+		t.Fatalf("error getting media references: %v", err)
+	}
+	if refs["uploads/images/photo.png"] != 3 || refs["attachments/sha2"] != 1 {
+		t.Errorf("expected photo.png referenced 3 times and sha2 once; got %v", refs)
+	}
+}
+
+func TestAvatarHistoryIsPruned(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`INSERT INTO users (id, username, token) VALUES ('user1', 'alice', 'token1')`)
+	if err != nil {
+		t.Fatalf("error inserting test user: %v", err)
+	}
+
+	for i := 0; i < avatarHistoryLength+5; i++ {
+		photoURL := "/uploads/images/" + string(rune('a'+i)) + ".png"
+		if err := db.UpdateUserPhoto(ctx, "user1", photoURL); err != nil {
+			t.Fatalf("error updating photo: %v", err)
+		}
+	}
+
+	refs, err := db.GetMediaReferences(ctx)
+	if err != nil {
+		t.Fatalf("error getting media references: %v", err)
+	}
+	if refs["uploads/images/a.png"] != 0 {
+		t.Errorf("expected the oldest photo to be forgotten; got %v", refs)
+	}
+	last := "uploads/images/" + string(rune('a'+avatarHistoryLength+4)) + ".png"
+	if refs[last] != 2 || len(refs) != avatarHistoryLength {
+		t.Errorf("expected the last %d photos to be kept; got %v", avatarHistoryLength, refs)
+	}
+}
+
+func TestImageAccess(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2'),
+		('user3', 'carol', 'token3')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+
+	if err := db.UpdateUserPhoto(ctx, "user1", "http://localhost:3000/uploads/images/old.png"); err != nil {
+		t.Fatalf("error updating photo: %v", err)
+	}
+	if err := db.UpdateUserPhoto(ctx, "user1", "http://localhost:3000/uploads/images/avatar.png"); err != nil {
+		t.Fatalf("error updating photo: %v", err)
+	}
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	if _, err := db.CreateImageMessage(ctx, conversationID, "user1", "/uploads/images/chat.png"); err != nil {
+		t.Fatalf("error sending image: %v", err)
+	}
+	group, err := db.CreateGroup(ctx, "friends", "user1", []string{"carol"})
+	if err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+	if _, err := db.CreateImageMessage(ctx, group.ID, "user1", "/uploads/images/group.png"); err != nil {
+		t.Fatalf("error sending image to group: %v", err)
+	}
+
+	// bob shows his photo to contacts only, carol to nobody, and that covers their previous photos too
+	photos := []struct{ userID, photoURL, visibility string }{
+		{"user2", "http://localhost:3000/uploads/images/bob.png", VisibleToContacts},
+		{"user3", "http://localhost:3000/uploads/images/carol-old.png", VisibleToNobody},
+		{"user3", "http://localhost:3000/uploads/images/carol.png", VisibleToNobody},
+	}
+	for _, p := range photos {
+		if err := db.UpdateUserPhoto(ctx, p.userID, p.photoURL); err != nil {
+			t.Fatalf("error updating photo: %v", err)
+		}
+		if err := db.SetPrivacy(ctx, p.userID, p.visibility, VisibleToEveryone, VisibleToEveryone); err != nil {
+			t.Fatalf("error updating privacy: %v", err)
+		}
+	}
+
+	for path, expected := range map[string]bool{
+		"/uploads/images/avatar.png":    true,
+		"/uploads/images/old.png":       true,
+		"/uploads/images/bob.png":       false,
+		"/uploads/images/carol-old.png": false,
+		"/uploads/images/carol.png":     false,
+		"/uploads/images/chat.png":      false,
+		"/uploads/images/missing":       false,
+	} {
+		if public, err := db.IsPublicImage(ctx, path); err != nil || public != expected {
+			t.Errorf("expected %s public to be %v; got %v, %v", path, expected, public, err)
+		}
+	}
+
+	for _, c := range []struct {
+		userID  string
+		path    string
+		allowed bool
+	}{
+		{"user2", "/uploads/images/chat.png", true},
+		{"user3", "/uploads/images/chat.png", false},
+		{"user3", "/uploads/images/group.png", true},
+		{"user2", "/uploads/images/group.png", false},
+		{"user1", "/uploads/images/bob.png", true},
+		{"user3", "/uploads/images/bob.png", false},
+		{"user2", "/uploads/images/bob.png", true},
+		{"user1", "/uploads/images/carol.png", false},
+		{"user1", "/uploads/images/carol-old.png", false},
+		{"user3", "/uploads/images/carol-old.png", true},
+	} {
+		if allowed, err := db.CanAccessImage(ctx, c.userID, c.path); err != nil || allowed != c.allowed {
+			t.Errorf("expected access of %s to %s to be %v; got %v, %v", c.userID, c.path, c.allowed, allowed, err)
+		}
+	}
+}
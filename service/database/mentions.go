@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// mentionPattern matches "@username" tokens that are not part of a longer word (e.g. an e-mail address)
+var mentionPattern = regexp.MustCompile(`(?:^|[^a-zA-Z0-9_@-])@([a-zA-Z0-9_-]{3,16})(?:[^a-zA-Z0-9_@-]|$)`)
+
+// execer is implemented by *sql.DB, *sql.Conn and *sql.Tx, so helpers can run inside or outside a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// parseMentions returns the distinct usernames mentioned in content, in order of appearance
+func parseMentions(content string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+
+	// Matches consume the character after the username, so look for the next match starting from it
+	for start := 0; start < len(content); {
+		loc := mentionPattern.FindStringSubmatchIndex(content[start:])
+		if loc == nil {
+			break
+		}
+		username := content[start+loc[2] : start+loc[3]]
+		if !seen[username] {
+			seen[username] = true
+			usernames = append(usernames, username)
+		}
+		start += loc[3]
+	}
+	return usernames
+}
+
+// insertMentions stores the mentions of a new message. Only members of the conversation other than the sender can be
+// mentioned, any other "@word" stays plain text.
+func insertMentions(ctx context.Context, ex execer, conversationID string, messageID string, senderID string,
+	content string) ([]Mention, error) {
+	mentions := make([]Mention, 0)
+	for _, username := range parseMentions(content) {
+		var mention Mention
+		err := ex.QueryRowContext(ctx, `
+            SELECT u.id, u.username
+            FROM users u
+            WHERE u.username = ? AND u.id != ?
+              AND (
+                EXISTS(SELECT 1 FROM conversation_participants WHERE conversation_id = ? AND user_id = u.id)
+                OR EXISTS(SELECT 1 FROM group_members WHERE group_id = ? AND user_id = u.id)
+              )
+        `, username, senderID, conversationID, conversationID).Scan(&mention.UserID, &mention.Username)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error validating mention of %s: %w", username, err)
+		}
+
+		_, err = ex.ExecContext(ctx, `
+            INSERT INTO message_mentions (message_id, user_id, seen)
+            VALUES (?, ?, 0)
+            ON CONFLICT DO NOTHING
+        `, messageID, mention.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("error saving mention of %s: %w", username, err)
+		}
+		mentions = append(mentions, mention)
+	}
+	return mentions, nil
+}
+
+// getConversationMentions returns the mentions of every message in a conversation, keyed by message ID
+func (db *appdbimpl) getConversationMentions(ctx context.Context, conversationID string) (map[string][]Mention, error) {
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT mm.message_id, mm.user_id, u.username
+        FROM message_mentions mm
+        JOIN messages m ON m.id = mm.message_id
+        JOIN users u ON u.id = mm.user_id
+        WHERE m.conversation_id = ?
+    `, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting mentions: %w", err)
+	}
+	defer rows.Close()
+
+	mentions := make(map[string][]Mention)
+	for rows.Next() {
+		var messageID string
+		var mention Mention
+		if err := rows.Scan(&messageID, &mention.UserID, &mention.Username); err != nil {
+			return nil, fmt.Errorf("error scanning mention: %w", err)
+		}
+		mentions[messageID] = append(mentions[messageID], mention)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating mentions: %w", err)
+	}
+	return mentions, nil
+}
+
+// GetUnseenMentions returns the mentions of a user not seen yet, across all the conversations the user is still in
+func (db *appdbimpl) GetUnseenMentions(ctx context.Context, userID string) ([]MentionNotification, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT m.id, m.conversation_id, COALESCE(s.username, m.sender), COALESCE(m.content, ''),
+               `+db.dialect.formatTime("m.timestamp")+`
+        FROM message_mentions mm
+        JOIN messages m ON m.id = mm.message_id
+        LEFT JOIN users s ON s.id = m.sender
+        WHERE mm.user_id = ? AND mm.seen = 0
+          AND (
+            EXISTS(SELECT 1 FROM conversation_participants WHERE conversation_id = m.conversation_id AND user_id = ?)
+            OR EXISTS(SELECT 1 FROM group_members WHERE group_id = m.conversation_id AND user_id = ?)
+          )
+        ORDER BY m.timestamp DESC
+    `, userID, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting mentions: %w", err)
+	}
+	defer rows.Close()
+
+	notifications := make([]MentionNotification, 0)
+	for rows.Next() {
+		var n MentionNotification
+		var timestampStr string
+		if err := rows.Scan(&n.MessageID, &n.ConversationID, &n.Sender, &n.Content, &timestampStr); err != nil {
+			return nil, fmt.Errorf("error scanning mention: %w", err)
+		}
+		n.Timestamp, err = time.Parse("2006-01-02 15:04:05", timestampStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing timestamp: %w", err)
+		}
+		n.Content = truncatePreview(n.Content)
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating mentions: %w", err)
+	}
+	return notifications, nil
+}
+
+// MarkMentionsSeen marks every mention of a user in a conversation as seen
+func (db *appdbimpl) MarkMentionsSeen(ctx context.Context, conversationID string, userID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.c.ExecContext(ctx, `
+        UPDATE message_mentions
+        SET seen = 1
+        WHERE user_id = ? AND seen = 0
+          AND message_id IN (SELECT id FROM messages WHERE conversation_id = ?)
+    `, userID, conversationID)
+	if err != nil {
+		return fmt.Errorf("error marking mentions as seen: %w", err)
+	}
+	return nil
+}
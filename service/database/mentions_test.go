@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{
+			name:     "single mention",
+			content:  "hi @bob",
+			expected: []string{"bob"},
+		},
+		{
+			name:     "several mentions with punctuation",
+			content:  "@alice, @bob_1: lunch? cc @alice",
+			expected: []string{"alice", "bob_1"},
+		},
+		{
+			name:     "e-mail addresses are not mentions",
+			content:  "write to me@example.com or @a@b",
+			expected: nil,
+		},
+		{
+			name:     "too short",
+			content:  "@ab",
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseMentions(tt.content)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v; got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMentions(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2'),
+		('user3', 'carol', 'token3')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	// carol is not in the conversation, alice is the sender
+	messageID, err := db.CreateMessage(ctx, conversationID, "user1", "@bob @carol @alice look")
+	if err != nil {
+		t.Fatalf("error creating message: %v", err)
+	}
+
+	messages, err := db.GetConversationMessages(ctx, conversationID, "user1")
+	if err != nil {
+		t.Fatalf("error getting messages: %v", err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message; got %d", len(messages))
+	}
+	expected := []Mention{{UserID: "user2", Username: "bob"}}
+	if !reflect.DeepEqual(messages[0].Mentions, expected) {
+		t.Errorf("expected mentions %v; got %v", expected, messages[0].Mentions)
+	}
+
+	unseen, err := db.GetUnseenMentions(ctx, "user2")
+	if err != nil {
+		t.Fatalf("error getting unseen mentions: %v", err)
+	}
+	if len(unseen) != 1 || unseen[0].MessageID != messageID {
+		t.Fatalf("expected one unseen mention of %s; got %+v", messageID, unseen)
+	}
+
+	if err := db.MarkMentionsSeen(ctx, conversationID, "user2"); err != nil {
+		t.Fatalf("error marking mentions as seen: %v", err)
+	}
+	unseen, err = db.GetUnseenMentions(ctx, "user2")
+	if err != nil {
+		t.Fatalf("error getting unseen mentions: %v", err)
+	}
+	if len(unseen) != 0 {
+		t.Errorf("expected no unseen mentions; got %+v", unseen)
+	}
+}
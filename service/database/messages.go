@@ -1,23 +1,32 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 )
 
+// ErrMessageNotFound is returned for a message that does not exist or that the user cannot read
+var ErrMessageNotFound = errors.New("message not found")
+
 // GetMessageByID obtiene un mensaje específico por su ID
-func (db *appdbimpl) GetMessageByID(messageID string) (*Message, error) {
+func (db *appdbimpl) GetMessageByID(ctx context.Context, messageID string) (*Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var msg Message
-	err := db.c.QueryRow(`
-        SELECT id, conversation_id, sender, content, timestamp
-        FROM messages
-        WHERE id = ?
-    `, messageID).Scan(&msg.ID, &msg.ConversationID, &msg.Sender, &msg.Content, &msg.Time)
+	err := db.r.QueryRowContext(ctx, `
+        SELECT m.id, m.conversation_id, m.sender, COALESCE(u.username, m.sender), m.content, m.timestamp
+        FROM messages m
+        LEFT JOIN users u ON u.id = m.sender
+        WHERE m.id = ?
+    `, messageID).Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Sender, &msg.Content, &msg.Time)
 
 	if errors.Is(err, sql.ErrNoRows) {
-		return nil, errors.New("message not found")
+		return nil, ErrMessageNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("error getting message: %w", err)
@@ -26,130 +35,431 @@ func (db *appdbimpl) GetMessageByID(messageID string) (*Message, error) {
 	return &msg, nil
 }
 
-// DeleteMessage elimina un mensaje por su ID
-func (db *appdbimpl) DeleteMessage(messageID string) error {
-	// First verify the message exists and get sender
-	var messageOwner string
-	err := db.c.QueryRow(`
-        SELECT sender 
-        FROM messages 
-        WHERE id = $1
-    `, messageID).Scan(&messageOwner)
+// DeleteMessage elimina un mensaje por su ID, together with its reactions, pins, mentions and attachments
+func (db *appdbimpl) DeleteMessage(ctx context.Context, messageID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
+	tx, err := db.c.BeginTx(ctx, nil)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("message not found")
-		}
-		return fmt.Errorf("error checking message: %w", err)
+		return fmt.Errorf("error starting transaction: %w", err)
 	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
 
-	// Delete the message
-	result, err := db.c.Exec(`
-        DELETE FROM messages
-        WHERE id = $1
-    `, messageID)
-
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM messages WHERE id = ?)`, messageID).Scan(&exists)
 	if err != nil {
-		return fmt.Errorf("error deleting message: %w", err)
+		return fmt.Errorf("error checking message: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("message not found")
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil || rowsAffected == 0 {
-		return fmt.Errorf("message not found or already deleted")
+	if err := db.deleteMessages(ctx, tx, []string{messageID}); err != nil {
+		return err
 	}
 
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
 	return nil
 }
 
-// ForwardMessage reenvía un mensaje a otra conversación
-func (db *appdbimpl) ForwardMessage(messageID, newConversationID, senderID string) (*Message, error) {
-	// Get the original message with both content and image_url
+// Errors of forwarding messages
+var (
+	// ErrNotForwardable is returned when forwarding a system message
+	ErrNotForwardable = errors.New("system messages cannot be forwarded")
+
+	// ErrForwardRejected is returned when some target does not accept the message; the results tell which
+	ErrForwardRejected = errors.New("message cannot be forwarded to every target")
+)
+
+// ForwardMessage reenvía un mensaje a varias conversaciones y grupos, keeping its kind and recording where it was
+// first sent. The sender must be able to read the message and take part in every target. Either every target gets
+// the message or none does: when a target does not accept it, ErrForwardRejected is returned together with the results,
+// whose Err tells why.
+func (db *appdbimpl) ForwardMessage(ctx context.Context, messageID string, senderID string,
+	targetIDs []string) ([]ForwardResult, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
+
+	// Get the original message with both content and image_url, and where it was first sent
 	var originalMsg Message
-	err := db.c.QueryRow(`
-        SELECT content, image_url
+	var origin ForwardedFrom
+	err = tx.QueryRowContext(ctx, `
+        SELECT conversation_id, content, image_url, kind, COALESCE(forwarded_from_message, id),
+               COALESCE(forwarded_from_sender, sender), COALESCE(forwarded_from_conversation, conversation_id)
         FROM messages
         WHERE id = ?
-    `, messageID).Scan(&originalMsg.Content, &originalMsg.ImageURL)
-
+    `, messageID).Scan(&originalMsg.ConversationID, &originalMsg.Content, &originalMsg.ImageURL, &originalMsg.Kind,
+		&origin.MessageID, &origin.SenderID, &origin.ConversationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error getting original message: %w", err)
 	}
 
-	// Create new message
-	newMsg := Message{
-		ID:             generateUUID(),
-		ConversationID: newConversationID,
-		Sender:         senderID,
-		Content:        originalMsg.Content,
-		ImageURL:       originalMsg.ImageURL,
-		Time:           time.Now(),
+	// Who cannot read the message is not told it exists
+	_, canRead, err := membership(ctx, tx, originalMsg.ConversationID, senderID)
+	if err != nil && !errors.Is(err, ErrConversationNotFound) {
+		return nil, err
+	}
+	if !canRead {
+		return nil, ErrMessageNotFound
+	}
+	if originalMsg.Kind == MessageKindSystem {
+		return nil, ErrNotForwardable
+	}
+
+	var senderName string
+	if err := tx.QueryRowContext(ctx, "SELECT username FROM users WHERE id = ?", senderID).Scan(&senderName); err != nil {
+		return nil, fmt.Errorf("error getting sender username: %w", err)
 	}
+	if err := tx.QueryRowContext(ctx, `SELECT username, forward_visibility FROM users WHERE id = ?`, origin.SenderID).Scan(
+		&origin.Sender, &origin.Visibility); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("error getting original sender: %w", err)
+	}
+
+	now := time.Now()
+	results := make([]ForwardResult, 0, len(targetIDs))
+	seen := make(map[string]bool, len(targetIDs))
+	rejected := false
+	for _, targetID := range targetIDs {
+		if seen[targetID] {
+			continue
+		}
+		seen[targetID] = true
 
-	// Insert the forwarded message
-	_, err = db.c.Exec(`
-        INSERT INTO messages (id, conversation_id, sender, content, image_url, timestamp)
-        VALUES (?, ?, ?, ?, ?, ?)
-    `, newMsg.ID, newMsg.ConversationID, newMsg.Sender, newMsg.Content, newMsg.ImageURL, newMsg.Time)
+		forwardedFrom := origin
+		newMsg := Message{
+			ConversationID: targetID,
+			SenderID:       senderID,
+			Sender:         senderName,
+			Content:        originalMsg.Content,
+			ImageURL:       originalMsg.ImageURL,
+			Time:           now,
+			Kind:           originalMsg.Kind,
+			ForwardedFrom:  &forwardedFrom,
+		}
+		err := appendMessage(ctx, tx, &newMsg)
+		if errors.Is(err, ErrConversationNotFound) || errors.Is(err, ErrNotParticipant) || errors.Is(err, ErrBlocked) {
+			results = append(results, ForwardResult{ConversationID: targetID, Err: err})
+			rejected = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Nothing is kept once a target rejected the message
+		if !rejected {
+			if err := db.copyForwardedContent(ctx, tx, messageID, &newMsg); err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, ForwardResult{ConversationID: targetID, Message: &newMsg})
+	}
+	if rejected {
+		for i := range results {
+			results[i].Message = nil
+		}
+		return results, ErrForwardRejected
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return results, nil
+}
 
+// copyForwardedContent gives newMsg, a forwarded copy of the message messageID, the attachments, link preview and
+// poll of the original
+func (db *appdbimpl) copyForwardedContent(ctx context.Context, ex execer, messageID string, newMsg *Message) error {
+	// The copies share the stored files with the original
+	if err := copyAttachments(ctx, ex, messageID, newMsg.ID); err != nil {
+		return err
+	}
+	var err error
+	newMsg.Attachments, err = getMessageAttachments(ctx, ex, newMsg.ID)
 	if err != nil {
-		return nil, fmt.Errorf("error forwarding message: %w", err)
+		return err
+	}
+	if err := copyLinkPreview(ctx, ex, messageID, newMsg.ID); err != nil {
+		return err
 	}
+	// Forwarded polls are asked again, starting without votes
+	if newMsg.Kind == MessageKindPoll {
+		if err := db.copyPoll(ctx, ex, messageID, newMsg.ID); err != nil {
+			return err
+		}
+		polls, err := db.queryPolls(ctx, ex, "m.id = ?", newMsg.ID, newMsg.SenderID)
+		if err != nil {
+			return err
+		}
+		newMsg.Poll = polls[newMsg.ID]
+	}
+	return nil
+}
 
-	// Update conversation's last message
-	var lastMessage string
-	if newMsg.Content.Valid {
-		lastMessage = newMsg.Content.String
-	} else if newMsg.ImageURL.Valid {
-		lastMessage = "[Image]"
+// CreateReplyMessage sends content in reply to replyToID, returning ErrMessageNotInConversation when it is not a
+// message of the conversation
+func (db *appdbimpl) CreateReplyMessage(ctx context.Context, conversationID, senderID, content,
+	replyToID string) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("error starting transaction: %w", err)
 	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
 
-	_, err = db.c.Exec(`
-        UPDATE conversations 
-        SET last_message = ?, timestamp = ?
-        WHERE id = ?
-    `, lastMessage, newMsg.Time, newConversationID)
+	// Only messages of the same conversation can be replied to
+	var inConversation bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT EXISTS(SELECT 1 FROM messages WHERE id = ? AND conversation_id = ?)
+    `, replyToID, conversationID).Scan(&inConversation)
+	if err != nil {
+		return "", fmt.Errorf("error checking replied message: %w", err)
+	}
+	if !inConversation {
+		return "", ErrMessageNotInConversation
+	}
+
+	msg := Message{
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Content:        sql.NullString{String: content, Valid: true},
+		ReplyToID:      sql.NullString{String: replyToID, Valid: true},
+		Kind:           MessageKindText,
+	}
+	if err := appendMessage(ctx, tx, &msg); err != nil {
+		return "", fmt.Errorf("error creating reply message: %w", err)
+	}
+
+	if _, err := insertMentions(ctx, tx, conversationID, msg.ID, senderID, content); err != nil {
+		return "", err
+	}
 
+	if err = tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing transaction: %w", err)
+	}
+	return msg.ID, nil
+}
+
+func (db *appdbimpl) CreateImageMessage(ctx context.Context, conversationID, senderID, imageURL string) (string,
+	error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.c.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error updating conversation: %w", err)
+		return "", fmt.Errorf("error starting transaction: %w", err)
 	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
 
-	// Set the string fields for JSON
-	if newMsg.Content.Valid {
-		newMsg.ContentStr = newMsg.Content.String
+	msg := Message{
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		ImageURL:       sql.NullString{String: imageURL, Valid: true},
+		Kind:           MessageKindImage,
+	}
+	if err := appendMessage(ctx, tx, &msg); err != nil {
+		return "", fmt.Errorf("error creating image message: %w", err)
 	}
-	if newMsg.ImageURL.Valid {
-		newMsg.ImageURLStr = newMsg.ImageURL.String
+
+	if err = tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing transaction: %w", err)
 	}
+	return msg.ID, nil
+}
 
-	return &newMsg, nil
+// insertSystemMessage posts a system message telling event in a conversation, sent by the user who caused it
+func insertSystemMessage(ctx context.Context, ex execer, conversationID string, actorID string, event string,
+	content string, at time.Time) error {
+	msg := Message{
+		ConversationID: conversationID,
+		SenderID:       actorID,
+		Content:        sql.NullString{String: content, Valid: true},
+		Time:           at,
+		Kind:           MessageKindSystem,
+		SystemEvent:    event,
+	}
+	if err := appendMessage(ctx, ex, &msg); err != nil {
+		return fmt.Errorf("error creating system message: %w", err)
+	}
+	return nil
 }
 
-func (db *appdbimpl) CreateReplyMessage(conversationID, sender, content, replyToID string) (string, error) {
-	messageID := generateUUID()
+// Errors of appending messages
+var (
+	// ErrConversationNotFound is returned for a conversation or group that does not exist
+	ErrConversationNotFound = errors.New("conversation not found")
 
-	_, err := db.c.Exec(`
-        INSERT INTO messages (id, conversation_id, sender, content, reply_to_id, timestamp)
-        VALUES (?, ?, ?, ?, ?, datetime('now'))
-    `, messageID, conversationID, sender, content, replyToID)
+	// ErrNotParticipant is returned when the sender is not part of the conversation or group
+	ErrNotParticipant = errors.New("not a participant of the conversation")
+)
 
+// appendMessage adds msg at the end of its conversation or group and makes it the preview shown in the list of
+// conversations. Every message is created through it, within the transaction ex of the caller, so that the message
+// and the preview never disagree. The sender must take part in the conversation and, unless msg is a system message,
+// not be blocked there. The ID, sender username and time of msg are filled in when missing, and so are its string
+// fields.
+func appendMessage(ctx context.Context, ex execer, msg *Message) error {
+	isGroup, isMember, err := membership(ctx, ex, msg.ConversationID, msg.SenderID)
 	if err != nil {
-		return "", fmt.Errorf("error creating reply message: %w", err)
+		return err
+	}
+	if !isMember {
+		return ErrNotParticipant
+	}
+	if msg.Kind != MessageKindSystem {
+		if err := checkSenderNotBlocked(ctx, ex, msg.ConversationID, msg.SenderID); err != nil {
+			return err
+		}
 	}
 
-	return messageID, nil
-}
+	if msg.ID == "" {
+		msg.ID = generateUUID()
+	}
+	if msg.Kind == "" {
+		msg.Kind = MessageKindText
+	}
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	if msg.Sender == "" {
+		if err := ex.QueryRowContext(ctx, "SELECT username FROM users WHERE id = ?",
+			msg.SenderID).Scan(&msg.Sender); err != nil {
+			return fmt.Errorf("error getting sender username: %w", err)
+		}
+	}
+	var origin ForwardedFrom
+	if msg.ForwardedFrom != nil {
+		origin = *msg.ForwardedFrom
+	}
 
-func (db *appdbimpl) CreateImageMessage(conversationID, sender, imageURL string) (string, error) {
-	messageID := generateUUID()
+	_, err = ex.ExecContext(ctx, `
+        INSERT INTO messages (id, conversation_id, sender, content, image_url, reply_to_id, timestamp, kind,
+                              system_event, forwarded_from_message, forwarded_from_sender, forwarded_from_conversation)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''))
+    `, msg.ID, msg.ConversationID, msg.SenderID, msg.Content, msg.ImageURL, msg.ReplyToID, msg.Time, msg.Kind,
+		msg.SystemEvent, origin.MessageID, origin.SenderID, origin.ConversationID)
+	if err != nil {
+		return fmt.Errorf("error inserting message: %w", err)
+	}
 
-	_, err := db.c.Exec(`
-        INSERT INTO messages (id, conversation_id, sender, image_url, timestamp)
-        VALUES (?, ?, ?, ?, datetime('now'))
-    `, messageID, conversationID, sender, imageURL)
+	table := "conversations"
+	if isGroup {
+		table = "groups"
+	}
+	_, err = ex.ExecContext(ctx, `
+        UPDATE `+table+`
+        SET last_message = ?, last_message_is_reply = ?, timestamp = ?
+        WHERE id = ?
+    `, messagePreview(msg), msg.ReplyToID.Valid, msg.Time, msg.ConversationID)
+	if err != nil {
+		return fmt.Errorf("error updating conversation preview: %w", err)
+	}
 
+	msg.ContentStr = msg.Content.String
+	msg.ImageURLStr = msg.ImageURL.String
+	msg.ReplyToIDStr = msg.ReplyToID.String
+	return nil
+}
+
+// membership tells whether conversationID is a group and whether userID takes part in it. It returns
+// ErrConversationNotFound when there is no such conversation or group.
+func membership(ctx context.Context, ex execer, conversationID string, userID string) (bool, bool, error) {
+	var isConversation, isGroup, isMember bool
+	err := ex.QueryRowContext(ctx, `
+        SELECT
+            EXISTS(SELECT 1 FROM conversations WHERE id = ?),
+            EXISTS(SELECT 1 FROM groups WHERE id = ?),
+            EXISTS(SELECT 1 FROM conversation_participants WHERE conversation_id = ? AND user_id = ?)
+            OR EXISTS(SELECT 1 FROM group_members WHERE group_id = ? AND user_id = ?)
+    `, conversationID, conversationID, conversationID, userID, conversationID, userID).Scan(
+		&isConversation, &isGroup, &isMember)
 	if err != nil {
-		return "", fmt.Errorf("error creating image message: %w", err)
+		return false, false, fmt.Errorf("error checking conversation participant: %w", err)
+	}
+	if !isConversation && !isGroup {
+		return false, false, ErrConversationNotFound
+	}
+	return isGroup, isMember, nil
+}
+
+// messagePreview is how msg is shown as the last message of its conversation
+func messagePreview(msg *Message) string {
+	switch {
+	case msg.Kind == MessageKindPoll:
+		return PollPreviewPrefix + msg.Content.String
+	case msg.Content.Valid:
+		return msg.Content.String
+	case msg.Kind == MessageKindImage:
+		return "[Image]"
+	case msg.Kind == MessageKindAttachment:
+		return "[Attachment]"
+	default:
+		return ""
 	}
+}
+
+// refreshPreviewQuery sets the preview of the rows of table, conversations or groups, to their newest message, as
+// messagePreview would. Without messages the preview is emptied and the time is kept.
+func refreshPreviewQuery(d dialect, table string) string {
+	return fmt.Sprintf(`
+        UPDATE %[1]s
+        SET (last_message, last_message_is_reply, timestamp) = (
+            SELECT CASE
+                       WHEN m.kind = 'poll' THEN '`+PollPreviewPrefix+`' || m.content
+                       WHEN m.content IS NOT NULL THEN m.content
+                       WHEN m.kind = 'image' THEN '[Image]'
+                       WHEN m.kind = 'attachment' THEN '[Attachment]'
+                       ELSE ''
+                   END,
+                   CASE WHEN m.reply_to_id IS NOT NULL THEN 1 ELSE 0 END,
+                   COALESCE(m.timestamp, %[1]s.timestamp)
+            FROM (SELECT 1) AS one
+            LEFT JOIN messages m ON m.id = (
+                SELECT id FROM messages
+                WHERE conversation_id = %[1]s.id
+                ORDER BY %[2]s DESC, rowid DESC
+                LIMIT 1
+            )
+        )`, table, d.timeValue("timestamp"))
+}
 
-	return messageID, nil
+// refreshPreview recomputes the preview of a conversation or group from its newest message, after some of its
+// messages were removed
+func (db *appdbimpl) refreshPreview(ctx context.Context, ex execer, conversationID string) error {
+	for _, table := range []string{"conversations", "groups"} {
+		if _, err := ex.ExecContext(ctx, refreshPreviewQuery(db.dialect, table)+" WHERE id = ?",
+			conversationID); err != nil {
+			return fmt.Errorf("error refreshing conversation preview: %w", err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,445 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMessageKinds(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	textID, err := db.CreateMessage(ctx, conversationID, "user1", "hi")
+	if err != nil {
+		t.Fatalf("error creating message: %v", err)
+	}
+	sent, err := db.SendMessage(ctx, conversationID, "user2", "hey")
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	imageID, err := db.CreateImageMessage(ctx, conversationID, "user1", "/uploads/images/cat.png")
+	if err != nil {
+		t.Fatalf("error creating image message: %v", err)
+	}
+	replyID, err := db.CreateReplyMessage(ctx, conversationID, "user2", "nice", textID)
+	if err != nil {
+		t.Fatalf("error creating reply: %v", err)
+	}
+
+	messages, err := db.GetConversationMessages(ctx, conversationID, "user1")
+	if err != nil {
+		t.Fatalf("error getting messages: %v", err)
+	}
+	want := map[string]string{
+		textID:  MessageKindText,
+		sent.ID: MessageKindText,
+		imageID: MessageKindImage,
+		replyID: MessageKindText,
+	}
+	if len(messages) != len(want) {
+		t.Fatalf("expected %d messages; got %d", len(want), len(messages))
+	}
+	for _, m := range messages {
+		if m.Kind != want[m.ID] || m.ForwardedFrom != nil {
+			t.Errorf("expected message %s of kind %s, not forwarded; got %+v", m.ID, want[m.ID], m)
+		}
+	}
+}
+
+func TestGroupSystemMessages(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	group, err := db.CreateGroup(ctx, "Office", "user1", []string{"bob"})
+	if err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+	if err := db.UpdateGroupName(ctx, group.ID, "user2", "Team"); err != nil {
+		t.Fatalf("error renaming group: %v", err)
+	}
+	if err := db.UpdateGroupPhoto(ctx, group.ID, "user1", "/uploads/images/team.png"); err != nil {
+		t.Fatalf("error changing group photo: %v", err)
+	}
+	if err := db.LeaveGroup(ctx, group.ID, "user2"); err != nil {
+		t.Fatalf("error leaving group: %v", err)
+	}
+
+	messages, err := db.GetConversationMessages(ctx, group.ID, "user1")
+	if err != nil {
+		t.Fatalf("error getting messages: %v", err)
+	}
+	want := []struct{ event, sender, content string }{
+		{SystemEventGroupCreated, "alice", `alice created the group "Office"`},
+		{SystemEventMemberJoined, "alice", "alice added bob"},
+		{SystemEventGroupRenamed, "bob", `bob renamed the group to "Team"`},
+		{SystemEventGroupPhotoChanged, "alice", "alice changed the group photo"},
+		{SystemEventMemberLeft, "bob", "bob left the group"},
+	}
+	if len(messages) != len(want) {
+		t.Fatalf("expected %d system messages; got %+v", len(want), messages)
+	}
+	for i, w := range want {
+		m := messages[i]
+		if m.Kind != MessageKindSystem || m.SystemEvent != w.event || m.Sender != w.sender || m.ContentStr != w.content {
+			t.Errorf("expected %s by %s (%q); got %s/%s by %s (%q)", w.event, w.sender, w.content, m.Kind,
+				m.SystemEvent, m.Sender, m.ContentStr)
+		}
+	}
+
+	if _, err := forwardTo(db, messages[0].ID, "user1", group.ID); !errors.Is(err, ErrNotForwardable) {
+		t.Errorf("expected ErrNotForwardable forwarding a system message; got %v", err)
+	}
+}
+
+func TestForwardedFrom(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2'),
+		('user3', 'carol', 'token3')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	if err := db.SetPrivacy(ctx, "user1", VisibleToEveryone, VisibleToEveryone, VisibleToNobody); err != nil {
+		t.Fatalf("error setting privacy: %v", err)
+	}
+	first, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	second, err := db.CreateConversation(ctx, []string{"bob", "carol"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	imageID, err := db.CreateImageMessage(ctx, first, "user1", "/uploads/images/cat.png")
+	if err != nil {
+		t.Fatalf("error creating image message: %v", err)
+	}
+	forwarded, err := forwardTo(db, imageID, "user2", second)
+	if err != nil {
+		t.Fatalf("error forwarding message: %v", err)
+	}
+	origin := forwarded.ForwardedFrom
+	if forwarded.Kind != MessageKindImage || origin == nil || origin.MessageID != imageID ||
+		origin.ConversationID != first || origin.SenderID != "user1" || origin.Sender != "alice" ||
+		origin.Visibility != VisibleToNobody {
+		t.Fatalf("expected an image forwarded from alice in the first conversation; got %+v, %+v", forwarded, origin)
+	}
+
+	// Forwarding a forward keeps pointing at where the message was first sent
+	again, err := forwardTo(db, forwarded.ID, "user2", first)
+	if err != nil {
+		t.Fatalf("error forwarding again: %v", err)
+	}
+	if again.ForwardedFrom == nil || again.ForwardedFrom.MessageID != imageID || again.ForwardedFrom.SenderID != "user1" {
+		t.Errorf("expected the first origin to be kept; got %+v", again.ForwardedFrom)
+	}
+
+	messages, err := db.GetConversationMessages(ctx, second, "user3")
+	if err != nil {
+		t.Fatalf("error getting messages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].ForwardedFrom == nil || *messages[0].ForwardedFrom != *origin {
+		t.Errorf("expected the stored origin %+v; got %+v", origin, messages)
+	}
+}
+
+func TestForwardToManyTargets(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2'),
+		('user3', 'carol', 'token3')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	direct, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	others, err := db.CreateConversation(ctx, []string{"bob", "carol"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	group, err := db.CreateGroup(ctx, "Office", "user1", []string{"bob", "carol"})
+	if err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+	messageID, err := db.CreateMessage(ctx, direct, "user1", "hi")
+	if err != nil {
+		t.Fatalf("error creating message: %v", err)
+	}
+
+	countMessages := func(conversationID string) int {
+		var n int
+		err := db.(*appdbimpl).c.QueryRow(`SELECT COUNT(*) FROM messages WHERE conversation_id = ?`,
+			conversationID).Scan(&n)
+		if err != nil {
+			t.Fatalf("error counting messages: %v", err)
+		}
+		return n
+	}
+	before := countMessages(group.ID)
+
+	// A target alice is not part of rejects the whole forward
+	results, err := db.ForwardMessage(ctx, messageID, "user1", []string{group.ID, others})
+	if !errors.Is(err, ErrForwardRejected) || len(results) != 2 || results[0].Err != nil ||
+		!errors.Is(results[1].Err, ErrNotParticipant) {
+		t.Fatalf("expected the forward rejected by the second target; got %+v, %v", results, err)
+	}
+	if countMessages(group.ID) != before {
+		t.Errorf("expected nothing forwarded when a target rejects the message")
+	}
+
+	// Repeated targets get a single copy
+	results, err = db.ForwardMessage(ctx, messageID, "user1", []string{group.ID, direct, group.ID})
+	if err != nil {
+		t.Fatalf("error forwarding message: %v", err)
+	}
+	if len(results) != 2 || results[0].Message == nil || results[1].Message == nil ||
+		results[0].Message.ConversationID != group.ID || results[1].Message.ConversationID != direct {
+		t.Fatalf("expected a copy in the group and one in the conversation; got %+v", results)
+	}
+	if countMessages(group.ID) != before+1 || countMessages(direct) != 2 {
+		t.Errorf("expected one copy per target")
+	}
+	var groupRows int
+	err = db.(*appdbimpl).c.QueryRow(`SELECT COUNT(*) FROM conversations WHERE id = ?`, group.ID).Scan(&groupRows)
+	if err != nil || groupRows != 0 {
+		t.Errorf("expected groups to stay out of conversations; got %d rows, %v", groupRows, err)
+	}
+
+	// Who cannot read the message is not told it exists
+	if _, err := db.ForwardMessage(ctx, messageID, "user3", []string{group.ID}); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound forwarding an unreadable message; got %v", err)
+	}
+	if _, err := db.ForwardMessage(ctx, "missing", "user1", []string{group.ID}); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound forwarding a missing message; got %v", err)
+	}
+}
+
+func TestConversationPreviews(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2'),
+		('user3', 'carol', 'token3')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	direct, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	group, err := db.CreateGroup(ctx, "Office", "user1", []string{"bob"})
+	if err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+
+	// checkPreview asserts that the preview of the conversation shows its newest message
+	checkPreview := func(conversationID string, want string, isReply bool) {
+		t.Helper()
+		conversations, _, err := db.GetUserConversations(ctx, "user2", 50, "")
+		if err != nil {
+			t.Fatalf("error getting conversations: %v", err)
+		}
+		messages, err := db.GetConversationMessages(ctx, conversationID, "user2")
+		if err != nil {
+			t.Fatalf("error getting messages: %v", err)
+		}
+		newest := messages[len(messages)-1]
+		for _, c := range conversations {
+			if c.ID != conversationID {
+				continue
+			}
+			if c.LastMessage != want || c.LastMessageIsReply != isReply || !c.Timestamp.Equal(newest.Time) {
+				t.Errorf("expected preview %q (reply %v) at %v; got %q (reply %v) at %v", want, isReply,
+					newest.Time, c.LastMessage, c.LastMessageIsReply, c.Timestamp)
+			}
+			return
+		}
+		t.Errorf("conversation %s not listed", conversationID)
+	}
+	checkPreview(group.ID, "alice added bob", false)
+
+	textID, err := db.CreateMessage(ctx, direct, "user1", "hi")
+	if err != nil {
+		t.Fatalf("error creating message: %v", err)
+	}
+	checkPreview(direct, "hi", false)
+	if _, err := db.CreateImageMessage(ctx, direct, "user2", "/uploads/images/cat.png"); err != nil {
+		t.Fatalf("error creating image message: %v", err)
+	}
+	checkPreview(direct, "[Image]", false)
+	replyID, err := db.CreateReplyMessage(ctx, direct, "user2", "nice", textID)
+	if err != nil {
+		t.Fatalf("error creating reply: %v", err)
+	}
+	checkPreview(direct, "nice", true)
+
+	// Removing the newest message brings back the one before
+	if err := db.DeleteMessage(ctx, replyID); err != nil {
+		t.Fatalf("error deleting message: %v", err)
+	}
+	checkPreview(direct, "[Image]", false)
+
+	if _, err := db.SendMessage(ctx, group.ID, "user2", "hello"); err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	checkPreview(group.ID, "hello", false)
+	poll := Poll{Question: "Lunch?", Options: []PollOption{{Text: "Pizza"}, {Text: "Sushi"}}}
+	if _, err := db.CreatePoll(ctx, group.ID, "user1", poll); err != nil {
+		t.Fatalf("error creating poll: %v", err)
+	}
+	checkPreview(group.ID, "📊 Lunch?", false)
+	_, err = db.CreateAttachmentMessage(ctx, group.ID, "user1", "", []Attachment{
+		{ID: "att1", Filename: "notes.pdf", MIMEType: "application/pdf", Size: 1024, Checksum: "aa", StorageKey: "key1"},
+	})
+	if err != nil {
+		t.Fatalf("error creating attachment message: %v", err)
+	}
+	checkPreview(group.ID, "[Attachment]", false)
+
+	// Only participants can append, and nothing is left behind when they cannot
+	if _, err := db.SendMessage(ctx, direct, "user3", "let me in"); !errors.Is(err, ErrNotParticipant) {
+		t.Errorf("expected ErrNotParticipant; got %v", err)
+	}
+	if _, err := db.CreateMessage(ctx, "missing", "user1", "hi"); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("expected ErrConversationNotFound; got %v", err)
+	}
+	var stray int
+	err = db.(*appdbimpl).c.QueryRow(`
+		SELECT COUNT(*) FROM messages WHERE sender = 'user3' OR conversation_id = 'missing'
+	`).Scan(&stray)
+	if err != nil || stray != 0 {
+		t.Errorf("expected no message from rejected appends; got %d, %v", stray, err)
+	}
+	checkPreview(direct, "[Image]", false)
+}
+
+// forwardTo forwards a message to a single target, returning why the target rejected it if it did
+func forwardTo(db AppDatabase, messageID string, senderID string, targetID string) (*Message, error) {
+	ctx := context.Background()
+	results, err := db.ForwardMessage(ctx, messageID, senderID, []string{targetID})
+	if errors.Is(err, ErrForwardRejected) {
+		return nil, results[0].Err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results[0].Message, nil
+}
+
+func TestCheckParticipant(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2'),
+		('user3', 'carol', 'token3')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	if err := db.CheckParticipant(ctx, conversationID, "user1"); err != nil {
+		t.Errorf("expected alice to take part; got %v", err)
+	}
+	if err := db.CheckParticipant(ctx, conversationID, "user3"); !errors.Is(err, ErrNotParticipant) {
+		t.Errorf("expected carol not to take part; got %v", err)
+	}
+	if err := db.CheckParticipant(ctx, "missing", "user1"); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("expected the conversation not found; got %v", err)
+	}
+}
+
+func TestReplyToOtherConversation(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2'),
+		('user3', 'carol', 'token3')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	otherID, err := db.CreateConversation(ctx, []string{"alice", "carol"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	other, err := db.CreateMessage(ctx, otherID, "user1", "between alice and carol")
+	if err != nil {
+		t.Fatalf("error creating message: %v", err)
+	}
+
+	// Replies only quote messages of their own conversation, and a refused reply leaves nothing behind
+	for _, replyToID := range []string{other, "missing"} {
+		_, err := db.CreateReplyMessage(ctx, conversationID, "user1", "hi", replyToID)
+		if !errors.Is(err, ErrMessageNotInConversation) {
+			t.Errorf("expected replying to %s refused; got %v", replyToID, err)
+		}
+	}
+	messages, err := db.GetConversationMessages(ctx, conversationID, "user1")
+	if err != nil {
+		t.Fatalf("error getting messages: %v", err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("expected no messages; got %+v", messages)
+	}
+
+	hello, err := db.CreateMessage(ctx, conversationID, "user2", "hello")
+	if err != nil {
+		t.Fatalf("error creating message: %v", err)
+	}
+	if _, err := db.CreateReplyMessage(ctx, conversationID, "user1", "hi", hello); err != nil {
+		t.Errorf("unexpected error replying: %v", err)
+	}
+}
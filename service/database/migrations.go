@@ -0,0 +1,359 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migrator is what the schema is set up and migrated on
+type migrator interface {
+	execer
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// withoutForeignKeys runs fn on a connection of db with foreign keys off, turning them back on afterwards since the
+// connection returns to the pool. Migrations rewrite keys and rebuild tables, which enforced foreign keys would refuse
+// or cascade from, and the pragma cannot change inside a transaction.
+func withoutForeignKeys(ctx context.Context, db *sql.DB, fn func(ctx context.Context, m migrator) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting a connection: %w", err)
+	}
+	defer conn.Close()
+
+	var enforced bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&enforced); err != nil {
+		return fmt.Errorf("error reading foreign keys enforcement: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return fmt.Errorf("error turning foreign keys off: %w", err)
+	}
+	if err := fn(ctx, conn); err != nil {
+		return err
+	}
+	if enforced {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = ON"); err != nil {
+			return fmt.Errorf("error turning foreign keys on: %w", err)
+		}
+	}
+	return nil
+}
+
+// userReferences lists every column holding a user ID, so that legacy IDs can be replaced everywhere
+var userReferences = []struct {
+	table  string
+	column string
+}{
+	{"messages", "sender"},
+	{"conversation_participants", "user_id"},
+	{"reactions", "user_id"},
+	{"group_members", "user_id"},
+	{"pinned_messages", "pinned_by"},
+	{"message_mentions", "user_id"},
+	{"blocks", "blocker_id"},
+	{"blocks", "blocked_id"},
+	{"avatar_history", "user_id"},
+	{"conversation_mutes", "user_id"},
+	{"attachments", "uploaded_by"},
+	{"uploads", "user_id"},
+	{"messages", "forwarded_from_sender"},
+}
+
+// migrateUserIDs upgrades databases created when the username was used as users.id and messages.sender stored the
+// sender username, rewritten on every rename. Senders are mapped to user IDs, users get a new opaque ID and the
+// messages table is rebuilt so that sender references users(id). That foreign key tells migrated databases, where
+// only the other foreign keys of messages are checked.
+func migrateUserIDs(ctx context.Context, db migrator) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting migration: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back migration: %v", err)
+		}
+	}()
+
+	var migrated bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT EXISTS(
+            SELECT 1 FROM pragma_foreign_key_list('messages') WHERE "from" = 'sender' AND "table" = 'users'
+        )
+    `).Scan(&migrated)
+	if err != nil {
+		return fmt.Errorf("error reading foreign keys of messages: %w", err)
+	}
+	if !migrated {
+		if err := migrateLegacyUserIDs(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	if err := migrateMessagesTable(ctx, tx); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing migration: %w", err)
+	}
+	return nil
+}
+
+// migrateLegacyUserIDs maps message senders to user IDs and gives the users still identified by their username a new
+// opaque ID
+func migrateLegacyUserIDs(ctx context.Context, tx *sql.Tx) error {
+	// Old renames kept messages.sender equal to the current username, so usernames are the reliable key here
+	_, err := tx.ExecContext(ctx, `
+        UPDATE messages
+        SET sender = (SELECT id FROM users WHERE username = messages.sender)
+        WHERE sender IN (SELECT username FROM users)
+    `)
+	if err != nil {
+		return fmt.Errorf("error mapping message senders to user IDs: %w", err)
+	}
+
+	// Legacy IDs are usernames, which are at most 16 characters, while generated IDs are 36 character UUIDs
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM users WHERE length(id) <= 16")
+	if err != nil {
+		return fmt.Errorf("error finding legacy user IDs: %w", err)
+	}
+	var legacyIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("error scanning legacy user ID: %w", err)
+		}
+		legacyIDs = append(legacyIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return fmt.Errorf("error iterating legacy user IDs: %w", err)
+	}
+	_ = rows.Close()
+
+	for _, oldID := range legacyIDs {
+		newID := generateUUID()
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET id = ? WHERE id = ?", newID, oldID); err != nil {
+			return fmt.Errorf("error replacing user ID %s: %w", oldID, err)
+		}
+		for _, ref := range userReferences {
+			query := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", ref.table, ref.column, ref.column)
+			if _, err := tx.ExecContext(ctx, query, newID, oldID); err != nil {
+				return fmt.Errorf("error replacing user ID in %s.%s: %w", ref.table, ref.column, err)
+			}
+		}
+	}
+	if len(legacyIDs) > 0 {
+		log.Printf("Migrated %d users to opaque IDs", len(legacyIDs))
+	}
+	return nil
+}
+
+// migrateMessagesTable rebuilds the messages table when its foreign keys are not the current ones: sender must
+// reference users(id), a deleted message must detach its replies, and conversation_id must not reference
+// conversations(id), since messages of groups belong to no conversation. SQLite cannot change the foreign keys of an
+// existing table, so the rows are copied into a new table that replaces the old one.
+func migrateMessagesTable(ctx context.Context, tx *sql.Tx) error {
+	var current bool
+	err := tx.QueryRowContext(ctx, `
+        SELECT EXISTS(
+            SELECT 1 FROM pragma_foreign_key_list('messages') WHERE "from" = 'sender' AND "table" = 'users'
+        ) AND EXISTS(
+            SELECT 1 FROM pragma_foreign_key_list('messages') WHERE "from" = 'reply_to_id' AND on_delete = 'SET NULL'
+        ) AND NOT EXISTS(
+            SELECT 1 FROM pragma_foreign_key_list('messages') WHERE "table" = 'conversations'
+        )
+    `).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("error reading foreign keys of messages: %w", err)
+	}
+	if current {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	CREATE TABLE messages_new (
+		id TEXT PRIMARY KEY,
+		conversation_id TEXT,
+		sender TEXT REFERENCES users(id),
+		content TEXT,
+		timestamp DATETIME,
+		reply_to_id TEXT REFERENCES messages(id) ON DELETE SET NULL,
+		image_url TEXT,
+		kind TEXT NOT NULL DEFAULT 'text',
+		system_event TEXT,
+		forwarded_from_message TEXT,
+		forwarded_from_sender TEXT REFERENCES users(id),
+		forwarded_from_conversation TEXT
+	);
+
+	INSERT INTO messages_new (id, conversation_id, sender, content, timestamp, reply_to_id, image_url, kind,
+	                          system_event, forwarded_from_message, forwarded_from_sender, forwarded_from_conversation)
+	SELECT id, conversation_id, sender, content, timestamp, reply_to_id, image_url, kind,
+	       system_event, forwarded_from_message, forwarded_from_sender, forwarded_from_conversation
+	FROM messages;
+
+	DROP TABLE messages;
+
+	ALTER TABLE messages_new RENAME TO messages;
+	CREATE INDEX IF NOT EXISTS messages_conversation ON messages (conversation_id, timestamp);`)
+	if err != nil {
+		return fmt.Errorf("error rebuilding the messages table: %w", err)
+	}
+	return nil
+}
+
+// migrateWebhooksTable rebuilds the webhooks table of databases where conversation_id referenced conversations(id),
+// which groups are not part of
+func migrateWebhooksTable(ctx context.Context, db migrator) error {
+	var referencesConversations bool
+	err := db.QueryRowContext(ctx, `
+        SELECT EXISTS(SELECT 1 FROM pragma_foreign_key_list('webhooks') WHERE "table" = 'conversations')
+    `).Scan(&referencesConversations)
+	if err != nil {
+		return fmt.Errorf("error reading foreign keys of webhooks: %w", err)
+	}
+	if !referencesConversations {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting migration: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back migration: %v", err)
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, `
+	CREATE TABLE webhooks_new (
+		id TEXT PRIMARY KEY,
+		conversation_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		created_by TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (created_by) REFERENCES users(id)
+	);
+
+	INSERT INTO webhooks_new (id, conversation_id, url, secret, events, created_by, created_at)
+	SELECT id, conversation_id, url, secret, events, created_by, created_at FROM webhooks;
+
+	DROP TABLE webhooks;
+
+	ALTER TABLE webhooks_new RENAME TO webhooks;
+	CREATE INDEX IF NOT EXISTS webhooks_conversation ON webhooks (conversation_id);`)
+	if err != nil {
+		return fmt.Errorf("error rebuilding the webhooks table: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing migration: %w", err)
+	}
+	return nil
+}
+
+// migrateReactionsKey rebuilds the reactions table of databases where the primary key was (message_id, user_id),
+// which allowed a single reaction per user, and the reaction length was checked in bytes
+func migrateReactionsKey(ctx context.Context, db migrator) error {
+	var multiReaction bool
+	err := db.QueryRowContext(ctx, `
+        SELECT EXISTS(SELECT 1 FROM pragma_table_info('reactions') WHERE name = 'reaction' AND pk > 0)
+    `).Scan(&multiReaction)
+	if err != nil {
+		return fmt.Errorf("error reading the reactions primary key: %w", err)
+	}
+	if multiReaction {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting migration: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back migration: %v", err)
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, `
+	CREATE TABLE reactions_new (
+		message_id TEXT,
+		user_id TEXT,
+		reaction TEXT NOT NULL,
+		PRIMARY KEY (message_id, user_id, reaction),
+		FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	INSERT INTO reactions_new (message_id, user_id, reaction)
+	SELECT message_id, user_id, reaction FROM reactions WHERE reaction IS NOT NULL;
+
+	DROP TABLE reactions;
+
+	ALTER TABLE reactions_new RENAME TO reactions;`)
+	if err != nil {
+		return fmt.Errorf("error migrating the reactions primary key: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing migration: %w", err)
+	}
+	return nil
+}
+
+// migrateMessageKinds sets the kind of the image messages sent before images had their own kind, which were told
+// apart only by their image_url
+func migrateMessageKinds(ctx context.Context, db execer) error {
+	result, err := db.ExecContext(ctx, `UPDATE messages SET kind = 'image' WHERE kind = 'text' AND image_url IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("error migrating image message kinds: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		log.Printf("Migrated %d image messages to the image kind", n)
+	}
+	return nil
+}
+
+// migrateHideLastSeen moves the last seen privacy of databases where users could only hide it, with hide_last_seen,
+// to last_seen_visibility: users who hid it keep it hidden from everyone. The old column is dropped afterwards.
+func migrateHideLastSeen(ctx context.Context, db execer) error {
+	found, err := hasColumn(ctx, db, "users", "hide_last_seen")
+	if err != nil || !found {
+		return err
+	}
+
+	result, err := db.ExecContext(ctx, `
+        UPDATE users SET last_seen_visibility = ?
+        WHERE hide_last_seen = 1
+    `, VisibleToNobody)
+	if err != nil {
+		return fmt.Errorf("error migrating hidden last seen: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		log.Printf("Migrated %d users hiding their last seen", n)
+	}
+
+	if _, err := db.ExecContext(ctx, "ALTER TABLE users DROP COLUMN hide_last_seen"); err != nil {
+		return fmt.Errorf("error dropping users.hide_last_seen: %w", err)
+	}
+	return nil
+}
+
+// migratePreviews computes the stored previews of every conversation and group from their newest message, for
+// databases where they were derived on every read
+func migratePreviews(ctx context.Context, db execer) error {
+	for _, table := range []string{"conversations", "groups"} {
+		if _, err := db.ExecContext(ctx, refreshPreviewQuery(sqliteDialect{}, table)); err != nil {
+			return fmt.Errorf("error computing previews of %s: %w", table, err)
+		}
+	}
+	return nil
+}
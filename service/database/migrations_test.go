@@ -0,0 +1,259 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestMigrateUserIDs(t *testing.T) {
+	ctx := context.Background()
+	c, err := sql.Open("sqlite3", "file:TestMigrateUserIDs?mode=memory&cache=shared&_foreign_keys=1")
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+
+	// Schema and data as written by the versions using usernames as IDs. alice was renamed to alicia, which rewrote
+	// her messages but kept her ID.
+	_, err = c.Exec(`
+	CREATE TABLE users (
+		id TEXT PRIMARY KEY,
+		username TEXT UNIQUE NOT NULL,
+		token TEXT UNIQUE NOT NULL,
+		photo_url TEXT
+	);
+	CREATE TABLE conversations (id TEXT PRIMARY KEY, last_message TEXT, timestamp DATETIME);
+	CREATE TABLE messages (
+		id TEXT PRIMARY KEY,
+		conversation_id TEXT,
+		sender TEXT,
+		content TEXT,
+		timestamp DATETIME,
+		reply_to_id TEXT REFERENCES messages(id),
+		image_url TEXT,
+		FOREIGN KEY (conversation_id) REFERENCES conversations(id)
+	);
+	CREATE TABLE conversation_participants (
+		conversation_id TEXT,
+		user_id TEXT,
+		PRIMARY KEY (conversation_id, user_id)
+	);
+
+	INSERT INTO users (id, username, token) VALUES ('alice', 'alicia', 'token1'), ('bob', 'bob', 'token2');
+	INSERT INTO conversations (id, last_message, timestamp) VALUES ('conv1', 'hey', '2024-01-01 10:00:00');
+	INSERT INTO conversation_participants (conversation_id, user_id) VALUES ('conv1', 'alice'), ('conv1', 'bob');
+	INSERT INTO messages (id, conversation_id, sender, content, timestamp) VALUES
+		('msg1', 'conv1', 'alicia', 'hi', '2024-01-01 10:00:00'),
+		('msg2', 'conv1', 'bob', 'hey', '2024-01-01 10:01:00');
+	INSERT INTO messages (id, conversation_id, sender, image_url, timestamp) VALUES
+		('msg3', 'conv1', 'bob', '/uploads/images/cat.png', '2024-01-01 10:02:00');
+	`)
+	if err != nil {
+		t.Fatalf("error creating legacy database: %v", err)
+	}
+
+	db, err := New(c)
+	if err != nil {
+		t.Fatalf("error migrating database: %v", err)
+	}
+
+	alice, err := db.GetUserByToken(ctx, "token1")
+	if err != nil {
+		t.Fatalf("error getting migrated user: %v", err)
+	}
+	if len(alice.ID) != 36 || alice.Username != "alicia" {
+		t.Fatalf("expected alicia with an opaque ID; got %+v", alice)
+	}
+
+	in, err := db.IsUserInConversation(ctx, "conv1", alice.ID)
+	if err != nil || !in {
+		t.Errorf("expected the participants to follow the new ID; got %v, %v", in, err)
+	}
+
+	msg, err := db.GetMessageByID(ctx, "msg1")
+	if err != nil {
+		t.Fatalf("error getting message: %v", err)
+	}
+	if msg.SenderID != alice.ID || msg.Sender != "alicia" {
+		t.Errorf("expected msg1 sent by %s (alicia); got %s (%s)", alice.ID, msg.SenderID, msg.Sender)
+	}
+
+	var hasForeignKey bool
+	err = c.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM pragma_foreign_key_list('messages') WHERE "from" = 'sender')
+	`).Scan(&hasForeignKey)
+	if err != nil || !hasForeignKey {
+		t.Errorf("expected messages.sender to reference users; got %v, %v", hasForeignKey, err)
+	}
+	// Group messages belong to no conversations row, and deleted messages detach their replies
+	var currentKeys bool
+	err = c.QueryRow(`
+		SELECT NOT EXISTS(SELECT 1 FROM pragma_foreign_key_list('messages') WHERE "table" = 'conversations')
+		   AND EXISTS(SELECT 1 FROM pragma_foreign_key_list('messages') WHERE on_delete = 'SET NULL')
+	`).Scan(&currentKeys)
+	if err != nil || !currentKeys {
+		t.Errorf("expected the current foreign keys of messages; got %v, %v", currentKeys, err)
+	}
+
+	// Messages predating kinds are typed by what they hold
+	var kinds []string
+	rows, err := c.Query(`SELECT kind FROM messages ORDER BY id`)
+	if err != nil {
+		t.Fatalf("error getting kinds: %v", err)
+	}
+	for rows.Next() {
+		var kind string
+		if err := rows.Scan(&kind); err != nil {
+			t.Fatalf("error scanning kind: %v", err)
+		}
+		kinds = append(kinds, kind)
+	}
+	_ = rows.Close()
+	if len(kinds) != 3 || kinds[0] != MessageKindText || kinds[2] != MessageKindImage {
+		t.Errorf("expected msg3 typed as image; got %v", kinds)
+	}
+
+	// The preview is computed from the newest message
+	conversations, _, err := db.GetUserConversations(ctx, alice.ID, 50, "")
+	if err != nil {
+		t.Fatalf("error getting conversations: %v", err)
+	}
+	if len(conversations) != 1 || conversations[0].LastMessage != "[Image]" ||
+		!conversations[0].Timestamp.Equal(time.Date(2024, 1, 1, 10, 2, 0, 0, time.UTC)) {
+		t.Errorf("expected the image as preview; got %+v", conversations)
+	}
+
+	// Renaming touches only the users row, the sender name follows at read time
+	if err := db.UpdateUsername(ctx, alice.ID, "ali"); err != nil {
+		t.Fatalf("error renaming: %v", err)
+	}
+	msg, err = db.GetMessageByID(ctx, "msg1")
+	if err != nil {
+		t.Fatalf("error getting message: %v", err)
+	}
+	if msg.SenderID != alice.ID || msg.Sender != "ali" {
+		t.Errorf("expected msg1 sent by ali after the rename; got %s", msg.Sender)
+	}
+
+	// A second start finds nothing left to migrate: short IDs and senders named like users are left alone
+	_, err = c.Exec(`
+		INSERT INTO users (id, username, token) VALUES ('carol', 'carol', 'token3');
+		INSERT INTO messages (id, conversation_id, sender, content, timestamp) VALUES
+			('msg4', 'conv1', 'carol', 'hello', '2024-01-01 10:03:00');
+	`)
+	if err != nil {
+		t.Fatalf("error inserting user: %v", err)
+	}
+	if _, err := New(c); err != nil {
+		t.Fatalf("error reopening database: %v", err)
+	}
+	again, err := db.GetUserByToken(ctx, "token1")
+	if err != nil || again.ID != alice.ID {
+		t.Errorf("expected the ID to stay %s; got %+v, %v", alice.ID, again, err)
+	}
+	carol, err := db.GetUserByToken(ctx, "token3")
+	if err != nil || carol.ID != "carol" {
+		t.Errorf("expected the ID of carol to stay carol; got %+v, %v", carol, err)
+	}
+}
+
+func TestMigrateReactionsKey(t *testing.T) {
+	ctx := context.Background()
+	c, err := sql.Open("sqlite3", "file:TestMigrateReactionsKey?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+
+	_, err = c.Exec(`
+	CREATE TABLE reactions (
+		message_id TEXT,
+		user_id TEXT,
+		reaction TEXT,
+		PRIMARY KEY (message_id, user_id),
+		CHECK (length(reaction) >= 1 AND length(reaction) <= 5)
+	);
+	INSERT INTO reactions (message_id, user_id, reaction) VALUES ('msg1', 'user1', '<3');
+	`)
+	if err != nil {
+		t.Fatalf("error creating legacy database: %v", err)
+	}
+
+	db, err := New(c)
+	if err != nil {
+		t.Fatalf("error migrating database: %v", err)
+	}
+	_, err = c.Exec(`
+		INSERT INTO users (id, username, token) VALUES ('user1', 'alice', 'token1');
+		INSERT INTO messages (id, conversation_id, sender, content, timestamp)
+		VALUES ('msg1', 'conv1', 'user1', 'hi', '2024-01-01 10:00:00');
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test data: %v", err)
+	}
+
+	// The old reaction survives and a second, longer one fits next to it
+	if err := db.AddReaction(ctx, "msg1", "user1", "👨‍👩‍👧‍👦"); err != nil {
+		t.Fatalf("error adding reaction: %v", err)
+	}
+	messages, err := db.GetConversationMessages(ctx, "conv1", "user1")
+	if err != nil {
+		t.Fatalf("error getting messages: %v", err)
+	}
+	if len(messages) != 1 || len(messages[0].Reactions) != 2 {
+		t.Errorf("expected two reactions; got %+v", messages)
+	}
+}
+
+func TestMigrateHideLastSeen(t *testing.T) {
+	ctx := context.Background()
+	c, err := sql.Open("sqlite3", "file:TestMigrateHideLastSeen?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+
+	// Users as written by the versions where the last seen could only be hidden
+	_, err = c.Exec(`
+	CREATE TABLE users (
+		id TEXT PRIMARY KEY,
+		username TEXT UNIQUE NOT NULL,
+		token TEXT UNIQUE NOT NULL,
+		photo_url TEXT,
+		last_seen DATETIME,
+		hide_last_seen INTEGER NOT NULL DEFAULT 0
+	);
+	INSERT INTO users (id, username, token, hide_last_seen) VALUES
+		('alice', 'alice', 'token1', 1),
+		('bob', 'bob', 'token2', 0);
+	`)
+	if err != nil {
+		t.Fatalf("error creating legacy database: %v", err)
+	}
+
+	db, err := New(c)
+	if err != nil {
+		t.Fatalf("error migrating database: %v", err)
+	}
+
+	for username, expected := range map[string]string{"alice": VisibleToNobody, "bob": VisibleToEveryone} {
+		profile, err := db.GetProfile(ctx, username)
+		if err != nil {
+			t.Fatalf("error getting profile: %v", err)
+		}
+		if profile.LastSeenVisibility != expected {
+			t.Errorf("expected %s last seen visible to %s; got %s", username, expected, profile.LastSeenVisibility)
+		}
+	}
+
+	if found, err := hasColumn(ctx, c, "users", "hide_last_seen"); err != nil || found {
+		t.Errorf("expected users.hide_last_seen dropped; got %v, %v", found, err)
+	}
+
+	// A second start finds nothing left to migrate
+	if _, err := New(c); err != nil {
+		t.Fatalf("error reopening database: %v", err)
+	}
+}
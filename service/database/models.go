@@ -2,38 +2,100 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 )
 
 // User representa la estructura de un usuario en la base de datos
 type User struct {
-	ID       string
-	Username string
-	Token    string
-	PhotoURL string
+	ID                 string
+	Username           string
+	Token              string
+	PhotoURL           string
+	DisplayName        string
+	Bio                string
+	PhotoVisibility    string
+	LastSeenVisibility string
+	ForwardVisibility  string
+	IsBot              bool
+	Scopes             []string // Scopes of the bot token the user authenticated with, nil for humans
 }
 
+// Bot is a user account driven through the API by automations. It belongs to the human who created it, who manages
+// its tokens.
+type Bot struct {
+	ID          string `json:"bot_id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	OwnerID     string `json:"owner_id"`
+}
+
+// BotToken is a long-lived API token of a bot, limited to its scopes. Only its hash is stored, so Token is only set
+// when the token is created.
+type BotToken struct {
+	ID         string     `json:"token_id"`
+	BotID      string     `json:"bot_id"`
+	Token      string     `json:"token,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Visibility levels of the privacy settings, deciding who can see a user's photo or last seen time
+const (
+	VisibleToEveryone = "everyone"
+	VisibleToContacts = "contacts" // Users sharing a conversation or group
+	VisibleToNobody   = "nobody"
+)
+
 type Conversation struct {
-	ID                 string    `json:"conversation_id"`
-	LastMessage        string    `json:"last_message"`
-	LastMessageIsReply bool      `json:"last_message_is_reply"`
-	Timestamp          time.Time `json:"timestamp"`
-	Participants       []string  `json:"participants"`
-	PhotoURL           string    `json:"photo_url,omitempty"`
-	IsGroup            bool      `json:"is_group"`
-	Name               string    `json:"name,omitempty"`
+	ID                 string     `json:"conversation_id"`
+	LastMessage        string     `json:"last_message"`
+	LastMessageIsReply bool       `json:"last_message_is_reply"`
+	Timestamp          time.Time  `json:"timestamp"`
+	Participants       []string   `json:"participants"`
+	Bots               []string   `json:"bots,omitempty"` // Participants that are bots
+	PhotoURL           string     `json:"photo_url,omitempty"`
+	IsGroup            bool       `json:"is_group"`
+	Name               string     `json:"name,omitempty"`
+	Muted              bool       `json:"muted"`
+	MutedUntil         *time.Time `json:"muted_until,omitempty"`
+	PhotoVisibility    string     `json:"-"` // Of the other participant, empty for groups
 }
 
-// Reaction representa una reacción a un mensaje
+// Reaction representa una reacción a un mensaje, agrupando a todos los usuarios que usaron el mismo emoji
 type Reaction struct {
-	UserID   string `json:"user_id" bson:"userID"`
-	Reaction string `json:"reaction" bson:"reaction"`
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
 }
 
+// Kinds of messages, telling clients how to render them
+const (
+	MessageKindText       = "text"
+	MessageKindImage      = "image"
+	MessageKindAttachment = "attachment"
+	MessageKindPoll       = "poll"
+	MessageKindSystem     = "system" // Posted by the server about a change in the conversation, see SystemEvent
+)
+
+// Events told by system messages. The content of the message describes the event for clients that do not know it.
+const (
+	SystemEventGroupCreated      = "group_created"
+	SystemEventMemberJoined      = "member_joined"
+	SystemEventMemberLeft        = "member_left"
+	SystemEventGroupRenamed      = "group_renamed"
+	SystemEventGroupPhotoChanged = "group_photo_changed"
+	SystemEventMessageTTLChanged = "message_ttl_changed"
+	SystemEventReminder          = "reminder"
+)
+
 type Message struct {
 	ID             string         `json:"message_id"`
 	ConversationID string         `json:"conversation_id"`
-	Sender         string         `json:"sender"`
+	SenderID       string         `json:"sender_id"`
+	Sender         string         `json:"sender"`  // Username of the sender, looked up when reading
 	Content        sql.NullString `json:"-"`       // Use sql.NullString for nullable fields
 	ContentStr     string         `json:"content"` // This will be populated from Content
 	ImageURL       sql.NullString `json:"-"`
@@ -41,7 +103,112 @@ type Message struct {
 	ReplyToID      sql.NullString `json:"-"`
 	ReplyToIDStr   string         `json:"reply_to_id"`
 	Time           time.Time      `json:"timestamp"`
-	Reactions      []Reaction     `json:"reactions,omitempty" bson:"reactions,omitempty"`
+	Kind           string         `json:"kind"`
+	SystemEvent    string         `json:"system_event,omitempty"` // Set for messages of kind system
+	ForwardedFrom  *ForwardedFrom `json:"forwarded_from,omitempty"`
+	Reactions      []Reaction     `json:"reactions"`
+	Mentions       []Mention      `json:"mentions"`
+	Attachments    []Attachment   `json:"attachments"`
+	LinkPreview    *LinkPreview   `json:"link_preview,omitempty"`
+	Poll           *Poll          `json:"poll,omitempty"` // Set for messages of kind poll
+}
+
+// Poll is the question of a message of kind poll, with the votes counted for the user reading it. A poll is closed
+// once its creator closes it or ClosesAt passes; closed polls no longer take votes.
+type Poll struct {
+	Question       string       `json:"question"`
+	Options        []PollOption `json:"options"`
+	MultipleChoice bool         `json:"multiple_choice"`
+	Anonymous      bool         `json:"anonymous"`
+	ClosesAt       *time.Time   `json:"closes_at,omitempty"`
+	Closed         bool         `json:"closed"`
+	ClosedAt       *time.Time   `json:"closed_at,omitempty"`
+	TotalVoters    int          `json:"total_voters"`
+}
+
+// PollOption is an answer of a poll with its votes. Voters lists the usernames of who chose it, unless the poll is
+// anonymous.
+type PollOption struct {
+	Text      string   `json:"text"`
+	Votes     int      `json:"votes"`
+	VotedByMe bool     `json:"voted_by_me"`
+	Voters    []string `json:"voters,omitempty"`
+}
+
+// ForwardedFrom tells where a forwarded message was first sent. Forwarding a forwarded message keeps its origin. The
+// API only shows the sender as allowed by Visibility, the forward visibility of the sender, and the conversation and
+// the message to the participants of that conversation.
+type ForwardedFrom struct {
+	SenderID       string `json:"sender_id,omitempty"`
+	Sender         string `json:"sender,omitempty"`
+	ConversationID string `json:"conversation_id,omitempty"`
+	MessageID      string `json:"message_id,omitempty"`
+	Visibility     string `json:"-"`
+}
+
+// ForwardResult is the outcome of forwarding a message to one target conversation or group: the new message, or why
+// the target did not accept it
+type ForwardResult struct {
+	ConversationID string
+	Message        *Message
+	Err            error
+}
+
+// LinkPreview describes the first link in the content of a message, as told by the OpenGraph tags of the page. It is
+// fetched in the background after the message is sent.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+}
+
+// Attachment is a file sent with a message. The file is stored under StorageKey, which copies of the attachment in
+// forwarded messages share, and is downloaded from URL.
+type Attachment struct {
+	ID             string `json:"attachment_id"`
+	MessageID      string `json:"-"`
+	ConversationID string `json:"-"`
+	Filename       string `json:"filename"`
+	MIMEType       string `json:"mime_type"`
+	Size           int64  `json:"size"`
+	Checksum       string `json:"checksum"` // SHA-256 of the content, hex encoded
+	StorageKey     string `json:"-"`
+	UploaderID     string `json:"-"`
+	DurationMS     *int64 `json:"duration_ms,omitempty"`
+	Width          *int   `json:"width,omitempty"`
+	Height         *int   `json:"height,omitempty"`
+	URL            string `json:"url"`
+}
+
+// Upload is a file being uploaded in chunks. Once all Size bytes are received it is finalized, getting a Checksum,
+// and can be sent as an attachment until it expires.
+type Upload struct {
+	ID         string    `json:"upload_id"`
+	UserID     string    `json:"-"`
+	Filename   string    `json:"filename"`
+	MIMEType   string    `json:"mime_type"`
+	Size       int64     `json:"size"`
+	Offset     int64     `json:"offset"`
+	Checksum   string    `json:"checksum,omitempty"`
+	StorageKey string    `json:"-"`
+	Completed  bool      `json:"completed"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Mention is a conversation member addressed with "@username" in a message
+type Mention struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// MentionNotification is a message where a user was mentioned
+type MentionNotification struct {
+	MessageID      string    `json:"message_id"`
+	ConversationID string    `json:"conversation_id"`
+	Sender         string    `json:"sender"`
+	Content        string    `json:"content"`
+	Timestamp      time.Time `json:"timestamp"`
 }
 
 // Group representa un grupo de chat
@@ -64,9 +231,109 @@ type Session struct {
 }
 
 type ConversationDetails struct {
-	ID           string   `json:"conversation_id"`
-	Participants []string `json:"participants"`
-	IsGroup      bool     `json:"is_group"`
-	Name         string   `json:"name,omitempty"`
-	PhotoURL     string   `json:"photo_url,omitempty"`
+	ID           string        `json:"conversation_id"`
+	Participants []Participant `json:"participants"`
+	IsGroup      bool          `json:"is_group"`
+	Name         string        `json:"name,omitempty"`
+	PhotoURL     string        `json:"photo_url,omitempty"`
+	MessageTTL   int64         `json:"message_ttl"` // Seconds before messages disappear, 0 when disabled
+
+	PinnedMessages []PinnedMessage `json:"pinned_messages"`
+}
+
+// PinnedMessage is a message highlighted in a conversation, with a short preview of its content
+type PinnedMessage struct {
+	MessageID string    `json:"message_id"`
+	Sender    string    `json:"sender"`
+	Preview   string    `json:"preview"`
+	PinnedBy  string    `json:"pinned_by"`
+	PinnedAt  time.Time `json:"pinned_at"`
+}
+
+// Participant is a member of a conversation as shown to the other members. Online and Typing are filled by the API
+// from the in-memory presence registry, which also hides PhotoURL and LastSeen according to the privacy settings.
+type Participant struct {
+	UserID             string     `json:"user_id"`
+	Username           string     `json:"username"`
+	DisplayName        string     `json:"display_name"`
+	PhotoURL           string     `json:"photo_url,omitempty"`
+	Online             bool       `json:"online"`
+	Typing             bool       `json:"typing"`
+	LastSeen           *time.Time `json:"last_seen,omitempty"`
+	IsBot              bool       `json:"is_bot"`
+	PhotoVisibility    string     `json:"-"`
+	LastSeenVisibility string     `json:"-"`
+}
+
+// Profile is the public profile of a user. Privacy settings are applied by the API before sending it.
+type Profile struct {
+	UserID             string     `json:"user_id"`
+	Username           string     `json:"username"`
+	DisplayName        string     `json:"display_name"`
+	Bio                string     `json:"bio"`
+	PhotoURL           string     `json:"photo_url,omitempty"`
+	Online             bool       `json:"online"`
+	LastSeen           *time.Time `json:"last_seen,omitempty"`
+	AvatarHistory      []Avatar   `json:"avatar_history,omitempty"`
+	PhotoVisibility    string     `json:"-"`
+	LastSeenVisibility string     `json:"-"`
+}
+
+// UserSummary is a user as listed by the directory search. The API hides PhotoURL according to PhotoVisibility.
+type UserSummary struct {
+	UserID          string `json:"user_id"`
+	Username        string `json:"username"`
+	DisplayName     string `json:"display_name"`
+	PhotoURL        string `json:"photo_url,omitempty"`
+	IsContact       bool   `json:"is_contact"`
+	PhotoVisibility string `json:"-"`
+}
+
+// Avatar is a profile photo a user had, with the time it was set
+type Avatar struct {
+	PhotoURL string    `json:"photo_url"`
+	SetAt    time.Time `json:"set_at"`
+}
+
+// Webhook is a subscription of an external URL to the events of a conversation. Events lists the event types it
+// receives; the Secret signs every delivery and is only shown when the webhook is created.
+type Webhook struct {
+	ID             string    `json:"webhook_id"`
+	ConversationID string    `json:"conversation_id"`
+	URL            string    `json:"url"`
+	Secret         string    `json:"secret,omitempty"`
+	Events         []string  `json:"events"`
+	CreatedBy      string    `json:"created_by"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is an event sent, or to be sent, to a webhook. Failed deliveries are retried until they are
+// delivered or, after too many attempts, dead.
+type WebhookDelivery struct {
+	ID             string          `json:"delivery_id"`
+	WebhookID      string          `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	URL            string          `json:"-"`
+	Secret         string          `json:"-"`
+}
+
+// SlashCommand is a command registered by a bot in a conversation. Typing /Name in the conversation POSTs the
+// command to URL, signed with Secret, and the bot answers with the message to post.
+type SlashCommand struct {
+	ConversationID string    `json:"conversation_id"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	BotID          string    `json:"bot_id"`
+	Bot            string    `json:"bot"` // Username of the bot, looked up when reading
+	URL            string    `json:"url,omitempty"`
+	Secret         string    `json:"secret,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
 }
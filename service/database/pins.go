@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// MaxPinnedMessages is the maximum number of messages pinned at the same time in a conversation
+const MaxPinnedMessages = 5
+
+// ErrPinLimitReached is returned by PinMessage when the conversation already has MaxPinnedMessages pins
+var ErrPinLimitReached = fmt.Errorf("a conversation can have at most %d pinned messages", MaxPinnedMessages)
+
+// ErrMessageNotInConversation is returned when a message ID does not belong to the given conversation
+var ErrMessageNotInConversation = errors.New("message not found in conversation")
+
+// PinMessage pins a message of a conversation on behalf of userID. Pinning an already pinned message is a no-op.
+func (db *appdbimpl) PinMessage(ctx context.Context, conversationID string, messageID string, userID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
+
+	var inConversation, alreadyPinned bool
+	var pins int
+	err = tx.QueryRowContext(ctx, `
+        SELECT
+            EXISTS(SELECT 1 FROM messages WHERE id = ? AND conversation_id = ?),
+            EXISTS(SELECT 1 FROM pinned_messages WHERE conversation_id = ? AND message_id = ?),
+            (SELECT COUNT(*) FROM pinned_messages WHERE conversation_id = ?)
+    `, messageID, conversationID, conversationID, messageID, conversationID).Scan(&inConversation, &alreadyPinned, &pins)
+	if err != nil {
+		return fmt.Errorf("error checking pinned messages: %w", err)
+	}
+	if !inConversation {
+		return ErrMessageNotInConversation
+	}
+	if alreadyPinned {
+		return nil
+	}
+	if pins >= MaxPinnedMessages {
+		return ErrPinLimitReached
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO pinned_messages (conversation_id, message_id, pinned_by, pinned_at)
+        VALUES (?, ?, ?, ?)
+    `, conversationID, messageID, userID, time.Now())
+	if err != nil {
+		return fmt.Errorf("error pinning message: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// UnpinMessage removes a pin from a conversation
+func (db *appdbimpl) UnpinMessage(ctx context.Context, conversationID string, messageID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.c.ExecContext(ctx, `
+        DELETE FROM pinned_messages
+        WHERE conversation_id = ? AND message_id = ?
+    `, conversationID, messageID)
+	if err != nil {
+		return fmt.Errorf("error unpinning message: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if rows == 0 {
+		return errors.New("message is not pinned")
+	}
+
+	return nil
+}
+
+// GetPinnedMessages returns the pins of a conversation, most recently pinned first
+func (db *appdbimpl) GetPinnedMessages(ctx context.Context, conversationID string) ([]PinnedMessage, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT p.message_id, COALESCE(s.username, m.sender), m.content, m.kind,
+               COALESCE(u.username, p.pinned_by),
+               `+db.dialect.formatTime("p.pinned_at")+`
+        FROM pinned_messages p
+        JOIN messages m ON m.id = p.message_id
+        LEFT JOIN users s ON s.id = m.sender
+        LEFT JOIN users u ON u.id = p.pinned_by
+        WHERE p.conversation_id = ?
+        ORDER BY p.pinned_at DESC
+    `, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting pinned messages: %w", err)
+	}
+	defer rows.Close()
+
+	pins := make([]PinnedMessage, 0)
+	for rows.Next() {
+		var pin PinnedMessage
+		var msg Message
+		var pinnedAt string
+		if err := rows.Scan(&pin.MessageID, &pin.Sender, &msg.Content, &msg.Kind, &pin.PinnedBy, &pinnedAt); err != nil {
+			return nil, fmt.Errorf("error scanning pinned message: %w", err)
+		}
+
+		pin.PinnedAt, err = time.Parse("2006-01-02 15:04:05", pinnedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing timestamp: %w", err)
+		}
+
+		pin.Preview = truncatePreview(messagePreview(&msg))
+
+		pins = append(pins, pin)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating pinned messages: %w", err)
+	}
+
+	return pins, nil
+}
+
+// truncatePreview shortens a message content to the first previewLength characters
+func truncatePreview(content string) string {
+	const previewLength = 100
+	runes := []rune(content)
+	if len(runes) <= previewLength {
+		return content
+	}
+	return string(runes[:previewLength]) + "…"
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPinMessage(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	c := db.(*appdbimpl).c
+
+	_, err := c.Exec(`INSERT INTO users (id, username, token) VALUES ('user1', 'test_user1', 'token1')`)
+	if err != nil {
+		t.Fatalf("error inserting test user: %v", err)
+	}
+	for i := 0; i <= MaxPinnedMessages; i++ {
+		_, err = c.Exec(`
+			INSERT INTO messages (id, conversation_id, sender, content, timestamp)
+			VALUES (?, 'conv1', 'user1', ?, ?)
+		`, fmt.Sprintf("msg%d", i), fmt.Sprintf("message %d", i), time.Now())
+		if err != nil {
+			t.Fatalf("error inserting test message: %v", err)
+		}
+	}
+
+	if err := db.PinMessage(ctx, "conv2", "msg0", "user1"); !errors.Is(err, ErrMessageNotInConversation) {
+		t.Errorf("expected ErrMessageNotInConversation; got %v", err)
+	}
+
+	for i := 0; i < MaxPinnedMessages; i++ {
+		if err := db.PinMessage(ctx, "conv1", fmt.Sprintf("msg%d", i), "user1"); err != nil {
+			t.Fatalf("unexpected error pinning msg%d: %v", i, err)
+		}
+	}
+
+	// Pinning twice does not count towards the limit
+	if err := db.PinMessage(ctx, "conv1", "msg0", "user1"); err != nil {
+		t.Errorf("unexpected error pinning twice: %v", err)
+	}
+
+	last := fmt.Sprintf("msg%d", MaxPinnedMessages)
+	if err := db.PinMessage(ctx, "conv1", last, "user1"); !errors.Is(err, ErrPinLimitReached) {
+		t.Errorf("expected ErrPinLimitReached; got %v", err)
+	}
+
+	if err := db.UnpinMessage(ctx, "conv1", "msg0"); err != nil {
+		t.Fatalf("unexpected error unpinning: %v", err)
+	}
+	if err := db.UnpinMessage(ctx, "conv1", "msg0"); err == nil {
+		t.Error("expected error unpinning a message that is not pinned")
+	}
+	if err := db.PinMessage(ctx, "conv1", last, "user1"); err != nil {
+		t.Errorf("unexpected error pinning after unpin: %v", err)
+	}
+
+	pins, err := db.GetPinnedMessages(ctx, "conv1")
+	if err != nil {
+		t.Fatalf("error getting pins: %v", err)
+	}
+	if len(pins) != MaxPinnedMessages {
+		t.Fatalf("expected %d pins; got %d", MaxPinnedMessages, len(pins))
+	}
+	for _, pin := range pins {
+		if pin.PinnedBy != "test_user1" {
+			t.Errorf("expected pinned_by test_user1; got %s", pin.PinnedBy)
+		}
+		if pin.MessageID == last && pin.Preview != fmt.Sprintf("message %d", MaxPinnedMessages) {
+			t.Errorf("unexpected preview %q", pin.Preview)
+		}
+	}
+}
+
+func TestPinnedMessagePreview(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	c := db.(*appdbimpl).c
+
+	_, err := c.Exec(`INSERT INTO users (id, username, token) VALUES ('user1', 'test_user1', 'token1')`)
+	if err != nil {
+		t.Fatalf("error inserting test user: %v", err)
+	}
+	_, err = c.Exec(`
+		INSERT INTO messages (id, conversation_id, sender, content, image_url, kind, timestamp) VALUES
+		('text', 'conv1', 'user1', 'hello', NULL, 'text', ?),
+		('image', 'conv1', 'user1', NULL, '/uploads/images/a.png', 'image', ?),
+		('attachment', 'conv1', 'user1', NULL, NULL, 'attachment', ?),
+		('poll', 'conv1', 'user1', 'Lunch?', NULL, 'poll', ?)
+	`, time.Now(), time.Now(), time.Now(), time.Now())
+	if err != nil {
+		t.Fatalf("error inserting test messages: %v", err)
+	}
+
+	// Pins are previewed like the last message of the inbox
+	expected := map[string]string{
+		"text":       "hello",
+		"image":      "[Image]",
+		"attachment": "[Attachment]",
+		"poll":       PollPreviewPrefix + "Lunch?",
+	}
+	for id := range expected {
+		if err := db.PinMessage(ctx, "conv1", id, "user1"); err != nil {
+			t.Fatalf("error pinning %s: %v", id, err)
+		}
+	}
+	pins, err := db.GetPinnedMessages(ctx, "conv1")
+	if err != nil {
+		t.Fatalf("error getting pins: %v", err)
+	}
+	if len(pins) != len(expected) {
+		t.Fatalf("expected %d pins; got %+v", len(expected), pins)
+	}
+	for _, pin := range pins {
+		if pin.Preview != expected[pin.MessageID] {
+			t.Errorf("expected the preview of %s to be %q; got %q", pin.MessageID, expected[pin.MessageID], pin.Preview)
+		}
+	}
+}
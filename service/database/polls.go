@@ -0,0 +1,347 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Limits of the options of a poll
+const (
+	MinPollOptions = 2
+	MaxPollOptions = 10
+)
+
+// PollPreviewPrefix marks polls in the last message preview of a conversation
+const PollPreviewPrefix = "📊 "
+
+var (
+	// ErrPollNotFound is returned when a message of the conversation is not a poll
+	ErrPollNotFound = errors.New("poll not found")
+
+	// ErrPollClosed is returned when voting in, or closing, a closed poll
+	ErrPollClosed = errors.New("poll is closed")
+
+	// ErrInvalidVote is returned when the chosen options do not exist or are too many for a single choice poll
+	ErrInvalidVote = errors.New("invalid poll options")
+)
+
+// CreatePoll creates a message of kind poll asking poll.Question. Only the question, the options texts, the choice
+// and anonymity settings and ClosesAt of poll are used.
+func (db *appdbimpl) CreatePoll(ctx context.Context, conversationID string, senderID string, poll Poll) (*Message,
+	error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if len(poll.Options) < MinPollOptions || len(poll.Options) > MaxPollOptions {
+		return nil, fmt.Errorf("a poll must have between %d and %d options", MinPollOptions, MaxPollOptions)
+	}
+
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
+
+	msg := Message{
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Content:        sql.NullString{String: poll.Question, Valid: true},
+		Kind:           MessageKindPoll,
+	}
+	if err := appendMessage(ctx, tx, &msg); err != nil {
+		return nil, err
+	}
+	if err := insertPoll(ctx, tx, msg.ID, &poll); err != nil {
+		return nil, err
+	}
+
+	msg.Mentions, err = insertMentions(ctx, tx, conversationID, msg.ID, senderID, poll.Question)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	for i := range poll.Options {
+		poll.Options[i] = PollOption{Text: poll.Options[i].Text}
+	}
+	poll.Closed, poll.ClosedAt, poll.TotalVoters = false, nil, 0
+	msg.Poll = &poll
+	msg.Reactions = make([]Reaction, 0)
+	msg.Attachments = make([]Attachment, 0)
+	return &msg, nil
+}
+
+// insertPoll stores the settings and the options of the poll asked by a message
+func insertPoll(ctx context.Context, ex execer, messageID string, poll *Poll) error {
+	var closesAt interface{}
+	if poll.ClosesAt != nil {
+		closesAt = poll.ClosesAt.UTC()
+	}
+	_, err := ex.ExecContext(ctx, `
+        INSERT INTO polls (message_id, multiple_choice, anonymous, closes_at)
+        VALUES (?, ?, ?, ?)
+    `, messageID, poll.MultipleChoice, poll.Anonymous, closesAt)
+	if err != nil {
+		return fmt.Errorf("error inserting poll: %w", err)
+	}
+	for i, option := range poll.Options {
+		_, err := ex.ExecContext(ctx, `INSERT INTO poll_options (message_id, position, text) VALUES (?, ?, ?)`,
+			messageID, i, option.Text)
+		if err != nil {
+			return fmt.Errorf("error inserting poll option: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyPoll asks the poll of fromMessageID again in toMessageID, without its votes and its closing time
+func (db *appdbimpl) copyPoll(ctx context.Context, ex execer, fromMessageID string, toMessageID string) error {
+	polls, err := db.queryPolls(ctx, ex, "m.id = ?", fromMessageID, "")
+	if err != nil {
+		return err
+	}
+	poll, ok := polls[fromMessageID]
+	if !ok {
+		return ErrPollNotFound
+	}
+	poll.ClosesAt = nil
+	return insertPoll(ctx, ex, toMessageID, poll)
+}
+
+// GetPoll returns the poll asked by a message, with the votes counted for viewerID
+func (db *appdbimpl) GetPoll(ctx context.Context, messageID string, viewerID string) (*Poll, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	polls, err := db.queryPolls(ctx, db.r, "m.id = ?", messageID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	poll, ok := polls[messageID]
+	if !ok {
+		return nil, ErrPollNotFound
+	}
+	return poll, nil
+}
+
+// getConversationPolls returns the polls of a conversation with the votes counted for viewerID, by message ID
+func (db *appdbimpl) getConversationPolls(ctx context.Context, conversationID string,
+	viewerID string) (map[string]*Poll, error) {
+	return db.queryPolls(ctx, db.r, "m.conversation_id = ?", conversationID, viewerID)
+}
+
+// queryPolls returns the polls of the messages matching filter, a condition on the messages m taking arg, by message
+// ID. Votes are counted for viewerID, and voters are only listed for polls that are not anonymous.
+func (db *appdbimpl) queryPolls(ctx context.Context, ex execer, filter string, arg string,
+	viewerID string) (map[string]*Poll, error) {
+	rows, err := ex.QueryContext(ctx, `
+        SELECT p.message_id, COALESCE(m.content, ''), p.multiple_choice, p.anonymous,
+               `+db.dialect.formatTime("p.closes_at")+`, `+db.dialect.formatTime("p.closed_at")+`
+        FROM polls p
+        JOIN messages m ON m.id = p.message_id
+        WHERE `+filter, arg)
+	if err != nil {
+		return nil, fmt.Errorf("error getting polls: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC()
+	polls := make(map[string]*Poll)
+	for rows.Next() {
+		var id string
+		var closesAt, closedAt sql.NullString
+		poll := Poll{Options: make([]PollOption, 0)}
+		if err := rows.Scan(&id, &poll.Question, &poll.MultipleChoice, &poll.Anonymous, &closesAt,
+			&closedAt); err != nil {
+			return nil, fmt.Errorf("error scanning poll: %w", err)
+		}
+		if poll.ClosesAt, err = parseNullTimestamp(closesAt); err != nil {
+			return nil, err
+		}
+		if poll.ClosedAt, err = parseNullTimestamp(closedAt); err != nil {
+			return nil, err
+		}
+		if poll.ClosedAt == nil && poll.ClosesAt != nil && !poll.ClosesAt.After(now) {
+			poll.ClosedAt = poll.ClosesAt
+		}
+		poll.Closed = poll.ClosedAt != nil
+		polls[id] = &poll
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating polls: %w", err)
+	}
+	_ = rows.Close()
+
+	options, err := ex.QueryContext(ctx, `
+        SELECT o.message_id, o.text
+        FROM poll_options o
+        JOIN messages m ON m.id = o.message_id
+        WHERE `+filter+`
+        ORDER BY o.message_id, o.position`, arg)
+	if err != nil {
+		return nil, fmt.Errorf("error getting poll options: %w", err)
+	}
+	defer options.Close()
+	for options.Next() {
+		var id, text string
+		if err := options.Scan(&id, &text); err != nil {
+			return nil, fmt.Errorf("error scanning poll option: %w", err)
+		}
+		if poll, ok := polls[id]; ok {
+			poll.Options = append(poll.Options, PollOption{Text: text})
+		}
+	}
+	if err := options.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating poll options: %w", err)
+	}
+	_ = options.Close()
+
+	votes, err := ex.QueryContext(ctx, `
+        SELECT v.message_id, v.position, v.user_id, COALESCE(u.username, v.user_id)
+        FROM poll_votes v
+        JOIN messages m ON m.id = v.message_id
+        LEFT JOIN users u ON u.id = v.user_id
+        WHERE `+filter+`
+        ORDER BY v.voted_at, v.position`, arg)
+	if err != nil {
+		return nil, fmt.Errorf("error getting poll votes: %w", err)
+	}
+	defer votes.Close()
+	voters := make(map[string]map[string]bool)
+	for votes.Next() {
+		var id, userID, username string
+		var position int
+		if err := votes.Scan(&id, &position, &userID, &username); err != nil {
+			return nil, fmt.Errorf("error scanning poll vote: %w", err)
+		}
+		poll, ok := polls[id]
+		if !ok || position < 0 || position >= len(poll.Options) {
+			continue
+		}
+		option := &poll.Options[position]
+		option.Votes++
+		if userID == viewerID {
+			option.VotedByMe = true
+		}
+		if !poll.Anonymous {
+			option.Voters = append(option.Voters, username)
+		}
+		if voters[id] == nil {
+			voters[id] = make(map[string]bool)
+		}
+		voters[id][userID] = true
+	}
+	if err := votes.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating poll votes: %w", err)
+	}
+	for id, poll := range polls {
+		poll.TotalVoters = len(voters[id])
+	}
+	return polls, nil
+}
+
+// checkPollOpen returns the number of options of the poll asked by a message of the conversation and whether it takes
+// more than one choice, failing with ErrPollNotFound or ErrPollClosed
+func (db *appdbimpl) checkPollOpen(ctx context.Context, ex execer, conversationID string,
+	messageID string) (int, bool, error) {
+	var options int
+	var multipleChoice, closed bool
+	err := ex.QueryRowContext(ctx, `
+        SELECT (SELECT COUNT(*) FROM poll_options WHERE message_id = p.message_id), p.multiple_choice,
+               p.closed_at IS NOT NULL
+               OR (p.closes_at IS NOT NULL AND `+db.dialect.timeValue("p.closes_at")+` <= `+db.dialect.timeValue("?")+`)
+        FROM polls p
+        JOIN messages m ON m.id = p.message_id
+        WHERE p.message_id = ? AND m.conversation_id = ?
+    `, time.Now().UTC(), messageID, conversationID).Scan(&options, &multipleChoice, &closed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, ErrPollNotFound
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("error getting poll: %w", err)
+	}
+	if closed {
+		return 0, false, ErrPollClosed
+	}
+	return options, multipleChoice, nil
+}
+
+// VotePoll replaces the votes of a user in a poll of the conversation with the options at the given positions. No
+// options withdraws the vote.
+func (db *appdbimpl) VotePoll(ctx context.Context, conversationID string, messageID string, userID string,
+	options []int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
+
+	count, multipleChoice, err := db.checkPollOpen(ctx, tx, conversationID, messageID)
+	if err != nil {
+		return err
+	}
+	if len(options) > 1 && !multipleChoice {
+		return fmt.Errorf("%w: the poll takes a single choice", ErrInvalidVote)
+	}
+	chosen := make(map[int]bool, len(options))
+	for _, position := range options {
+		if position < 0 || position >= count || chosen[position] {
+			return fmt.Errorf("%w: options must be distinct positions from 0 to %d", ErrInvalidVote, count-1)
+		}
+		chosen[position] = true
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM poll_votes WHERE message_id = ? AND user_id = ?`, messageID,
+		userID); err != nil {
+		return fmt.Errorf("error deleting votes: %w", err)
+	}
+	now := time.Now().UTC()
+	for _, position := range options {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO poll_votes (message_id, position, user_id, voted_at)
+            VALUES (?, ?, ?, ?)
+        `, messageID, position, userID, now)
+		if err != nil {
+			return fmt.Errorf("error inserting vote: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// ClosePoll closes a poll of the conversation, freezing its votes
+func (db *appdbimpl) ClosePoll(ctx context.Context, conversationID string, messageID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if _, _, err := db.checkPollOpen(ctx, db.c, conversationID, messageID); err != nil {
+		return err
+	}
+	_, err := db.c.ExecContext(ctx, `UPDATE polls SET closed_at = ? WHERE message_id = ? AND closed_at IS NULL`,
+		time.Now().UTC(), messageID)
+	if err != nil {
+		return fmt.Errorf("error closing poll: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPolls(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2'),
+		('user3', 'carol', 'token3')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	group, err := db.CreateGroup(ctx, "Office", "user1", []string{"bob", "carol"})
+	if err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+
+	poll := Poll{Question: "Lunch?", Options: []PollOption{{Text: "Pizza"}, {Text: "Sushi"}, {Text: "Salad"}}}
+	msg, err := db.CreatePoll(ctx, group.ID, "user1", poll)
+	if err != nil {
+		t.Fatalf("error creating poll: %v", err)
+	}
+	if msg.Kind != "poll" || msg.Poll == nil || len(msg.Poll.Options) != 3 {
+		t.Fatalf("expected a poll message with 3 options; got %+v", msg)
+	}
+	if _, err := db.CreatePoll(ctx, group.ID, "user1", Poll{Question: "?",
+		Options: []PollOption{{Text: "a"}}}); err == nil {
+		t.Errorf("expected a poll with one option to be rejected")
+	}
+
+	if err := db.VotePoll(ctx, group.ID, msg.ID, "user2", []int{0, 1}); !errors.Is(err, ErrInvalidVote) {
+		t.Errorf("expected ErrInvalidVote for two choices in a single choice poll; got %v", err)
+	}
+	if err := db.VotePoll(ctx, group.ID, msg.ID, "user2", []int{3}); !errors.Is(err, ErrInvalidVote) {
+		t.Errorf("expected ErrInvalidVote for a missing option; got %v", err)
+	}
+	if err := db.VotePoll(ctx, "other", msg.ID, "user2", []int{0}); !errors.Is(err, ErrPollNotFound) {
+		t.Errorf("expected ErrPollNotFound in another conversation; got %v", err)
+	}
+	if err := db.VotePoll(ctx, group.ID, msg.ID, "user2", []int{0}); err != nil {
+		t.Fatalf("error voting: %v", err)
+	}
+	if err := db.VotePoll(ctx, group.ID, msg.ID, "user3", []int{0}); err != nil {
+		t.Fatalf("error voting: %v", err)
+	}
+	// Voting again replaces the previous vote
+	if err := db.VotePoll(ctx, group.ID, msg.ID, "user3", []int{1}); err != nil {
+		t.Fatalf("error changing vote: %v", err)
+	}
+
+	messages, err := db.GetConversationMessages(ctx, group.ID, "user2")
+	if err != nil {
+		t.Fatalf("error getting messages: %v", err)
+	}
+	// The group starts with the system messages of its creation
+	if len(messages) != 4 || messages[3].Poll == nil {
+		t.Fatalf("expected the poll after the system messages; got %+v", messages)
+	}
+	got := messages[3].Poll
+	if got.TotalVoters != 2 || got.Options[0].Votes != 1 || !got.Options[0].VotedByMe ||
+		got.Options[1].Votes != 1 || got.Options[1].VotedByMe || got.Options[2].Votes != 0 {
+		t.Errorf("unexpected tally %+v", got.Options)
+	}
+	if len(got.Options[1].Voters) != 1 || got.Options[1].Voters[0] != "carol" {
+		t.Errorf("expected carol among the voters of the second option; got %v", got.Options[1].Voters)
+	}
+
+	conversations, _, err := db.GetUserConversations(ctx, "user2", 50, "")
+	if err != nil {
+		t.Fatalf("error getting conversations: %v", err)
+	}
+	if len(conversations) != 1 || conversations[0].LastMessage != "📊 Lunch?" {
+		t.Errorf("expected the poll as last message; got %+v", conversations)
+	}
+
+	if err := db.ClosePoll(ctx, group.ID, msg.ID); err != nil {
+		t.Fatalf("error closing poll: %v", err)
+	}
+	if err := db.ClosePoll(ctx, group.ID, msg.ID); !errors.Is(err, ErrPollClosed) {
+		t.Errorf("expected ErrPollClosed closing twice; got %v", err)
+	}
+	if err := db.VotePoll(ctx, group.ID, msg.ID, "user1", []int{2}); !errors.Is(err, ErrPollClosed) {
+		t.Errorf("expected ErrPollClosed voting in a closed poll; got %v", err)
+	}
+	got, err = db.GetPoll(ctx, msg.ID, "user1")
+	if err != nil || !got.Closed || got.ClosedAt == nil || got.TotalVoters != 2 {
+		t.Errorf("expected the closed poll with its votes; got %+v, %v", got, err)
+	}
+
+	if err := db.DeleteMessage(ctx, msg.ID); err != nil {
+		t.Fatalf("error deleting poll: %v", err)
+	}
+	var left int
+	err = db.(*appdbimpl).c.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM polls) + (SELECT COUNT(*) FROM poll_options) + (SELECT COUNT(*) FROM poll_votes)
+	`).Scan(&left)
+	if err != nil || left != 0 {
+		t.Errorf("expected the poll to be deleted with the message; %d rows left, %v", left, err)
+	}
+}
+
+func TestPollClosesAt(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	closesAt := time.Now().Add(-time.Minute)
+	poll := Poll{Question: "Past?", Options: []PollOption{{Text: "Yes"}, {Text: "No"}}, MultipleChoice: true,
+		Anonymous: true, ClosesAt: &closesAt}
+	msg, err := db.CreatePoll(ctx, conversationID, "user1", poll)
+	if err != nil {
+		t.Fatalf("error creating poll: %v", err)
+	}
+	if err := db.VotePoll(ctx, conversationID, msg.ID, "user2", []int{0, 1}); !errors.Is(err, ErrPollClosed) {
+		t.Errorf("expected ErrPollClosed after the closing time; got %v", err)
+	}
+	got, err := db.GetPoll(ctx, msg.ID, "user2")
+	if err != nil || !got.Closed || got.ClosedAt == nil {
+		t.Errorf("expected the poll to be closed; got %+v, %v", got, err)
+	}
+
+	forwarded, err := forwardTo(db, msg.ID, "user2", conversationID)
+	if err != nil {
+		t.Fatalf("error forwarding poll: %v", err)
+	}
+	if forwarded.Kind != "poll" || forwarded.Poll == nil || forwarded.Poll.Closed || !forwarded.Poll.Anonymous {
+		t.Fatalf("expected an open copy of the poll; got %+v", forwarded.Poll)
+	}
+	if err := db.VotePoll(ctx, conversationID, forwarded.ID, "user1", []int{0, 1}); err != nil {
+		t.Fatalf("error voting in the forwarded poll: %v", err)
+	}
+	got, err = db.GetPoll(ctx, forwarded.ID, "user2")
+	if err != nil || got.Options[0].Votes != 1 || got.Options[0].VotedByMe || got.Options[0].Voters != nil {
+		t.Errorf("expected an anonymous vote; got %+v, %v", got, err)
+	}
+}
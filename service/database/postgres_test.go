@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRebindPlaceholders(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{
+			name:     "no placeholders",
+			query:    "SELECT 1",
+			expected: "SELECT 1",
+		},
+		{
+			name:     "sequential placeholders",
+			query:    "SELECT id FROM users WHERE username = ? AND id != ? LIMIT ?",
+			expected: "SELECT id FROM users WHERE username = $1 AND id != $2 LIMIT $3",
+		},
+		{
+			name:     "numbered placeholders",
+			query:    "SELECT 1 FROM messages WHERE image_url = ?2 AND sender = ?1 OR sender = ?1",
+			expected: "SELECT 1 FROM messages WHERE image_url = $2 AND sender = $1 OR sender = $1",
+		},
+		{
+			name:     "quoted strings and identifiers",
+			query:    `SELECT '?', "a?" FROM t WHERE x LIKE ? ESCAPE '\' AND y = 'it''s?' AND z = ?`,
+			expected: `SELECT '?', "a?" FROM t WHERE x LIKE $1 ESCAPE '\' AND y = 'it''s?' AND z = $2`,
+		},
+		{
+			name:     "comments",
+			query:    "SELECT ? -- isn't it?\nFROM t WHERE x = ?",
+			expected: "SELECT $1 -- isn't it?\nFROM t WHERE x = $2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rebindPlaceholders(tt.query); got != tt.expected {
+				t.Errorf("expected %q; got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestPostgresArguments(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		value    interface{}
+		expected driver.Value
+	}{
+		{true, int64(1)},
+		{false, int64(0)},
+		{42, int64(42)},
+		{"text", "text"},
+		{now, now},
+		{nil, nil},
+	}
+
+	var c postgresConn
+	for _, tt := range tests {
+		nv := driver.NamedValue{Value: tt.value}
+		if err := c.CheckNamedValue(&nv); err != nil {
+			t.Fatalf("error converting %v: %v", tt.value, err)
+		}
+		if nv.Value != tt.expected {
+			t.Errorf("expected %v to be sent as %#v; got %#v", tt.value, tt.expected, nv.Value)
+		}
+	}
+}
+
+func TestPostgresTables(t *testing.T) {
+	if strings.Contains(postgresSchema, "DATETIME") {
+		t.Errorf("expected no DATETIME columns in the PostgreSQL schema")
+	}
+	for _, table := range insertionOrdered {
+		create := regexp.MustCompile(`CREATE TABLE IF NOT EXISTS ` + table + ` \(\n\t\trowid BIGINT GENERATED`)
+		if len(create.FindAllString(postgresSchema, -1)) != 1 {
+			t.Errorf("expected a rowid in table %s", table)
+		}
+	}
+
+	// Apart from the types and the rowids, the tables are the ones of SQLite
+	back := strings.ReplaceAll(postgresSchema, "\t\trowid BIGINT GENERATED ALWAYS AS IDENTITY,\n", "")
+	if back = strings.ReplaceAll(back, "TIMESTAMPTZ", "DATETIME"); back != tablesSchema {
+		t.Errorf("expected the PostgreSQL schema to follow the SQLite one; got\n%s", postgresSchema)
+	}
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPresence(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	lastSeen := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	if err := db.UpdateLastSeen(ctx, "user1", lastSeen); err != nil {
+		t.Fatalf("error updating last seen: %v", err)
+	}
+	if err := db.SetPrivacy(ctx, "user2", VisibleToEveryone, VisibleToNobody, VisibleToEveryone); err != nil {
+		t.Fatalf("error updating privacy: %v", err)
+	}
+	err = db.SetPrivacy(ctx, "fake_user", VisibleToEveryone, VisibleToNobody, VisibleToEveryone)
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound for non-existent user; got %v", err)
+	}
+	err = db.SetPrivacy(ctx, "user2", VisibleToEveryone, "hidden", VisibleToEveryone)
+	if !errors.Is(err, ErrInvalidProfile) {
+		t.Errorf("expected ErrInvalidProfile for unknown visibility; got %v", err)
+	}
+
+	details, err := db.GetConversationDetails(ctx, conversationID)
+	if err != nil {
+		t.Fatalf("error getting details: %v", err)
+	}
+	if len(details.Participants) != 2 {
+		t.Fatalf("expected 2 participants; got %d", len(details.Participants))
+	}
+
+	alice, bob := details.Participants[0], details.Participants[1]
+	if alice.Username != "alice" || alice.LastSeenVisibility != VisibleToEveryone || alice.LastSeen == nil ||
+		!alice.LastSeen.Equal(lastSeen) {
+		t.Errorf("expected alice showing last seen at %v; got %+v", lastSeen, alice)
+	}
+	if bob.Username != "bob" || bob.LastSeenVisibility != VisibleToNobody || bob.LastSeen != nil {
+		t.Errorf("expected bob hiding last seen and never seen; got %+v", bob)
+	}
+}
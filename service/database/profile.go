@@ -0,0 +1,240 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxDisplayNameLength and maxBioLength are measured in characters, not bytes
+	maxDisplayNameLength = 32
+	maxBioLength         = 140
+
+	// avatarHistoryLength is how many previous profile photos a profile shows
+	avatarHistoryLength = 10
+)
+
+// ErrInvalidProfile is returned when a display name, bio or privacy setting is not acceptable
+var ErrInvalidProfile = errors.New("invalid profile")
+
+// UpdateProfile changes the display name and bio of a user. Unlike usernames, display names can contain any printable
+// Unicode character. An empty display name falls back to the username.
+func (db *appdbimpl) UpdateProfile(ctx context.Context, userID string, displayName string, bio string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	displayName = strings.TrimSpace(displayName)
+	bio = strings.TrimSpace(bio)
+
+	if err := validateProfileText("display name", displayName, maxDisplayNameLength); err != nil {
+		return err
+	}
+	if err := validateProfileText("bio", bio, maxBioLength); err != nil {
+		return err
+	}
+
+	result, err := db.c.ExecContext(ctx, `
+        UPDATE users SET display_name = NULLIF(?, ''), bio = NULLIF(?, '')
+        WHERE id = ?
+    `, displayName, bio, userID)
+	if err != nil {
+		return fmt.Errorf("error updating profile: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// validateProfileText checks that a profile field is valid UTF-8, short enough and without control characters
+func validateProfileText(field string, text string, maxLength int) error {
+	if !utf8.ValidString(text) {
+		return fmt.Errorf("%w: %s must be valid UTF-8", ErrInvalidProfile, field)
+	}
+	if utf8.RuneCountInString(text) > maxLength {
+		return fmt.Errorf("%w: %s must be at most %d characters", ErrInvalidProfile, field, maxLength)
+	}
+	for _, r := range text {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: %s cannot contain control characters", ErrInvalidProfile, field)
+		}
+	}
+	return nil
+}
+
+// SetPrivacy changes who can see the photo and the last seen time of a user, and who is told the user sent the
+// messages others forward
+func (db *appdbimpl) SetPrivacy(ctx context.Context, userID string, photoVisibility string, lastSeenVisibility string,
+	forwardVisibility string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	for _, visibility := range []string{photoVisibility, lastSeenVisibility, forwardVisibility} {
+		switch visibility {
+		case VisibleToEveryone, VisibleToContacts, VisibleToNobody:
+		default:
+			return fmt.Errorf("%w: unknown visibility %q", ErrInvalidProfile, visibility)
+		}
+	}
+
+	result, err := db.c.ExecContext(ctx, `
+        UPDATE users SET photo_visibility = ?, last_seen_visibility = ?, forward_visibility = ?
+        WHERE id = ?
+    `, photoVisibility, lastSeenVisibility, forwardVisibility, userID)
+	if err != nil {
+		return fmt.Errorf("error updating privacy settings: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// AreContacts checks if two users share at least one conversation or group
+func (db *appdbimpl) AreContacts(ctx context.Context, userID string, otherID string) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var contacts bool
+	err := db.r.QueryRowContext(ctx, `
+        SELECT EXISTS(
+            SELECT 1 FROM conversation_participants a
+            JOIN conversation_participants b ON a.conversation_id = b.conversation_id
+            WHERE a.user_id = ? AND b.user_id = ?
+            UNION ALL
+            SELECT 1 FROM group_members a
+            JOIN group_members b ON a.group_id = b.group_id
+            WHERE a.user_id = ? AND b.user_id = ?
+        )
+    `, userID, otherID, userID, otherID).Scan(&contacts)
+	if err != nil {
+		return false, fmt.Errorf("error checking contacts: %w", err)
+	}
+	return contacts, nil
+}
+
+// GetProfile returns the profile of a user, including the recent avatar history
+func (db *appdbimpl) GetProfile(ctx context.Context, username string) (*Profile, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var profile Profile
+	var photoURL, bio, lastSeen sql.NullString
+	err := db.r.QueryRowContext(ctx, `
+        SELECT id, username, COALESCE(display_name, username), bio, photo_url,
+               `+db.dialect.formatTime("last_seen")+`, photo_visibility, last_seen_visibility
+        FROM users
+        WHERE username = ?
+    `, username).Scan(&profile.UserID, &profile.Username, &profile.DisplayName, &bio, &photoURL, &lastSeen,
+		&profile.PhotoVisibility, &profile.LastSeenVisibility)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting profile: %w", err)
+	}
+
+	profile.Bio = bio.String
+	profile.PhotoURL = photoURL.String
+	profile.LastSeen, err = parseNullTimestamp(lastSeen)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT photo_url, `+db.dialect.formatTime("set_at")+`
+        FROM avatar_history
+        WHERE user_id = ?
+        ORDER BY set_at DESC
+        LIMIT ?
+    `, profile.UserID, avatarHistoryLength)
+	if err != nil {
+		return nil, fmt.Errorf("error getting avatar history: %w", err)
+	}
+	defer rows.Close()
+
+	profile.AvatarHistory = make([]Avatar, 0)
+	for rows.Next() {
+		var avatar Avatar
+		var setAt string
+		if err := rows.Scan(&avatar.PhotoURL, &setAt); err != nil {
+			return nil, fmt.Errorf("error scanning avatar: %w", err)
+		}
+		avatar.SetAt, err = time.Parse("2006-01-02 15:04:05", setAt)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing timestamp: %w", err)
+		}
+		profile.AvatarHistory = append(profile.AvatarHistory, avatar)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating avatar history: %w", err)
+	}
+
+	return &profile, nil
+}
+
+// getParticipants returns the profile data of every participant of a conversation or group
+func (db *appdbimpl) getParticipants(ctx context.Context, conversationID string) ([]Participant, error) {
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT u.id, u.username, COALESCE(u.display_name, u.username), COALESCE(u.photo_url, ''),
+               `+db.dialect.formatTime("u.last_seen")+`, u.is_bot, u.photo_visibility, u.last_seen_visibility
+        FROM users u
+        WHERE u.id IN (
+            SELECT user_id FROM conversation_participants WHERE conversation_id = ?
+            UNION
+            SELECT user_id FROM group_members WHERE group_id = ?
+        )
+        ORDER BY u.username
+    `, conversationID, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting participants: %w", err)
+	}
+	defer rows.Close()
+
+	participants := make([]Participant, 0)
+	for rows.Next() {
+		var p Participant
+		var lastSeen sql.NullString
+		if err := rows.Scan(&p.UserID, &p.Username, &p.DisplayName, &p.PhotoURL, &lastSeen, &p.IsBot,
+			&p.PhotoVisibility, &p.LastSeenVisibility); err != nil {
+			return nil, fmt.Errorf("error scanning participant: %w", err)
+		}
+		p.LastSeen, err = parseNullTimestamp(lastSeen)
+		if err != nil {
+			return nil, err
+		}
+		participants = append(participants, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating participants: %w", err)
+	}
+	return participants, nil
+}
+
+// parseNullTimestamp parses a timestamp selected with the formatTime of the dialect, returning nil for NULL
+func parseNullTimestamp(s sql.NullString) (*time.Time, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	t, err := time.Parse("2006-01-02 15:04:05", s.String)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing timestamp: %w", err)
+	}
+	return &t, nil
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES ('user1', 'alice', 'token1')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test user: %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		displayName     string
+		bio             string
+		expectError     bool
+		expectedDisplay string
+	}{
+		{name: "unicode display name", displayName: "  Álice 🌸 ", bio: "Hola", expectedDisplay: "Álice 🌸"},
+		{name: "empty display name falls back to username", displayName: "", bio: "", expectedDisplay: "alice"},
+		{name: "display name too long", displayName: strings.Repeat("a", 33), expectError: true},
+		{name: "display name with control characters", displayName: "ali\nce", expectError: true},
+		{name: "bio too long", displayName: "Alice", bio: strings.Repeat("é", 141), expectError: true},
+		{name: "invalid UTF-8", displayName: "ali\xffce", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := db.UpdateProfile(ctx, "user1", tt.displayName, tt.bio)
+			if tt.expectError {
+				if !errors.Is(err, ErrInvalidProfile) {
+					t.Errorf("expected ErrInvalidProfile; got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			profile, err := db.GetProfile(ctx, "alice")
+			if err != nil {
+				t.Fatalf("error getting profile: %v", err)
+			}
+			if profile.DisplayName != tt.expectedDisplay {
+				t.Errorf("expected display name %q; got %q", tt.expectedDisplay, profile.DisplayName)
+			}
+		})
+	}
+
+	if err := db.UpdateProfile(ctx, "fake_user", "Name", ""); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound; got %v", err)
+	}
+}
+
+func TestGetProfile(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2'),
+		('user3', 'carol', 'token3')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+
+	for _, photo := range []string{"/uploads/1.png", "/uploads/2.png"} {
+		if err := db.UpdateUserPhoto(ctx, "user1", photo); err != nil {
+			t.Fatalf("error updating photo: %v", err)
+		}
+	}
+
+	profile, err := db.GetProfile(ctx, "alice")
+	if err != nil {
+		t.Fatalf("error getting profile: %v", err)
+	}
+	if profile.PhotoURL != "/uploads/2.png" {
+		t.Errorf("expected current photo /uploads/2.png; got %q", profile.PhotoURL)
+	}
+	if len(profile.AvatarHistory) != 2 {
+		t.Errorf("expected 2 avatars in history; got %+v", profile.AvatarHistory)
+	}
+	if profile.PhotoVisibility != VisibleToEveryone || profile.LastSeenVisibility != VisibleToEveryone {
+		t.Errorf("expected default visibility everyone; got %q and %q",
+			profile.PhotoVisibility, profile.LastSeenVisibility)
+	}
+
+	if _, err := db.GetProfile(ctx, "nobody"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound; got %v", err)
+	}
+
+	if err := db.SetPrivacy(ctx, "user1", VisibleToContacts, VisibleToNobody, VisibleToEveryone); err != nil {
+		t.Fatalf("error setting privacy: %v", err)
+	}
+	if err := db.SetPrivacy(ctx, "user1", "friends", VisibleToNobody,
+		VisibleToEveryone); !errors.Is(err, ErrInvalidProfile) {
+		t.Errorf("expected ErrInvalidProfile; got %v", err)
+	}
+	profile, err = db.GetProfile(ctx, "alice")
+	if err != nil {
+		t.Fatalf("error getting profile: %v", err)
+	}
+	if profile.PhotoVisibility != VisibleToContacts || profile.LastSeenVisibility != VisibleToNobody {
+		t.Errorf("expected contacts and nobody; got %q and %q", profile.PhotoVisibility, profile.LastSeenVisibility)
+	}
+
+	if _, err := db.CreateConversation(ctx, []string{"alice", "bob"}); err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	if contacts, err := db.AreContacts(ctx, "user1", "user2"); err != nil || !contacts {
+		t.Errorf("expected alice and bob to be contacts; got %v, %v", contacts, err)
+	}
+	if contacts, err := db.AreContacts(ctx, "user1", "user3"); err != nil || contacts {
+		t.Errorf("expected alice and carol not to be contacts; got %v, %v", contacts, err)
+	}
+}
+
+func TestConversationParticipants(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token, display_name) VALUES
+		('user1', 'alice', 'token1', 'Alice'),
+		('user2', 'bob', 'token2', NULL)
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	lastSeen := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	if err := db.UpdateLastSeen(ctx, "user1", lastSeen); err != nil {
+		t.Fatalf("error updating last seen: %v", err)
+	}
+
+	details, err := db.GetConversationDetails(ctx, conversationID)
+	if err != nil {
+		t.Fatalf("error getting details: %v", err)
+	}
+	if len(details.Participants) != 2 {
+		t.Fatalf("expected 2 participants; got %d", len(details.Participants))
+	}
+
+	alice, bob := details.Participants[0], details.Participants[1]
+	if alice.UserID != "user1" || alice.DisplayName != "Alice" || alice.LastSeen == nil || !alice.LastSeen.Equal(lastSeen) {
+		t.Errorf("expected alice last seen at %v; got %+v", lastSeen, alice)
+	}
+	if bob.Username != "bob" || bob.DisplayName != "bob" || bob.LastSeen != nil {
+		t.Errorf("expected bob with username as display name and never seen; got %+v", bob)
+	}
+}
@@ -1,30 +1,41 @@
 package database
 
 import (
+	"context"
 	"errors"
 	"fmt"
 )
 
-// AddReaction añade una reacción a un mensaje
-func (db *appdbimpl) AddReaction(messageID string, userID string, reaction string) error {
-	// Validar longitud de la reacción
-	if len(reaction) < 1 || len(reaction) > 5 {
-		return errors.New("reaction must be between 1 and 5 characters")
+// ErrInvalidReaction is returned when a reaction is neither a single emoji nor one of the legacy emoticons
+var ErrInvalidReaction = errors.New("reaction must be a single emoji")
+
+// ErrReactionNotFound is returned by RemoveReaction when the user did not leave that reaction on the message
+var ErrReactionNotFound = errors.New("reaction not found")
+
+// AddReaction añade una reacción a un mensaje. A user can leave several different reactions on the same message;
+// adding one that is already there does nothing.
+func (db *appdbimpl) AddReaction(ctx context.Context, messageID string, userID string, reaction string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if !isValidReaction(reaction) {
+		return ErrInvalidReaction
 	}
 
 	// Verificar que el mensaje existe
-	exists, err := db.messageExists(messageID)
+	exists, err := db.messageExists(ctx, messageID)
 	if err != nil {
 		return err
 	}
 	if !exists {
-		return errors.New("message not found")
+		return ErrMessageNotFound
 	}
 
 	// Insertar reacción
-	_, err = db.c.Exec(`
-        INSERT OR REPLACE INTO reactions (message_id, user_id, reaction)
+	_, err = db.c.ExecContext(ctx, `
+        INSERT INTO reactions (message_id, user_id, reaction)
         VALUES (?, ?, ?)
+        ON CONFLICT DO NOTHING
     `, messageID, userID, reaction)
 
 	if err != nil {
@@ -34,12 +45,15 @@ func (db *appdbimpl) AddReaction(messageID string, userID string, reaction strin
 	return nil
 }
 
-// RemoveReaction elimina una reacción de un mensaje
-func (db *appdbimpl) RemoveReaction(messageID string, userID string) error {
-	result, err := db.c.Exec(`
+// RemoveReaction elimina una reacción concreta de un usuario en un mensaje
+func (db *appdbimpl) RemoveReaction(ctx context.Context, messageID string, userID string, reaction string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.c.ExecContext(ctx, `
         DELETE FROM reactions
-        WHERE message_id = ? AND user_id = ?
-    `, messageID, userID)
+        WHERE message_id = ? AND user_id = ? AND reaction = ?
+    `, messageID, userID, reaction)
 
 	if err != nil {
 		return fmt.Errorf("error removing reaction: %w", err)
@@ -51,16 +65,48 @@ func (db *appdbimpl) RemoveReaction(messageID string, userID string) error {
 	}
 
 	if rows == 0 {
-		return errors.New("reaction not found")
+		return ErrReactionNotFound
 	}
 
 	return nil
 }
 
+// getConversationReactions returns the reactions of every message of a conversation, grouped by emoji in the order
+// they were first added, with whether viewerID is among the users who reacted
+func (db *appdbimpl) getConversationReactions(ctx context.Context, conversationID string,
+	viewerID string) (map[string][]Reaction, error) {
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT r.message_id, r.reaction, COUNT(*), MAX(CASE WHEN r.user_id = ? THEN 1 ELSE 0 END)
+        FROM reactions r
+        JOIN messages m ON m.id = r.message_id
+        WHERE m.conversation_id = ?
+        GROUP BY r.message_id, r.reaction
+        ORDER BY MIN(r.rowid)
+    `, viewerID, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting reactions: %w", err)
+	}
+	defer rows.Close()
+
+	reactions := make(map[string][]Reaction)
+	for rows.Next() {
+		var messageID string
+		var reaction Reaction
+		if err := rows.Scan(&messageID, &reaction.Emoji, &reaction.Count, &reaction.ReactedByMe); err != nil {
+			return nil, fmt.Errorf("error scanning reaction: %w", err)
+		}
+		reactions[messageID] = append(reactions[messageID], reaction)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reactions: %w", err)
+	}
+	return reactions, nil
+}
+
 // messageExists verifica si un mensaje existe
-func (db *appdbimpl) messageExists(messageID string) (bool, error) {
+func (db *appdbimpl) messageExists(ctx context.Context, messageID string) (bool, error) {
 	var exists bool
-	err := db.c.QueryRow(`
+	err := db.r.QueryRowContext(ctx, `
         SELECT EXISTS(
             SELECT 1 FROM messages 
             WHERE id = ?
@@ -1,12 +1,16 @@
 package database
 
 import (
+	"context"
+	"errors"
+	"reflect"
 	"testing"
 	"time"
 )
 
 func TestAddReaction(t *testing.T) {
 	db := setupTestDB(t)
+	ctx := context.Background()
 
 	// Crear usuario y mensaje de prueba
 	_, err := db.(*appdbimpl).c.Exec(`
@@ -59,11 +63,18 @@ func TestAddReaction(t *testing.T) {
 			reaction:    "",
 			expectError: true,
 		},
+		{
+			name:        "multi-codepoint emoji",
+			messageID:   "msg1",
+			userID:      "user1",
+			reaction:    "👨‍👩‍👧‍👦",
+			expectError: false,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := db.AddReaction(tt.messageID, tt.userID, tt.reaction)
+			err := db.AddReaction(ctx, tt.messageID, tt.userID, tt.reaction)
 			if tt.expectError && err == nil {
 				t.Error("expected error but got none")
 			}
@@ -76,6 +87,7 @@ func TestAddReaction(t *testing.T) {
 
 func TestRemoveReaction(t *testing.T) {
 	db := setupTestDB(t)
+	ctx := context.Background()
 
 	// Crear datos de prueba
 	_, err := db.(*appdbimpl).c.Exec(`
@@ -94,7 +106,7 @@ func TestRemoveReaction(t *testing.T) {
 	}
 
 	// Añadir una reacción para probar su eliminación
-	err = db.AddReaction("msg1", "user1", "<3")
+	err = db.AddReaction(ctx, "msg1", "user1", "<3")
 	if err != nil {
 		t.Fatalf("error adding test reaction: %v", err)
 	}
@@ -121,7 +133,7 @@ func TestRemoveReaction(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := db.RemoveReaction(tt.messageID, tt.userID)
+			err := db.RemoveReaction(ctx, tt.messageID, tt.userID, "<3")
 			if tt.expectError && err == nil {
 				t.Error("expected error but got none")
 			}
@@ -131,3 +143,88 @@ func TestRemoveReaction(t *testing.T) {
 		})
 	}
 }
+
+func TestIsValidReaction(t *testing.T) {
+	tests := []struct {
+		reaction string
+		valid    bool
+	}{
+		{reaction: "👍", valid: true},
+		{reaction: "👍🏽", valid: true},
+		{reaction: "❤️", valid: true},
+		{reaction: "🇮🇹", valid: true},
+		{reaction: "🏴󠁧󠁢󠁥󠁮󠁧󠁿", valid: true},
+		{reaction: "#️⃣", valid: true},
+		{reaction: "👩🏻‍❤️‍💋‍👨🏼", valid: true},
+		{reaction: ":D", valid: true},
+		{reaction: "👍👍", valid: false},
+		{reaction: "🇮", valid: false},
+		{reaction: "a", valid: false},
+		{reaction: "‍👍", valid: false},
+		{reaction: "👍‍", valid: false},
+		{reaction: "👨‍👩‍👧‍👦‍👦", valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.reaction, func(t *testing.T) {
+			if got := isValidReaction(tt.reaction); got != tt.valid {
+				t.Errorf("expected valid=%v; got %v", tt.valid, got)
+			}
+		})
+	}
+}
+
+func TestReactionGroups(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2');
+		INSERT INTO messages (id, conversation_id, sender, content, timestamp)
+		VALUES ('msg1', 'conv1', 'user1', 'test message', '2024-01-01 10:00:00');
+	`)
+	if err != nil {
+		t.Fatalf("error creating test data: %v", err)
+	}
+
+	for _, r := range []struct{ userID, emoji string }{
+		{"user1", "👍"}, {"user1", "🎉"}, {"user2", "👍"}, {"user2", "👍"},
+	} {
+		if err := db.AddReaction(ctx, "msg1", r.userID, r.emoji); err != nil {
+			t.Fatalf("error adding reaction: %v", err)
+		}
+	}
+
+	messages, err := db.GetConversationMessages(ctx, "conv1", "user2")
+	if err != nil {
+		t.Fatalf("error getting messages: %v", err)
+	}
+	expected := []Reaction{
+		{Emoji: "👍", Count: 2, ReactedByMe: true},
+		{Emoji: "🎉", Count: 1, ReactedByMe: false},
+	}
+	if len(messages) != 1 || !reflect.DeepEqual(messages[0].Reactions, expected) {
+		t.Fatalf("expected reactions %+v; got %+v", expected, messages)
+	}
+
+	// Removing one reaction keeps the others of the same user; groups follow the oldest remaining reaction
+	if err := db.RemoveReaction(ctx, "msg1", "user1", "👍"); err != nil {
+		t.Fatalf("error removing reaction: %v", err)
+	}
+	if err := db.RemoveReaction(ctx, "msg1", "user1", "👍"); !errors.Is(err, ErrReactionNotFound) {
+		t.Errorf("expected the reaction not found when removed twice; got %v", err)
+	}
+	messages, err = db.GetConversationMessages(ctx, "conv1", "user1")
+	if err != nil {
+		t.Fatalf("error getting messages: %v", err)
+	}
+	expected = []Reaction{
+		{Emoji: "🎉", Count: 1, ReactedByMe: true},
+		{Emoji: "👍", Count: 1, ReactedByMe: false},
+	}
+	if !reflect.DeepEqual(messages[0].Reactions, expected) {
+		t.Errorf("expected reactions %+v; got %+v", expected, messages[0].Reactions)
+	}
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// SetMessageTTL changes how many seconds new messages live in a conversation (0 turns disappearing messages off) and
+// posts a system message in the name of the actor announcing the change
+func (db *appdbimpl) SetMessageTTL(ctx context.Context, conversationID string, actorID string, ttl int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if ttl < 0 {
+		return errors.New("message TTL cannot be negative")
+	}
+
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO conversation_settings (conversation_id, message_ttl, updated_at)
+        VALUES (?, ?, ?)
+        ON CONFLICT (conversation_id) DO UPDATE SET message_ttl = excluded.message_ttl, updated_at = excluded.updated_at
+    `, conversationID, ttl, now)
+	if err != nil {
+		return fmt.Errorf("error updating message TTL: %w", err)
+	}
+
+	var actor string
+	if err := tx.QueryRowContext(ctx, "SELECT username FROM users WHERE id = ?", actorID).Scan(&actor); err != nil {
+		return fmt.Errorf("error getting actor username: %w", err)
+	}
+
+	content := fmt.Sprintf("%s turned off disappearing messages", actor)
+	if ttl > 0 {
+		content = fmt.Sprintf("%s set disappearing messages to %s", actor, formatTTL(ttl))
+	}
+	if err := insertSystemMessage(ctx, tx, conversationID, actorID, SystemEventMessageTTLChanged, content,
+		now); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// getMessageTTL returns the message TTL of a conversation in seconds, 0 if disappearing messages are off
+func (db *appdbimpl) getMessageTTL(ctx context.Context, conversationID string) (int64, error) {
+	var ttl int64
+	err := db.r.QueryRowContext(ctx, `
+        SELECT message_ttl FROM conversation_settings WHERE conversation_id = ?
+    `, conversationID).Scan(&ttl)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error getting message TTL: %w", err)
+	}
+	return ttl, nil
+}
+
+// DeleteExpiredMessages hard-deletes messages older than their conversation TTL, together with their reactions,
+// pins, mentions and attachments. Messages sent before the TTL was set expire too. Their files are left to the media
+// garbage collection, as other messages, profiles or groups may share them.
+func (db *appdbimpl) DeleteExpiredMessages(ctx context.Context, now time.Time) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.c.QueryContext(ctx, `
+        SELECT m.id
+        FROM messages m
+        JOIN conversation_settings s ON s.conversation_id = m.conversation_id
+        WHERE s.message_ttl > 0
+          AND `+db.dialect.addSeconds("m.timestamp", "s.message_ttl")+` <= `+db.dialect.timeValue("?")+`
+    `, now)
+	if err != nil {
+		return fmt.Errorf("error getting expired messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messageIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("error scanning expired message: %w", err)
+		}
+		messageIDs = append(messageIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating expired messages: %w", err)
+	}
+	_ = rows.Close()
+
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
+
+	if err := db.deleteMessages(ctx, tx, messageIDs); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	log.Printf("Deleted %d expired messages", len(messageIDs))
+	return nil
+}
+
+// formatTTL renders a TTL in seconds with the largest whole unit, e.g. 86400 as "24h" and 604800 as "7d"
+func formatTTL(ttl int64) string {
+	switch {
+	case ttl%86400 == 0 && ttl > 86400:
+		return fmt.Sprintf("%dd", ttl/86400)
+	case ttl%3600 == 0:
+		return fmt.Sprintf("%dh", ttl/3600)
+	case ttl%60 == 0:
+		return fmt.Sprintf("%dm", ttl/60)
+	default:
+		return fmt.Sprintf("%ds", ttl)
+	}
+}
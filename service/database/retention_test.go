@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSetMessageTTL(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'test_user1', 'token1'),
+		('user2', 'test_user2', 'token2')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+
+	conversationID, err := db.CreateConversation(ctx, []string{"test_user1", "test_user2"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	if err := db.SetMessageTTL(ctx, conversationID, "user1", 24*60*60); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	details, err := db.GetConversationDetails(ctx, conversationID)
+	if err != nil {
+		t.Fatalf("error getting details: %v", err)
+	}
+	if details.MessageTTL != 24*60*60 {
+		t.Errorf("expected message TTL %d; got %d", 24*60*60, details.MessageTTL)
+	}
+
+	messages, err := db.GetConversationMessages(ctx, conversationID, "user1")
+	if err != nil {
+		t.Fatalf("error getting messages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Kind != "system" {
+		t.Fatalf("expected one system message; got %+v", messages)
+	}
+	if messages[0].ContentStr != "test_user1 set disappearing messages to 24h" {
+		t.Errorf("unexpected system message %q", messages[0].ContentStr)
+	}
+
+	if err := db.SetMessageTTL(ctx, conversationID, "user1", -1); err == nil {
+		t.Error("expected error for negative TTL but got none")
+	}
+}
+
+func TestDeleteExpiredMessages(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	c := db.(*appdbimpl).c
+
+	_, err := c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+
+	now := time.Now()
+	_, err = c.Exec(`
+		INSERT INTO conversation_settings (conversation_id, message_ttl, updated_at) VALUES
+		('conv1', 3600, ?)
+	`, now.Add(-3*time.Hour))
+	if err != nil {
+		t.Fatalf("error inserting settings: %v", err)
+	}
+
+	_, err = c.Exec(`
+		INSERT INTO messages (id, conversation_id, sender, content, image_url, timestamp) VALUES
+		('before_ttl', 'conv1', 'user1', 'sent before the TTL was set', NULL, ?),
+		('expired', 'conv1', 'user1', NULL, '/uploads/images/expired.png', ?),
+		('shared', 'conv1', 'user1', NULL, '/uploads/images/shared.png', ?),
+		('fresh', 'conv1', 'user1', 'still visible', NULL, ?),
+		('forwarded', 'conv2', 'user1', NULL, '/uploads/images/shared.png', ?),
+		('no_ttl', 'conv2', 'user1', 'no TTL here', NULL, ?)
+	`, now.Add(-4*time.Hour), now.Add(-2*time.Hour), now.Add(-2*time.Hour),
+		now.Add(-30*time.Minute), now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+	if err != nil {
+		t.Fatalf("error inserting messages: %v", err)
+	}
+
+	_, err = c.Exec(`INSERT INTO reactions (message_id, user_id, reaction) VALUES ('expired', 'user2', '<3')`)
+	if err != nil {
+		t.Fatalf("error inserting reaction: %v", err)
+	}
+
+	if err := db.DeleteExpiredMessages(ctx, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	refs, err := db.GetMediaReferences(ctx)
+	if err != nil {
+		t.Fatalf("error getting media references: %v", err)
+	}
+	if refs["uploads/images/expired.png"] != 0 || refs["uploads/images/shared.png"] != 1 {
+		t.Errorf("expected only expired.png to be unreferenced; got %v", refs)
+	}
+
+	for id, expectExists := range map[string]bool{
+		"before_ttl": false, // enabling a TTL expires older messages as well
+		"expired":    false,
+		"shared":     false,
+		"fresh":      true,
+		"forwarded":  true,
+		"no_ttl":     true,
+	} {
+		exists, err := db.(*appdbimpl).messageExists(ctx, id)
+		if err != nil {
+			t.Fatalf("error checking message %s: %v", id, err)
+		}
+		if exists != expectExists {
+			t.Errorf("message %s: expected exists=%v; got %v", id, expectExists, exists)
+		}
+	}
+
+	var reactions int
+	if err := c.QueryRow(`SELECT COUNT(*) FROM reactions`).Scan(&reactions); err != nil {
+		t.Fatalf("error counting reactions: %v", err)
+	}
+	if reactions != 0 {
+		t.Errorf("expected reactions of expired messages to be deleted; got %d", reactions)
+	}
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidCursor is returned when a paging cursor was not produced by a previous search
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// MinSearchQueryLength is how many characters a search needs, so that paging through short queries cannot list the
+// whole directory
+const MinSearchQueryLength = 2
+
+// ErrSearchQueryTooShort is returned for a search shorter than MinSearchQueryLength, spaces aside
+var ErrSearchQueryTooShort = fmt.Errorf("search must have at least %d characters", MinSearchQueryLength)
+
+// likeEscaper escapes the LIKE wildcards, since "_" is common in usernames
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchUsers finds users whose username or display name contains query, case-insensitively. The caller, bots and
+// users with a block in either direction are left out. Contacts come first, then prefix matches, then the rest, each
+// group sorted by username. It returns at most limit users and the cursor of the next page, empty on the last page.
+func (db *appdbimpl) SearchUsers(ctx context.Context, userID string, query string, limit int,
+	cursor string) ([]UserSummary, string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if limit <= 0 {
+		return nil, "", errors.New("limit must be positive")
+	}
+	query = strings.TrimSpace(query)
+	if utf8.RuneCountInString(query) < MinSearchQueryLength {
+		return nil, "", ErrSearchQueryTooShort
+	}
+
+	// Ranks go from 0 to 3, so the first page starts below 4
+	afterRank, afterUsername := 4, ""
+	if cursor != "" {
+		var err error
+		afterRank, afterUsername, err = decodeSearchCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	escaped := likeEscaper.Replace(query)
+	prefix := escaped + "%"
+	substring := "%" + escaped + "%"
+
+	like := db.dialect.likeNoCase()
+	rows, err := db.r.QueryContext(ctx, `
+        WITH candidates AS (
+            SELECT u.id, u.username,
+                   COALESCE(u.display_name, u.username) AS display_name,
+                   COALESCE(u.photo_url, '') AS photo_url,
+                   u.photo_visibility,
+                   CASE WHEN EXISTS(
+                       SELECT 1 FROM conversation_participants a
+                       JOIN conversation_participants b ON a.conversation_id = b.conversation_id
+                       WHERE a.user_id = ? AND b.user_id = u.id
+                   ) OR EXISTS(
+                       SELECT 1 FROM group_members a
+                       JOIN group_members b ON a.group_id = b.group_id
+                       WHERE a.user_id = ? AND b.user_id = u.id
+                   ) THEN 1 ELSE 0 END AS is_contact,
+                   CASE WHEN u.username `+like+` ? ESCAPE '\' OR COALESCE(u.display_name, '') `+like+` ? ESCAPE '\'
+                        THEN 1 ELSE 0 END AS is_prefix
+            FROM users u
+            WHERE u.id != ?
+              AND u.is_bot = 0
+              AND u.id NOT IN (SELECT blocked_id FROM blocks WHERE blocker_id = ?)
+              AND u.id NOT IN (SELECT blocker_id FROM blocks WHERE blocked_id = ?)
+              AND (u.username `+like+` ? ESCAPE '\' OR COALESCE(u.display_name, '') `+like+` ? ESCAPE '\')
+        ), ranked AS (
+            SELECT id, username, display_name, photo_url, photo_visibility, is_contact,
+                   is_contact * 2 + is_prefix AS rank
+            FROM candidates
+        )
+        SELECT id, username, display_name, photo_url, photo_visibility, is_contact, rank
+        FROM ranked
+        WHERE rank < ? OR (rank = ? AND username > ?)
+        ORDER BY rank DESC, username
+        LIMIT ?
+    `, userID, userID, prefix, prefix, userID, userID, userID, substring, substring,
+		afterRank, afterRank, afterUsername, limit+1)
+	if err != nil {
+		return nil, "", fmt.Errorf("error searching users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]UserSummary, 0)
+	var ranks []int
+	for rows.Next() {
+		var u UserSummary
+		var rank int
+		if err := rows.Scan(&u.UserID, &u.Username, &u.DisplayName, &u.PhotoURL, &u.PhotoVisibility,
+			&u.IsContact, &rank); err != nil {
+			return nil, "", fmt.Errorf("error scanning user: %w", err)
+		}
+		users = append(users, u)
+		ranks = append(ranks, rank)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("error iterating users: %w", err)
+	}
+
+	// The extra row only tells whether there is another page
+	if len(users) <= limit {
+		return users, "", nil
+	}
+	users = users[:limit]
+	return users, encodeSearchCursor(ranks[limit-1], users[limit-1].Username), nil
+}
+
+// encodeSearchCursor packs the sort key of the last user of a page
+func encodeSearchCursor(rank int, username string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(rank) + ":" + username))
+}
+
+// decodeSearchCursor unpacks a cursor made by encodeSearchCursor
+func decodeSearchCursor(cursor string) (int, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return 0, "", ErrInvalidCursor
+	}
+	rank, err := strconv.Atoi(parts[0])
+	if err != nil || rank < 0 || rank > 3 {
+		return 0, "", ErrInvalidCursor
+	}
+	return rank, parts[1], nil
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSearchUsers(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token, display_name) VALUES
+		('user1', 'alice', 'token1', NULL),
+		('user2', 'bob', 'token2', NULL),
+		('user3', 'bobby', 'token3', NULL),
+		('user4', 'rob_b', 'token4', NULL),
+		('user5', 'carol', 'token5', 'Bo Carol'),
+		('user6', 'jimbo', 'token6', NULL),
+		('user7', 'lobo', 'token7', NULL)
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+
+	// jimbo is a contact of alice, so he comes first even though he only matches in the middle
+	if _, err := db.CreateConversation(ctx, []string{"alice", "jimbo"}); err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{name: "contacts, then prefix, then substring", query: "BO", expected: []string{"jimbo", "bob", "bobby", "carol", "lobo"}},
+		{name: "underscore is not a wildcard", query: "ob_", expected: []string{"rob_b"}},
+		{name: "no match", query: "zed", expected: []string{}},
+		{name: "surrounding spaces are ignored", query: " lo ", expected: []string{"lobo"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, next, err := db.SearchUsers(ctx, "user1", tt.query, 10, "")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if next != "" {
+				t.Errorf("expected a single page; got cursor %q", next)
+			}
+			got := make([]string, 0, len(users))
+			for _, u := range users {
+				got = append(got, u.Username)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v; got %v", tt.expected, got)
+			}
+		})
+	}
+
+	// Paging through two at a time returns every match once, in order
+	var paged []string
+	cursor := ""
+	for pages := 0; pages < 5; pages++ {
+		users, next, err := db.SearchUsers(ctx, "user1", "bo", 2, cursor)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, u := range users {
+			paged = append(paged, u.Username)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if expected := []string{"jimbo", "bob", "bobby", "carol", "lobo"}; !reflect.DeepEqual(paged, expected) {
+		t.Errorf("expected %v across pages; got %v", expected, paged)
+	}
+
+	if _, _, err := db.SearchUsers(ctx, "user1", "bo", 2, "not a cursor"); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor; got %v", err)
+	}
+
+	// Short queries would list the whole directory a page at a time
+	for _, query := range []string{"", "   ", "b", " b "} {
+		if _, _, err := db.SearchUsers(ctx, "user1", query, 2, ""); !errors.Is(err, ErrSearchQueryTooShort) {
+			t.Errorf("expected %q to be too short; got %v", query, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteOptions tune the connections to a SQLite database file. Foreign keys are always enforced, as deletes rely on
+// their cascades.
+type SQLiteOptions struct {
+	// JournalMode is the journal_mode pragma; WAL lets the readers go on while the writer commits
+	JournalMode string
+
+	// Synchronous is the synchronous pragma: OFF, NORMAL, FULL or EXTRA
+	Synchronous string
+
+	// BusyTimeout is how long a connection waits for a lock held by another connection before failing with
+	// "database is locked"
+	BusyTimeout time.Duration
+
+	// MaxReaders caps the connections of the reader pool
+	MaxReaders int
+}
+
+// synchronousLevels are the accepted values of SQLiteOptions.Synchronous
+var synchronousLevels = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+
+// journalModes are the accepted values of SQLiteOptions.JournalMode
+var journalModes = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+
+// OpenSQLite opens a database file as a pool with a single writer connection, so that writes queue in the process
+// instead of failing on the database lock, and a pool of query-only reader connections. Pass both to NewWithReaders.
+func OpenSQLite(filename string, opts SQLiteOptions) (*sql.DB, *sql.DB, error) {
+	if opts.MaxReaders < 1 {
+		return nil, nil, fmt.Errorf("invalid number of readers %d", opts.MaxReaders)
+	}
+	journalMode := strings.ToUpper(opts.JournalMode)
+	if !contains(journalModes, journalMode) {
+		return nil, nil, fmt.Errorf("invalid journal mode %q", opts.JournalMode)
+	}
+	synchronous := strings.ToUpper(opts.Synchronous)
+	if !contains(synchronousLevels, synchronous) {
+		return nil, nil, fmt.Errorf("invalid synchronous level %q", opts.Synchronous)
+	}
+
+	busyTimeout := fmt.Sprint(opts.BusyTimeout.Milliseconds())
+
+	// Write transactions take the lock when they begin, since a reader upgrading to a writer cannot wait for the lock
+	writerParams := url.Values{
+		"_foreign_keys": {"1"},
+		"_busy_timeout": {busyTimeout},
+		"_journal_mode": {journalMode},
+		"_synchronous":  {synchronous},
+		"_txlock":       {"immediate"},
+	}
+	writer, err := sql.Open("sqlite3", filename+"?"+writerParams.Encode())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening the writer: %w", err)
+	}
+	writer.SetMaxOpenConns(1)
+
+	readerParams := url.Values{
+		"_foreign_keys": {"1"},
+		"_busy_timeout": {busyTimeout},
+		"_query_only":   {"1"},
+	}
+	reader, err := sql.Open("sqlite3", filename+"?"+readerParams.Encode())
+	if err != nil {
+		_ = writer.Close()
+		return nil, nil, fmt.Errorf("error opening the readers: %w", err)
+	}
+	reader.SetMaxOpenConns(opts.MaxReaders)
+	reader.SetMaxIdleConns(opts.MaxReaders)
+
+	return writer, reader, nil
+}
+
+// contains tells whether values has value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenSQLite(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "decaf.db")
+	opts := SQLiteOptions{JournalMode: "wal", Synchronous: "normal", BusyTimeout: 2 * time.Second, MaxReaders: 2}
+	writer, reader, err := OpenSQLite(filename, opts)
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = reader.Close()
+		_ = writer.Close()
+	})
+
+	db, err := NewWithReaders(writer, reader, time.Second)
+	if err != nil {
+		t.Fatalf("error creating app database: %v", err)
+	}
+
+	var journalMode string
+	var foreignKeys, busyTimeout, synchronous int
+	if err := writer.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil || journalMode != "wal" {
+		t.Errorf("expected the wal journal mode; got %q, %v", journalMode, err)
+	}
+	if err := writer.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys); err != nil || foreignKeys != 1 {
+		t.Errorf("expected foreign keys on after the migrations; got %d, %v", foreignKeys, err)
+	}
+	if err := writer.QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout); err != nil || busyTimeout != 2000 {
+		t.Errorf("expected a busy timeout of 2000ms; got %d, %v", busyTimeout, err)
+	}
+	if err := writer.QueryRow("PRAGMA synchronous").Scan(&synchronous); err != nil || synchronous != 1 {
+		t.Errorf("expected the normal synchronous level; got %d, %v", synchronous, err)
+	}
+	if err := reader.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys); err != nil || foreignKeys != 1 {
+		t.Errorf("expected foreign keys on in the readers; got %d, %v", foreignKeys, err)
+	}
+
+	// Readers see what the writer committed, but cannot write
+	if _, err := db.CreateSession(ctx, "alice"); err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+	if !db.HasUser(ctx, "alice") {
+		t.Errorf("expected the readers to see the new user")
+	}
+	if _, err := reader.Exec(`INSERT INTO users (id, username, token) VALUES ('user2', 'bob', 'token2')`); err == nil {
+		t.Errorf("expected the readers to refuse writes")
+	}
+
+	for _, bad := range []SQLiteOptions{
+		{JournalMode: "fast", Synchronous: "normal", MaxReaders: 1},
+		{JournalMode: "wal", Synchronous: "sometimes", MaxReaders: 1},
+		{JournalMode: "wal", Synchronous: "normal", MaxReaders: 0},
+	} {
+		if _, _, err := OpenSQLite(filename, bad); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+}
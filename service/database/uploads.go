@@ -0,0 +1,235 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	// ErrUploadNotFound is returned when an upload does not exist, expired or belongs to another user
+	ErrUploadNotFound = errors.New("upload not found")
+
+	// ErrQuotaExceeded is returned when storing a file would take a user over their storage quota
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+)
+
+// UploadExpiry is how long an upload is kept after its last chunk, or after it was finalized if no message uses it
+const UploadExpiry = 24 * time.Hour
+
+// CreateUpload starts a resumable upload of upload.Size bytes, as long as the user stays within quota bytes of
+// storage counting the files they already sent and their other uploads
+func (db *appdbimpl) CreateUpload(ctx context.Context, upload *Upload, quota int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
+
+	used, err := storageUsage(ctx, tx, upload.UserID)
+	if err != nil {
+		return err
+	}
+	if used+upload.Size > quota {
+		return ErrQuotaExceeded
+	}
+
+	now := time.Now().UTC()
+	upload.Offset = 0
+	upload.Completed = false
+	upload.ExpiresAt = now.Add(UploadExpiry).Truncate(time.Second)
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO uploads (id, user_id, filename, mime_type, size, upload_offset, storage_key, completed, expires_at)
+        VALUES (?, ?, ?, ?, ?, 0, ?, 0, ?)
+    `, upload.ID, upload.UserID, upload.Filename, upload.MIMEType, upload.Size, upload.StorageKey, upload.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("error creating upload: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// GetUpload returns an upload of the user. Expired uploads are reported as not found even before they are swept.
+func (db *appdbimpl) GetUpload(ctx context.Context, uploadID string, userID string) (*Upload, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var u Upload
+	var expiresAt string
+	err := db.r.QueryRowContext(ctx, `
+        SELECT id, user_id, filename, mime_type, size, upload_offset, COALESCE(checksum, ''), storage_key, completed,
+               `+db.dialect.formatTime("expires_at")+`
+        FROM uploads
+        WHERE id = ? AND user_id = ? AND `+db.dialect.timeValue("expires_at")+` > `+db.dialect.timeValue("?")+`
+    `, uploadID, userID, time.Now().UTC()).Scan(&u.ID, &u.UserID, &u.Filename, &u.MIMEType, &u.Size, &u.Offset,
+		&u.Checksum, &u.StorageKey, &u.Completed, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting upload: %w", err)
+	}
+
+	u.ExpiresAt, err = time.Parse("2006-01-02 15:04:05", expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing expiry: %w", err)
+	}
+	return &u, nil
+}
+
+// SetUploadOffset records that the first offset bytes of an upload are stored and postpones its expiry
+func (db *appdbimpl) SetUploadOffset(ctx context.Context, uploadID string, offset int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.c.ExecContext(ctx, `
+        UPDATE uploads SET upload_offset = ?, expires_at = ?
+        WHERE id = ? AND completed = 0 AND ? <= size
+    `, offset, time.Now().UTC().Add(UploadExpiry), uploadID, offset)
+	if err != nil {
+		return fmt.Errorf("error updating upload offset: %w", err)
+	}
+	return nil
+}
+
+// CompleteUpload marks a fully received upload as finalized, with the MIME type and checksum of its content, which
+// is also the storage key of the file from now on. The finalized upload waits for a message to use it until it
+// expires.
+func (db *appdbimpl) CompleteUpload(ctx context.Context, uploadID string, mimeType string, checksum string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	res, err := db.c.ExecContext(ctx, `
+        UPDATE uploads SET completed = 1, mime_type = ?, checksum = ?, storage_key = ?, expires_at = ?
+        WHERE id = ? AND completed = 0 AND upload_offset = size
+    `, mimeType, checksum, checksum, time.Now().UTC().Add(UploadExpiry), uploadID)
+	if err != nil {
+		return fmt.Errorf("error completing upload: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrUploadNotFound
+	}
+	return nil
+}
+
+// DeleteUpload forgets an upload, either aborted or used by a message. If it was not finalized, it returns the
+// storage key of the partial file to remove; finalized files are left to the media garbage collection.
+func (db *appdbimpl) DeleteUpload(ctx context.Context, uploadID string) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
+
+	partialKeys, err := deleteUploads(ctx, tx, `id = ?`, uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing transaction: %w", err)
+	}
+	if len(partialKeys) == 0 {
+		return "", nil
+	}
+	return partialKeys[0], nil
+}
+
+// DeleteExpiredUploads drops the uploads abandoned before being finalized or used by a message, returning the storage
+// keys of the partial files to remove
+func (db *appdbimpl) DeleteExpiredUploads(ctx context.Context, now time.Time) ([]string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("error rolling back transaction: %v", err)
+		}
+	}()
+
+	partialKeys, err := deleteUploads(ctx, tx, db.dialect.timeValue("expires_at")+` <= `+db.dialect.timeValue("?"),
+		now.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return partialKeys, nil
+}
+
+// deleteUploads deletes the uploads matching the condition, returning the storage keys of those not finalized
+func deleteUploads(ctx context.Context, tx *sql.Tx, condition string, args ...interface{}) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT storage_key FROM uploads WHERE completed = 0 AND `+condition, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting uploads: %w", err)
+	}
+	defer rows.Close()
+
+	var partialKeys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("error scanning upload: %w", err)
+		}
+		partialKeys = append(partialKeys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating uploads: %w", err)
+	}
+	_ = rows.Close()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM uploads WHERE `+condition, args...); err != nil {
+		return nil, fmt.Errorf("error deleting uploads: %w", err)
+	}
+	return partialKeys, nil
+}
+
+// GetStorageUsage returns how many bytes a user takes with the files they sent and their pending uploads
+func (db *appdbimpl) GetStorageUsage(ctx context.Context, userID string) (int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	return storageUsage(ctx, db.r, userID)
+}
+
+// storageUsage counts every stored file once, however many messages share it, against the user who uploaded it.
+// Uploads count with their full size from the start, so that quota cannot be exceeded by uploading in parallel.
+func storageUsage(ctx context.Context, ex execer, userID string) (int64, error) {
+	var used int64
+	err := ex.QueryRowContext(ctx, `
+        SELECT COALESCE((SELECT SUM(size) FROM uploads WHERE user_id = ? AND storage_key NOT IN (
+                   SELECT storage_key FROM attachments WHERE uploaded_by = ?)), 0)
+             + COALESCE((SELECT SUM(size) FROM (
+                   SELECT DISTINCT storage_key, size FROM attachments WHERE uploaded_by = ?) AS files), 0)
+    `, userID, userID, userID).Scan(&used)
+	if err != nil {
+		return 0, fmt.Errorf("error getting storage usage: %w", err)
+	}
+	return used, nil
+}
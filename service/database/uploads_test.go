@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUploads(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+
+	upload := Upload{ID: "up1", UserID: "user1", Filename: "movie.mp4", MIMEType: "video/mp4", Size: 600,
+		StorageKey: "key1"}
+	if err := db.CreateUpload(ctx, &upload, 1000); err != nil {
+		t.Fatalf("error creating upload: %v", err)
+	}
+
+	// The pending upload counts with its full size against the quota
+	second := Upload{ID: "up2", UserID: "user1", Filename: "big.zip", MIMEType: "application/zip", Size: 500,
+		StorageKey: "key2"}
+	if err := db.CreateUpload(ctx, &second, 1000); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded; got %v", err)
+	}
+	second.Size = 400
+	if err := db.CreateUpload(ctx, &second, 1000); err != nil {
+		t.Fatalf("error creating upload within quota: %v", err)
+	}
+
+	if _, err := db.GetUpload(ctx, "up1", "user2"); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("expected the upload to be hidden from other users; got %v", err)
+	}
+
+	if err := db.CompleteUpload(ctx, "up1", "video/mp4", "abc"); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("expected an incomplete upload not to be finalized; got %v", err)
+	}
+	if err := db.SetUploadOffset(ctx, "up1", 600); err != nil {
+		t.Fatalf("error setting offset: %v", err)
+	}
+	if err := db.CompleteUpload(ctx, "up1", "video/mp4", "abc"); err != nil {
+		t.Fatalf("error completing upload: %v", err)
+	}
+	got, err := db.GetUpload(ctx, "up1", "user1")
+	if err != nil {
+		t.Fatalf("error getting upload: %v", err)
+	}
+	if !got.Completed || got.Offset != 600 || got.Checksum != "abc" {
+		t.Errorf("expected a finalized upload of 600 bytes; got %+v", got)
+	}
+
+	// Sending the upload moves its bytes from the pending uploads to the attachments of the user
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+	_, err = db.CreateAttachmentMessage(ctx, conversationID, "user1", "", []Attachment{
+		{ID: "att1", Filename: got.Filename, MIMEType: got.MIMEType, Size: got.Size, Checksum: got.Checksum,
+			StorageKey: got.StorageKey},
+	})
+	if err != nil {
+		t.Fatalf("error sending upload: %v", err)
+	}
+	if used, err := db.GetStorageUsage(ctx, "user1"); err != nil || used != 1000 {
+		t.Errorf("expected 1000 bytes used; got %d, %v", used, err)
+	}
+	partialKey, err := db.DeleteUpload(ctx, "up1")
+	if err != nil {
+		t.Fatalf("error deleting upload: %v", err)
+	}
+	if partialKey != "" {
+		t.Errorf("expected no partial file for a finalized upload; got %q", partialKey)
+	}
+
+	// The abandoned upload expires with its partial file
+	partialKeys, err := db.DeleteExpiredUploads(ctx, time.Now().Add(UploadExpiry+time.Minute))
+	if err != nil {
+		t.Fatalf("error deleting expired uploads: %v", err)
+	}
+	if len(partialKeys) != 1 || partialKeys[0] != "key2" {
+		t.Errorf("expected the partial file key2 to be removed; got %v", partialKeys)
+	}
+	if used, err := db.GetStorageUsage(ctx, "user1"); err != nil || used != 600 {
+		t.Errorf("expected 600 bytes used; got %d, %v", used, err)
+	}
+}
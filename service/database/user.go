@@ -1,23 +1,35 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
+	"time"
 )
 
+// ErrUserNotFound is returned when looking up a user that does not exist
+var ErrUserNotFound = errors.New("user not found")
+
 // GetUserByToken busca un usuario por su token de autenticación
-func (db *appdbimpl) GetUserByToken(token string) (*User, error) {
+func (db *appdbimpl) GetUserByToken(ctx context.Context, token string) (*User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	log.Printf("Searching for user with token: %s", token)
 
 	var user User
 	var photoURL sql.NullString // Use sql.NullString for nullable column
+	var displayName, bio sql.NullString
 
-	err := db.c.QueryRow(
-		"SELECT id, username, token, photo_url FROM users WHERE token = ?",
+	err := db.r.QueryRowContext(ctx,
+		`SELECT id, username, token, photo_url, display_name, bio, photo_visibility, last_seen_visibility,
+		forward_visibility
+		FROM users WHERE token = ?`,
 		token,
-	).Scan(&user.ID, &user.Username, &user.Token, &photoURL)
+	).Scan(&user.ID, &user.Username, &user.Token, &photoURL, &displayName, &bio,
+		&user.PhotoVisibility, &user.LastSeenVisibility, &user.ForwardVisibility)
 
 	if err == sql.ErrNoRows {
 		log.Printf("No user found with token: %s", token)
@@ -32,22 +44,21 @@ func (db *appdbimpl) GetUserByToken(token string) (*User, error) {
 	if photoURL.Valid {
 		user.PhotoURL = photoURL.String
 	}
+	user.DisplayName = displayName.String
+	user.Bio = bio.String
 
 	log.Printf("Found user: %s", user.Username)
 	return &user, nil
 }
 
-// UpdateUsername actualiza el nombre de usuario y todas sus referencias
-func (db *appdbimpl) UpdateUsername(userID string, newUsername string) error {
-	tx, err := db.c.Begin()
-	if err != nil {
-		return fmt.Errorf("error starting transaction: %w", err)
-	}
-	defer tx.Rollback()
+// UpdateUsername actualiza el nombre de usuario. Everything else references the user ID, so only the users row changes.
+func (db *appdbimpl) UpdateUsername(ctx context.Context, userID string, newUsername string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
 	// Get the old username first
 	var oldUsername string
-	err = tx.QueryRow(
+	err := db.c.QueryRowContext(ctx,
 		"SELECT username FROM users WHERE id = ?",
 		userID,
 	).Scan(&oldUsername)
@@ -62,7 +73,7 @@ func (db *appdbimpl) UpdateUsername(userID string, newUsername string) error {
 
 	// Check if new username already exists
 	var exists bool
-	err = tx.QueryRow(
+	err = db.c.QueryRowContext(ctx,
 		"SELECT EXISTS(SELECT 1 FROM users WHERE username = ? AND id != ?)",
 		newUsername, userID,
 	).Scan(&exists)
@@ -75,8 +86,7 @@ func (db *appdbimpl) UpdateUsername(userID string, newUsername string) error {
 		return fmt.Errorf("username '%s' is already taken, please choose a different one", newUsername)
 	}
 
-	// Update username in users table
-	_, err = tx.Exec(
+	_, err = db.c.ExecContext(ctx,
 		"UPDATE users SET username = ? WHERE id = ?",
 		newUsername, userID,
 	)
@@ -84,36 +94,17 @@ func (db *appdbimpl) UpdateUsername(userID string, newUsername string) error {
 		return fmt.Errorf("error updating username: %w", err)
 	}
 
-	// Update username in messages table (sender field)
-	_, err = tx.Exec(
-		"UPDATE messages SET sender = ? WHERE sender = ?",
-		newUsername, oldUsername,
-	)
-	if err != nil {
-		return fmt.Errorf("error updating message senders: %w", err)
-	}
-
-	// Update username in sessions table
-	_, err = tx.Exec(
-		"UPDATE sessions SET username = ? WHERE username = ?",
-		newUsername, oldUsername,
-	)
-	if err != nil {
-		return fmt.Errorf("error updating sessions: %w", err)
-	}
-
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("error committing transaction: %w", err)
-	}
-
 	return nil
 }
 
 // UpdateUserPhoto actualiza la foto de perfil del usuario
-func (db *appdbimpl) UpdateUserPhoto(userID string, photoURL string) error {
+func (db *appdbimpl) UpdateUserPhoto(ctx context.Context, userID string, photoURL string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	log.Printf("Updating photo for user %s to: %s", userID, photoURL)
 
-	result, err := db.c.Exec(
+	result, err := db.c.ExecContext(ctx,
 		"UPDATE users SET photo_url = ? WHERE id = ?",
 		photoURL, userID,
 	)
@@ -125,9 +116,30 @@ func (db *appdbimpl) UpdateUserPhoto(userID string, photoURL string) error {
 	rows, _ := result.RowsAffected() // Ignore the error since we don't use it
 	log.Printf("Rows affected by update: %d", rows)
 
+	// Keep the previous photos for the profile avatar history
+	if rows > 0 {
+		_, err = db.c.ExecContext(ctx,
+			"INSERT INTO avatar_history (user_id, photo_url, set_at) VALUES (?, ?, ?)",
+			userID, photoURL, time.Now(),
+		)
+		if err != nil {
+			return fmt.Errorf("error saving avatar history: %w", err)
+		}
+
+		// Older photos are not shown anymore, forget them so that their files can be collected
+		_, err = db.c.ExecContext(ctx, `
+            DELETE FROM avatar_history
+            WHERE user_id = ? AND rowid NOT IN (
+                SELECT rowid FROM avatar_history WHERE user_id = ? ORDER BY set_at DESC, rowid DESC LIMIT ?)
+        `, userID, userID, avatarHistoryLength)
+		if err != nil {
+			return fmt.Errorf("error pruning avatar history: %w", err)
+		}
+	}
+
 	// Verify the update
 	var savedURL string
-	err = db.c.QueryRow(
+	err = db.c.QueryRowContext(ctx,
 		"SELECT photo_url FROM users WHERE id = ?",
 		userID,
 	).Scan(&savedURL)
@@ -142,11 +154,42 @@ func (db *appdbimpl) UpdateUserPhoto(userID string, photoURL string) error {
 }
 
 // HasUser checks if a user exists in the database
-func (db *appdbimpl) HasUser(username string) bool {
+func (db *appdbimpl) HasUser(ctx context.Context, username string) bool {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var exists bool
-	err := db.c.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", username).Scan(&exists)
+	err := db.r.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", username).Scan(&exists)
 	if err != nil {
 		return false
 	}
 	return exists
 }
+
+// UpdateLastSeen saves the last time the user was active
+func (db *appdbimpl) UpdateLastSeen(ctx context.Context, userID string, lastSeen time.Time) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.c.ExecContext(ctx, "UPDATE users SET last_seen = ? WHERE id = ?", lastSeen, userID)
+	if err != nil {
+		return fmt.Errorf("error updating last seen: %w", err)
+	}
+	return nil
+}
+
+// GetUserID returns the ID of the user with the given username
+func (db *appdbimpl) GetUserID(ctx context.Context, username string) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var userID string
+	err := db.r.QueryRowContext(ctx, "SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("error getting user ID: %w", err)
+	}
+	return userID, nil
+}
@@ -1,11 +1,16 @@
 package database
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
 	"testing"
 )
 
 func TestGetUserByToken(t *testing.T) {
 	db := setupTestDB(t)
+	ctx := context.Background()
 
 	// Insertar usuario de prueba
 	_, err := db.(*appdbimpl).c.Exec(
@@ -41,7 +46,7 @@ func TestGetUserByToken(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			user, err := db.GetUserByToken(tt.token)
+			user, err := db.GetUserByToken(ctx, tt.token)
 
 			if tt.expectError && err == nil {
 				t.Error("expected error but got none")
@@ -68,6 +73,7 @@ func TestGetUserByToken(t *testing.T) {
 
 func TestUpdateUsername(t *testing.T) {
 	db := setupTestDB(t)
+	ctx := context.Background()
 
 	// Insertar usuario inicial
 	_, err := db.(*appdbimpl).c.Exec(
@@ -100,7 +106,7 @@ func TestUpdateUsername(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := db.UpdateUsername(tt.userID, tt.newUsername)
+			err := db.UpdateUsername(ctx, tt.userID, tt.newUsername)
 
 			if tt.expectError && err == nil {
 				t.Error("expected error but got none")
@@ -132,6 +138,7 @@ func TestUpdateUsername(t *testing.T) {
 
 func TestUpdateUserPhoto(t *testing.T) {
 	db := setupTestDB(t)
+	ctx := context.Background()
 
 	// Insertar usuario de prueba
 	_, err := db.(*appdbimpl).c.Exec(
@@ -164,7 +171,7 @@ func TestUpdateUserPhoto(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := db.UpdateUserPhoto(tt.userID, tt.photoURL)
+			err := db.UpdateUserPhoto(ctx, tt.userID, tt.photoURL)
 
 			if tt.expectError && err == nil {
 				t.Error("expected error but got none")
@@ -196,6 +203,7 @@ func TestUpdateUserPhoto(t *testing.T) {
 
 func TestGetUserConversations(t *testing.T) {
 	db := setupTestDB(t)
+	ctx := context.Background()
 
 	// Insertar datos de prueba
 	_, err := db.(*appdbimpl).c.Exec(`
@@ -256,7 +264,7 @@ func TestGetUserConversations(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			conversations, err := db.GetUserConversations(tt.userID)
+			conversations, _, err := db.GetUserConversations(ctx, tt.userID, 50, "")
 
 			if tt.expectError && err == nil {
 				t.Error("expected error but got none")
@@ -272,3 +280,109 @@ func TestGetUserConversations(t *testing.T) {
 		})
 	}
 }
+
+func TestGetUserConversationsPages(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token, is_bot) VALUES
+		('user1', 'alice', 'token1', 0),
+		('user2', 'bob', 'token2', 0),
+		('user3', 'carol', 'token3', 0),
+		('bot1', 'helper', 'token4', 1);
+		INSERT INTO conversations (id, last_message, timestamp) VALUES
+		('conv1', 'Hello', '2024-01-01 10:00:00'),
+		('conv2', 'Hi there', '2024-01-01 11:00:00'),
+		('conv3', 'Same time', '2024-01-01 11:00:00');
+		INSERT INTO conversation_participants (conversation_id, user_id) VALUES
+		('conv1', 'user1'), ('conv1', 'user2'),
+		('conv2', 'user1'), ('conv2', 'user3'),
+		('conv3', 'user1'), ('conv3', 'bot1');
+		INSERT INTO groups (id, name, timestamp, last_message) VALUES
+		('group1', 'Office', '2024-01-01 12:00:00', 'Lunch?');
+		INSERT INTO group_members (group_id, user_id) VALUES
+		('group1', 'user1'), ('group1', 'user2'), ('group1', 'user3')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test data: %v", err)
+	}
+
+	// Paging through two at a time returns every conversation once, ties broken by ID
+	var paged []string
+	members := make(map[string][]string)
+	cursor := ""
+	for pages := 0; pages < 5; pages++ {
+		conversations, next, err := db.GetUserConversations(ctx, "user1", 2, cursor)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, c := range conversations {
+			paged = append(paged, c.ID)
+			members[c.ID] = c.Participants
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if expected := []string{"group1", "conv3", "conv2", "conv1"}; !reflect.DeepEqual(paged, expected) {
+		t.Errorf("expected %v across pages; got %v", expected, paged)
+	}
+	if len(members["group1"]) != 3 || len(members["conv1"]) != 2 {
+		t.Errorf("expected the members of every conversation; got %v", members)
+	}
+
+	conversations, _, err := db.GetUserConversations(ctx, "user1", 1, encodeInboxCursor("2024-01-01 11:00:00", "conv3"))
+	if err != nil || len(conversations) != 1 || conversations[0].ID != "conv2" {
+		t.Fatalf("expected conv2 after conv3; got %+v, %v", conversations, err)
+	}
+	if len(conversations[0].Bots) != 0 {
+		t.Errorf("expected no bots in conv2; got %v", conversations[0].Bots)
+	}
+	conversations, _, err = db.GetUserConversations(ctx, "user1", 1, encodeInboxCursor("2024-01-01 11:00:00", "conv4"))
+	if err != nil || len(conversations) != 1 || conversations[0].ID != "conv3" ||
+		!reflect.DeepEqual(conversations[0].Bots, []string{"helper"}) {
+		t.Errorf("expected conv3 with its bot; got %+v, %v", conversations, err)
+	}
+
+	if _, _, err := db.GetUserConversations(ctx, "user1", 2, "not a cursor"); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor; got %v", err)
+	}
+}
+
+// BenchmarkGetUserConversations loads the inbox of a user with 10k conversations
+func BenchmarkGetUserConversations(b *testing.B) {
+	// The conversations are seeded with SQLite date functions
+	skipOnPostgres(b)
+	db := setupTestDB(b)
+	ctx := context.Background()
+
+	const conversations = 10000
+	_, err := db.(*appdbimpl).c.Exec(fmt.Sprintf(`
+		INSERT INTO users (id, username, token) VALUES ('user1', 'alice', 'token1');
+		WITH RECURSIVE n(i) AS (SELECT 0 UNION ALL SELECT i + 1 FROM n WHERE i < 99)
+		INSERT INTO users (id, username, token) SELECT 'peer' || i, 'peer' || i, 'token-peer' || i FROM n;
+		WITH RECURSIVE n(i) AS (SELECT 0 UNION ALL SELECT i + 1 FROM n WHERE i < %[1]d - 1)
+		INSERT INTO conversations (id, last_message, timestamp)
+		SELECT 'conv' || i, 'Message ' || i, datetime('2024-01-01', '+' || i || ' minutes') FROM n;
+		WITH RECURSIVE n(i) AS (SELECT 0 UNION ALL SELECT i + 1 FROM n WHERE i < %[1]d - 1)
+		INSERT INTO conversation_participants (conversation_id, user_id)
+		SELECT 'conv' || i, 'user1' FROM n UNION ALL SELECT 'conv' || i, 'peer' || (i %% 100) FROM n;
+		WITH RECURSIVE n(i) AS (SELECT 0 UNION ALL SELECT i + 1 FROM n WHERE i < %[1]d - 1)
+		INSERT INTO messages (id, conversation_id, sender, content, timestamp)
+		SELECT 'msg' || i, 'conv' || i, 'user1', 'Message ' || i, datetime('2024-01-01', '+' || i || ' minutes')
+		FROM n
+	`, conversations))
+	if err != nil {
+		b.Fatalf("error seeding conversations: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		page, _, err := db.GetUserConversations(ctx, "user1", 50, "")
+		if err != nil || len(page) != 50 {
+			b.Fatalf("expected a full page; got %d conversations, %v", len(page), err)
+		}
+	}
+}
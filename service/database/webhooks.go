@@ -0,0 +1,280 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrWebhookNotFound is returned when a webhook does not exist in the given conversation
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Statuses of webhook deliveries
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryDead      = "dead"
+)
+
+// CreateWebhook subscribes webhook.URL to the events of webhook.ConversationID
+func (db *appdbimpl) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	webhook.ID = generateUUID()
+	webhook.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	_, err := db.c.ExecContext(ctx, `
+        INSERT INTO webhooks (id, conversation_id, url, secret, events, created_by, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, webhook.ID, webhook.ConversationID, webhook.URL, webhook.Secret, strings.Join(webhook.Events, ","),
+		webhook.CreatedBy, webhook.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error creating webhook: %w", err)
+	}
+	return nil
+}
+
+// GetWebhooks returns the webhooks of a conversation, without their secrets
+func (db *appdbimpl) GetWebhooks(ctx context.Context, conversationID string) ([]Webhook, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT id, conversation_id, url, events, created_by, `+db.dialect.formatTime("created_at")+`
+        FROM webhooks
+        WHERE conversation_id = ?
+        ORDER BY created_at
+    `, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := make([]Webhook, 0)
+	for rows.Next() {
+		var w Webhook
+		var events, createdAt string
+		if err := rows.Scan(&w.ID, &w.ConversationID, &w.URL, &events, &w.CreatedBy, &createdAt); err != nil {
+			return nil, fmt.Errorf("error scanning webhook: %w", err)
+		}
+		w.Events = strings.Split(events, ",")
+		w.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing timestamp: %w", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook of a conversation, whose delivery history is deleted with it
+func (db *appdbimpl) DeleteWebhook(ctx context.Context, conversationID string, webhookID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	res, err := db.c.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ? AND conversation_id = ?`, webhookID,
+		conversationID)
+	if err != nil {
+		return fmt.Errorf("error deleting webhook: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// QueueWebhookDeliveries schedules the delivery of an event of a conversation to every webhook subscribed to it,
+// returning how many deliveries were queued
+func (db *appdbimpl) QueueWebhookDeliveries(ctx context.Context, conversationID string, event string,
+	payload []byte) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.c.QueryContext(ctx, `
+        SELECT id FROM webhooks
+        WHERE conversation_id = ? AND `+db.dialect.position("',' || events || ','", "',' || ? || ','")+` > 0
+    `, conversationID, event)
+	if err != nil {
+		return 0, fmt.Errorf("error getting webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var webhookIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return 0, fmt.Errorf("error scanning webhook: %w", err)
+		}
+		webhookIDs = append(webhookIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating webhooks: %w", err)
+	}
+	_ = rows.Close()
+
+	now := time.Now().UTC()
+	for _, webhookID := range webhookIDs {
+		_, err := db.c.ExecContext(ctx, `
+            INSERT INTO webhook_deliveries (id, webhook_id, event, payload, status, next_attempt_at, created_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?)
+        `, generateUUID(), webhookID, event, string(payload), WebhookDeliveryPending, now, now)
+		if err != nil {
+			return 0, fmt.Errorf("error queueing webhook delivery: %w", err)
+		}
+	}
+	return len(webhookIDs), nil
+}
+
+// GetDueWebhookDeliveries returns up to limit pending deliveries whose next attempt is due, oldest first, with the
+// URL and secret of their webhook
+func (db *appdbimpl) GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, COALESCE(d.last_status_code, 0),
+               COALESCE(d.last_error, ''), `+db.dialect.formatTime("d.next_attempt_at")+`,
+               `+db.dialect.formatTime("d.created_at")+`, `+db.dialect.formatTime("d.delivered_at")+`,
+               w.url, w.secret
+        FROM webhook_deliveries d
+        JOIN webhooks w ON w.id = d.webhook_id
+        WHERE d.status = ? AND `+db.dialect.timeValue("d.next_attempt_at")+` <= `+db.dialect.timeValue("?")+`
+        ORDER BY d.next_attempt_at
+        LIMIT ?
+    `, WebhookDeliveryPending, now.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error getting due webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	return scanWebhookDeliveries(rows)
+}
+
+// MarkWebhookDelivered records a successful attempt of a delivery
+func (db *appdbimpl) MarkWebhookDelivered(ctx context.Context, deliveryID string, statusCode int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.c.ExecContext(ctx, `
+        UPDATE webhook_deliveries
+        SET status = ?, attempts = attempts + 1, last_status_code = ?, last_error = NULL, next_attempt_at = NULL,
+            delivered_at = ?
+        WHERE id = ?
+    `, WebhookDeliveryDelivered, statusCode, time.Now().UTC(), deliveryID)
+	if err != nil {
+		return fmt.Errorf("error updating webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// MarkWebhookFailed records a failed attempt of a delivery, with the status code received if any. The delivery is
+// retried at retryAt, or is dead if retryAt is nil.
+func (db *appdbimpl) MarkWebhookFailed(ctx context.Context, deliveryID string, statusCode int, reason string,
+	retryAt *time.Time) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	status := WebhookDeliveryPending
+	var next interface{}
+	if retryAt != nil {
+		next = retryAt.UTC()
+	} else {
+		status = WebhookDeliveryDead
+	}
+	_, err := db.c.ExecContext(ctx, `
+        UPDATE webhook_deliveries
+        SET status = ?, attempts = attempts + 1, last_status_code = NULLIF(?, 0), last_error = ?, next_attempt_at = ?
+        WHERE id = ?
+    `, status, statusCode, reason, next, deliveryID)
+	if err != nil {
+		return fmt.Errorf("error updating webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// GetWebhookDeliveries returns the latest deliveries of a webhook of a conversation, newest first, optionally only
+// those with the given status
+func (db *appdbimpl) GetWebhookDeliveries(ctx context.Context, conversationID string, webhookID string, status string,
+	limit int) ([]WebhookDelivery, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	err := db.r.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM webhooks WHERE id = ? AND conversation_id = ?)`,
+		webhookID, conversationID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("error checking webhook: %w", err)
+	}
+	if !exists {
+		return nil, ErrWebhookNotFound
+	}
+
+	rows, err := db.r.QueryContext(ctx, `
+        SELECT d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, COALESCE(d.last_status_code, 0),
+               COALESCE(d.last_error, ''), `+db.dialect.formatTime("d.next_attempt_at")+`,
+               `+db.dialect.formatTime("d.created_at")+`, `+db.dialect.formatTime("d.delivered_at")+`,
+               '', ''
+        FROM webhook_deliveries d
+        WHERE d.webhook_id = ? AND (? = '' OR d.status = ?)
+        ORDER BY d.created_at DESC, d.rowid DESC
+        LIMIT ?
+    `, webhookID, status, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error getting webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	return scanWebhookDeliveries(rows)
+}
+
+// DeleteOldWebhookDeliveries forgets the deliveries created before the given time that are no longer pending
+func (db *appdbimpl) DeleteOldWebhookDeliveries(ctx context.Context, before time.Time) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.c.ExecContext(ctx, `
+        DELETE FROM webhook_deliveries
+        WHERE status != ? AND `+db.dialect.timeValue("created_at")+` < `+db.dialect.timeValue("?")+`
+    `, WebhookDeliveryPending, before.UTC())
+	if err != nil {
+		return fmt.Errorf("error deleting old webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// scanWebhookDeliveries reads delivery rows selected with the column order of GetDueWebhookDeliveries
+func scanWebhookDeliveries(rows *sql.Rows) ([]WebhookDelivery, error) {
+	deliveries := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		var d WebhookDelivery
+		var payload, createdAt string
+		var nextAttemptAt, deliveredAt sql.NullString
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.LastStatusCode,
+			&d.LastError, &nextAttemptAt, &createdAt, &deliveredAt, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook delivery: %w", err)
+		}
+		d.Payload = []byte(payload)
+		d.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing timestamp: %w", err)
+		}
+		if d.NextAttemptAt, err = parseNullTimestamp(nextAttemptAt); err != nil {
+			return nil, err
+		}
+		if d.DeliveredAt, err = parseNullTimestamp(deliveredAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
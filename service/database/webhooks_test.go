@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.(*appdbimpl).c.Exec(`
+		INSERT INTO users (id, username, token) VALUES
+		('user1', 'alice', 'token1'),
+		('user2', 'bob', 'token2')
+	`)
+	if err != nil {
+		t.Fatalf("error inserting test users: %v", err)
+	}
+	conversationID, err := db.CreateConversation(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("error creating conversation: %v", err)
+	}
+
+	all := Webhook{ConversationID: conversationID, URL: "http://hooks.internal/all", Secret: "s1",
+		Events: []string{"message.created", "message.deleted"}, CreatedBy: "user1"}
+	reactions := Webhook{ConversationID: conversationID, URL: "http://hooks.internal/reactions", Secret: "s2",
+		Events: []string{"message.reacted"}, CreatedBy: "user1"}
+	for _, w := range []*Webhook{&all, &reactions} {
+		if err := db.CreateWebhook(ctx, w); err != nil {
+			t.Fatalf("error creating webhook: %v", err)
+		}
+	}
+	webhooks, err := db.GetWebhooks(ctx, conversationID)
+	if err != nil || len(webhooks) != 2 || webhooks[0].Secret != "" || len(webhooks[0].Events) != 2 {
+		t.Fatalf("expected two webhooks without secrets; got %+v, %v", webhooks, err)
+	}
+
+	// Only the webhooks subscribed to an event get it
+	if n, err := db.QueueWebhookDeliveries(ctx, conversationID, "message.created",
+		[]byte(`{"a":1}`)); err != nil || n != 1 {
+		t.Fatalf("expected one delivery queued; got %d, %v", n, err)
+	}
+	if n, err := db.QueueWebhookDeliveries(ctx, conversationID, "message.reacted",
+		[]byte(`{"b":2}`)); err != nil || n != 1 {
+		t.Fatalf("expected one delivery queued; got %d, %v", n, err)
+	}
+
+	due, err := db.GetDueWebhookDeliveries(ctx, time.Now().Add(time.Second), 10)
+	if err != nil || len(due) != 2 {
+		t.Fatalf("expected two due deliveries; got %+v, %v", due, err)
+	}
+	created, reacted := due[0], due[1]
+	if created.WebhookID != all.ID {
+		created, reacted = reacted, created
+	}
+	if created.URL != all.URL || created.Secret != "s1" || string(created.Payload) != `{"a":1}` {
+		t.Errorf("expected the delivery with its webhook URL and secret; got %+v", created)
+	}
+
+	// A failed delivery waits for its retry, and is dead when no retry is left
+	if err := db.MarkWebhookDelivered(ctx, created.ID, 200); err != nil {
+		t.Fatalf("error marking delivery: %v", err)
+	}
+	retryAt := time.Now().Add(time.Minute)
+	if err := db.MarkWebhookFailed(ctx, reacted.ID, 500, "server error", &retryAt); err != nil {
+		t.Fatalf("error marking delivery: %v", err)
+	}
+	if due, err := db.GetDueWebhookDeliveries(ctx, time.Now().Add(time.Second), 10); err != nil || len(due) != 0 {
+		t.Errorf("expected no due deliveries before the retry; got %+v, %v", due, err)
+	}
+	due, err = db.GetDueWebhookDeliveries(ctx, retryAt.Add(time.Second), 10)
+	if err != nil || len(due) != 1 || due[0].Attempts != 1 || due[0].LastStatusCode != 500 {
+		t.Fatalf("expected the failed delivery to be due again; got %+v, %v", due, err)
+	}
+	if err := db.MarkWebhookFailed(ctx, reacted.ID, 0, "connection refused", nil); err != nil {
+		t.Fatalf("error marking delivery: %v", err)
+	}
+
+	history, err := db.GetWebhookDeliveries(ctx, conversationID, reactions.ID, WebhookDeliveryDead, 10)
+	if err != nil || len(history) != 1 || history[0].Attempts != 2 || history[0].LastError != "connection refused" ||
+		history[0].NextAttemptAt != nil {
+		t.Errorf("expected the dead delivery in the history; got %+v, %v", history, err)
+	}
+	history, err = db.GetWebhookDeliveries(ctx, conversationID, all.ID, "", 10)
+	if err != nil || len(history) != 1 || history[0].Status != WebhookDeliveryDelivered ||
+		history[0].DeliveredAt == nil {
+		t.Errorf("expected the delivered delivery in the history; got %+v, %v", history, err)
+	}
+	if _, err := db.GetWebhookDeliveries(ctx, "other", all.ID, "", 10); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("expected ErrWebhookNotFound for another conversation; got %v", err)
+	}
+
+	if err := db.DeleteOldWebhookDeliveries(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("error deleting old deliveries: %v", err)
+	}
+	if history, err := db.GetWebhookDeliveries(ctx, conversationID, all.ID, "", 10); err != nil || len(history) != 0 {
+		t.Errorf("expected the old deliveries to be forgotten; got %+v, %v", history, err)
+	}
+
+	if err := db.DeleteWebhook(ctx, "other", all.ID); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("expected ErrWebhookNotFound for another conversation; got %v", err)
+	}
+	if err := db.DeleteWebhook(ctx, conversationID, all.ID); err != nil {
+		t.Fatalf("error deleting webhook: %v", err)
+	}
+	if webhooks, err := db.GetWebhooks(ctx, conversationID); err != nil || len(webhooks) != 1 {
+		t.Errorf("expected one webhook left; got %+v, %v", webhooks, err)
+	}
+}
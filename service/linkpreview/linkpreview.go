@@ -0,0 +1,252 @@
+/*
+Package linkpreview fetches the OpenGraph title, description and image of web pages linked in messages.
+
+Pages are fetched on behalf of the server, so the fetcher refuses to connect to loopback, private, link-local and
+other non-public addresses, checking the address actually dialed so that redirects and DNS tricks cannot reach the
+internal network. Requests time out, and only the beginning of the page is read.
+*/
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrForbiddenAddress is returned when a link points to an address that is not public
+	ErrForbiddenAddress = errors.New("address not allowed")
+
+	// ErrNoPreview is returned when a page has nothing to preview, or is not an HTML page
+	ErrNoPreview = errors.New("no preview available")
+)
+
+const (
+	// maxRedirects is how many redirects are followed before giving up
+	maxRedirects = 3
+
+	// maxFieldLength caps the length of titles and descriptions taken from pages
+	maxFieldLength = 300
+)
+
+var (
+	urlPattern       = regexp.MustCompile(`https?://[^\s<>"']+`)
+	metaPattern      = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attributePattern = regexp.MustCompile(`(?s)([a-zA-Z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titlePattern     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// nonPublicNetworks are the ranges not covered by the net.IP predicates that a server should not reach
+var nonPublicNetworks = []*net.IPNet{
+	mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT
+	mustParseCIDR("192.0.0.0/24"),  // IETF protocol assignments
+	mustParseCIDR("198.18.0.0/15"), // benchmarking
+	mustParseCIDR("240.0.0.0/4"),   // reserved
+}
+
+// Preview is what a page says about itself. URL is the link found in the message.
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+// Fetcher fetches previews. It is safe for concurrent use.
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+// New returns a fetcher giving up on a page after timeout, and reading at most maxBytes of it
+func New(timeout time.Duration, maxBytes int64) *Fetcher {
+	return newFetcher(timeout, maxBytes, false)
+}
+
+// newFetcher returns a fetcher that, if allowPrivate is set, also connects to non-public addresses
+func newFetcher(timeout time.Duration, maxBytes int64, allowPrivate bool) *Fetcher {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = ControlPublic
+	}
+
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       time.Minute,
+	}
+	return &Fetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return errors.New("too many redirects")
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return ErrForbiddenAddress
+				}
+				return nil
+			},
+		},
+		maxBytes: maxBytes,
+	}
+}
+
+// FindURL returns the first http or https link in a text, or an empty string if there is none
+func FindURL(text string) string {
+	link := urlPattern.FindString(text)
+	// Punctuation closing a sentence is not part of the link
+	return strings.TrimRight(link, ".,;:!?)]}")
+}
+
+// Fetch downloads the page at link and reads its preview
+func (f *Fetcher) Fetch(ctx context.Context, link string) (*Preview, error) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid link %q", link)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "WASAText-LinkPreview/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrForbiddenAddress) {
+			return nil, ErrForbiddenAddress
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return nil, ErrNoPreview
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	preview := parse(string(body), resp.Request.URL)
+	if preview.Title == "" && preview.Description == "" {
+		return nil, ErrNoPreview
+	}
+	preview.URL = link
+	return preview, nil
+}
+
+// parse reads the OpenGraph tags of a page, falling back to its title and description. Relative image URLs are
+// resolved against the address the page was served from.
+func parse(page string, base *url.URL) *Preview {
+	if end := strings.Index(strings.ToLower(page), "</head>"); end >= 0 {
+		page = page[:end]
+	}
+
+	meta := make(map[string]string)
+	for _, tag := range metaPattern.FindAllString(page, -1) {
+		attributes := make(map[string]string)
+		for _, m := range attributePattern.FindAllStringSubmatch(tag, -1) {
+			attributes[strings.ToLower(m[1])] = m[2] + m[3] + m[4]
+		}
+		key := attributes["property"]
+		if key == "" {
+			key = attributes["name"]
+		}
+		key = strings.ToLower(key)
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = attributes["content"]
+		}
+	}
+
+	preview := Preview{
+		Title:       firstNonEmpty(meta["og:title"], meta["twitter:title"]),
+		Description: firstNonEmpty(meta["og:description"], meta["twitter:description"], meta["description"]),
+	}
+	if preview.Title == "" {
+		if m := titlePattern.FindStringSubmatch(page); m != nil {
+			preview.Title = m[1]
+		}
+	}
+	preview.Title = clean(preview.Title)
+	preview.Description = clean(preview.Description)
+
+	if image := html.UnescapeString(firstNonEmpty(meta["og:image"], meta["twitter:image"])); image != "" {
+		if u, err := base.Parse(strings.TrimSpace(image)); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			preview.ImageURL = u.String()
+		}
+	}
+	return &preview
+}
+
+// clean decodes the entities of a text taken from a page, collapses its spaces and caps its length
+func clean(text string) string {
+	text = strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+	if runes := []rune(text); len(runes) > maxFieldLength {
+		text = strings.TrimSpace(string(runes[:maxFieldLength-1])) + "…"
+	}
+	return text
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ControlPublic is a net.Dialer Control refusing with ErrForbiddenAddress to connect to addresses that are not
+// public. Other clients calling URLs given by users use it too.
+func ControlPublic(_ string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublic(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// isPublic tells whether an address is reachable on the public internet
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
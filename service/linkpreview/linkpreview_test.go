@@ -0,0 +1,133 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindURL(t *testing.T) {
+	for text, expected := range map[string]string{
+		"look at https://example.com/a?b=c, nice": "https://example.com/a?b=c",
+		"(see http://example.com/page).":          "http://example.com/page",
+		"no links here, just example.com":         "",
+		"ftp://example.com is not fetched":        "",
+	} {
+		if got := FindURL(text); got != expected {
+			t.Errorf("FindURL(%q) = %q; expected %q", text, got, expected)
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(`<!doctype html><html><head>
+			<title>Fallback title</title>
+			<meta property="og:title" content="Coffee &amp; Code">
+			<meta name='description' content='A page about
+			    coffee'>
+			<meta property="og:image" content="/images/cup.png">
+			</head><body><meta property="og:title" content="Not in the head"></body></html>`))
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head><title>Just a title</title></head></html>`))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/article", http.StatusFound)
+	})
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		_, _ = w.Write([]byte("%PDF-1.4"))
+	})
+	mux.HandleFunc("/huge", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head>` + strings.Repeat(" ", 4096) + `<title>Too far</title></head></html>`))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	f := newFetcher(500*time.Millisecond, 1024, true)
+	ctx := context.Background()
+
+	preview, err := f.Fetch(ctx, server.URL+"/article")
+	if err != nil {
+		t.Fatalf("error fetching preview: %v", err)
+	}
+	expected := Preview{
+		URL:         server.URL + "/article",
+		Title:       "Coffee & Code",
+		Description: "A page about coffee",
+		ImageURL:    server.URL + "/images/cup.png",
+	}
+	if *preview != expected {
+		t.Errorf("expected %+v; got %+v", expected, *preview)
+	}
+
+	if preview, err := f.Fetch(ctx, server.URL+"/plain"); err != nil || preview.Title != "Just a title" {
+		t.Errorf("expected the title as fallback; got %+v, %v", preview, err)
+	}
+	if preview, err := f.Fetch(ctx, server.URL+"/redirect"); err != nil || preview.URL != server.URL+"/redirect" ||
+		preview.Title != "Coffee & Code" {
+		t.Errorf("expected the redirect to be followed; got %+v, %v", preview, err)
+	}
+	if _, err := f.Fetch(ctx, server.URL+"/file"); !errors.Is(err, ErrNoPreview) {
+		t.Errorf("expected ErrNoPreview for a PDF; got %v", err)
+	}
+	if _, err := f.Fetch(ctx, server.URL+"/huge"); !errors.Is(err, ErrNoPreview) {
+		t.Errorf("expected the page to be cut after 1024 bytes; got %v", err)
+	}
+	if _, err := f.Fetch(ctx, server.URL+"/slow"); err == nil {
+		t.Error("expected a slow page to time out")
+	}
+}
+
+func TestFetchRefusesPrivateAddresses(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	// The test server listens on loopback, which the default fetcher must not reach
+	f := New(time.Second, 1024)
+	if _, err := f.Fetch(context.Background(), server.URL); !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("expected ErrForbiddenAddress; got %v", err)
+	}
+	if requested {
+		t.Error("expected no request to reach the server")
+	}
+
+	for address, public := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1":    true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+		"fe80::1":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if got := isPublic(net.ParseIP(address)); got != public {
+			t.Errorf("expected isPublic(%s) to be %v", address, public)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Package presence keeps track, in memory, of which users are online and which are typing in a conversation.
+
+Every entry expires after a short TTL unless it is refreshed: clients are online while they keep making requests, and
+typing while they keep sending typing heartbeats. Nothing here is persisted; a restart simply shows everybody offline
+until their next request.
+*/
+package presence
+
+import (
+	"sync"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/globaltime"
+)
+
+// Registry is the presence registry, keyed by user ID. It is safe for concurrent use.
+type Registry struct {
+	onlineTTL     time.Duration
+	typingTTL     time.Duration
+	persistPeriod time.Duration
+
+	mu            sync.Mutex
+	lastActive    map[string]time.Time
+	lastPersisted map[string]time.Time
+	typing        map[string]map[string]time.Time
+}
+
+// New returns a registry where users stay online for onlineTTL after their last activity and typing for typingTTL
+// after their last heartbeat. Touch asks to persist the last seen time at most once every persistPeriod per user.
+func New(onlineTTL time.Duration, typingTTL time.Duration, persistPeriod time.Duration) *Registry {
+	return &Registry{
+		onlineTTL:     onlineTTL,
+		typingTTL:     typingTTL,
+		persistPeriod: persistPeriod,
+		lastActive:    make(map[string]time.Time),
+		lastPersisted: make(map[string]time.Time),
+		typing:        make(map[string]map[string]time.Time),
+	}
+}
+
+// Touch records an activity of the user. It returns true when the last seen time should be saved in the database.
+func (r *Registry) Touch(userID string) bool {
+	now := globaltime.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastActive[userID] = now
+	if last, ok := r.lastPersisted[userID]; ok && now.Sub(last) < r.persistPeriod {
+		return false
+	}
+	r.lastPersisted[userID] = now
+	return true
+}
+
+// Typing records a typing heartbeat of the user in a conversation. Typing is an activity too.
+func (r *Registry) Typing(userID string, conversationID string) {
+	now := globaltime.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastActive[userID] = now
+	if r.typing[userID] == nil {
+		r.typing[userID] = make(map[string]time.Time)
+	}
+	r.typing[userID][conversationID] = now
+}
+
+// IsOnline reports whether the user had any activity in the last onlineTTL
+func (r *Registry) IsOnline(userID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last, ok := r.lastActive[userID]
+	return ok && globaltime.Since(last) < r.onlineTTL
+}
+
+// IsTyping reports whether the user sent a typing heartbeat for the conversation in the last typingTTL
+func (r *Registry) IsTyping(userID string, conversationID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last, ok := r.typing[userID][conversationID]
+	return ok && globaltime.Since(last) < r.typingTTL
+}
+
+// Prune drops expired entries, to keep memory bounded by the number of recently active users
+func (r *Registry) Prune() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for userID, last := range r.lastActive {
+		if globaltime.Since(last) >= r.onlineTTL {
+			delete(r.lastActive, userID)
+		}
+	}
+	for userID, last := range r.lastPersisted {
+		if globaltime.Since(last) >= r.persistPeriod {
+			delete(r.lastPersisted, userID)
+		}
+	}
+	for userID, conversations := range r.typing {
+		for conversationID, last := range conversations {
+			if globaltime.Since(last) >= r.typingTTL {
+				delete(conversations, conversationID)
+			}
+		}
+		if len(conversations) == 0 {
+			delete(r.typing, userID)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package presence
+
+import (
+	"testing"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/globaltime"
+)
+
+func TestRegistry(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	globaltime.FixedTime = start
+	defer func() { globaltime.FixedTime = time.Time{} }()
+
+	r := New(30*time.Second, 5*time.Second, time.Minute)
+
+	if r.IsOnline("user1") {
+		t.Error("expected user1 offline before any activity")
+	}
+	if !r.Touch("user1") {
+		t.Error("expected first activity to be persisted")
+	}
+	if r.Touch("user1") {
+		t.Error("expected second activity in the same period not to be persisted")
+	}
+
+	r.Typing("user1", "conv1")
+	if !r.IsOnline("user1") || !r.IsTyping("user1", "conv1") {
+		t.Error("expected user1 online and typing in conv1")
+	}
+	if r.IsTyping("user1", "conv2") {
+		t.Error("expected user1 not typing in conv2")
+	}
+
+	globaltime.FixedTime = start.Add(10 * time.Second)
+	if r.IsTyping("user1", "conv1") {
+		t.Error("expected typing to expire")
+	}
+	if !r.IsOnline("user1") {
+		t.Error("expected user1 still online")
+	}
+
+	globaltime.FixedTime = start.Add(2 * time.Minute)
+	if r.IsOnline("user1") {
+		t.Error("expected online to expire")
+	}
+	r.Prune()
+	if len(r.lastActive) != 0 || len(r.typing) != 0 || len(r.lastPersisted) != 0 {
+		t.Error("expected prune to drop expired entries")
+	}
+	if !r.Touch("user1") {
+		t.Error("expected activity after the persist period to be persisted")
+	}
+}
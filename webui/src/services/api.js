@@ -43,9 +43,11 @@ async function apiCall(endpoint, options = {}) {
 }
 
 export const api = {
-    // Get user's conversations
-    async getConversations(username) {
-        return apiCall(`/users/${username}/conversations`)
+    // Get a page of the user's conversations; returns { conversations, next_cursor }
+    async getConversations(username, cursor = '') {
+        const params = new URLSearchParams()
+        if (cursor) params.set('cursor', cursor)
+        return apiCall(`/users/${username}/conversations?${params}`)
     },
 
     // Get conversation messages
@@ -96,15 +98,26 @@ export const api = {
         })
     },
     
-    forwardMessage: async (conversationId, messageId) => {
-        console.log('Forwarding message:', {
-            conversationId,
-            messageId,
-            url: `/conversations/${conversationId}/messages/${messageId}/forward`
+    // Forwards to every target or to none; the results tell each target's outcome
+    forwardMessage: async (messageId, targets) => {
+        return apiCall(`/messages/${messageId}/forward`, {
+            method: 'POST',
+            body: JSON.stringify({ targets })
         });
-        return apiCall(`/conversations/${conversationId}/messages/${messageId}/forward`, {
+    },
+
+    // Options are the positions of the chosen answers; an empty list withdraws the vote
+    async votePoll(conversationId, messageId, options) {
+        return apiCall(`/conversations/${conversationId}/messages/${messageId}/votes`, {
+            method: 'POST',
+            body: JSON.stringify({ options })
+        })
+    },
+
+    async closePoll(conversationId, messageId) {
+        return apiCall(`/conversations/${conversationId}/messages/${messageId}/close`, {
             method: 'POST'
-        });
+        })
     },
 
     addReaction: async (messageId, emoji, conversationId) => {
@@ -125,8 +138,9 @@ export const api = {
         return text.length > 0 ? JSON.parse(text) : {}
     },
 
-    deleteReaction: async (messageId, conversationId) => {
-        const response = await fetch(`${API_URL}/conversations/${conversationId}/messages/${messageId}/reactions`, {
+    deleteReaction: async (messageId, emoji, conversationId) => {
+        const params = new URLSearchParams({ reaction: emoji })
+        const response = await fetch(`${API_URL}/conversations/${conversationId}/messages/${messageId}/reactions?${params}`, {
             method: 'DELETE',
             headers: {
                 'Content-Type': 'application/json',
@@ -269,6 +283,47 @@ export const api = {
         return response.json();
     },
 
+    sendAttachments: async (conversationId, files, caption = '') => {
+        const formData = new FormData();
+        for (const file of files) {
+            formData.append('files', file);
+        }
+        if (caption) {
+            formData.append('caption', caption);
+        }
+
+        const response = await fetch(`${API_URL}/conversations/${conversationId}/attachments`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${localStorage.getItem('sessionId')}`
+            },
+            body: formData
+        });
+
+        if (!response.ok) {
+            const text = await response.text();
+            throw new Error(text);
+        }
+
+        return response.json();
+    },
+
+    // Attachments need the session token, so they are fetched as blobs instead of linked directly
+    downloadAttachment: async (attachment) => {
+        const response = await fetch(`${API_URL}${attachment.url}`, {
+            headers: {
+                'Authorization': `Bearer ${localStorage.getItem('sessionId')}`
+            }
+        });
+
+        if (!response.ok) {
+            const text = await response.text();
+            throw new Error(text);
+        }
+
+        return response.blob();
+    },
+
     // Add this new method to the api object
     uploadProfilePhoto: async (username, file) => {
         const formData = new FormData()
@@ -312,9 +367,11 @@ export const api = {
         }
     },
 
-    // Update the getAllUsers method
-    getAllUsers: async () => {
-        return apiCall('/allusers', {
+    // Search the user directory; returns { users, next_cursor }
+    searchUsers: async (query = '', cursor = '') => {
+        const params = new URLSearchParams({ q: query })
+        if (cursor) params.set('cursor', cursor)
+        return apiCall(`/users?${params}`, {
             method: 'GET'
         })
     },